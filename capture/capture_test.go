@@ -0,0 +1,125 @@
+package capture
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zupolgec/curl-impersonate-service/models"
+	"github.com/zupolgec/curl-impersonate-service/store"
+)
+
+func TestRedactorHeaders(t *testing.T) {
+	r := NewRedactor(Config{RedactHeaders: []string{"x-api-key"}})
+	got := r.RequestHeaders(map[string]string{
+		"authorization": "Bearer abc",
+		"X-Api-Key":     "k",
+		"Accept":        "text/html",
+	})
+	if got["Authorization"][0] != Redacted || got["X-Api-Key"][0] != Redacted {
+		t.Errorf("secret headers not redacted: %v", got)
+	}
+	if got["Accept"][0] != "text/html" {
+		t.Errorf("Accept = %v, want untouched", got["Accept"])
+	}
+
+	resp := r.ResponseHeaders(map[string][]string{"Set-Cookie": {"a=1", "b=2"}})
+	if resp["Set-Cookie"][0] != Redacted || resp["Set-Cookie"][1] != Redacted {
+		t.Errorf("Set-Cookie not redacted: %v", resp)
+	}
+}
+
+func TestRedactorBodyAndURL(t *testing.T) {
+	r := NewRedactor(Config{MaxBodyBytes: 1000, RedactFields: []string{"password", "Token"}})
+
+	body, truncated := r.Body([]byte(`{"user":"bob","password":"hunter2","nested":[{"token":"t"}]}`))
+	if truncated || strings.Contains(body, "hunter2") || strings.Contains(body, `"t"`) || !strings.Contains(body, "bob") {
+		t.Errorf("Body = %q", body)
+	}
+
+	if body, _ := r.Body([]byte("password=hunter2")); body != "password=hunter2" {
+		t.Errorf("non-JSON body altered: %q", body)
+	}
+
+	u := r.URL("https://u:p@example.com/x?token=abc&q=1")
+	if strings.Contains(u, "abc") || strings.Contains(u, "u:p") || !strings.Contains(u, "q=1") {
+		t.Errorf("URL = %q", u)
+	}
+}
+
+func TestRedactorTruncates(t *testing.T) {
+	r := NewRedactor(Config{MaxBodyBytes: 4})
+	body, truncated := r.Body([]byte("abcdefgh"))
+	if body != "abcd" || !truncated {
+		t.Errorf("Body = %q,%v want abcd,true", body, truncated)
+	}
+}
+
+func TestRecorderOnlyRecordsActiveTokens(t *testing.T) {
+	st, err := store.Open(filepath.Join(t.TempDir(), "capture.db"))
+	if err != nil {
+		t.Fatalf("store.Open: %v", err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+
+	rec := NewRecorder(st, NewRedactor(Config{}), time.Hour, 2)
+	req := &models.ImpersonateRequest{Method: "GET", URL: "https://example.com/", Headers: map[string]string{"Cookie": "s=1"}}
+	resp := &models.ImpersonateResponse{Success: true, StatusCode: 403, Body: "blocked", Timing: &models.Timing{Total: 0.2}}
+
+	rec.Record("ci", "chrome136", req, resp, time.Millisecond)
+	if caps, _ := st.ListCaptures(0, 10); len(caps) != 0 {
+		t.Fatalf("captured %d without an active session", len(caps))
+	}
+
+	if _, err := st.StartCapture("ci", time.Hour); err != nil {
+		t.Fatalf("StartCapture: %v", err)
+	}
+	for range 3 {
+		rec.Record("ci", "chrome136", req, resp, time.Millisecond)
+	}
+	rec.Record("other", "chrome136", req, resp, time.Millisecond)
+
+	caps, _ := st.ListCaptures(0, 10)
+	if len(caps) != 2 {
+		t.Fatalf("captured %d, want 2 (per-session cap)", len(caps))
+	}
+	c := caps[0]
+	if c.RequestHeaders["Cookie"][0] != Redacted || c.ResponseBody != "blocked" || c.StatusCode != 403 || len(c.Timing) == 0 {
+		t.Errorf("capture = %+v", c)
+	}
+}
+
+func TestDiffHeadersAndLines(t *testing.T) {
+	hd := DiffHeaders(
+		map[string][]string{"A": {"1"}, "B": {"x"}, "C": {"same"}},
+		map[string][]string{"B": {"y"}, "C": {"same"}, "D": {"new"}},
+	)
+	want := map[string]string{"A": Removed, "B": Changed, "C": Same, "D": Added}
+	if len(hd) != len(want) {
+		t.Fatalf("got %d header diffs, want %d", len(hd), len(want))
+	}
+	for _, d := range hd {
+		if want[d.Name] != d.Change {
+			t.Errorf("%s: change = %s, want %s", d.Name, d.Change, want[d.Name])
+		}
+	}
+
+	lines, ok := DiffLines("a\nb\nc", "a\nx\nc")
+	if !ok {
+		t.Fatal("small texts should be diffable")
+	}
+	var got []string
+	for _, l := range lines {
+		got = append(got, l.Op+l.Text)
+	}
+	if strings.Join(got, "|") != " a|-b|+x| c" {
+		t.Fatalf("diff = %q", got)
+	}
+	if lines, ok := DiffLines("same", "same"); !ok || lines != nil {
+		t.Fatalf("identical texts: lines=%v ok=%v", lines, ok)
+	}
+	if _, ok := DiffLines(strings.Repeat("x\n", maxDiffLines+1), "y"); ok {
+		t.Fatal("oversized text should not be diffable")
+	}
+}
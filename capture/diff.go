@@ -0,0 +1,113 @@
+package capture
+
+import (
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Header comparison outcomes.
+const (
+	Same    = "same"
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// HeaderDiff compares one header name between two responses.
+type HeaderDiff struct {
+	Name   string
+	Before []string
+	After  []string
+	Change string
+}
+
+// DiffHeaders compares two header sets, sorted by header name.
+func DiffHeaders(before, after map[string][]string) []HeaderDiff {
+	names := make(map[string]struct{}, len(before)+len(after))
+	for k := range before {
+		names[k] = struct{}{}
+	}
+	for k := range after {
+		names[k] = struct{}{}
+	}
+
+	out := make([]HeaderDiff, 0, len(names))
+	for name := range names {
+		b, inBefore := before[name]
+		a, inAfter := after[name]
+		d := HeaderDiff{Name: name, Before: b, After: a, Change: Same}
+		switch {
+		case !inBefore:
+			d.Change = Added
+		case !inAfter:
+			d.Change = Removed
+		case !slices.Equal(b, a):
+			d.Change = Changed
+		}
+		out = append(out, d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// DiffLine is one line of a line-based diff. Op is ' ' (unchanged), '-'
+// (only in the first text) or '+' (only in the second).
+type DiffLine struct {
+	Op   string
+	Text string
+}
+
+// maxDiffLines bounds the quadratic diff; larger bodies are reported as a
+// whole-body change.
+const maxDiffLines = 2000
+
+// DiffLines returns a line-based diff of two texts (longest common
+// subsequence). ok is false when the texts are too large to diff.
+func DiffLines(before, after string) (lines []DiffLine, ok bool) {
+	if before == after {
+		return nil, true
+	}
+	a, b := strings.Split(before, "\n"), strings.Split(after, "\n")
+	if len(a) > maxDiffLines || len(b) > maxDiffLines {
+		return nil, false
+	}
+
+	// lcs[i][j] is the LCS length of a[i:] and b[j:].
+	lcs := make([][]int32, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, DiffLine{" ", a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, DiffLine{"-", a[i]})
+			i++
+		default:
+			lines = append(lines, DiffLine{"+", b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, DiffLine{"-", a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, DiffLine{"+", b[j]})
+	}
+	return lines, true
+}
@@ -0,0 +1,102 @@
+package capture
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/zupolgec/curl-impersonate-service/models"
+	"github.com/zupolgec/curl-impersonate-service/store"
+)
+
+// Recorder stores redacted request/response pairs for tokens that have an
+// active capture session.
+type Recorder struct {
+	store         *store.Store
+	redactor      *Redactor
+	retention     time.Duration
+	maxPerSession int64
+}
+
+// NewRecorder builds a Recorder. Captures expire after retention; a session
+// stops recording once it holds maxPerSession captures.
+func NewRecorder(st *store.Store, redactor *Redactor, retention time.Duration, maxPerSession int) *Recorder {
+	if retention <= 0 {
+		retention = 24 * time.Hour
+	}
+	if maxPerSession <= 0 {
+		maxPerSession = 1000
+	}
+	return &Recorder{store: st, redactor: redactor, retention: retention, maxPerSession: int64(maxPerSession)}
+}
+
+// Record captures one exchange if tokenName is being captured. It never fails
+// the request: storage errors are only logged.
+func (r *Recorder) Record(tokenName, browser string, req *models.ImpersonateRequest, resp *models.ImpersonateResponse, d time.Duration) {
+	if r == nil || r.store == nil {
+		return
+	}
+	sess, ok := r.store.ActiveCapture(tokenName)
+	if !ok || sess.Count >= r.maxPerSession {
+		return
+	}
+
+	c := store.Capture{
+		SessionID:       sess.ID,
+		TokenName:       tokenName,
+		Browser:         browser,
+		Method:          req.Method,
+		URL:             r.redactor.URL(withQuery(req.URL, req.QueryParams)),
+		RequestHeaders:  r.redactor.RequestHeaders(req.Headers),
+		StatusCode:      resp.StatusCode,
+		ResponseHeaders: r.redactor.ResponseHeaders(resp.Headers),
+		Success:         resp.Success,
+		Error:           resp.Error,
+		ErrorType:       resp.ErrorType,
+		DurationMs:      d.Milliseconds(),
+		ExpiresAt:       time.Now().Add(r.retention),
+	}
+
+	// Base64 bodies are binary: they can't be field-redacted, only truncated.
+	if req.BodyBase64 != "" {
+		c.RequestBody, c.RequestTruncated = r.redactor.Truncate(req.BodyBase64)
+		c.RequestBodyBase64 = true
+	} else {
+		c.RequestBody, c.RequestTruncated = r.redactor.Body([]byte(req.Body))
+	}
+	if resp.BodyBase64 {
+		c.ResponseBody, c.ResponseTruncated = r.redactor.Truncate(resp.Body)
+		c.ResponseBase64 = true
+	} else {
+		c.ResponseBody, c.ResponseTruncated = r.redactor.Body([]byte(resp.Body))
+	}
+	if resp.Timing != nil {
+		c.Timing, _ = json.Marshal(resp.Timing)
+	}
+
+	if _, err := r.store.AddCapture(c); err != nil {
+		log.Printf("Warning: failed to store capture for %s: %v", tokenName, err)
+	}
+}
+
+// Redactor returns the redactor applied to captures, so that anything shown
+// next to a capture (such as a replay) gets the same treatment.
+func (r *Recorder) Redactor() *Redactor { return r.redactor }
+
+// withQuery merges query_params into the URL the same way the executors do.
+func withQuery(raw string, params map[string]string) string {
+	if len(params) == 0 {
+		return raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	q := u.Query()
+	for k, v := range params {
+		q.Set(k, v)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,165 @@
+// Package capture implements opt-in debug capture of full request/response
+// pairs: redaction of secrets and truncation of bodies before they are stored.
+package capture
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Redacted replaces the value of anything considered secret.
+const Redacted = "[REDACTED]"
+
+// DefaultRedactHeaders are always redacted, whatever the configuration says.
+var DefaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// Config controls what a Redactor hides and how much it keeps.
+type Config struct {
+	// MaxBodyBytes truncates stored bodies. Non-positive means 64 KiB.
+	MaxBodyBytes int
+	// RedactHeaders are extra header names to redact (case-insensitive).
+	RedactHeaders []string
+	// RedactFields are JSON object keys and query parameter names whose values
+	// are redacted (case-insensitive), at any nesting depth.
+	RedactFields []string
+}
+
+// Redactor scrubs secrets from captured requests and responses.
+type Redactor struct {
+	maxBody int
+	headers map[string]struct{}
+	fields  map[string]struct{}
+}
+
+// NewRedactor builds a Redactor from config values.
+func NewRedactor(cfg Config) *Redactor {
+	r := &Redactor{
+		maxBody: cfg.MaxBodyBytes,
+		headers: make(map[string]struct{}),
+		fields:  make(map[string]struct{}),
+	}
+	if r.maxBody <= 0 {
+		r.maxBody = 64 << 10
+	}
+	for _, h := range append(append([]string{}, DefaultRedactHeaders...), cfg.RedactHeaders...) {
+		if h = strings.TrimSpace(h); h != "" {
+			r.headers[http.CanonicalHeaderKey(h)] = struct{}{}
+		}
+	}
+	for _, f := range cfg.RedactFields {
+		if f = strings.ToLower(strings.TrimSpace(f)); f != "" {
+			r.fields[f] = struct{}{}
+		}
+	}
+	return r
+}
+
+func (r *Redactor) secretHeader(name string) bool {
+	_, ok := r.headers[http.CanonicalHeaderKey(name)]
+	return ok
+}
+
+func (r *Redactor) secretField(name string) bool {
+	_, ok := r.fields[strings.ToLower(name)]
+	return ok
+}
+
+// RequestHeaders returns a redacted copy of single-valued request headers.
+func (r *Redactor) RequestHeaders(h map[string]string) map[string][]string {
+	out := make(map[string][]string, len(h))
+	for k, v := range h {
+		if r.secretHeader(k) {
+			v = Redacted
+		}
+		key := http.CanonicalHeaderKey(k)
+		out[key] = append(out[key], v)
+	}
+	return out
+}
+
+// ResponseHeaders returns a redacted copy of multi-valued response headers.
+func (r *Redactor) ResponseHeaders(h map[string][]string) map[string][]string {
+	out := make(map[string][]string, len(h))
+	for k, vals := range h {
+		cp := make([]string, len(vals))
+		for i, v := range vals {
+			if r.secretHeader(k) {
+				v = Redacted
+			}
+			cp[i] = v
+		}
+		out[k] = cp
+	}
+	return out
+}
+
+// URL redacts secret query parameters. Unparseable URLs are returned as-is
+// minus their query string, to be safe.
+func (r *Redactor) URL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		if i := strings.IndexByte(raw, '?'); i >= 0 {
+			return raw[:i]
+		}
+		return raw
+	}
+	if u.User != nil {
+		u.User = url.User(Redacted)
+	}
+	if u.RawQuery == "" || len(r.fields) == 0 {
+		return u.String()
+	}
+	q := u.Query()
+	for k := range q {
+		if r.secretField(k) {
+			q[k] = []string{Redacted}
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// Body redacts secret fields of JSON bodies and truncates to the size cap. It
+// reports whether the body was truncated. Non-JSON bodies are only truncated.
+func (r *Redactor) Body(body []byte) (string, bool) {
+	if len(r.fields) > 0 && len(body) > 0 {
+		var v any
+		if err := json.Unmarshal(body, &v); err == nil {
+			if redacted, err := json.Marshal(r.redactJSON(v)); err == nil {
+				body = redacted
+			}
+		}
+	}
+	if len(body) > r.maxBody {
+		return string(body[:r.maxBody]), true
+	}
+	return string(body), false
+}
+
+// Truncate cuts s to the capture body size cap, reporting whether it did.
+func (r *Redactor) Truncate(s string) (string, bool) {
+	if len(s) > r.maxBody {
+		return s[:r.maxBody], true
+	}
+	return s, false
+}
+
+func (r *Redactor) redactJSON(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, val := range t {
+			if r.secretField(k) {
+				t[k] = Redacted
+			} else {
+				t[k] = r.redactJSON(val)
+			}
+		}
+	case []any:
+		for i, val := range t {
+			t[i] = r.redactJSON(val)
+		}
+	}
+	return v
+}
@@ -1,8 +1,13 @@
 package config
 
 import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -10,6 +15,9 @@ import (
 const Version = "1.3.2"
 
 type Config struct {
+	// File is the config file read, if any.
+	File string
+
 	Token               string
 	Port                string
 	LogLevel            string
@@ -18,6 +26,9 @@ type Config struct {
 	MaxTimeout          int
 	DefaultTimeout      int
 	BrowsersJSONPath    string
+	// BlockRulesPath is the block-page detection rules file; empty switches
+	// detection off.
+	BlockRulesPath string
 
 	// SSRF protection
 	SSRFAllowPrivate bool
@@ -26,107 +37,380 @@ type Config struct {
 	SSRFDenyHosts    []string
 	SSRFAllowHosts   []string
 
-	// CORS: initial allowed origins, "*" for any. May be overridden at runtime
-	// via the admin UI (persisted in the datastore).
+	// CORS: allowed origins, "*" for any. May be overridden at runtime via the
+	// admin UI (persisted in the datastore).
 	CORSAllowedOrigins []string
 
 	// Persistence and admin UI
 	AdminToken        string
+	AdminSessionHours int
 	DataDir           string
 	LogRetentionHours int
 
+	// Usage rollups outlive raw logs: hourly and daily aggregates are kept for
+	// these many days.
+	RollupHourlyRetentionDays int
+	RollupDailyRetentionDays  int
+
+	// Debug capture of full request/response pairs, switched on per token
+	// from the admin UI.
+	CaptureMaxBodyBytes   int
+	CaptureRedactHeaders  []string
+	CaptureRedactFields   []string
+	CaptureRetentionHours int
+	CaptureMaxPerSession  int
+
+	// Native TLS, enabled by TLSCertFile and TLSKeyFile. TLSClientCertMap
+	// maps client certificate identities ("cn:", "dns:", "uri:", "email:")
+	// to token names.
+	TLSCertFile      string
+	TLSKeyFile       string
+	TLSClientCAFile  string
+	TLSClientAuth    string
+	TLSClientCertMap map[string]string
+
+	// UnixSocket, when set, serves on this Unix domain socket instead of
+	// Port.
+	UnixSocket     string
+	UnixSocketMode os.FileMode
+
+	// AllowQueryToken accepts API tokens as a ?token= parameter; tokens can
+	// also refuse it individually. SignatureMaxSkewSeconds bounds the clock
+	// difference accepted on signed requests.
+	AllowQueryToken         bool
+	SignatureMaxSkewSeconds int
+
+	// SecretsKey encrypts the CA bundles and client certificates stored for
+	// targets. Without it they cannot be stored or used.
+	SecretsKey []byte
+
 	// APIDocsEnabled serves the public API docs page at "/".
 	APIDocsEnabled bool
+
+	// OpenID Connect single sign-on for the admin UI, enabled by OIDCIssuer.
+	// OIDCRoleMap maps IdP groups to admin roles.
+	OIDCIssuer        string
+	OIDCClientID      string
+	OIDCClientSecret  string
+	OIDCRedirectURL   string
+	OIDCScopes        []string
+	OIDCUsernameClaim string
+	OIDCGroupsClaim   string
+	OIDCRoleMap       map[string]string
+
+	// raw and sources record each setting's text value and where it came
+	// from, keyed by environment variable name.
+	raw     map[string]string
+	sources map[string]string
 }
 
+// Sources of a configuration value.
+const (
+	SourceDefault = "default"
+	SourceEnv     = "env"
+	SourceFile    = "file"
+)
+
+// Load reads the configuration from the environment and the optional config
+// file named by CONFIG_FILE. See LoadFile.
 func Load() (*Config, error) {
-	token := os.Getenv("TOKEN")
-	adminToken := os.Getenv("ADMIN_TOKEN")
-	if token == "" && adminToken == "" {
-		return nil, fmt.Errorf("set TOKEN and/or ADMIN_TOKEN: at least one is required")
+	return LoadFile(os.Getenv("CONFIG_FILE"))
+}
+
+// LoadFile reads the configuration from the environment and, if path is not
+// empty, from a JSON or TOML config file whose keys are the environment
+// variable names. A non-empty environment variable overrides the file.
+// Every malformed, out-of-range or unknown value is reported: the error
+// joins one error per problem.
+func LoadFile(path string) (*Config, error) {
+	l := &loader{known: map[string]bool{}, raw: map[string]string{}, sources: map[string]string{}}
+	if path != "" {
+		file, err := readFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("CONFIG_FILE: %w", err)
+		}
+		l.file = file
 	}
 
 	cfg := &Config{
-		Token:               token,
-		Port:                getEnvOrDefault("PORT", "8080"),
-		LogLevel:            getEnvOrDefault("LOG_LEVEL", "info"),
-		MaxRequestBodySize:  getEnvInt64OrDefault("MAX_REQUEST_BODY_SIZE", 10485760),  // 10MB
-		MaxResponseBodySize: getEnvInt64OrDefault("MAX_RESPONSE_BODY_SIZE", 52428800), // 50MB
-		MaxTimeout:          getEnvIntOrDefault("MAX_TIMEOUT", 120),
-		DefaultTimeout:      getEnvIntOrDefault("DEFAULT_TIMEOUT", 30),
-		BrowsersJSONPath:    getEnvOrDefault("BROWSERS_JSON_PATH", "/etc/impersonate/browsers.json"),
+		File:                path,
+		Token:               l.str("TOKEN", ""),
+		Port:                l.port("PORT", "8080"),
+		LogLevel:            l.oneOf("LOG_LEVEL", "info", "debug", "info", "warn", "error"),
+		MaxRequestBodySize:  l.int64("MAX_REQUEST_BODY_SIZE", 10485760, 1024, 1<<30),  // 10MB
+		MaxResponseBodySize: l.int64("MAX_RESPONSE_BODY_SIZE", 52428800, 1024, 1<<30), // 50MB
+		MaxTimeout:          l.int("MAX_TIMEOUT", 120, 1, 3600),
+		DefaultTimeout:      l.int("DEFAULT_TIMEOUT", 30, 1, 3600),
+		BrowsersJSONPath:    l.str("BROWSERS_JSON_PATH", "/etc/impersonate/browsers.json"),
+		BlockRulesPath:      l.str("BLOCK_RULES_PATH", "/etc/impersonate/block_rules.json"),
+
+		SSRFAllowPrivate: l.bool("SSRF_ALLOW_PRIVATE", false),
+		SSRFAllowHTTP:    l.bool("SSRF_ALLOW_HTTP", false),
+		SSRFAllowIP:      l.bool("SSRF_ALLOW_IP", false),
+		SSRFDenyHosts:    l.list("SSRF_DENY_HOSTS", nil),
+		SSRFAllowHosts:   l.list("SSRF_ALLOW_HOSTS", nil),
+
+		// "*" allows any origin.
+		CORSAllowedOrigins: l.list("CORS_ALLOWED_ORIGINS", []string{"*"}),
+
+		AdminToken:        l.str("ADMIN_TOKEN", ""),
+		AdminSessionHours: l.int("ADMIN_SESSION_HOURS", 12, 1, 24*90),
+		DataDir:           l.str("DATA_DIR", "/data"),
+		LogRetentionHours: l.int("LOG_RETENTION_HOURS", 72, 0, 24*3650),
+
+		RollupHourlyRetentionDays: l.int("ROLLUP_HOURLY_RETENTION_DAYS", 90, 0, 36500),
+		RollupDailyRetentionDays:  l.int("ROLLUP_DAILY_RETENTION_DAYS", 730, 0, 36500),
+
+		CaptureMaxBodyBytes:  l.int("CAPTURE_MAX_BODY_BYTES", 65536, 0, 1<<30),
+		CaptureRedactHeaders: l.list("CAPTURE_REDACT_HEADERS", nil),
+		// Common credential field names.
+		CaptureRedactFields:   l.list("CAPTURE_REDACT_FIELDS", []string{"password", "passwd", "secret", "token", "access_token", "refresh_token", "api_key", "apikey", "client_secret"}),
+		CaptureRetentionHours: l.int("CAPTURE_RETENTION_HOURS", 24, 1, 24*365),
+		CaptureMaxPerSession:  l.int("CAPTURE_MAX_PER_SESSION", 1000, 1, 1000000),
 
-		SSRFAllowPrivate: getEnvBool("SSRF_ALLOW_PRIVATE", false),
-		SSRFAllowHTTP:    getEnvBool("SSRF_ALLOW_HTTP", false),
-		SSRFAllowIP:      getEnvBool("SSRF_ALLOW_IP", false),
-		SSRFDenyHosts:    getEnvList("SSRF_DENY_HOSTS"),
-		SSRFAllowHosts:   getEnvList("SSRF_ALLOW_HOSTS"),
+		TLSCertFile:      l.str("TLS_CERT_FILE", ""),
+		TLSKeyFile:       l.str("TLS_KEY_FILE", ""),
+		TLSClientCAFile:  l.str("TLS_CLIENT_CA_FILE", ""),
+		TLSClientAuth:    l.oneOf("TLS_CLIENT_AUTH", "", "none", "optional", "require"),
+		TLSClientCertMap: l.mapping("TLS_CLIENT_CERT_MAP"),
 
-		CORSAllowedOrigins: corsOrigins(),
+		UnixSocket:     l.str("UNIX_SOCKET", ""),
+		UnixSocketMode: l.fileMode("UNIX_SOCKET_MODE", 0o660),
 
-		AdminToken:        adminToken,
-		DataDir:           getEnvOrDefault("DATA_DIR", "/data"),
-		LogRetentionHours: getEnvIntOrDefault("LOG_RETENTION_HOURS", 72),
+		AllowQueryToken:         l.bool("ALLOW_QUERY_TOKEN", true),
+		SignatureMaxSkewSeconds: l.int("SIGNATURE_MAX_SKEW_SECONDS", 300, 1, 86400),
 
-		APIDocsEnabled: getEnvBool("API_DOCS_ENABLED", true),
+		SecretsKey: l.key("SECRETS_KEY"),
+
+		APIDocsEnabled: l.bool("API_DOCS_ENABLED", true),
+
+		OIDCIssuer:       l.str("OIDC_ISSUER", ""),
+		OIDCClientID:     l.str("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret: l.str("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:  l.str("OIDC_REDIRECT_URL", ""),
+		// "openid" is always requested.
+		OIDCScopes:        l.list("OIDC_SCOPES", []string{"profile", "email"}),
+		OIDCUsernameClaim: l.str("OIDC_USERNAME_CLAIM", "email"),
+		OIDCGroupsClaim:   l.str("OIDC_GROUPS_CLAIM", "groups"),
+		OIDCRoleMap:       l.mapping("OIDC_ROLE_MAP"),
 	}
 
+	if cfg.Token == "" && cfg.AdminToken == "" {
+		l.errs = append(l.errs, errors.New("set TOKEN and/or ADMIN_TOKEN: at least one is required"))
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		l.errs = append(l.errs, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together"))
+	}
+	if cfg.TLSCertFile == "" && (cfg.TLSClientCAFile != "" || cfg.TLSClientAuth != "") {
+		l.errs = append(l.errs, errors.New("TLS_CLIENT_CA_FILE and TLS_CLIENT_AUTH need TLS_CERT_FILE and TLS_KEY_FILE"))
+	}
+	if len(cfg.TLSClientCertMap) > 0 && cfg.TLSClientCAFile == "" {
+		l.errs = append(l.errs, errors.New("TLS_CLIENT_CERT_MAP needs TLS_CLIENT_CA_FILE"))
+	}
+	if cfg.OIDCIssuer != "" && (cfg.OIDCClientID == "" || cfg.OIDCRedirectURL == "") {
+		l.errs = append(l.errs, errors.New("OIDC_ISSUER is set: OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required"))
+	}
+	var unknown []string
+	for key := range l.file {
+		if !l.known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		l.errs = append(l.errs, fmt.Errorf("%s (config file): unknown setting", strings.ToLower(key)))
+	}
+	if len(l.errs) > 0 {
+		return nil, errors.Join(l.errs...)
+	}
+
+	cfg.raw, cfg.sources = l.raw, l.sources
 	return cfg, nil
 }
 
-// corsOrigins reads CORS_ALLOWED_ORIGINS, defaulting to "*" (any origin).
-func corsOrigins() []string {
-	if origins := getEnvList("CORS_ALLOWED_ORIGINS"); origins != nil {
-		return origins
+// Source reports where the value of an environment variable's setting came
+// from: SourceEnv, SourceFile or SourceDefault.
+func (c *Config) Source(key string) string {
+	if src, ok := c.sources[key]; ok {
+		return src
 	}
-	return []string{"*"}
+	return SourceDefault
 }
 
-func getEnvBool(key string, defaultValue bool) bool {
-	if value := os.Getenv(key); value != "" {
-		if b, err := strconv.ParseBool(value); err == nil {
-			return b
+// Changed lists the settings, by environment variable name, whose value
+// differs between c and other.
+func (c *Config) Changed(other *Config) []string {
+	var out []string
+	for key, v := range c.raw {
+		if other.raw[key] != v {
+			out = append(out, key)
+		}
+	}
+	for key := range other.raw {
+		if _, ok := c.raw[key]; !ok {
+			out = append(out, key)
 		}
 	}
-	return defaultValue
+	sort.Strings(out)
+	return out
 }
 
-// getEnvList parses a comma-separated env var into a trimmed, non-empty slice.
-func getEnvList(key string) []string {
-	value := os.Getenv(key)
-	if value == "" {
-		return nil
+// loader reads settings from the environment, then the config file, and
+// collects every problem instead of stopping at the first.
+type loader struct {
+	file    map[string]string
+	known   map[string]bool
+	raw     map[string]string
+	sources map[string]string
+	errs    []error
+}
+
+// lookup returns the value set for key, if any.
+func (l *loader) lookup(key string) (string, bool) {
+	l.known[key] = true
+	if v := os.Getenv(key); v != "" {
+		l.raw[key], l.sources[key] = v, SourceEnv
+		return v, true
+	}
+	if v := l.file[key]; v != "" {
+		l.raw[key], l.sources[key] = v, SourceFile
+		return v, true
+	}
+	return "", false
+}
+
+func (l *loader) fail(key, format string, args ...any) {
+	where := key
+	if l.sources[key] == SourceFile {
+		where = strings.ToLower(key) + " (config file)"
+	}
+	l.errs = append(l.errs, fmt.Errorf("%s: %s", where, fmt.Sprintf(format, args...)))
+}
+
+func (l *loader) str(key, def string) string {
+	if v, ok := l.lookup(key); ok {
+		return v
+	}
+	return def
+}
+
+func (l *loader) oneOf(key, def string, allowed ...string) string {
+	v, ok := l.lookup(key)
+	if !ok {
+		return def
+	}
+	if !slices.Contains(allowed, v) {
+		l.fail(key, "%q is not one of %s", v, strings.Join(allowed, ", "))
+		return def
+	}
+	return v
+}
+
+func (l *loader) port(key, def string) string {
+	v, ok := l.lookup(key)
+	if !ok {
+		return def
+	}
+	if n, err := strconv.Atoi(v); err != nil || n < 1 || n > 65535 {
+		l.fail(key, "%q is not a port number", v)
+		return def
+	}
+	return v
+}
+
+func (l *loader) int(key string, def, min, max int) int {
+	return int(l.int64(key, int64(def), int64(min), int64(max)))
+}
+
+func (l *loader) int64(key string, def, min, max int64) int64 {
+	v, ok := l.lookup(key)
+	if !ok {
+		return def
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		l.fail(key, "want an integer, got %q", v)
+		return def
+	}
+	if n < min || n > max {
+		l.fail(key, "%d is out of range [%d, %d]", n, min, max)
+		return def
+	}
+	return n
+}
+
+func (l *loader) bool(key string, def bool) bool {
+	v, ok := l.lookup(key)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		l.fail(key, "want true or false, got %q", v)
+		return def
+	}
+	return b
+}
+
+// list parses a comma-separated value into a trimmed, non-empty slice.
+func (l *loader) list(key string, def []string) []string {
+	v, ok := l.lookup(key)
+	if !ok {
+		return def
 	}
 	var out []string
-	for _, item := range strings.Split(value, ",") {
+	for _, item := range strings.Split(v, ",") {
 		if item = strings.TrimSpace(item); item != "" {
 			out = append(out, item)
 		}
 	}
+	if out == nil {
+		return def
+	}
 	return out
 }
 
-func getEnvOrDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// mapping parses "key=value" pairs separated by commas.
+func (l *loader) mapping(key string) map[string]string {
+	out := map[string]string{}
+	for _, item := range l.list(key, nil) {
+		k, v, ok := strings.Cut(item, "=")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok || k == "" || v == "" {
+			l.fail(key, "invalid entry %q, want key=value", item)
+			continue
+		}
+		out[k] = v
 	}
-	return defaultValue
+	return out
 }
 
-func getEnvIntOrDefault(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
-		}
+// key parses a 32-byte key, hex or base64 encoded.
+func (l *loader) key(key string) []byte {
+	v, ok := l.lookup(key)
+	if !ok {
+		return nil
 	}
-	return defaultValue
+	if b, err := hex.DecodeString(v); err == nil && len(b) == 32 {
+		return b
+	}
+	if b, err := base64.StdEncoding.DecodeString(v); err == nil && len(b) == 32 {
+		return b
+	}
+	l.fail(key, "want 32 bytes, hex or base64 encoded (openssl rand -hex 32)")
+	return nil
 }
 
-func getEnvInt64OrDefault(key string, defaultValue int64) int64 {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.ParseInt(value, 10, 64); err == nil {
-			return intValue
-		}
+// fileMode parses an octal file mode such as 0660.
+func (l *loader) fileMode(key string, def os.FileMode) os.FileMode {
+	v, ok := l.lookup(key)
+	if !ok {
+		return def
+	}
+	mode, err := strconv.ParseUint(v, 8, 32)
+	if err != nil || mode > 0o777 {
+		l.fail(key, "want an octal file mode such as 0660, got %q", v)
+		return def
 	}
-	return defaultValue
+	return os.FileMode(mode)
 }
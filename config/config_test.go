@@ -2,6 +2,8 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -39,6 +41,9 @@ func TestLoad_WithToken(t *testing.T) {
 	if cfg.MaxTimeout != 120 {
 		t.Errorf("MaxTimeout = %d, want %d", cfg.MaxTimeout, 120)
 	}
+	if !cfg.AllowQueryToken || cfg.SignatureMaxSkewSeconds != 300 {
+		t.Errorf("AllowQueryToken = %v, SignatureMaxSkewSeconds = %d, want true, 300", cfg.AllowQueryToken, cfg.SignatureMaxSkewSeconds)
+	}
 }
 
 func TestLoad_CustomValues(t *testing.T) {
@@ -65,3 +70,202 @@ func TestLoad_CustomValues(t *testing.T) {
 		t.Errorf("MaxTimeout = %d, want %d", cfg.MaxTimeout, 60)
 	}
 }
+
+func TestLoad_SecretsKey(t *testing.T) {
+	os.Clearenv()
+	t.Setenv("TOKEN", "test-token")
+	for _, v := range []string{
+		"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+		"AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=",
+	} {
+		t.Setenv("SECRETS_KEY", v)
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Load() failed: %v", err)
+		}
+		if len(cfg.SecretsKey) != 32 || cfg.SecretsKey[31] != 0x1f {
+			t.Errorf("SECRETS_KEY=%q: key = %x", v, cfg.SecretsKey)
+		}
+	}
+	t.Setenv("SECRETS_KEY", "too-short")
+	if _, err := Load(); err == nil {
+		t.Error("short SECRETS_KEY accepted")
+	}
+}
+
+func TestLoad_OIDC(t *testing.T) {
+	os.Clearenv()
+	t.Setenv("TOKEN", "test-token")
+	t.Setenv("OIDC_ISSUER", "https://idp.example.com")
+	t.Setenv("OIDC_CLIENT_ID", "impersonate")
+
+	if _, err := Load(); err == nil {
+		t.Error("Load() should fail without OIDC_REDIRECT_URL")
+	}
+
+	t.Setenv("OIDC_REDIRECT_URL", "https://impersonate.example.com/admin/oidc/callback")
+	t.Setenv("OIDC_ROLE_MAP", "impersonate-admins=owner, sre=operator")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if cfg.OIDCRoleMap["sre"] != "operator" || cfg.OIDCRoleMap["impersonate-admins"] != "owner" {
+		t.Errorf("OIDCRoleMap = %v", cfg.OIDCRoleMap)
+	}
+	if cfg.OIDCGroupsClaim != "groups" || cfg.OIDCUsernameClaim != "email" {
+		t.Errorf("claims = %q, %q; want groups, email", cfg.OIDCGroupsClaim, cfg.OIDCUsernameClaim)
+	}
+
+	t.Setenv("OIDC_ROLE_MAP", "sre")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should fail on a malformed OIDC_ROLE_MAP")
+	}
+}
+
+func TestLoad_TLS(t *testing.T) {
+	os.Clearenv()
+	t.Setenv("TOKEN", "test-token")
+	t.Setenv("TLS_CERT_FILE", "/certs/tls.crt")
+	t.Setenv("TLS_KEY_FILE", "/certs/tls.key")
+	t.Setenv("TLS_CLIENT_CA_FILE", "/certs/ca.crt")
+	t.Setenv("TLS_CLIENT_CERT_MAP", "cn:worker-1=scraper,uri:spiffe://prod/batch=batch")
+	t.Setenv("UNIX_SOCKET", "/run/impersonate.sock")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if cfg.TLSClientCertMap["uri:spiffe://prod/batch"] != "batch" || cfg.UnixSocketMode != 0o660 {
+		t.Errorf("cert map = %v, socket mode = %v", cfg.TLSClientCertMap, cfg.UnixSocketMode)
+	}
+
+	bad := map[string]string{
+		"TLS_KEY_FILE":     "",
+		"UNIX_SOCKET_MODE": "rw",
+	}
+	for key, value := range bad {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			if _, err := Load(); err == nil {
+				t.Errorf("%s=%q accepted", key, value)
+			}
+		})
+	}
+	t.Setenv("TLS_CLIENT_CA_FILE", "")
+	if _, err := Load(); err == nil {
+		t.Error("TLS_CLIENT_CERT_MAP without a client CA accepted")
+	}
+}
+
+func TestLoad_ReportsEveryProblem(t *testing.T) {
+	os.Clearenv()
+	t.Setenv("TOKEN", "test-token")
+	t.Setenv("MAX_TIMEOUT", "abc")
+	t.Setenv("SSRF_ALLOW_HTTP", "yes please")
+	t.Setenv("PORT", "70000")
+	t.Setenv("LOG_LEVEL", "loud")
+
+	_, err := Load()
+	if err == nil {
+		t.Fatal("Load() accepted malformed values")
+	}
+	for _, key := range []string{"MAX_TIMEOUT", "SSRF_ALLOW_HTTP", "PORT", "LOG_LEVEL"} {
+		if !strings.Contains(err.Error(), key+":") {
+			t.Errorf("error does not mention %s:\n%v", key, err)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	tomlPath := write("config.toml", `
+# Service settings
+token = "file-token"
+max_timeout = 90
+ssrf_allow_http = true
+cors_allowed_origins = [
+  "https://a.example.com",
+  'https://b.example.com', # trailing comma is fine
+]
+TLS_CERT_FILE = "/certs/tls.crt"
+tls_key_file = "/certs/tls.key"
+tls_client_ca_file = "/certs/ca.crt"
+
+[tls_client_cert_map]
+"cn:worker-1" = "scraper"
+"uri:spiffe://prod/batch" = "batch"
+`)
+	jsonPath := write("config.json", `{"token": "file-token", "max_timeout": 90, "ssrf_allow_http": true,
+		"cors_allowed_origins": ["https://a.example.com", "https://b.example.com"],
+		"oidc_role_map": {"sre": "operator"}}`)
+
+	for _, path := range []string{tomlPath, jsonPath} {
+		t.Run(filepath.Ext(path), func(t *testing.T) {
+			os.Clearenv()
+			t.Setenv("MAX_TIMEOUT", "60")
+			cfg, err := LoadFile(path)
+			if err != nil {
+				t.Fatalf("LoadFile() failed: %v", err)
+			}
+			if cfg.Token != "file-token" || !cfg.SSRFAllowHTTP || len(cfg.CORSAllowedOrigins) != 2 {
+				t.Errorf("file values not applied: %+v", cfg)
+			}
+			if cfg.MaxTimeout != 60 || cfg.Source("MAX_TIMEOUT") != SourceEnv {
+				t.Errorf("MaxTimeout = %d from %s, want 60 from the environment", cfg.MaxTimeout, cfg.Source("MAX_TIMEOUT"))
+			}
+			if cfg.Source("SSRF_ALLOW_HTTP") != SourceFile || cfg.Source("SSRF_ALLOW_IP") != SourceDefault {
+				t.Errorf("sources = %s, %s", cfg.Source("SSRF_ALLOW_HTTP"), cfg.Source("SSRF_ALLOW_IP"))
+			}
+		})
+	}
+	cfg, _ := LoadFile(tomlPath)
+	if cfg.TLSClientCertMap["uri:spiffe://prod/batch"] != "batch" {
+		t.Errorf("cert map = %v", cfg.TLSClientCertMap)
+	}
+
+	os.Clearenv()
+	bad := write("bad.json", `{"token": "x", "max_timout": 90, "max_request_body_size": "big", "ssrf_allow_ip": 2}`)
+	_, err := LoadFile(bad)
+	if err == nil {
+		t.Fatal("LoadFile() accepted a bad file")
+	}
+	for _, want := range []string{"max_timout (config file): unknown setting", "max_request_body_size (config file)", "ssrf_allow_ip (config file)"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+
+	for name, content := range map[string]string{
+		"syntax.toml":  "token = \"x\nmax_timeout = 1",
+		"float.toml":   "max_timeout = 1.5",
+		"dup.toml":     "token = \"a\"\ntoken = \"b\"",
+		"config.yaml":  "token: x",
+		"nested.json":  `{"token": "x", "ssrf_deny_hosts": [["a"]]}`,
+		"comma.json":   `{"token": "x", "ssrf_deny_hosts": ["a,b"]}`,
+		"trailer.toml": "token = \"x\" y",
+	} {
+		if _, err := LoadFile(write(name, content)); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
+
+func TestConfigChanged(t *testing.T) {
+	os.Clearenv()
+	t.Setenv("TOKEN", "test-token")
+	t.Setenv("MAX_TIMEOUT", "60")
+	a, _ := Load()
+	t.Setenv("MAX_TIMEOUT", "")
+	t.Setenv("PORT", "9090")
+	b, _ := Load()
+	if got := a.Changed(b); len(got) != 2 || got[0] != "MAX_TIMEOUT" || got[1] != "PORT" {
+		t.Errorf("Changed() = %v, want [MAX_TIMEOUT PORT]", got)
+	}
+}
@@ -0,0 +1,387 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// readFile reads a JSON or TOML config file, chosen by its extension, and
+// returns its values in the text form of the matching environment
+// variables, keyed by variable name. Keys are the variable names in any case
+// ("max_timeout" or "MAX_TIMEOUT"); lists are arrays and maps are objects or
+// tables.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	case ".toml":
+		if doc, err = parseTOML(string(data)); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported config file format, want .json or .toml", path)
+	}
+
+	out := map[string]string{}
+	var problems []string
+	for key, value := range doc {
+		name := strings.ToUpper(key)
+		if _, dup := out[name]; dup {
+			problems = append(problems, fmt.Sprintf("%s: set more than once", key))
+			continue
+		}
+		text, err := fileValue(value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", key, err))
+			continue
+		}
+		out[name] = text
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return nil, fmt.Errorf("%s: %s", path, strings.Join(problems, "; "))
+	}
+	return out, nil
+}
+
+// fileValue converts a decoded value to its environment variable form:
+// arrays become comma-separated lists and maps "key=value" lists.
+func fileValue(v any) (string, error) {
+	switch v := v.(type) {
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := scalar(item)
+			if err != nil {
+				return "", err
+			}
+			if strings.Contains(s, ",") {
+				return "", fmt.Errorf("list item %q contains a comma", s)
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		items := make([]string, 0, len(v))
+		for _, k := range keys {
+			s, err := scalar(v[k])
+			if err != nil {
+				return "", err
+			}
+			if strings.ContainsAny(k, ",=") || strings.Contains(s, ",") {
+				return "", fmt.Errorf("map entry %q contains a comma or =", k)
+			}
+			items = append(items, k+"="+s)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return scalar(v)
+}
+
+func scalar(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case json.Number:
+		return v.String(), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case nil:
+		return "", nil
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
+
+// parseTOML parses the subset of TOML a config file needs: key = value
+// pairs holding strings, integers, booleans, arrays and inline tables, plus
+// [table] headers whose keys form a map. Dotted keys, floats, dates and
+// multi-line strings are not supported.
+func parseTOML(s string) (map[string]any, error) {
+	p := &tomlParser{s: s, line: 1}
+	out := map[string]any{}
+	cur := out
+	for {
+		p.skipBlank()
+		if p.eof() {
+			return out, nil
+		}
+		if p.peek() == '[' {
+			p.pos++
+			p.skipSpace()
+			name, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			p.skipSpace()
+			if !p.consume(']') {
+				return nil, p.errorf("expected ] after table name")
+			}
+			if _, dup := out[name]; dup {
+				return nil, p.errorf("%s defined more than once", name)
+			}
+			table := map[string]any{}
+			out[name] = table
+			cur = table
+		} else {
+			key, value, err := p.pair()
+			if err != nil {
+				return nil, err
+			}
+			if _, dup := cur[key]; dup {
+				return nil, p.errorf("%s set more than once", key)
+			}
+			cur[key] = value
+		}
+		if err := p.endLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+type tomlParser struct {
+	s    string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) eof() bool  { return p.pos >= len(p.s) }
+func (p *tomlParser) peek() byte { return p.s[p.pos] }
+
+func (p *tomlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *tomlParser) consume(c byte) bool {
+	if !p.eof() && p.peek() == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// skipSpace skips spaces and tabs.
+func (p *tomlParser) skipSpace() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+// skipBlank skips whitespace, newlines and comments.
+func (p *tomlParser) skipBlank() {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t', '\r':
+			p.pos++
+		case '\n':
+			p.pos++
+			p.line++
+		case '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// endLine expects the rest of the line to be blank or a comment.
+func (p *tomlParser) endLine() error {
+	p.skipSpace()
+	if p.consume('#') {
+		for !p.eof() && p.peek() != '\n' {
+			p.pos++
+		}
+	}
+	p.consume('\r')
+	if !p.eof() && !p.consume('\n') {
+		return p.errorf("unexpected %q", p.peek())
+	}
+	p.line++
+	return nil
+}
+
+func (p *tomlParser) pair() (string, any, error) {
+	key, err := p.key()
+	if err != nil {
+		return "", nil, err
+	}
+	p.skipSpace()
+	if !p.consume('=') {
+		return "", nil, p.errorf("expected = after %s", key)
+	}
+	p.skipSpace()
+	value, err := p.value()
+	if err != nil {
+		return "", nil, err
+	}
+	return key, value, nil
+}
+
+func (p *tomlParser) key() (string, error) {
+	if p.eof() {
+		return "", p.errorf("expected a key")
+	}
+	if c := p.peek(); c == '"' || c == '\'' {
+		return p.str()
+	}
+	start := p.pos
+	for !p.eof() {
+		c := p.peek()
+		if c != '_' && c != '-' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			break
+		}
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("expected a key")
+	}
+	return p.s[start:p.pos], nil
+}
+
+func (p *tomlParser) value() (any, error) {
+	if p.eof() {
+		return nil, p.errorf("expected a value")
+	}
+	switch c := p.peek(); {
+	case c == '"' || c == '\'':
+		return p.str()
+	case c == '[':
+		return p.array()
+	case c == '{':
+		return p.inlineTable()
+	}
+	start := p.pos
+	for !p.eof() && !strings.ContainsRune(" \t\r\n#,]}", rune(p.peek())) {
+		p.pos++
+	}
+	word := p.s[start:p.pos]
+	switch word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	n, err := strconv.ParseInt(strings.ReplaceAll(word, "_", ""), 10, 64)
+	if err != nil {
+		return nil, p.errorf("unsupported value %q", word)
+	}
+	return n, nil
+}
+
+func (p *tomlParser) str() (string, error) {
+	quote := p.peek()
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.peek()
+		p.pos++
+		switch {
+		case c == quote:
+			return b.String(), nil
+		case c == '\\' && quote == '"':
+			if p.eof() {
+				return "", p.errorf("unterminated string")
+			}
+			esc := p.peek()
+			p.pos++
+			switch esc {
+			case '"', '\\':
+				b.WriteByte(esc)
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case 'u':
+				if p.pos+4 > len(p.s) {
+					return "", p.errorf("invalid \\u escape")
+				}
+				r, err := strconv.ParseUint(p.s[p.pos:p.pos+4], 16, 32)
+				if err != nil {
+					return "", p.errorf("invalid \\u escape")
+				}
+				b.WriteRune(rune(r))
+				p.pos += 4
+			default:
+				return "", p.errorf("invalid escape \\%c", esc)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// array parses [a, b, ...]; it may span lines and end with a comma.
+func (p *tomlParser) array() ([]any, error) {
+	p.pos++
+	out := []any{}
+	for {
+		p.skipBlank()
+		if p.consume(']') {
+			return out, nil
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+		p.skipBlank()
+		if p.consume(']') {
+			return out, nil
+		}
+		if !p.consume(',') {
+			return nil, p.errorf("expected , or ] in array")
+		}
+	}
+}
+
+// inlineTable parses { key = value, ... } on one line.
+func (p *tomlParser) inlineTable() (map[string]any, error) {
+	p.pos++
+	out := map[string]any{}
+	for {
+		p.skipSpace()
+		if p.consume('}') {
+			return out, nil
+		}
+		key, value, err := p.pair()
+		if err != nil {
+			return nil, err
+		}
+		out[key] = value
+		p.skipSpace()
+		if p.consume('}') {
+			return out, nil
+		}
+		if !p.consume(',') {
+			return nil, p.errorf("expected , or } in inline table")
+		}
+	}
+}
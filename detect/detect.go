@@ -0,0 +1,209 @@
+// Package detect classifies responses that are bot challenges or block
+// pages (Cloudflare, Akamai, DataDome and the like) using a rules file of
+// status, header, cookie and body signatures.
+package detect
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/zupolgec/curl-impersonate-service/models"
+)
+
+// Kinds of block.
+const (
+	KindCaptcha     = "captcha"
+	KindJSChallenge = "js-challenge"
+	KindRateLimit   = "rate-limit"
+	KindBan         = "ban"
+)
+
+var kinds = []string{KindCaptcha, KindJSChallenge, KindRateLimit, KindBan}
+
+// maxBodyScan bounds how much of a body the body patterns look at. Block
+// pages are small; the signatures sit near the top.
+const maxBodyScan = 256 << 10
+
+// Rule is one block signature. Every condition it sets must match: the
+// status is one of Status, every header in Headers matches its pattern,
+// one of Cookies is set and one of Body matches the body.
+type Rule struct {
+	Name   string `json:"name"`
+	Vendor string `json:"vendor"`
+	Kind   string `json:"kind"`
+	Status []int  `json:"status,omitempty"`
+	// Headers maps response header names to regular expressions matched
+	// against their values.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Cookies are names of cookies the response sets.
+	Cookies []string `json:"cookies,omitempty"`
+	// Body are regular expressions matched against a text body.
+	Body []string `json:"body,omitempty"`
+}
+
+// RulesFile is the layout of the rules file.
+type RulesFile struct {
+	Rules []Rule `json:"rules"`
+}
+
+type rule struct {
+	Rule
+	headers map[string]*regexp.Regexp
+	body    []*regexp.Regexp
+}
+
+// Rules is a compiled rules file. Rules are tried in file order and the
+// first match wins.
+type Rules struct {
+	Path     string
+	LoadedAt time.Time
+	rules    []rule
+}
+
+var current atomic.Pointer[Rules]
+
+// Current returns the loaded rules, or nil if none are.
+func Current() *Rules { return current.Load() }
+
+// Load reads and compiles the rules file at path and makes it current. An
+// empty path switches detection off. On error the current rules are kept.
+func Load(path string) error {
+	if path == "" {
+		current.Store(nil)
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	rules, err := Parse(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	rules.Path = path
+	current.Store(rules)
+	return nil
+}
+
+// Parse compiles a rules file, reporting every invalid rule.
+func Parse(data []byte) (*Rules, error) {
+	var file RulesFile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("parse rules: %w", err)
+	}
+	out := &Rules{LoadedAt: time.Now()}
+	var errs []error
+	seen := map[string]bool{}
+	for i, r := range file.Rules {
+		c, err := compile(r)
+		if err == nil && seen[r.Name] {
+			err = fmt.Errorf("duplicate name")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %d (%s): %w", i+1, r.Name, err))
+			continue
+		}
+		seen[r.Name] = true
+		out.rules = append(out.rules, c)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return out, nil
+}
+
+func compile(r Rule) (rule, error) {
+	c := rule{Rule: r, headers: map[string]*regexp.Regexp{}}
+	switch {
+	case r.Name == "":
+		return c, fmt.Errorf("name is required")
+	case r.Vendor == "":
+		return c, fmt.Errorf("vendor is required")
+	case !slices.Contains(kinds, r.Kind):
+		return c, fmt.Errorf("kind must be one of %s", strings.Join(kinds, ", "))
+	case len(r.Status)+len(r.Headers)+len(r.Cookies)+len(r.Body) == 0:
+		return c, fmt.Errorf("no conditions")
+	}
+	for name, expr := range r.Headers {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return c, fmt.Errorf("header %s: %w", name, err)
+		}
+		c.headers[http.CanonicalHeaderKey(name)] = re
+	}
+	for _, expr := range r.Body {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return c, fmt.Errorf("body: %w", err)
+		}
+		c.body = append(c.body, re)
+	}
+	return c, nil
+}
+
+// Len returns the number of rules.
+func (rs *Rules) Len() int { return len(rs.rules) }
+
+// List returns the rules in the order they are tried.
+func (rs *Rules) List() []Rule {
+	out := make([]Rule, len(rs.rules))
+	for i, r := range rs.rules {
+		out[i] = r.Rule
+	}
+	return out
+}
+
+// Detect returns the block resp is, or nil. Failed requests are never
+// blocks: there is no response to classify.
+func (rs *Rules) Detect(resp *models.ImpersonateResponse) *models.Block {
+	if rs == nil || !resp.Success {
+		return nil
+	}
+	body := ""
+	if !resp.BodyBase64 {
+		body = resp.Body[:min(len(resp.Body), maxBodyScan)]
+	}
+	for _, r := range rs.rules {
+		if r.match(resp, body) {
+			return &models.Block{Vendor: r.Vendor, Kind: r.Kind, Rule: r.Name}
+		}
+	}
+	return nil
+}
+
+func (r rule) match(resp *models.ImpersonateResponse, body string) bool {
+	if len(r.Status) > 0 && !slices.Contains(r.Status, resp.StatusCode) {
+		return false
+	}
+	for name, re := range r.headers {
+		if !slices.ContainsFunc(resp.Headers[name], re.MatchString) {
+			return false
+		}
+	}
+	if len(r.Cookies) > 0 && !slices.ContainsFunc(resp.Headers["Set-Cookie"], func(c string) bool {
+		name, _, _ := strings.Cut(c, "=")
+		return slices.Contains(r.Cookies, strings.TrimSpace(name))
+	}) {
+		return false
+	}
+	if len(r.body) > 0 && !slices.ContainsFunc(r.body, func(re *regexp.Regexp) bool { return re.MatchString(body) }) {
+		return false
+	}
+	return true
+}
+
+// Detect classifies resp with the current rules.
+func Detect(resp *models.ImpersonateResponse) *models.Block {
+	return Current().Detect(resp)
+}
@@ -0,0 +1,119 @@
+package detect
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/zupolgec/curl-impersonate-service/models"
+)
+
+func TestShippedRules(t *testing.T) {
+	data, err := os.ReadFile("../block_rules.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		resp models.ImpersonateResponse
+		want string // vendor/kind, "" for no block
+	}{
+		{
+			name: "cloudflare managed challenge",
+			resp: models.ImpersonateResponse{StatusCode: 403, Headers: map[string][]string{"Cf-Mitigated": {"challenge"}}},
+			want: "cloudflare/js-challenge",
+		},
+		{
+			name: "cloudflare interstitial",
+			resp: models.ImpersonateResponse{StatusCode: 503, Headers: map[string][]string{"Server": {"cloudflare"}},
+				Body: "<html><head><title>Just a moment...</title>"},
+			want: "cloudflare/js-challenge",
+		},
+		{
+			name: "cloudflare 403 without a block page",
+			resp: models.ImpersonateResponse{StatusCode: 403, Headers: map[string][]string{"Server": {"cloudflare"}}, Body: "forbidden"},
+		},
+		{
+			name: "datadome captcha",
+			resp: models.ImpersonateResponse{StatusCode: 403, Body: `<script src="https://ct.captcha-delivery.com/c.js">`},
+			want: "datadome/captcha",
+		},
+		{
+			name: "akamai cookie on 403",
+			resp: models.ImpersonateResponse{StatusCode: 403, Headers: map[string][]string{"Set-Cookie": {"_abck=abc; Path=/"}}},
+			want: "akamai/js-challenge",
+		},
+		{
+			name: "akamai cookie on 200",
+			resp: models.ImpersonateResponse{StatusCode: 200, Headers: map[string][]string{"Set-Cookie": {"_abck=abc; Path=/"}}},
+		},
+		{
+			name: "generic rate limit",
+			resp: models.ImpersonateResponse{StatusCode: 429},
+			want: "generic/rate-limit",
+		},
+		{
+			name: "binary body is not scanned",
+			resp: models.ImpersonateResponse{StatusCode: 403, Body: "captcha-delivery.com", BodyBase64: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.resp.Success = true
+			got := ""
+			if b := rules.Detect(&tt.resp); b != nil {
+				got = b.Vendor + "/" + b.Kind
+			}
+			if got != tt.want {
+				t.Errorf("Detect() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if b := rules.Detect(&models.ImpersonateResponse{Success: false, StatusCode: 429}); b != nil {
+		t.Errorf("failed request detected as %+v", b)
+	}
+}
+
+func TestParseReportsEveryBadRule(t *testing.T) {
+	_, err := Parse([]byte(`{"rules": [
+		{"name": "a", "vendor": "x", "kind": "captcha", "status": [403]},
+		{"name": "a", "vendor": "x", "kind": "captcha", "status": [403]},
+		{"name": "b", "vendor": "x", "kind": "tarpit", "status": [403]},
+		{"name": "c", "vendor": "x", "kind": "ban"},
+		{"name": "d", "vendor": "x", "kind": "ban", "body": ["("]}
+	]}`))
+	if err == nil {
+		t.Fatal("Parse accepted bad rules")
+	}
+	for _, want := range []string{"rule 2 (a): duplicate name", "rule 3 (b): kind", "rule 4 (c): no conditions", "rule 5 (d): body"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+	if _, err := Parse([]byte(`{"rules": [], "extra": 1}`)); err == nil {
+		t.Error("unknown field accepted")
+	}
+}
+
+func TestLoadKeepsRulesOnError(t *testing.T) {
+	t.Cleanup(func() { current.Store(nil) })
+	if err := Load("../block_rules.json"); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	before := Current()
+	if err := Load("missing.json"); err == nil {
+		t.Fatal("Load of a missing file succeeded")
+	}
+	if Current() != before {
+		t.Error("failed Load replaced the rules")
+	}
+	if err := Load(""); err != nil || Current() != nil {
+		t.Errorf("Load(\"\") = %v, rules %v; want detection off", err, Current())
+	}
+}
@@ -0,0 +1,73 @@
+package executor
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"net"
+	"strings"
+
+	"github.com/zupolgec/curl-impersonate-service/models"
+)
+
+// certChain describes the certificates in text, which curl's certinfo
+// (CURLINFO_CERTINFO, or --write-out %{certs}) reports with each
+// certificate's PEM. Anything that is not a PEM certificate is skipped.
+func certChain(text string, withPEM bool) []models.Certificate {
+	var chain []models.Certificate
+	// curl prefixes the PEM with "Cert:", which pem.Decode would not see.
+	rest := []byte(strings.ReplaceAll(text, "Cert:-----BEGIN", "Cert:\n-----BEGIN"))
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return chain
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		sum := sha256.Sum256(cert.Raw)
+		c := models.Certificate{
+			Subject:           cert.Subject.String(),
+			Issuer:            cert.Issuer.String(),
+			SANs:              certSANs(cert),
+			SerialNumber:      strings.ToUpper(cert.SerialNumber.Text(16)),
+			NotBefore:         cert.NotBefore.UTC(),
+			NotAfter:          cert.NotAfter.UTC(),
+			FingerprintSHA256: strings.ToUpper(hex.EncodeToString(sum[:])),
+		}
+		if withPEM {
+			c.PEM = string(pem.EncodeToMemory(block))
+		}
+		chain = append(chain, c)
+	}
+}
+
+// certSANs lists a certificate's DNS names, IP addresses, emails and URIs.
+func certSANs(cert *x509.Certificate) []string {
+	sans := append([]string(nil), cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, net.IP.String(ip))
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		sans = append(sans, u.String())
+	}
+	return sans
+}
+
+// certProbeTimeout caps, in seconds, the extra handshake that fetches a
+// chain that failed verification.
+const certProbeTimeout = 10
+
+// wantsFailedChain reports whether resp failed certificate verification and
+// req asked for the chain, which curl does not report then: the executors
+// fetch it again without verification.
+func wantsFailedChain(req *models.ImpersonateRequest, resp *models.ImpersonateResponse) bool {
+	return req.IncludeCertInfo && !req.Insecure && resp.ErrorCode == models.ErrorCodeCertInvalid
+}
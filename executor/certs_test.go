@@ -0,0 +1,90 @@
+package executor
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zupolgec/curl-impersonate-service/models"
+)
+
+func testCertPEM(t *testing.T, cn string) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(0xabc123),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+		DNSNames:     []string{cn, "www." + cn},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestCertChain(t *testing.T) {
+	leaf, issuer := testCertPEM(t, "example.com"), testCertPEM(t, "ca.example")
+	// The shape of curl's certinfo: "name:value" lines per certificate.
+	text := "Subject:CN = example.com\nIssuer:CN = example.com\nVersion:2\nCert:" + leaf +
+		"Subject:CN = ca.example\nCert:" + issuer
+
+	chain := certChain(text, false)
+	if len(chain) != 2 {
+		t.Fatalf("got %d certificates, want 2", len(chain))
+	}
+	c := chain[0]
+	if c.Subject != "CN=example.com" || c.Issuer != "CN=example.com" || c.SerialNumber != "ABC123" {
+		t.Errorf("leaf = %+v", c)
+	}
+	if strings.Join(c.SANs, ",") != "example.com,www.example.com,127.0.0.1" {
+		t.Errorf("SANs = %v", c.SANs)
+	}
+	if !c.NotAfter.Equal(time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)) || len(c.FingerprintSHA256) != 64 || c.PEM != "" {
+		t.Errorf("leaf = %+v", c)
+	}
+	if chain[1].Subject != "CN=ca.example" {
+		t.Errorf("issuer = %+v", chain[1])
+	}
+
+	if withPEM := certChain(text, true); withPEM[0].PEM != leaf {
+		t.Errorf("PEM = %q, want %q", withPEM[0].PEM, leaf)
+	}
+	if chain := certChain("Subject:CN = x\nCert:-----BEGIN CERTIFICATE-----\nbm90IGEgY2VydA==\n-----END CERTIFICATE-----\n", false); len(chain) != 0 {
+		t.Errorf("invalid certificate parsed: %+v", chain)
+	}
+}
+
+func TestWantsFailedChain(t *testing.T) {
+	certFailure := &models.ImpersonateResponse{ErrorType: models.ErrorTypeSSL, ErrorCode: models.ErrorCodeCertInvalid}
+	handshakeFailure := &models.ImpersonateResponse{ErrorType: models.ErrorTypeSSL, ErrorCode: models.ErrorCodeTLSHandshake}
+	tests := []struct {
+		req  models.ImpersonateRequest
+		resp *models.ImpersonateResponse
+		want bool
+	}{
+		{models.ImpersonateRequest{IncludeCertInfo: true}, certFailure, true},
+		{models.ImpersonateRequest{}, certFailure, false},
+		{models.ImpersonateRequest{IncludeCertInfo: true, Insecure: true}, certFailure, false},
+		{models.ImpersonateRequest{IncludeCertInfo: true}, handshakeFailure, false},
+	}
+	for i, tt := range tests {
+		if got := wantsFailedChain(&tt.req, tt.resp); got != tt.want {
+			t.Errorf("case %d: got %v, want %v", i, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zupolgec/curl-impersonate-service/models"
+)
+
+// credentialArgs writes a request's TLS credentials to files only the
+// service can read, for the curl tool, which takes them by path. remove
+// deletes the files; call it once curl has exited.
+func credentialArgs(creds *models.TLSCredentials) (args []string, remove func(), err error) {
+	remove = func() {}
+	if creds == nil {
+		return nil, remove, nil
+	}
+	dir, err := os.MkdirTemp("", "impersonate-tls-")
+	if err != nil {
+		return nil, remove, fmt.Errorf("tls credentials: %w", err)
+	}
+	remove = func() { _ = os.RemoveAll(dir) }
+
+	files := []struct{ flag, name, pem string }{
+		{"--cacert", "ca.pem", creds.CAPEM},
+		{"--cert", "cert.pem", creds.CertPEM},
+		{"--key", "key.pem", creds.KeyPEM},
+	}
+	for _, f := range files {
+		if f.pem == "" {
+			continue
+		}
+		path := filepath.Join(dir, f.name)
+		if err := os.WriteFile(path, []byte(f.pem), 0o600); err != nil {
+			remove()
+			return nil, func() {}, fmt.Errorf("tls credentials: %w", err)
+		}
+		args = append(args, f.flag, path)
+	}
+	return args, remove, nil
+}
@@ -0,0 +1,38 @@
+package executor
+
+import (
+	"os"
+	"testing"
+
+	"github.com/zupolgec/curl-impersonate-service/models"
+)
+
+func TestCredentialArgs(t *testing.T) {
+	args, remove, err := credentialArgs(&models.TLSCredentials{CAPEM: "ca", CertPEM: "cert", KeyPEM: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 6 || args[0] != "--cacert" || args[2] != "--cert" || args[4] != "--key" {
+		t.Fatalf("args = %q", args)
+	}
+	info, err := os.Stat(args[5])
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("key file: %v, %v", info, err)
+	}
+	if b, _ := os.ReadFile(args[5]); string(b) != "key" {
+		t.Errorf("key file = %q", b)
+	}
+	remove()
+	if _, err := os.Stat(args[1]); !os.IsNotExist(err) {
+		t.Errorf("CA file not removed: %v", err)
+	}
+
+	args, remove, err = credentialArgs(&models.TLSCredentials{CAPEM: "ca"})
+	if err != nil || len(args) != 2 {
+		t.Fatalf("CA only: %q, %v", args, err)
+	}
+	remove()
+	if args, _, err := credentialArgs(nil); err != nil || args != nil {
+		t.Fatalf("no credentials: %q, %v", args, err)
+	}
+}
@@ -3,11 +3,5 @@
 
 package executor
 
-import (
-	"github.com/zupolgec/curl-impersonate-service/models"
-)
-
-// Execute runs curl-impersonate with the given request (non-CGO version uses shell)
-func Execute(req *models.ImpersonateRequest, browserConfig models.BrowserConfig, maxResponseSize int64) (*models.ImpersonateResponse, error) {
-	return executeShell(req, browserConfig, maxResponseSize)
-}
+// Default returns the executor of this build: without CGO, Shell.
+func Default() Executor { return Shell{} }
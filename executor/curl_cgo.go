@@ -37,6 +37,24 @@ size_t write_callback(void *ptr, size_t size, size_t nmemb, void *userdata) {
 
     return realsize;
 }
+
+// Callback collecting curl's informational verbose text, which reports the
+// TLS version, cipher and ALPN. Text past the buffer's cap is dropped.
+int debug_callback(CURL *handle, int type, char *data, size_t size, void *userdata) {
+    struct resp_buffer *mem = userdata;
+
+    if (type != CURLINFO_TEXT || mem->size + size > mem->maxsize) return 0;
+
+    char *ptr2 = realloc(mem->data, mem->size + size + 1);
+    if (ptr2 == NULL) return 0;
+
+    mem->data = ptr2;
+    memcpy(&(mem->data[mem->size]), data, size);
+    mem->size += size;
+    mem->data[mem->size] = 0;
+
+    return 0;
+}
 */
 import "C"
 
@@ -57,7 +75,59 @@ type responseBuffer struct {
 	overflow C.int
 }
 
-func Execute(req *models.ImpersonateRequest, browserConfig models.BrowserConfig, maxResponseSize int64) (*models.ImpersonateResponse, error) {
+// Libcurl runs requests in-process with libcurl-impersonate.
+type Libcurl struct{}
+
+// Default returns the executor of this build: with CGO, Libcurl.
+func Default() Executor { return Libcurl{} }
+
+// SupportsTarget reports whether libcurl-impersonate can impersonate
+// browserConfig.
+func (Libcurl) SupportsTarget(browserConfig models.BrowserConfig) error {
+	curl := C.curl_easy_init()
+	if curl == nil {
+		return fmt.Errorf("failed to initialize curl")
+	}
+	defer C.curl_easy_cleanup(curl)
+	cBrowser := C.CString(browserConfig.Name)
+	defer C.free(unsafe.Pointer(cBrowser))
+	if res := C.curl_easy_impersonate(curl, cBrowser, 0); res != 0 {
+		return fmt.Errorf("not a libcurl-impersonate target (code %d)", int(res))
+	}
+	return nil
+}
+
+// setProfileOptions applies a custom profile's TLS and HTTP/2 settings,
+// overriding those curl_easy_impersonate set for the base. libcurl copies
+// string options, so the C strings are freed on return.
+func setProfileOptions(curl unsafe.Pointer, p *models.Profile) {
+	setString := func(option C.CURLoption, value string) {
+		if value == "" {
+			return
+		}
+		cValue := C.CString(value)
+		defer C.free(unsafe.Pointer(cValue))
+		C._curl_easy_setopt_ptr(curl, option, unsafe.Pointer(cValue))
+	}
+	if t := p.TLS; t != nil {
+		setString(C.CURLOPT_SSL_CIPHER_LIST, t.Ciphers)
+		setString(C.CURLOPT_SSL_EC_CURVES, t.Curves)
+		setString(C.CURLOPT_SSL_SIG_HASH_ALGS, t.SignatureHashes)
+		setString(C.CURLOPT_TLS_EXTENSION_ORDER, t.ExtensionOrder)
+		if t.PermuteExtensions {
+			C._curl_easy_setopt_long(curl, C.CURLOPT_SSL_PERMUTE_EXTENSIONS, 1)
+		}
+	}
+	if h := p.HTTP2; h != nil {
+		setString(C.CURLOPT_HTTP2_PSEUDO_HEADERS_ORDER, h.PseudoHeadersOrder)
+		setString(C.CURLOPT_HTTP2_SETTINGS, h.Settings)
+		if h.WindowUpdate > 0 {
+			C._curl_easy_setopt_long(curl, C.CURLOPT_HTTP2_WINDOW_UPDATE, C.long(h.WindowUpdate))
+		}
+	}
+}
+
+func (Libcurl) Execute(req *models.ImpersonateRequest, browserConfig models.BrowserConfig, maxResponseSize int64) (*models.ImpersonateResponse, error) {
 	// The lexiforest fork ships a single unified libcurl-impersonate that
 	// supports every target (Chrome, Firefox, Safari, Edge, Tor), so all
 	// browsers go through the CGO path.
@@ -84,12 +154,15 @@ func Execute(req *models.ImpersonateRequest, browserConfig models.BrowserConfig,
 	C._curl_easy_setopt_ptr(curl, C.CURLOPT_CUSTOMREQUEST, unsafe.Pointer(cMethod))
 
 	// Set Impersonate
-	cBrowser := C.CString(browserConfig.Name)
+	cBrowser := C.CString(browserConfig.Target())
 	defer C.free(unsafe.Pointer(cBrowser))
 	// 1 means add default headers
 	impersonateRes := C.curl_easy_impersonate(curl, cBrowser, 1)
 	if impersonateRes != 0 {
-		return nil, fmt.Errorf("failed to impersonate %s: %d", browserConfig.Name, int(impersonateRes))
+		return nil, fmt.Errorf("failed to impersonate %s: %d", browserConfig.Target(), int(impersonateRes))
+	}
+	if browserConfig.Profile != nil {
+		setProfileOptions(curl, browserConfig.Profile)
 	}
 
 	// Advertise every compression format supported by this libcurl build and
@@ -100,8 +173,7 @@ func Execute(req *models.ImpersonateRequest, browserConfig models.BrowserConfig,
 
 	// Set Headers
 	var headerList *C.struct_curl_slist
-	for k, v := range req.Headers {
-		header := fmt.Sprintf("%s: %s", k, v)
+	for _, header := range browserConfig.RequestHeaders(req.Headers) {
 		cHeader := C.CString(header)
 		headerList = C.curl_slist_append(headerList, cHeader)
 		C.free(unsafe.Pointer(cHeader))
@@ -149,6 +221,21 @@ func Execute(req *models.ImpersonateRequest, browserConfig models.BrowserConfig,
 		C._curl_easy_setopt_long(curl, 81, 0)
 	}
 
+	// Route through an upstream proxy if requested (replays only)
+	if req.Proxy != "" {
+		cProxy := C.CString(req.Proxy)
+		defer C.free(unsafe.Pointer(cProxy))
+		C._curl_easy_setopt_ptr(curl, C.CURLOPT_PROXY, unsafe.Pointer(cProxy))
+	}
+
+	if req.Credentials != nil {
+		setCredentials(curl, req.Credentials)
+	}
+
+	if req.IncludeCertInfo {
+		C._curl_easy_setopt_long(curl, C.CURLOPT_CERTINFO, 1)
+	}
+
 	// Setup Response Buffers
 	var respBuf responseBuffer
 	var headerBuf responseBuffer
@@ -163,42 +250,52 @@ func Execute(req *models.ImpersonateRequest, browserConfig models.BrowserConfig,
 	C._curl_easy_setopt_ptr(curl, C.CURLOPT_HEADERFUNCTION, unsafe.Pointer(C.write_callback))
 	C._curl_easy_setopt_ptr(curl, C.CURLOPT_HEADERDATA, unsafe.Pointer(&headerBuf))
 
+	// Detailed error message, e.g. "Failed to connect to ... : Connection
+	// refused" rather than curl_easy_strerror's "Couldn't connect to server"
+	errBuf := (*C.char)(C.calloc(C.CURL_ERROR_SIZE, 1))
+	defer C.free(unsafe.Pointer(errBuf))
+	C._curl_easy_setopt_ptr(curl, C.CURLOPT_ERRORBUFFER, unsafe.Pointer(errBuf))
+
+	// Verbose text, for the TLS details curl_easy_getinfo does not report
+	verboseBuf := responseBuffer{maxsize: 64 * 1024}
+	C._curl_easy_setopt_long(curl, C.CURLOPT_VERBOSE, 1)
+	C._curl_easy_setopt_ptr(curl, C.CURLOPT_DEBUGFUNCTION, unsafe.Pointer(C.debug_callback))
+	C._curl_easy_setopt_ptr(curl, C.CURLOPT_DEBUGDATA, unsafe.Pointer(&verboseBuf))
+
 	// Execute
 	res := C.curl_easy_perform(curl)
 
 	// Handle Cleanup for buffers
 	defer func() {
-		if respBuf.data != nil {
-			C.free(unsafe.Pointer(respBuf.data))
-		}
-		if headerBuf.data != nil {
-			C.free(unsafe.Pointer(headerBuf.data))
+		for _, buf := range []*responseBuffer{&respBuf, &headerBuf, &verboseBuf} {
+			if buf.data != nil {
+				C.free(unsafe.Pointer(buf.data))
+			}
 		}
 	}()
 
+	info := getTransferInfo(curl)
+	conn := info.connection()
+	if verboseBuf.data != nil {
+		setTLSInfo(conn, C.GoStringN(verboseBuf.data, C.int(verboseBuf.size)))
+	}
+
 	if res != C.CURLE_OK {
+		var resp *models.ImpersonateResponse
 		if respBuf.overflow != 0 || headerBuf.overflow != 0 {
-			return &models.ImpersonateResponse{
-				Success:   false,
-				Error:     "response exceeds maximum allowed size",
-				ErrorType: "size",
-			}, nil
+			resp = sizeFailure(int(res))
+		} else {
+			errStr := C.GoString(errBuf)
+			if errStr == "" {
+				errStr = C.GoString(C.curl_easy_strerror(res))
+			}
+			resp = curlFailure(int(res), errStr)
 		}
-		errStr := C.GoString(C.curl_easy_strerror(res))
-		errorType := "network"
-		if res == C.CURLE_OPERATION_TIMEDOUT {
-			errorType = "timeout"
-		} else if res == C.CURLE_COULDNT_RESOLVE_HOST {
-			errorType = "dns"
-		} else if res == C.CURLE_SSL_CONNECT_ERROR || res == C.CURLE_PEER_FAILED_VERIFICATION {
-			errorType = "ssl"
+		resp.Timing, resp.Connection = info.timing(), conn
+		if wantsFailedChain(req, resp) {
+			resp.Certificates = probeCertChain(req, browserConfig, finalURL)
 		}
-
-		return &models.ImpersonateResponse{
-			Success:   false,
-			Error:     errStr,
-			ErrorType: errorType,
-		}, nil
+		return resp, nil
 	}
 
 	// Get Status Code
@@ -209,13 +306,6 @@ func Execute(req *models.ImpersonateRequest, browserConfig models.BrowserConfig,
 	var finalURLPtr *C.char
 	C._curl_easy_getinfo_ptr(curl, C.CURLINFO_EFFECTIVE_URL, unsafe.Pointer(&finalURLPtr))
 
-	// Get Timings
-	var tTotal, tName, tConnect, tStart C.double
-	C._curl_easy_getinfo_ptr(curl, C.CURLINFO_TOTAL_TIME, unsafe.Pointer(&tTotal))
-	C._curl_easy_getinfo_ptr(curl, C.CURLINFO_NAMELOOKUP_TIME, unsafe.Pointer(&tName))
-	C._curl_easy_getinfo_ptr(curl, C.CURLINFO_CONNECT_TIME, unsafe.Pointer(&tConnect))
-	C._curl_easy_getinfo_ptr(curl, C.CURLINFO_STARTTRANSFER_TIME, unsafe.Pointer(&tStart))
-
 	// Parse headers
 	headers := make(map[string][]string)
 	if headerBuf.data != nil {
@@ -239,12 +329,11 @@ func Execute(req *models.ImpersonateRequest, browserConfig models.BrowserConfig,
 		StatusCode: int(statusCode),
 		Headers:    headers,
 		FinalURL:   C.GoString(finalURLPtr),
-		Timing: &models.Timing{
-			Total:         float64(tTotal),
-			NameLookup:    float64(tName),
-			Connect:       float64(tConnect),
-			StartTransfer: float64(tStart),
-		},
+		Timing:     info.timing(),
+		Connection: conn,
+	}
+	if req.IncludeCertInfo {
+		response.Certificates = certChain(getCertInfo(curl), req.IncludeCertPEM)
 	}
 
 	// Body handling
@@ -256,3 +345,133 @@ func Execute(req *models.ImpersonateRequest, browserConfig models.BrowserConfig,
 
 	return response, nil
 }
+
+// getTransferInfo reads the timings and connection info of the last transfer,
+// the same fields the shell executor gets from curl's --write-out.
+func getTransferInfo(curl unsafe.Pointer) transferInfo {
+	getDouble := func(info C.CURLINFO) float64 {
+		var v C.double
+		C._curl_easy_getinfo_ptr(curl, info, unsafe.Pointer(&v))
+		return float64(v)
+	}
+	getLong := func(info C.CURLINFO) int64 {
+		var v C.long
+		C._curl_easy_getinfo_ptr(curl, info, unsafe.Pointer(&v))
+		return int64(v)
+	}
+	// curl_off_t values; CURLINFO_QUEUE_TIME_T is in microseconds
+	getOffT := func(info C.CURLINFO) int64 {
+		var v C.longlong
+		C._curl_easy_getinfo_ptr(curl, info, unsafe.Pointer(&v))
+		return int64(v)
+	}
+	getString := func(info C.CURLINFO) string {
+		var v *C.char
+		C._curl_easy_getinfo_ptr(curl, info, unsafe.Pointer(&v))
+		if v == nil {
+			return ""
+		}
+		return C.GoString(v)
+	}
+
+	return transferInfo{
+		CurlTiming: CurlTiming{
+			TimeTotal:         getDouble(C.CURLINFO_TOTAL_TIME),
+			TimeNameLookup:    getDouble(C.CURLINFO_NAMELOOKUP_TIME),
+			TimeConnect:       getDouble(C.CURLINFO_CONNECT_TIME),
+			TimeAppConnect:    getDouble(C.CURLINFO_APPCONNECT_TIME),
+			TimePreTransfer:   getDouble(C.CURLINFO_PRETRANSFER_TIME),
+			TimeStartTransfer: getDouble(C.CURLINFO_STARTTRANSFER_TIME),
+			TimeRedirect:      getDouble(C.CURLINFO_REDIRECT_TIME),
+			TimeQueue:         float64(getOffT(C.CURLINFO_QUEUE_TIME_T)) / 1e6,
+		},
+		RemoteIP:    getString(C.CURLINFO_PRIMARY_IP),
+		RemotePort:  int(getLong(C.CURLINFO_PRIMARY_PORT)),
+		LocalIP:     getString(C.CURLINFO_LOCAL_IP),
+		LocalPort:   int(getLong(C.CURLINFO_LOCAL_PORT)),
+		HTTPVersion: httpVersions[int(getLong(C.CURLINFO_HTTP_VERSION))],
+		NumConnects: int(getLong(C.CURLINFO_NUM_CONNECTS)),
+		SizeRequest: getLong(C.CURLINFO_REQUEST_SIZE),
+		SizeUpload:  getOffT(C.CURLINFO_SIZE_UPLOAD_T),
+		SizeHeader:  getLong(C.CURLINFO_HEADER_SIZE),
+		SizeDown:    getOffT(C.CURLINFO_SIZE_DOWNLOAD_T),
+	}
+}
+
+// setCredentials passes a request's CA bundle and client certificate to
+// libcurl from memory. libcurl copies the blobs, so they are wiped and freed
+// on return.
+func setCredentials(curl unsafe.Pointer, creds *models.TLSCredentials) {
+	setBlob := func(option C.CURLoption, pem string) {
+		if pem == "" {
+			return
+		}
+		data := C.CBytes([]byte(pem))
+		defer func() {
+			C.memset(data, 0, C.size_t(len(pem)))
+			C.free(data)
+		}()
+		blob := C.struct_curl_blob{data: data, len: C.size_t(len(pem)), flags: C.CURL_BLOB_COPY}
+		C._curl_easy_setopt_ptr(curl, option, unsafe.Pointer(&blob))
+	}
+	setBlob(C.CURLOPT_CAINFO_BLOB, creds.CAPEM)
+	setBlob(C.CURLOPT_SSLCERT_BLOB, creds.CertPEM)
+	setBlob(C.CURLOPT_SSLKEY_BLOB, creds.KeyPEM)
+}
+
+// getCertInfo returns the CURLINFO_CERTINFO entries of the last transfer,
+// one per line.
+func getCertInfo(curl unsafe.Pointer) string {
+	var ci *C.struct_curl_certinfo
+	C._curl_easy_getinfo_ptr(curl, C.CURLINFO_CERTINFO, unsafe.Pointer(&ci))
+	if ci == nil || ci.num_of_certs <= 0 {
+		return ""
+	}
+	var b strings.Builder
+	for _, list := range unsafe.Slice(ci.certinfo, int(ci.num_of_certs)) {
+		for node := list; node != nil; node = node.next {
+			b.WriteString(C.GoString(node.data))
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// probeCertChain fetches the chain that failed verification: it repeats the
+// TLS handshake without verification and stops before sending the request.
+func probeCertChain(req *models.ImpersonateRequest, browserConfig models.BrowserConfig, finalURL string) []models.Certificate {
+	curl := C.curl_easy_init()
+	if curl == nil {
+		return nil
+	}
+	defer C.curl_easy_cleanup(curl)
+
+	cBrowser := C.CString(browserConfig.Target())
+	defer C.free(unsafe.Pointer(cBrowser))
+	if C.curl_easy_impersonate(curl, cBrowser, 1) != 0 {
+		return nil
+	}
+	if browserConfig.Profile != nil {
+		setProfileOptions(curl, browserConfig.Profile)
+	}
+
+	cURL := C.CString(finalURL)
+	defer C.free(unsafe.Pointer(cURL))
+	C._curl_easy_setopt_ptr(curl, C.CURLOPT_URL, unsafe.Pointer(cURL))
+	if req.Proxy != "" {
+		cProxy := C.CString(req.Proxy)
+		defer C.free(unsafe.Pointer(cProxy))
+		C._curl_easy_setopt_ptr(curl, C.CURLOPT_PROXY, unsafe.Pointer(cProxy))
+	}
+	C._curl_easy_setopt_long(curl, C.CURLoption(181), 2) // CURLOPT_PROTOCOLS: https only
+	C._curl_easy_setopt_long(curl, C.CURLOPT_TIMEOUT, C.long(min(req.Timeout, certProbeTimeout)))
+	C._curl_easy_setopt_long(curl, 64, 0) // CURLOPT_SSL_VERIFYPEER
+	C._curl_easy_setopt_long(curl, 81, 0) // CURLOPT_SSL_VERIFYHOST
+	C._curl_easy_setopt_long(curl, C.CURLOPT_CERTINFO, 1)
+	C._curl_easy_setopt_long(curl, C.CURLOPT_CONNECT_ONLY, 1)
+	// The client certificate is never offered to a server that failed
+	// verification. One that requires it aborts the handshake; whatever
+	// chain was received before that is returned, possibly none.
+	_ = C.curl_easy_perform(curl)
+	return certChain(getCertInfo(curl), req.IncludeCertPEM)
+}
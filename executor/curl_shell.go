@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
 	"os/exec"
 	"strconv"
 	"strings"
@@ -17,9 +19,27 @@ type CurlTiming struct {
 	TimeTotal         float64 `json:"time_total"`
 	TimeNameLookup    float64 `json:"time_namelookup"`
 	TimeConnect       float64 `json:"time_connect"`
+	TimeAppConnect    float64 `json:"time_appconnect"`
+	TimePreTransfer   float64 `json:"time_pretransfer"`
 	TimeStartTransfer float64 `json:"time_starttransfer"`
+	TimeRedirect      float64 `json:"time_redirect"`
+	TimeQueue         float64 `json:"time_queue"`
 }
 
+// writeOut is the --write-out %{json} trailer curl prints after the
+// response, also when the transfer fails.
+type writeOut struct {
+	transferInfo
+	ExitCode int    `json:"exitcode"`
+	ErrorMsg string `json:"errormsg"`
+	// Certs is the peer's certificate chain; %{json} turns on curl's
+	// certinfo.
+	Certs string `json:"certs"`
+}
+
+// writeOutMarker separates the response from the write-out trailer.
+const writeOutMarker = "\n---TIMING---\n"
+
 // executeShell runs curl-impersonate via shell wrapper script
 func executeShell(req *models.ImpersonateRequest, browserConfig models.BrowserConfig, maxResponseSize int64) (*models.ImpersonateResponse, error) {
 	// Merge query params
@@ -32,27 +52,94 @@ func executeShell(req *models.ImpersonateRequest, browserConfig models.BrowserCo
 	wrapperScript := "/usr/local/bin/" + browserConfig.WrapperScript
 
 	// Build curl command
-	args, err := buildCurlArgs(req, finalURL, maxResponseSize)
+	args, err := buildCurlArgs(req, browserConfig, finalURL, maxResponseSize)
 	if err != nil {
 		return nil, err
 	}
+	credArgs, removeCreds, err := credentialArgs(req.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	defer removeCreds()
+	args = append(credArgs, args...)
 
 	// Execute curl via wrapper script
 	cmd := exec.Command(wrapperScript, args...)
-	output, err := cmd.CombinedOutput()
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
 
-	// Parse response even if there's an error (might be network error)
+	// curl exits with its error code when the transfer fails
 	if err != nil {
-		return parseErrorResponse(output, err)
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("run %s: %w", wrapperScript, err)
+		}
+		resp := parseErrorResponse(output, stderr.Bytes(), exitErr.ExitCode())
+		setTLSInfo(resp.Connection, stderr.String())
+		if wantsFailedChain(req, resp) {
+			resp.Certificates = probeCertChainShell(req, browserConfig, finalURL)
+		}
+		return resp, nil
 	}
 
-	return parseSuccessResponse(output, finalURL)
+	resp, err := parseSuccessResponse(output, finalURL)
+	if err != nil {
+		return nil, err
+	}
+	setTLSInfo(resp.Connection, stderr.String())
+	if req.IncludeCertInfo {
+		_, wo, _ := splitWriteOut(output)
+		resp.Certificates = certChain(wo.Certs, req.IncludeCertPEM)
+	}
+	return resp, nil
 }
 
-func buildCurlArgs(req *models.ImpersonateRequest, finalURL string, maxResponseSize int64) ([]string, error) {
+// probeCertChainShell fetches the chain that failed verification with a
+// HEAD request that skips it; the curl tool cannot stop after the handshake.
+func probeCertChainShell(req *models.ImpersonateRequest, browserConfig models.BrowserConfig, finalURL string) []models.Certificate {
+	args := []string{
+		"-sS", "-k", "-I", "-o", "/dev/null",
+		"--max-time", strconv.Itoa(min(req.Timeout, certProbeTimeout)),
+		"--proto", "=https",
+		"-w", writeOutMarker + "%{json}",
+	}
+	if req.Proxy != "" {
+		args = append(args, "--proxy", req.Proxy)
+	}
+	if browserConfig.Profile != nil {
+		args = append(args, profileArgs(browserConfig.Profile)...)
+	}
+	args = append(args, finalURL)
+
+	// The client certificate is never offered to a server that failed
+	// verification. A failed HEAD request still reports whatever chain was
+	// received, so one that requires the certificate yields a partial chain
+	// or none.
+	output, _ := exec.Command("/usr/local/bin/"+browserConfig.WrapperScript, args...).Output()
+	_, wo, err := splitWriteOut(output)
+	if err != nil {
+		return nil
+	}
+	return certChain(wo.Certs, req.IncludeCertPEM)
+}
+
+// wrapperInstalled checks that the wrapper script executeShell runs exists.
+func wrapperInstalled(browserConfig models.BrowserConfig) error {
+	if browserConfig.WrapperScript == "" {
+		return fmt.Errorf("no wrapper_script")
+	}
+	if _, err := os.Stat("/usr/local/bin/" + browserConfig.WrapperScript); err != nil {
+		return fmt.Errorf("wrapper script not installed: %w", err)
+	}
+	return nil
+}
+
+func buildCurlArgs(req *models.ImpersonateRequest, browserConfig models.BrowserConfig, finalURL string, maxResponseSize int64) ([]string, error) {
 	args := []string{
 		"-i",             // Include response headers
-		"-s",             // Silent mode
+		"-sS",            // Silent, but still print errors
+		"-v",             // Verbose output on stderr reports the TLS version, cipher and ALPN
 		"--compressed",   // Request and automatically decode supported encodings
 		"-X", req.Method, // HTTP method
 		"--max-time", strconv.Itoa(req.Timeout),
@@ -60,7 +147,8 @@ func buildCurlArgs(req *models.ImpersonateRequest, finalURL string, maxResponseS
 		// redirects to prevent SSRF via file://, gopher:// and similar.
 		"--proto", "=http,https",
 		"--proto-redir", "=http,https",
-		"-w", "\n---TIMING---\n{\"time_total\":%{time_total},\"time_namelookup\":%{time_namelookup},\"time_connect\":%{time_connect},\"time_starttransfer\":%{time_starttransfer}}",
+		// Timings, connection info, exit code and error message, as JSON
+		"-w", writeOutMarker + "%{json}",
 	}
 
 	// Abort the transfer if the target advertises a body larger than the cap.
@@ -76,9 +164,17 @@ func buildCurlArgs(req *models.ImpersonateRequest, finalURL string, maxResponseS
 		args = append(args, "--insecure")
 	}
 
+	if req.Proxy != "" {
+		args = append(args, "--proxy", req.Proxy)
+	}
+
+	if browserConfig.Profile != nil {
+		args = append(args, profileArgs(browserConfig.Profile)...)
+	}
+
 	// Add custom headers
-	for key, value := range req.Headers {
-		args = append(args, "-H", fmt.Sprintf("%s: %s", key, value))
+	for _, header := range browserConfig.RequestHeaders(req.Headers) {
+		args = append(args, "-H", header)
 	}
 
 	// Add body
@@ -96,22 +192,54 @@ func buildCurlArgs(req *models.ImpersonateRequest, finalURL string, maxResponseS
 	return args, nil
 }
 
-func parseSuccessResponse(output []byte, requestedURL string) (*models.ImpersonateResponse, error) {
-	// Split output into response and timing
-	parts := bytes.Split(output, []byte("\n---TIMING---\n"))
-	if len(parts) != 2 {
-		return nil, fmt.Errorf("unexpected curl output format")
+// profileArgs returns the curl-impersonate options for a custom profile's
+// TLS and HTTP/2 settings, which override the wrapper script's.
+func profileArgs(p *models.Profile) []string {
+	var args []string
+	add := func(flag, value string) {
+		if value != "" {
+			args = append(args, flag, value)
+		}
 	}
+	if t := p.TLS; t != nil {
+		add("--ciphers", t.Ciphers)
+		add("--curves", t.Curves)
+		add("--signature-hashes", t.SignatureHashes)
+		add("--tls-extension-order", t.ExtensionOrder)
+		if t.PermuteExtensions {
+			args = append(args, "--tls-permute-extensions")
+		}
+	}
+	if h := p.HTTP2; h != nil {
+		add("--http2-pseudo-headers-order", h.PseudoHeadersOrder)
+		add("--http2-settings", h.Settings)
+		if h.WindowUpdate > 0 {
+			args = append(args, "--http2-window-update", strconv.Itoa(h.WindowUpdate))
+		}
+	}
+	return args
+}
 
-	responseData := parts[0]
-	timingData := parts[1]
-
-	// Parse timing first
-	var timing CurlTiming
-	if err := json.Unmarshal(timingData, &timing); err != nil {
-		return nil, fmt.Errorf("failed to parse timing: %w", err)
+// splitWriteOut separates curl's output into the response and the
+// write-out trailer. The last marker is the trailer's; the body may contain
+// the marker too.
+func splitWriteOut(output []byte) ([]byte, writeOut, error) {
+	var wo writeOut
+	i := bytes.LastIndex(output, []byte(writeOutMarker))
+	if i < 0 {
+		return nil, wo, fmt.Errorf("unexpected curl output format")
+	}
+	if err := json.Unmarshal(output[i+len(writeOutMarker):], &wo); err != nil {
+		return nil, wo, fmt.Errorf("failed to parse timing: %w", err)
 	}
+	return output[:i], wo, nil
+}
 
+func parseSuccessResponse(output []byte, requestedURL string) (*models.ImpersonateResponse, error) {
+	responseData, wo, err := splitWriteOut(output)
+	if err != nil {
+		return nil, err
+	}
 	// Split headers and body (separated by blank line)
 	headerBodySplit := bytes.SplitN(responseData, []byte("\r\n\r\n"), 2)
 	if len(headerBodySplit) < 2 {
@@ -158,12 +286,8 @@ func parseSuccessResponse(output []byte, requestedURL string) (*models.Impersona
 		StatusCode: statusCode,
 		Headers:    headers,
 		FinalURL:   requestedURL,
-		Timing: &models.Timing{
-			Total:         timing.TimeTotal,
-			NameLookup:    timing.TimeNameLookup,
-			Connect:       timing.TimeConnect,
-			StartTransfer: timing.TimeStartTransfer,
-		},
+		Timing:     wo.timing(),
+		Connection: wo.connection(),
 	}
 
 	setResponseBody(response, bodyBytes)
@@ -171,26 +295,37 @@ func parseSuccessResponse(output []byte, requestedURL string) (*models.Impersona
 	return response, nil
 }
 
-func parseErrorResponse(output []byte, cmdErr error) (*models.ImpersonateResponse, error) {
-	errorMsg := string(output)
-	if errorMsg == "" {
-		errorMsg = cmdErr.Error()
+// parseErrorResponse reports a failed transfer. The write-out trailer has
+// curl's error code and message, timings and connection info; without it,
+// the exit code and the message curl printed on stderr ("curl: (7) Failed
+// to connect ...") are used.
+func parseErrorResponse(output, stderr []byte, exitCode int) *models.ImpersonateResponse {
+	code, msg := exitCode, ""
+	_, wo, err := splitWriteOut(output)
+	if err == nil && wo.ExitCode != 0 {
+		code, msg = wo.ExitCode, wo.ErrorMsg
 	}
-
-	// Determine error type based on curl output
-	errorType := "network"
-	if strings.Contains(errorMsg, "timeout") || strings.Contains(errorMsg, "timed out") {
-		errorType = "timeout"
-	} else if strings.Contains(errorMsg, "Could not resolve host") {
-		errorType = "dns"
-	} else if strings.Contains(errorMsg, "SSL") || strings.Contains(errorMsg, "certificate") {
-		errorType = "ssl"
+	if msg == "" {
+		msg = stderrMessage(stderr)
+	}
+	resp := curlFailure(code, msg)
+	if err == nil {
+		resp.Timing, resp.Connection = wo.timing(), wo.connection()
 	}
+	return resp
+}
 
-	return &models.ImpersonateResponse{
-		Success:    false,
-		Error:      errorMsg,
-		ErrorType:  errorType,
-		StatusCode: 0,
-	}, nil
+// stderrMessage returns the error message curl printed among its verbose
+// output, without the "curl: (N) " prefix.
+func stderrMessage(stderr []byte) string {
+	var msg string
+	for _, line := range strings.Split(string(stderr), "\n") {
+		if rest, ok := strings.CutPrefix(strings.TrimSpace(line), "curl: ("); ok {
+			msg = rest
+			if _, after, ok := strings.Cut(rest, ") "); ok {
+				msg = after
+			}
+		}
+	}
+	return msg
 }
@@ -8,12 +8,11 @@ typedef int CURLcode;
 typedef int CURLoption;
 typedef int CURLINFO;
 
-// Common CURLcode values
+// Common CURLcode values; errors.go maps the failures
 #define CURLE_OK 0
-#define CURLE_OPERATION_TIMEDOUT 28
-#define CURLE_COULDNT_RESOLVE_HOST 6
-#define CURLE_SSL_CONNECT_ERROR 35
-#define CURLE_PEER_FAILED_VERIFICATION 60
+
+// Size of the buffer CURLOPT_ERRORBUFFER fills
+#define CURL_ERROR_SIZE 256
 
 // Common CURLoption values
 #define CURLOPT_URL 10002
@@ -28,6 +27,26 @@ typedef int CURLINFO;
 #define CURLOPT_HEADERFUNCTION 20079
 #define CURLOPT_HEADERDATA 10029
 #define CURLOPT_ACCEPT_ENCODING 10102
+#define CURLOPT_PROXY 10004
+#define CURLOPT_ERRORBUFFER 10010
+#define CURLOPT_VERBOSE 41
+#define CURLOPT_DEBUGFUNCTION 20094
+#define CURLOPT_DEBUGDATA 10095
+#define CURLOPT_CERTINFO 172
+#define CURLOPT_CONNECT_ONLY 141
+#define CURLOPT_CAINFO_BLOB 40309
+#define CURLOPT_SSLCERT_BLOB 40291
+#define CURLOPT_SSLKEY_BLOB 40292
+#define CURLOPT_SSL_CIPHER_LIST 10083
+#define CURLOPT_SSL_EC_CURVES 10298
+
+// libcurl-impersonate options for custom profiles
+#define CURLOPT_SSL_SIG_HASH_ALGS 11001
+#define CURLOPT_HTTP2_PSEUDO_HEADERS_ORDER 11005
+#define CURLOPT_HTTP2_SETTINGS 11006
+#define CURLOPT_SSL_PERMUTE_EXTENSIONS 1007
+#define CURLOPT_HTTP2_WINDOW_UPDATE 1008
+#define CURLOPT_TLS_EXTENSION_ORDER 11012
 
 // Common CURLINFO values
 #define CURLINFO_RESPONSE_CODE 0x200002
@@ -36,6 +55,24 @@ typedef int CURLINFO;
 #define CURLINFO_NAMELOOKUP_TIME 0x300004
 #define CURLINFO_CONNECT_TIME 0x300005
 #define CURLINFO_STARTTRANSFER_TIME 0x300006
+#define CURLINFO_APPCONNECT_TIME 0x300021
+#define CURLINFO_PRETRANSFER_TIME 0x300007
+#define CURLINFO_REDIRECT_TIME 0x300013
+#define CURLINFO_QUEUE_TIME_T 0x600041
+#define CURLINFO_PRIMARY_IP 0x100020
+#define CURLINFO_PRIMARY_PORT 0x200028
+#define CURLINFO_LOCAL_IP 0x100029
+#define CURLINFO_LOCAL_PORT 0x20002A
+#define CURLINFO_HTTP_VERSION 0x20002E
+#define CURLINFO_NUM_CONNECTS 0x20001A
+#define CURLINFO_REQUEST_SIZE 0x20000C
+#define CURLINFO_HEADER_SIZE 0x20000B
+#define CURLINFO_SIZE_UPLOAD_T 0x600007
+#define CURLINFO_SIZE_DOWNLOAD_T 0x600008
+#define CURLINFO_CERTINFO 0x400022
+
+// curl_infotype of the informational text passed to CURLOPT_DEBUGFUNCTION
+#define CURLINFO_TEXT 0
 
 // Slist type for headers
 struct curl_slist {
@@ -43,6 +80,22 @@ struct curl_slist {
     struct curl_slist *next;
 };
 
+// In-memory data for the *_BLOB options; CURL_BLOB_COPY makes libcurl keep
+// its own copy
+struct curl_blob {
+    void *data;
+    size_t len;
+    unsigned int flags;
+};
+#define CURL_BLOB_COPY 1
+
+// Certificate chain reported by CURLINFO_CERTINFO: one list of
+// "name:value" entries per certificate
+struct curl_certinfo {
+    int num_of_certs;
+    struct curl_slist **certinfo;
+};
+
 // Core curl functions
 CURL *curl_easy_init(void);
 void curl_easy_cleanup(CURL *curl);
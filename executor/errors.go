@@ -0,0 +1,71 @@
+package executor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zupolgec/curl-impersonate-service/models"
+)
+
+// curlErrorCodes maps curl error codes (CURLcode values, which are also the
+// curl tool's exit codes) to error codes. Codes not listed are reported as
+// models.ErrorCodeOther.
+var curlErrorCodes = map[int]string{
+	1:  models.ErrorCodeProtocol,         // CURLE_UNSUPPORTED_PROTOCOL
+	5:  models.ErrorCodeProxy,            // CURLE_COULDNT_RESOLVE_PROXY
+	6:  models.ErrorCodeDNS,              // CURLE_COULDNT_RESOLVE_HOST
+	7:  models.ErrorCodeConnectFailed,    // CURLE_COULDNT_CONNECT
+	8:  models.ErrorCodeProtocol,         // CURLE_WEIRD_SERVER_REPLY
+	16: models.ErrorCodeHTTP2Stream,      // CURLE_HTTP2
+	18: models.ErrorCodePartialBody,      // CURLE_PARTIAL_FILE
+	28: models.ErrorCodeTimeout,          // CURLE_OPERATION_TIMEDOUT
+	35: models.ErrorCodeTLSHandshake,     // CURLE_SSL_CONNECT_ERROR
+	47: models.ErrorCodeTooManyRedirects, // CURLE_TOO_MANY_REDIRECTS
+	52: models.ErrorCodeEmptyReply,       // CURLE_GOT_NOTHING
+	55: models.ErrorCodeSendFailed,       // CURLE_SEND_ERROR
+	56: models.ErrorCodeRecvFailed,       // CURLE_RECV_ERROR
+	58: models.ErrorCodeTLSHandshake,     // CURLE_SSL_CERTPROBLEM (client certificate)
+	59: models.ErrorCodeTLSHandshake,     // CURLE_SSL_CIPHER
+	60: models.ErrorCodeCertInvalid,      // CURLE_PEER_FAILED_VERIFICATION
+	61: models.ErrorCodeBadEncoding,      // CURLE_BAD_CONTENT_ENCODING
+	63: models.ErrorCodeSize,             // CURLE_FILESIZE_EXCEEDED
+	77: models.ErrorCodeCertInvalid,      // CURLE_SSL_CACERT_BADFILE
+	80: models.ErrorCodeTLSHandshake,     // CURLE_SSL_SHUTDOWN_FAILED
+	83: models.ErrorCodeCertInvalid,      // CURLE_SSL_ISSUER_ERROR
+	90: models.ErrorCodeCertInvalid,      // CURLE_SSL_PINNEDPUBKEYNOTMATCH
+	91: models.ErrorCodeCertInvalid,      // CURLE_SSL_INVALIDCERTSTATUS
+	92: models.ErrorCodeHTTP2Stream,      // CURLE_HTTP2_STREAM
+	95: models.ErrorCodeHTTP3,            // CURLE_HTTP3
+	97: models.ErrorCodeProxy,            // CURLE_PROXY
+}
+
+// curlFailure builds the response for a transfer that failed with curl
+// error code and message msg. Both executors report errors through it.
+func curlFailure(code int, msg string) *models.ImpersonateResponse {
+	errorCode, ok := curlErrorCodes[code]
+	if !ok {
+		errorCode = models.ErrorCodeOther
+	}
+	// curl reports refused and unreachable connections alike.
+	if errorCode == models.ErrorCodeConnectFailed && strings.Contains(strings.ToLower(msg), "refused") {
+		errorCode = models.ErrorCodeConnectRefused
+	}
+	if msg == "" {
+		msg = fmt.Sprintf("curl error %d", code)
+	}
+	return &models.ImpersonateResponse{
+		Success:   false,
+		Error:     msg,
+		ErrorType: models.ErrorTypeOf(errorCode),
+		ErrorCode: errorCode,
+		CurlCode:  code,
+	}
+}
+
+// sizeFailure builds the response for a transfer aborted because the
+// response exceeded the size cap.
+func sizeFailure(code int) *models.ImpersonateResponse {
+	resp := curlFailure(code, "response exceeds maximum allowed size")
+	resp.ErrorType, resp.ErrorCode = models.ErrorTypeSize, models.ErrorCodeSize
+	return resp
+}
@@ -0,0 +1,74 @@
+package executor
+
+import "testing"
+
+func TestParseErrorResponse(t *testing.T) {
+	tests := []struct {
+		name      string
+		output    string
+		stderr    string
+		exitCode  int
+		wantCode  string
+		wantType  string
+		wantCurl  int
+		wantError string
+	}{
+		{
+			name:      "write-out",
+			output:    "\n---TIMING---\n" + `{"exitcode":7,"errormsg":"Failed to connect to example.com port 443: Connection refused","time_total":0.01}`,
+			exitCode:  7,
+			wantCode:  "connect_refused",
+			wantType:  "network",
+			wantCurl:  7,
+			wantError: "Failed to connect to example.com port 443: Connection refused",
+		},
+		{
+			name:      "stderr",
+			stderr:    "curl: (60) SSL certificate problem: self-signed certificate\n",
+			exitCode:  60,
+			wantCode:  "cert_invalid",
+			wantType:  "ssl",
+			wantCurl:  60,
+			wantError: "SSL certificate problem: self-signed certificate",
+		},
+		{
+			name:      "size cap",
+			output:    "HTTP/2 200\r\n\r\n" + "\n---TIMING---\n" + `{"exitcode":63,"errormsg":"Maximum file size exceeded"}`,
+			exitCode:  63,
+			wantCode:  "size",
+			wantType:  "size",
+			wantCurl:  63,
+			wantError: "Maximum file size exceeded",
+		},
+		{
+			name:      "unknown code, no message",
+			exitCode:  99,
+			wantCode:  "curl_error",
+			wantType:  "network",
+			wantCurl:  99,
+			wantError: "curl error 99",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := parseErrorResponse([]byte(tt.output), []byte(tt.stderr), tt.exitCode)
+			if resp.Success || resp.ErrorCode != tt.wantCode || resp.ErrorType != tt.wantType || resp.CurlCode != tt.wantCurl || resp.Error != tt.wantError {
+				t.Errorf("got %+v", resp)
+			}
+		})
+	}
+}
+
+func TestCurlFailureTaxonomy(t *testing.T) {
+	for code, want := range map[int]string{
+		6: "dns", 7: "connect_failed", 28: "timeout", 35: "tls_handshake", 47: "too_many_redirects",
+		97: "proxy", 92: "http2_stream", 18: "partial_body", 52: "empty_reply", 56: "recv_failed",
+	} {
+		if got := curlFailure(code, "").ErrorCode; got != want {
+			t.Errorf("curl code %d: error_code = %q, want %q", code, got, want)
+		}
+	}
+	if resp := sizeFailure(23); resp.ErrorType != "size" || resp.ErrorCode != "size" || resp.CurlCode != 23 {
+		t.Errorf("sizeFailure = %+v", resp)
+	}
+}
@@ -0,0 +1,28 @@
+package executor
+
+import "github.com/zupolgec/curl-impersonate-service/models"
+
+// Executor runs impersonated requests. The handlers are given one: Default
+// in the service, a fake from executortest in tests.
+type Executor interface {
+	// Execute runs req as browserConfig, keeping at most maxResponseSize
+	// bytes of the body. A failed transfer is reported in the response; the
+	// error means the request could not be run at all.
+	Execute(req *models.ImpersonateRequest, browserConfig models.BrowserConfig, maxResponseSize int64) (*models.ImpersonateResponse, error)
+	// SupportsTarget reports whether browserConfig can be impersonated.
+	SupportsTarget(browserConfig models.BrowserConfig) error
+}
+
+// Shell runs requests with the curl-impersonate wrapper scripts in
+// /usr/local/bin.
+type Shell struct{}
+
+func (Shell) Execute(req *models.ImpersonateRequest, browserConfig models.BrowserConfig, maxResponseSize int64) (*models.ImpersonateResponse, error) {
+	return executeShell(req, browserConfig, maxResponseSize)
+}
+
+// SupportsTarget reports whether the wrapper script for browserConfig is
+// installed.
+func (Shell) SupportsTarget(browserConfig models.BrowserConfig) error {
+	return wrapperInstalled(browserConfig)
+}
@@ -0,0 +1,249 @@
+// Package executortest provides an executor and an upstream server for
+// tests, so handlers can be tested with go test alone: no curl-impersonate
+// and no network. Fake records every request it is given and answers it
+// from a script or by forwarding it with net/http, typically to an
+// Upstream.
+package executortest
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zupolgec/curl-impersonate-service/models"
+)
+
+// Call is one request an executor was given.
+type Call struct {
+	Request         models.ImpersonateRequest
+	Browser         models.BrowserConfig
+	MaxResponseSize int64
+}
+
+// Fake is an executor.Executor that records its calls. It answers them with
+// Respond, or with Forward if Respond is nil. It is safe for concurrent use.
+type Fake struct {
+	Respond func(Call) (*models.ImpersonateResponse, error)
+	// Unsupported lists the browsers SupportsTarget rejects.
+	Unsupported []string
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+func (f *Fake) Execute(req *models.ImpersonateRequest, browserConfig models.BrowserConfig, maxResponseSize int64) (*models.ImpersonateResponse, error) {
+	call := Call{Request: *req, Browser: browserConfig, MaxResponseSize: maxResponseSize}
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	f.mu.Unlock()
+	if f.Respond == nil {
+		return Forward(call)
+	}
+	return f.Respond(call)
+}
+
+func (f *Fake) SupportsTarget(browserConfig models.BrowserConfig) error {
+	if slices.Contains(f.Unsupported, browserConfig.Name) {
+		return fmt.Errorf("%s is not supported by the fake executor", browserConfig.Name)
+	}
+	return nil
+}
+
+// Calls returns the calls made so far, in order.
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.calls)
+}
+
+// Browsers returns the browser of each call so far, in order.
+func (f *Fake) Browsers() []string {
+	var names []string
+	for _, c := range f.Calls() {
+		names = append(names, c.Browser.Name)
+	}
+	return names
+}
+
+// Responses returns a Respond function that answers successive calls with
+// resps, repeating the last one. Each call gets a copy, which the caller
+// may change.
+func Responses(resps ...*models.ImpersonateResponse) func(Call) (*models.ImpersonateResponse, error) {
+	var mu sync.Mutex
+	next := 0
+	return func(Call) (*models.ImpersonateResponse, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		resp := *resps[next]
+		if next < len(resps)-1 {
+			next++
+		}
+		return &resp, nil
+	}
+}
+
+// Status is a successful response with a status code and text body.
+func Status(code int, body string) *models.ImpersonateResponse {
+	return &models.ImpersonateResponse{
+		Success:    true,
+		StatusCode: code,
+		Headers:    map[string][]string{"Content-Type": {"text/plain; charset=utf-8"}},
+		Body:       body,
+		Timing:     &models.Timing{},
+	}
+}
+
+// Failure is a failed transfer with an error code such as
+// models.ErrorCodeConnectRefused, as the executors report it.
+func Failure(errorCode, msg string) *models.ImpersonateResponse {
+	return &models.ImpersonateResponse{
+		Error:     msg,
+		ErrorType: models.ErrorTypeOf(errorCode),
+		ErrorCode: errorCode,
+		CurlCode:  curlCodes[errorCode],
+	}
+}
+
+// curlCodes are the curl error codes Failure reports for error codes.
+var curlCodes = map[string]int{
+	models.ErrorCodeDNS:              6,
+	models.ErrorCodeConnectRefused:   7,
+	models.ErrorCodeConnectFailed:    7,
+	models.ErrorCodeTimeout:          28,
+	models.ErrorCodeTLSHandshake:     35,
+	models.ErrorCodeCertInvalid:      60,
+	models.ErrorCodeTooManyRedirects: 47,
+	models.ErrorCodeEmptyReply:       52,
+	models.ErrorCodeRecvFailed:       56,
+	models.ErrorCodeSize:             63,
+}
+
+// maxRedirects matches curl's default limit.
+const maxRedirects = 30
+
+// Forward makes the call's request with net/http: the same URL, query
+// parameters, method, headers (including the browser profile's) and body,
+// following redirects and skipping certificate verification as asked,
+// within the request timeout, through the request's proxy if it has one.
+// TLS credentials are ignored, and there is no impersonation. Transport
+// failures become failed responses, as with curl. Bodies that are valid
+// UTF-8 are returned as text, others base64-encoded.
+func Forward(call Call) (*models.ImpersonateResponse, error) {
+	req := call.Request
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	if len(req.QueryParams) > 0 {
+		q := u.Query()
+		for k, v := range req.QueryParams {
+			q.Set(k, v)
+		}
+		u.RawQuery = q.Encode()
+	}
+	body := []byte(req.Body)
+	if req.BodyBase64 != "" {
+		if body, err = base64.StdEncoding.DecodeString(req.BodyBase64); err != nil {
+			return nil, fmt.Errorf("invalid base64 body: %w", err)
+		}
+	}
+	hreq, err := http.NewRequest(req.Method, u.String(), strings.NewReader(string(body)))
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range call.Browser.RequestHeaders(req.Headers) {
+		if name, value, _ := strings.Cut(line, ":"); strings.TrimSpace(value) != "" {
+			hreq.Header.Add(name, strings.TrimSpace(value))
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: req.Insecure}
+	if req.Proxy != "" {
+		proxy, err := url.Parse(req.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(req.Timeout) * time.Second,
+		CheckRedirect: func(_ *http.Request, via []*http.Request) error {
+			if !req.FollowRedirects {
+				return http.ErrUseLastResponse
+			}
+			if len(via) > maxRedirects {
+				return errTooManyRedirects
+			}
+			return nil
+		},
+	}
+
+	start := time.Now()
+	resp, err := client.Do(hreq)
+	if err != nil {
+		return transportFailure(err), nil
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, call.MaxResponseSize+1))
+	if err != nil {
+		return transportFailure(err), nil
+	}
+	if int64(len(data)) > call.MaxResponseSize {
+		return Failure(models.ErrorCodeSize, "response exceeds maximum allowed size"), nil
+	}
+
+	out := &models.ImpersonateResponse{
+		Success:    true,
+		StatusCode: resp.StatusCode,
+		Headers:    resp.Header,
+		FinalURL:   resp.Request.URL.String(),
+		Timing:     &models.Timing{Total: time.Since(start).Seconds()},
+	}
+	if len(data) > 0 {
+		if utf8.Valid(data) {
+			out.Body = string(data)
+		} else {
+			out.Body, out.BodyBase64 = base64.StdEncoding.EncodeToString(data), true
+		}
+	}
+	return out, nil
+}
+
+var errTooManyRedirects = errors.New("maximum redirects followed")
+
+// transportFailure reports a net/http error the way curl would.
+func transportFailure(err error) *models.ImpersonateResponse {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	code := models.ErrorCodeRecvFailed
+	switch {
+	case errors.Is(err, errTooManyRedirects):
+		code = models.ErrorCodeTooManyRedirects
+	case errors.As(err, &dnsErr):
+		code = models.ErrorCodeDNS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		code = models.ErrorCodeTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		code = models.ErrorCodeConnectRefused
+	case errors.As(err, &certErr):
+		code = models.ErrorCodeCertInvalid
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		code = models.ErrorCodeEmptyReply
+	}
+	return Failure(code, err.Error())
+}
@@ -0,0 +1,153 @@
+package executortest
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Upstream is an in-process target server, a small httpbin. Close it when
+// done. Its endpoints are:
+//
+//	/anything    echoes the request as JSON: method, url, args, headers, body
+//	/status/N    answers with status code N
+//	/redirect/N  redirects N times, then answers 200
+//	/delay/D     answers after D, a duration such as "50ms"
+//	/bytes/N     answers with N random bytes
+//	/flaky/N     answers 503 to its first N requests, then 200
+//	/challenge   answers with a Cloudflare challenge page
+//
+// Add others with Handle.
+type Upstream struct {
+	*httptest.Server
+
+	mux  *http.ServeMux
+	mu   sync.Mutex
+	hits map[string]int
+}
+
+// Echo is the JSON body of /anything.
+type Echo struct {
+	Method  string              `json:"method"`
+	URL     string              `json:"url"`
+	Args    map[string][]string `json:"args"`
+	Headers map[string][]string `json:"headers"`
+	Body    string              `json:"body"`
+}
+
+// NewUpstream starts an Upstream on a loopback address, over plain HTTP.
+func NewUpstream() *Upstream {
+	u := &Upstream{mux: http.NewServeMux(), hits: map[string]int{}}
+	u.mux.HandleFunc("/anything", u.anything)
+	u.mux.HandleFunc("/anything/", u.anything)
+	u.mux.HandleFunc("/status/{code}", u.status)
+	u.mux.HandleFunc("/redirect/{n}", u.redirect)
+	u.mux.HandleFunc("/delay/{d}", u.delay)
+	u.mux.HandleFunc("/bytes/{n}", u.bytes)
+	u.mux.HandleFunc("/flaky/{n}", u.flaky)
+	u.mux.HandleFunc("/challenge", u.challenge)
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.mu.Lock()
+		u.hits[r.URL.Path]++
+		u.mu.Unlock()
+		u.mux.ServeHTTP(w, r)
+	}))
+	return u
+}
+
+// Handle adds an endpoint, as http.ServeMux.Handle does.
+func (u *Upstream) Handle(pattern string, handler http.Handler) { u.mux.Handle(pattern, handler) }
+
+// Hits returns how many requests path has had.
+func (u *Upstream) Hits(path string) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.hits[path]
+}
+
+func (u *Upstream) anything(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(Echo{
+		Method:  r.Method,
+		URL:     r.URL.String(),
+		Args:    r.URL.Query(),
+		Headers: r.Header,
+		Body:    string(body),
+	})
+}
+
+func (u *Upstream) status(w http.ResponseWriter, r *http.Request) {
+	code, err := strconv.Atoi(r.PathValue("code"))
+	if err != nil || code < 100 || code > 999 {
+		http.Error(w, "bad status code", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(code)
+}
+
+func (u *Upstream) redirect(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(r.PathValue("n"))
+	if err != nil || n < 0 {
+		http.Error(w, "bad redirect count", http.StatusBadRequest)
+		return
+	}
+	if n == 0 {
+		_, _ = io.WriteString(w, "redirected")
+		return
+	}
+	http.Redirect(w, r, "/redirect/"+strconv.Itoa(n-1), http.StatusFound)
+}
+
+func (u *Upstream) delay(w http.ResponseWriter, r *http.Request) {
+	d, err := time.ParseDuration(r.PathValue("d"))
+	if err != nil {
+		http.Error(w, "bad duration", http.StatusBadRequest)
+		return
+	}
+	select {
+	case <-time.After(d):
+		_, _ = io.WriteString(w, "done")
+	case <-r.Context().Done():
+	}
+}
+
+func (u *Upstream) bytes(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(r.PathValue("n"))
+	if err != nil || n < 0 || n > 10<<20 {
+		http.Error(w, "bad size", http.StatusBadRequest)
+		return
+	}
+	data := make([]byte, n)
+	_, _ = rand.Read(data)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(data)
+}
+
+func (u *Upstream) flaky(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(r.PathValue("n"))
+	if err != nil {
+		http.Error(w, "bad failure count", http.StatusBadRequest)
+		return
+	}
+	if u.Hits(r.URL.Path) <= n {
+		w.Header().Set("Retry-After", "0")
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = io.WriteString(w, "ok")
+}
+
+func (u *Upstream) challenge(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Server", "cloudflare")
+	w.Header().Set("Cf-Mitigated", "challenge")
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.WriteHeader(http.StatusForbidden)
+	_, _ = io.WriteString(w, `<!DOCTYPE html><html><head><title>Just a moment...</title></head>`+
+		`<body><script src="/cdn-cgi/challenge-platform/h/g/orchestrate/chl_page/v1"></script></body></html>`)
+}
@@ -11,7 +11,7 @@ import (
 func TestBuildCurlArgsEnablesCompressedResponses(t *testing.T) {
 	req := &models.ImpersonateRequest{Method: "GET", Timeout: 30}
 
-	args, err := buildCurlArgs(req, "https://example.com", 0)
+	args, err := buildCurlArgs(req, models.BrowserConfig{Name: "chrome136"}, "https://example.com", 0)
 	if err != nil {
 		t.Fatalf("buildCurlArgs() error = %v", err)
 	}
@@ -24,6 +24,43 @@ func TestBuildCurlArgsEnablesCompressedResponses(t *testing.T) {
 	t.Fatalf("buildCurlArgs() = %q, want --compressed", args)
 }
 
+func TestBuildCurlArgsAppliesProfile(t *testing.T) {
+	req := &models.ImpersonateRequest{Method: "GET", Timeout: 30, Headers: map[string]string{"X-Trace": "1"}}
+	browser := models.BrowserConfig{Name: "chrome136-de", Base: "chrome136", Profile: &models.Profile{
+		Headers:        map[string]string{"Sec-Ch-Ua-Platform": `"Windows"`, "X-Trace": "0"},
+		HeaderOrder:    []string{"x-trace", "Accept-Language"},
+		AcceptLanguage: "de-DE,de;q=0.9",
+		TLS:            &models.TLSOptions{Curves: "X25519:P-256", PermuteExtensions: true},
+		HTTP2:          &models.HTTP2Options{Settings: "1:65536;4:6291456", WindowUpdate: 15663105},
+	}}
+
+	args, err := buildCurlArgs(req, browser, "https://example.com", 0)
+	if err != nil {
+		t.Fatalf("buildCurlArgs() error = %v", err)
+	}
+	var headers, options []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-H":
+			headers = append(headers, args[i+1])
+			i++
+		case "--curves", "--http2-settings", "--http2-window-update":
+			options = append(options, args[i], args[i+1])
+			i++
+		case "--tls-permute-extensions":
+			options = append(options, args[i])
+		}
+	}
+	wantHeaders := []string{"X-Trace: 1", "Accept-Language: de-DE,de;q=0.9", `Sec-Ch-Ua-Platform: "Windows"`}
+	if !reflect.DeepEqual(headers, wantHeaders) {
+		t.Fatalf("headers = %q, want %q", headers, wantHeaders)
+	}
+	wantOptions := []string{"--curves", "X25519:P-256", "--tls-permute-extensions", "--http2-settings", "1:65536;4:6291456", "--http2-window-update", "15663105"}
+	if !reflect.DeepEqual(options, wantOptions) {
+		t.Fatalf("options = %q, want %q", options, wantOptions)
+	}
+}
+
 func TestParseSuccessResponseReturnsDecodedBody(t *testing.T) {
 	output := []byte("HTTP/2 200\r\n" +
 		"Content-Type: text/html; charset=UTF-8\r\n" +
@@ -0,0 +1,90 @@
+package executor
+
+import (
+	"bufio"
+	"strings"
+
+	"github.com/zupolgec/curl-impersonate-service/models"
+)
+
+// transferInfo is what curl reports about a transfer: the shell executor
+// reads it from the --write-out JSON, the CGO executor from
+// curl_easy_getinfo.
+type transferInfo struct {
+	CurlTiming
+	RemoteIP    string `json:"remote_ip"`
+	RemotePort  int    `json:"remote_port"`
+	LocalIP     string `json:"local_ip"`
+	LocalPort   int    `json:"local_port"`
+	HTTPVersion string `json:"http_version"`
+	NumConnects int    `json:"num_connects"`
+	SizeRequest int64  `json:"size_request"`
+	SizeUpload  int64  `json:"size_upload"`
+	SizeHeader  int64  `json:"size_header"`
+	SizeDown    int64  `json:"size_download"`
+}
+
+func (ti transferInfo) timing() *models.Timing {
+	return &models.Timing{
+		Total:         ti.TimeTotal,
+		NameLookup:    ti.TimeNameLookup,
+		Connect:       ti.TimeConnect,
+		AppConnect:    ti.TimeAppConnect,
+		PreTransfer:   ti.TimePreTransfer,
+		StartTransfer: ti.TimeStartTransfer,
+		Redirect:      ti.TimeRedirect,
+		Queue:         ti.TimeQueue,
+	}
+}
+
+// connection returns the connection info, or nil if no connection was made.
+func (ti transferInfo) connection() *models.Connection {
+	if ti.RemoteIP == "" {
+		return nil
+	}
+	return &models.Connection{
+		RemoteIP:        ti.RemoteIP,
+		RemotePort:      ti.RemotePort,
+		LocalIP:         ti.LocalIP,
+		LocalPort:       ti.LocalPort,
+		HTTPVersion:     ti.HTTPVersion,
+		Reused:          ti.NumConnects == 0,
+		BytesUploaded:   ti.SizeRequest + ti.SizeUpload,
+		BytesDownloaded: ti.SizeHeader + ti.SizeDown,
+	}
+}
+
+// httpVersions maps CURLINFO_HTTP_VERSION values to the names curl's
+// %{http_version} prints.
+var httpVersions = map[int]string{1: "1.0", 2: "1.1", 3: "2", 30: "3"}
+
+// setTLSInfo fills c's TLS version, cipher and ALPN protocol from curl's
+// verbose output, which is the only place curl reports them. Lines look like
+// "* SSL connection using TLSv1.3 / TLS_AES_128_GCM_SHA256 / X25519" and
+// "* ALPN: server accepted h2"; the "* " prefix is only on the curl tool's.
+// With redirects, the last connection's lines win.
+func setTLSInfo(c *models.Connection, verbose string) {
+	if c == nil {
+		return
+	}
+	sc := bufio.NewScanner(strings.NewReader(verbose))
+	sc.Buffer(nil, 64*1024)
+	for sc.Scan() {
+		line := strings.TrimPrefix(strings.TrimSpace(sc.Text()), "* ")
+		if rest, ok := strings.CutPrefix(line, "SSL connection using "); ok {
+			parts := strings.Split(rest, " / ")
+			c.TLSVersion = strings.TrimSpace(parts[0])
+			if len(parts) > 1 {
+				c.TLSCipher = strings.TrimSpace(parts[1])
+			}
+			continue
+		}
+		// curl 8 prints "ALPN: server accepted h2", older versions
+		// "ALPN, server accepted to use h2".
+		for _, prefix := range []string{"ALPN: server accepted ", "ALPN, server accepted to use "} {
+			if alpn, ok := strings.CutPrefix(line, prefix); ok {
+				c.ALPN = strings.TrimSpace(alpn)
+			}
+		}
+	}
+}
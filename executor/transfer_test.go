@@ -0,0 +1,90 @@
+package executor
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zupolgec/curl-impersonate-service/models"
+)
+
+func TestParseSuccessResponseConnectionInfo(t *testing.T) {
+	output := []byte("HTTP/2 200\r\n\r\nok" +
+		"\n---TIMING---\n" +
+		`{"time_total":0.3,"time_namelookup":0.01,"time_connect":0.02,"time_appconnect":0.05,` +
+		`"time_pretransfer":0.06,"time_starttransfer":0.2,"time_redirect":0,"time_queue":0.001,` +
+		`"remote_ip":"93.184.215.14","remote_port":443,"local_ip":"10.0.0.2","local_port":51234,` +
+		`"http_version":"2","num_connects":1,"size_request":120,"size_upload":0,"size_header":300,"size_download":2}`)
+
+	resp, err := parseSuccessResponse(output, "https://example.com")
+	if err != nil {
+		t.Fatalf("parseSuccessResponse() error = %v", err)
+	}
+	wantTiming := &models.Timing{Total: 0.3, NameLookup: 0.01, Connect: 0.02, AppConnect: 0.05, PreTransfer: 0.06, StartTransfer: 0.2, Queue: 0.001}
+	if !reflect.DeepEqual(resp.Timing, wantTiming) {
+		t.Errorf("Timing = %+v, want %+v", resp.Timing, wantTiming)
+	}
+	wantConn := &models.Connection{
+		RemoteIP: "93.184.215.14", RemotePort: 443, LocalIP: "10.0.0.2", LocalPort: 51234,
+		HTTPVersion: "2", BytesUploaded: 120, BytesDownloaded: 302,
+	}
+	if !reflect.DeepEqual(resp.Connection, wantConn) {
+		t.Errorf("Connection = %+v, want %+v", resp.Connection, wantConn)
+	}
+}
+
+func TestParseErrorResponseConnectionInfo(t *testing.T) {
+	// DNS failed: no connection, but timings are reported.
+	output := "\n---TIMING---\n" + `{"exitcode":6,"errormsg":"Could not resolve host: nx.invalid","time_total":0.02,"time_namelookup":0.02}`
+	resp := parseErrorResponse([]byte(output), nil, 6)
+	if resp.Timing == nil || resp.Timing.Total != 0.02 {
+		t.Errorf("Timing = %+v", resp.Timing)
+	}
+	if resp.Connection != nil {
+		t.Errorf("Connection = %+v, want nil", resp.Connection)
+	}
+}
+
+func TestStderrMessageAmongVerboseOutput(t *testing.T) {
+	stderr := "* Host example.com:443 was resolved.\n* Trying 93.184.215.14:443...\n" +
+		"curl: (7) Failed to connect to example.com port 443: Connection refused\n"
+	if got, want := stderrMessage([]byte(stderr)), "Failed to connect to example.com port 443: Connection refused"; got != want {
+		t.Errorf("stderrMessage() = %q, want %q", got, want)
+	}
+}
+
+func TestSetTLSInfo(t *testing.T) {
+	tests := []struct {
+		name    string
+		verbose string
+		want    models.Connection
+	}{
+		{
+			name: "curl tool",
+			verbose: "* Connected to example.com (93.184.215.14) port 443\n" +
+				"* ALPN: curl offers h2,http/1.1\n" +
+				"* SSL connection using TLSv1.3 / TLS_AES_128_GCM_SHA256 / X25519 / id-ecPublicKey\n" +
+				"* ALPN: server accepted h2\n" +
+				"> GET / HTTP/2\n",
+			want: models.Connection{TLSVersion: "TLSv1.3", TLSCipher: "TLS_AES_128_GCM_SHA256", ALPN: "h2"},
+		},
+		{
+			name:    "debug callback, older curl",
+			verbose: "SSL connection using TLSv1.2 / ECDHE-RSA-AES128-GCM-SHA256\nALPN, server accepted to use http/1.1\n",
+			want:    models.Connection{TLSVersion: "TLSv1.2", TLSCipher: "ECDHE-RSA-AES128-GCM-SHA256", ALPN: "http/1.1"},
+		},
+		{
+			name:    "plain http",
+			verbose: "* Connected to example.com (93.184.215.14) port 80\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c models.Connection
+			setTLSInfo(&c, tt.verbose)
+			if c != tt.want {
+				t.Errorf("got %+v, want %+v", c, tt.want)
+			}
+		})
+	}
+	setTLSInfo(nil, "SSL connection using TLSv1.3 / X") // no connection: no-op
+}
@@ -1,45 +1,120 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"html/template"
 	"net/http"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/zupolgec/curl-impersonate-service/metrics"
+	"github.com/zupolgec/curl-impersonate-service/models"
+	"github.com/zupolgec/curl-impersonate-service/settings"
 	"github.com/zupolgec/curl-impersonate-service/store"
 )
 
-// corsSettingKey is the settings key holding the comma-separated CORS origins.
-const corsSettingKey = "cors_allowed_origins"
+// AdminOptions configures the admin UI.
+type AdminOptions struct {
+	// AdminToken, when set, signs in as the built-in "admin" owner account.
+	AdminToken string
+	// SessionTTL is how long a sign-in lasts.
+	SessionTTL time.Duration
+	// SSO, when set, adds OpenID Connect sign-in.
+	SSO *SSOOptions
+	// Settings, when set, adds the Settings page for runtime settings.
+	Settings *settings.Registry
+	// ReloadBrowsers, when set, adds a Reload button to the Browsers page.
+	// It reloads browsers.json and leaves the loaded set alone on error.
+	ReloadBrowsers func() error
+}
 
 // AdminHandler serves the admin UI and its form actions.
 type AdminHandler struct {
 	store     *store.Store
 	collector *metrics.Collector
+	replay    *ImpersonateHandler
+	opts      AdminOptions
+	flash     *flashes
+	sso       *ssoPending
+	logins    *loginLimiter
 	tmpl      *template.Template
 }
 
 // NewAdminHandler builds the admin UI handler and returns an http.Handler
-// mounted under /admin/.
-func NewAdminHandler(st *store.Store, collector *metrics.Collector) http.Handler {
+// mounted under /admin/. replay runs captured requests again; it may be nil,
+// which disables replays. Every page requires a signed-in admin account.
+func NewAdminHandler(st *store.Store, collector *metrics.Collector, replay *ImpersonateHandler, opts AdminOptions) http.Handler {
+	if opts.SessionTTL <= 0 {
+		opts.SessionTTL = 12 * time.Hour
+	}
 	h := &AdminHandler{
 		store:     st,
 		collector: collector,
+		replay:    replay,
+		opts:      opts,
+		flash:     newFlashes(),
+		sso:       &ssoPending{items: map[string]ssoRequest{}},
+		logins:    newLoginLimiter(),
 		tmpl:      template.Must(template.New("admin").Funcs(adminFuncs).Parse(adminTemplates)),
 	}
 
+	const viewer, operator, owner = store.RoleViewer, store.RoleOperator, store.RoleOwner
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /admin/", h.dashboard)
-	mux.HandleFunc("GET /admin/tokens", h.tokens)
-	mux.HandleFunc("POST /admin/tokens", h.createToken)
-	mux.HandleFunc("POST /admin/tokens/delete", h.deleteToken)
-	mux.HandleFunc("POST /admin/tokens/toggle", h.toggleToken)
-	mux.HandleFunc("GET /admin/cors", h.cors)
-	mux.HandleFunc("POST /admin/cors", h.saveCORS)
-	mux.HandleFunc("GET /admin/logs", h.logs)
+	mux.HandleFunc("GET /admin/login", h.loginPage)
+	mux.HandleFunc("POST /admin/login", h.login)
+	if opts.SSO != nil {
+		mux.HandleFunc("GET /admin/oidc/login", h.ssoLogin)
+		mux.HandleFunc("GET /admin/oidc/callback", h.ssoCallback)
+	}
+	h.handle(mux, "POST /admin/logout", viewer, h.logout)
+	h.handle(mux, "GET /admin/", viewer, h.dashboard)
+	h.handle(mux, "GET /admin/tokens", viewer, h.tokens)
+	h.handle(mux, "POST /admin/tokens", operator, h.createToken)
+	h.handle(mux, "POST /admin/tokens/delete", operator, h.deleteToken)
+	h.handle(mux, "POST /admin/tokens/toggle", operator, h.toggleToken)
+	h.handle(mux, "POST /admin/tokens/rotate", operator, h.rotateToken)
+	h.handle(mux, "POST /admin/tokens/update", operator, h.updateToken)
+	h.handle(mux, "POST /admin/tokens/signing", operator, h.tokenSigning)
+	if opts.Settings != nil {
+		h.handle(mux, "GET /admin/settings", viewer, h.settings)
+		h.handle(mux, "POST /admin/settings", operator, h.saveSetting)
+		// The CORS page became part of Settings.
+		h.handle(mux, "GET /admin/cors", viewer, func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/admin/settings", http.StatusMovedPermanently)
+		})
+	}
+	h.handle(mux, "GET /admin/browsers", viewer, h.browsers)
+	if opts.ReloadBrowsers != nil {
+		h.handle(mux, "POST /admin/browsers/reload", operator, h.reloadBrowsers)
+	}
+	h.handle(mux, "POST /admin/browsers/profiles", operator, h.saveProfile)
+	h.handle(mux, "POST /admin/browsers/profiles/delete", operator, h.deleteProfile)
+	h.handle(mux, "GET /admin/credentials", viewer, h.credentials)
+	h.handle(mux, "POST /admin/credentials", operator, h.saveCredential)
+	h.handle(mux, "POST /admin/credentials/delete", operator, h.deleteCredential)
+	h.handle(mux, "GET /admin/logs", viewer, h.logs)
+	h.handle(mux, "GET /admin/logs/search", viewer, h.searchLogs)
+	h.handle(mux, "GET /admin/logs/export", viewer, h.exportLogs)
+	h.handle(mux, "GET /admin/analytics", viewer, h.analytics)
+	h.handle(mux, "POST /admin/metrics/reset", operator, h.resetMetrics)
+	h.handle(mux, "GET /admin/captures", viewer, h.captures)
+	h.handle(mux, "POST /admin/captures", operator, h.startCapture)
+	h.handle(mux, "POST /admin/captures/stop", operator, h.stopCapture)
+	h.handle(mux, "GET /admin/captures/{id}", viewer, h.captureDetail)
+	h.handle(mux, "POST /admin/captures/{id}/replay", operator, h.replayCapture)
+	h.handle(mux, "GET /admin/audit", viewer, h.auditLog)
+	h.handle(mux, "GET /admin/account", viewer, h.account)
+	h.handle(mux, "POST /admin/account/password", viewer, h.changePassword)
+	h.handle(mux, "GET /admin/users", owner, h.users)
+	h.handle(mux, "POST /admin/users", owner, h.createUser)
+	h.handle(mux, "POST /admin/users/role", owner, h.setUserRole)
+	h.handle(mux, "POST /admin/users/password", owner, h.resetUserPassword)
+	h.handle(mux, "POST /admin/users/sso", owner, h.linkUserSSO)
+	h.handle(mux, "POST /admin/users/delete", owner, h.deleteUser)
 	return mux
 }
 
@@ -56,13 +131,28 @@ var adminFuncs = template.FuncMap{
 		}
 		return t.UTC().Format("2006-01-02 15:04:05 UTC")
 	},
+	"join": strings.Join,
+	"has":  slices.Contains[[]string],
+	"inputTime": func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format("2006-01-02T15:04")
+	},
 }
 
-func (h *AdminHandler) render(w http.ResponseWriter, page string, data map[string]any) {
+func (h *AdminHandler) render(w http.ResponseWriter, r *http.Request, page string, data map[string]any) {
 	if data == nil {
 		data = map[string]any{}
 	}
 	data["Page"] = page
+	if sess := adminSession(r.Context()); sess != nil {
+		data["User"] = sess.User
+		data["CSRF"] = sess.CSRF
+		data["CanOperate"] = store.RoleAtLeast(sess.User.Role, store.RoleOperator)
+		data["IsOwner"] = sess.User.Role == store.RoleOwner
+		data["HasSettings"] = h.opts.Settings != nil
+	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if err := h.tmpl.ExecuteTemplate(w, "layout", data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -72,130 +162,267 @@ func (h *AdminHandler) render(w http.ResponseWriter, page string, data map[strin
 func (h *AdminHandler) dashboard(w http.ResponseWriter, r *http.Request) {
 	uptime, total, success, failed, avg, browsers := h.collector.GetMetrics()
 
-	type kv struct {
-		Name  string
-		Count int64
-	}
-	var used []kv
-	for k, v := range browsers {
-		used = append(used, kv{k, v})
-	}
-	sort.Slice(used, func(i, j int) bool { return used[i].Count > used[j].Count })
+	all := h.collector.AllTime()
 
 	logs, _ := h.store.ListLogs(15)
+	toks, _ := h.store.ListTokens()
 
-	h.render(w, "dashboard", map[string]any{
-		"Uptime":   uptime,
-		"Total":    total,
-		"Success":  success,
-		"Failed":   failed,
-		"Avg":      avg,
-		"Browsers": used,
-		"Logs":     logs,
+	h.render(w, r, "dashboard", map[string]any{
+		"Uptime":      uptime,
+		"Total":       total,
+		"Success":     success,
+		"Failed":      failed,
+		"Avg":         avg,
+		"Browsers":    sortedCounts(browsers),
+		"AllTime":     all,
+		"AllBrowsers": sortedCounts(all.BrowsersUsed),
+		"Reset":       r.URL.Query().Get("reset") == "1",
+		"Logs":        logs,
+		"Expiring":    expiringTokens(toks, time.Now(), expiryWarning),
 	})
 }
 
+type namedCount struct {
+	Name  string
+	Count int64
+}
+
+// sortedCounts orders a name→count map busiest first.
+func sortedCounts(m map[string]int64) []namedCount {
+	out := make([]namedCount, 0, len(m))
+	for k, v := range m {
+		out = append(out, namedCount{k, v})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Count > out[j].Count })
+	return out
+}
+
+// resetMetrics zeroes the since-start and all-time counters and persists the
+// reset immediately so a restart doesn't bring the old totals back.
+func (h *AdminHandler) resetMetrics(w http.ResponseWriter, r *http.Request) {
+	h.collector.Reset()
+	if err := h.collector.SaveSnapshot(h.store); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, "metrics.reset", "", "")
+	http.Redirect(w, r, "/admin/?reset=1", http.StatusSeeOther)
+}
+
 func (h *AdminHandler) tokens(w http.ResponseWriter, r *http.Request) {
 	toks, err := h.store.ListTokens()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	h.render(w, "tokens", map[string]any{
-		"Tokens":  toks,
-		"Created": r.URL.Query().Get("created"),
+	created := h.flash.pop(w, r)
+	if created != "" {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	var signingKey, signingSecret string
+	if rest, ok := strings.CutPrefix(created, signingFlashPrefix); ok {
+		signingKey, signingSecret, _ = strings.Cut(rest, " ")
+		created = ""
+	}
+	h.render(w, r, "tokens", map[string]any{
+		"Tokens":        toks,
+		"Created":       created,
+		"SigningKey":    signingKey,
+		"SigningSecret": signingSecret,
+		"Expiring":      expiringTokens(toks, time.Now(), expiryWarning),
+		"Scopes":        models.Scopes,
+		"Graces":        rotationGraces,
+		"Now":           time.Now(),
 	})
 }
 
+// expiryWarning is how far ahead the admin UI warns about token expiry.
+const expiryWarning = 14 * 24 * time.Hour
+
+// rotationGraces are the grace periods offered when rotating a token.
+var rotationGraces = []struct{ Value, Label string }{
+	{"0s", "none"},
+	{"1h", "1 hour"},
+	{"24h", "1 day"},
+	{"168h", "7 days"},
+}
+
+// expiringTokens returns enabled tokens that are still valid but expire
+// within the given window, soonest first.
+func expiringTokens(toks []store.Token, now time.Time, within time.Duration) []store.Token {
+	var out []store.Token
+	for _, t := range toks {
+		if t.Enabled && t.ExpiresAt != nil && !t.Expired(now) && t.ExpiresAt.Before(now.Add(within)) {
+			out = append(out, t)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ExpiresAt.Before(*out[j].ExpiresAt) })
+	return out
+}
+
 func (h *AdminHandler) createToken(w http.ResponseWriter, r *http.Request) {
 	name := strings.TrimSpace(r.FormValue("name"))
 	if name == "" {
 		name = "token"
 	}
-	tok, err := h.store.CreateToken(name)
+	_ = r.ParseForm()
+	opts := store.TokenOptions{Scopes: r.PostForm["scope"]}
+	if v := r.FormValue("expires"); v != "" {
+		t, err := parseFilterTime(v)
+		if err != nil || !t.After(time.Now()) {
+			http.Error(w, "invalid expiry: must be a future date", http.StatusBadRequest)
+			return
+		}
+		opts.ExpiresAt = &t
+	}
+	tok, err := h.store.CreateToken(name, opts)
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.audit(r, "token.create", tokenTarget(tok.ID, tok.Name), tokenDetail(tok.Scopes, tok.ExpiresAt))
+	// Show the new token value once. Only its hash is stored from here on.
+	if err := h.flash.set(w, r, tok.Token); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	// Show the new token value once, via query param.
-	http.Redirect(w, r, "/admin/tokens?created="+tok.Token, http.StatusSeeOther)
+	http.Redirect(w, r, "/admin/tokens", http.StatusSeeOther)
 }
 
-func (h *AdminHandler) deleteToken(w http.ResponseWriter, r *http.Request) {
+// updateToken edits a token's name, scopes, expiry and accepted ways of
+// authenticating. An empty expiry removes it.
+func (h *AdminHandler) updateToken(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
-	if err := h.store.DeleteToken(id); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	_ = r.ParseForm()
+	scopes := r.PostForm["scope"]
+	signedOnly := r.FormValue("signed_only") == "on"
+	noQuery := r.FormValue("query_token") != "on"
+	u := store.TokenUpdate{
+		Scopes:       &scopes,
+		ClearExpiry:  r.FormValue("expires") == "",
+		SignedOnly:   &signedOnly,
+		NoQueryToken: &noQuery,
+	}
+	if name := strings.TrimSpace(r.FormValue("name")); name != "" {
+		u.Name = &name
+	}
+	if !u.ClearExpiry {
+		t, err := parseFilterTime(r.FormValue("expires"))
+		if err != nil {
+			http.Error(w, "invalid expiry", http.StatusBadRequest)
+			return
+		}
+		u.ExpiresAt = &t
+	}
+	if err := h.store.UpdateToken(id, u); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	name := ""
+	if u.Name != nil {
+		name = *u.Name
+	}
+	detail := tokenDetail(scopes, u.ExpiresAt) + fmt.Sprintf(" signed_only=%t query_token=%t", signedOnly, !noQuery)
+	h.audit(r, "token.update", tokenTarget(id, name), detail)
 	http.Redirect(w, r, "/admin/tokens", http.StatusSeeOther)
 }
 
-func (h *AdminHandler) toggleToken(w http.ResponseWriter, r *http.Request) {
+// signingFlashPrefix marks a flash carrying a new signing key ID and secret.
+const signingFlashPrefix = "signing:"
+
+// tokenSigning issues a new request-signing key for a token (replacing any
+// previous one) and reveals its secret once, or removes it.
+func (h *AdminHandler) tokenSigning(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
-	enabled := r.FormValue("enabled") == "true"
-	if err := h.store.SetTokenEnabled(id, enabled); err != nil {
+	if r.FormValue("action") == "disable" {
+		if err := h.store.DisableSigning(id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, "token.signing.disable", tokenTarget(id, ""), "")
+		http.Redirect(w, r, "/admin/tokens", http.StatusSeeOther)
+		return
+	}
+	keyID, secret, err := h.store.EnableSigning(id)
+	if errors.Is(err, store.ErrNoSecretsKey) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, "token.signing.enable", tokenTarget(id, ""), "key="+keyID)
+	if err := h.flash.set(w, r, signingFlashPrefix+keyID+" "+secret); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	http.Redirect(w, r, "/admin/tokens", http.StatusSeeOther)
 }
 
-func (h *AdminHandler) cors(w http.ResponseWriter, r *http.Request) {
-	origins := h.store.GetSetting(corsSettingKey, "*")
-	h.render(w, "cors", map[string]any{
-		"Origins": origins,
-		"Saved":   r.URL.Query().Get("saved") == "1",
-	})
-}
-
-func (h *AdminHandler) saveCORS(w http.ResponseWriter, r *http.Request) {
-	origins := strings.TrimSpace(r.FormValue("origins"))
-	if origins == "" {
-		origins = "*"
+// rotateToken issues a replacement token, keeping the old value valid for the
+// chosen grace period, and reveals the new value once.
+func (h *AdminHandler) rotateToken(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	grace, err := time.ParseDuration(r.FormValue("grace"))
+	if err != nil || grace < 0 {
+		http.Error(w, "invalid grace period", http.StatusBadRequest)
+		return
+	}
+	tok, err := h.store.RotateToken(id, grace)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	if err := h.store.SetSetting(corsSettingKey, origins); err != nil {
+	h.audit(r, "token.rotate", tokenTarget(id, tok.Name), fmt.Sprintf("replacement=%d grace=%s", tok.ID, grace))
+	if err := h.flash.set(w, r, tok.Token); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	http.Redirect(w, r, "/admin/cors?saved=1", http.StatusSeeOther)
+	http.Redirect(w, r, "/admin/tokens", http.StatusSeeOther)
 }
 
-func (h *AdminHandler) logs(w http.ResponseWriter, r *http.Request) {
-	limit := 200
-	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 2000 {
-		limit = l
+func (h *AdminHandler) deleteToken(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err := h.store.DeleteToken(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	logs, err := h.store.ListLogs(limit)
-	if err != nil {
+	h.audit(r, "token.delete", tokenTarget(id, ""), "")
+	http.Redirect(w, r, "/admin/tokens", http.StatusSeeOther)
+}
+
+func (h *AdminHandler) toggleToken(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	enabled := r.FormValue("enabled") == "true"
+	if err := h.store.SetTokenEnabled(id, enabled); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	h.render(w, "logs", map[string]any{"Logs": logs, "Limit": limit})
+	action := "token.disable"
+	if enabled {
+		action = "token.enable"
+	}
+	h.audit(r, action, tokenTarget(id, ""), "")
+	http.Redirect(w, r, "/admin/tokens", http.StatusSeeOther)
 }
 
-// CORSOriginProvider returns an origin provider backed by the datastore setting,
-// falling back to def when unset.
-func CORSOriginProvider(st *store.Store, def []string) func() []string {
-	defStr := strings.Join(def, ",")
-	return func() []string {
-		raw := st.GetSetting(corsSettingKey, defStr)
-		var out []string
-		for _, o := range strings.Split(raw, ",") {
-			if o = strings.TrimSpace(o); o != "" {
-				out = append(out, o)
-			}
-		}
-		if len(out) == 0 {
-			return []string{"*"}
-		}
-		return out
+// tokenTarget names a token in audit entries.
+func tokenTarget(id int64, name string) string {
+	if name == "" {
+		return fmt.Sprintf("token #%d", id)
 	}
+	return fmt.Sprintf("token #%d (%s)", id, name)
 }
 
-// SeedCORSSetting stores the initial CORS setting if none exists yet.
-func SeedCORSSetting(st *store.Store, def []string) error {
-	if st.GetSetting(corsSettingKey, "") != "" {
-		return nil
+// tokenDetail summarizes a token's restrictions for audit entries.
+func tokenDetail(scopes []string, expires *time.Time) string {
+	d := "scopes=all"
+	if len(scopes) > 0 {
+		d = "scopes=" + strings.Join(scopes, ",")
+	}
+	if expires != nil {
+		d += " expires=" + expires.UTC().Format(time.RFC3339)
 	}
-	return st.SetSetting(corsSettingKey, strings.Join(def, ","))
+	return d
 }
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/zupolgec/curl-impersonate-service/store"
+)
+
+// analyticsRange is a selectable time window on the analytics page.
+type analyticsRange struct {
+	Key    string
+	Label  string
+	Span   time.Duration
+	Period string
+}
+
+var analyticsRanges = []analyticsRange{
+	{"24h", "24 hours", 24 * time.Hour, store.PeriodHour},
+	{"7d", "7 days", 7 * 24 * time.Hour, store.PeriodHour},
+	{"30d", "30 days", 30 * 24 * time.Hour, store.PeriodDay},
+	{"90d", "90 days", 90 * 24 * time.Hour, store.PeriodDay},
+	{"365d", "1 year", 365 * 24 * time.Hour, store.PeriodDay},
+}
+
+// chartBar is one pre-computed bar of the SVG usage chart.
+type chartBar struct {
+	X, Width     float64
+	Y, Height    float64
+	FailY, FailH float64
+	Title        string
+}
+
+const (
+	chartWidth  = 952.0
+	chartHeight = 160.0
+)
+
+func (h *AdminHandler) analytics(w http.ResponseWriter, r *http.Request) {
+	rng := analyticsRanges[0]
+	for _, ar := range analyticsRanges {
+		if ar.Key == r.URL.Query().Get("range") {
+			rng = ar
+		}
+	}
+	token := r.URL.Query().Get("token_name")
+
+	step := time.Hour
+	if rng.Period == store.PeriodDay {
+		step = 24 * time.Hour
+	}
+	until := time.Now().UTC().Truncate(step)
+	q := store.RollupQuery{Period: rng.Period, Since: until.Add(-rng.Span), Until: until, TokenName: token}
+
+	series, err := h.store.UsageSeries(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	breakdowns := map[string][]store.RollupGroup{}
+	for _, dim := range []string{"token_name", "browser", "target_host", "error_type"} {
+		if breakdowns[dim], err = h.store.UsageBreakdown(q, dim, 10); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	blocks, err := h.store.BlockBreakdown(q.Since, 20)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var total store.RollupPoint
+	var p95w float64
+	for _, p := range series {
+		total.Requests += p.Requests
+		total.Failed += p.Failed
+		total.AvgMs += p.AvgMs * float64(p.Requests)
+		p95w += p.P95Ms * float64(p.Requests)
+	}
+	if total.Requests > 0 {
+		total.AvgMs /= float64(total.Requests)
+		total.P95Ms = p95w / float64(total.Requests)
+	}
+
+	h.render(w, r, "analytics", map[string]any{
+		"Ranges":     analyticsRanges,
+		"Range":      rng.Key,
+		"TokenName":  token,
+		"Bars":       buildChart(series, q.Since, until, step),
+		"Total":      total,
+		"Breakdowns": breakdowns,
+		"Blocks":     blocks,
+	})
+}
+
+// buildChart lays out one bar per bucket in [since, until), including empty
+// buckets, scaled to the busiest bucket.
+func buildChart(series []store.RollupPoint, since, until time.Time, step time.Duration) []chartBar {
+	byBucket := make(map[int64]store.RollupPoint, len(series))
+	var peak int64
+	for _, p := range series {
+		byBucket[p.Bucket.Unix()] = p
+		peak = max(peak, p.Requests)
+	}
+	n := int(until.Sub(since) / step)
+	if n <= 0 {
+		return nil
+	}
+	width := chartWidth / float64(n)
+	layout := "2006-01-02 15:00"
+	if step >= 24*time.Hour {
+		layout = "2006-01-02"
+	}
+
+	bars := make([]chartBar, 0, n)
+	for i := range n {
+		t := since.Add(time.Duration(i) * step)
+		p := byBucket[t.Unix()]
+		bar := chartBar{
+			X:     float64(i) * width,
+			Width: max(width-1, 0.5),
+			Title: fmt.Sprintf("%s UTC · %d requests, %d failed, p95 %.0f ms", t.Format(layout), p.Requests, p.Failed, p.P95Ms),
+		}
+		if peak > 0 {
+			bar.Height = chartHeight * float64(p.Requests) / float64(peak)
+			bar.FailH = chartHeight * float64(p.Failed) / float64(peak)
+		}
+		bar.Y = chartHeight - bar.Height
+		bar.FailY = chartHeight - bar.FailH
+		bars = append(bars, bar)
+	}
+	return bars
+}
@@ -0,0 +1,489 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zupolgec/curl-impersonate-service/metrics"
+	"github.com/zupolgec/curl-impersonate-service/middleware"
+	"github.com/zupolgec/curl-impersonate-service/models"
+	"github.com/zupolgec/curl-impersonate-service/settings"
+	"github.com/zupolgec/curl-impersonate-service/store"
+)
+
+// adminAPIPrefix is the versioned root of the JSON admin API.
+const adminAPIPrefix = "/admin/api/v1"
+
+// maxAdminAPIBody caps JSON request bodies of the admin API.
+const maxAdminAPIBody = 1 << 20
+
+// AdminAPIHandler serves the JSON admin API, the automation counterpart of
+// the HTML admin UI. Errors use models.ErrorResponse.
+type AdminAPIHandler struct {
+	store     *store.Store
+	collector *metrics.Collector
+	settings  *settings.Registry
+}
+
+// NewAdminAPIHandler builds the JSON admin API, mounted under /admin/api/.
+// reg holds the runtime settings served under /settings.
+func NewAdminAPIHandler(st *store.Store, collector *metrics.Collector, reg *settings.Registry) http.Handler {
+	h := &AdminAPIHandler{store: st, collector: collector, settings: reg}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+adminAPIPrefix+"/tokens", h.listTokens)
+	mux.HandleFunc("POST "+adminAPIPrefix+"/tokens", h.createToken)
+	mux.HandleFunc("GET "+adminAPIPrefix+"/tokens/{id}", h.getToken)
+	mux.HandleFunc("PATCH "+adminAPIPrefix+"/tokens/{id}", h.updateToken)
+	mux.HandleFunc("DELETE "+adminAPIPrefix+"/tokens/{id}", h.deleteToken)
+	mux.HandleFunc("POST "+adminAPIPrefix+"/tokens/{id}/enable", h.setTokenEnabled(true))
+	mux.HandleFunc("POST "+adminAPIPrefix+"/tokens/{id}/disable", h.setTokenEnabled(false))
+	mux.HandleFunc("POST "+adminAPIPrefix+"/tokens/{id}/rotate", h.rotateToken)
+	mux.HandleFunc("POST "+adminAPIPrefix+"/tokens/{id}/signing", h.enableSigning)
+	mux.HandleFunc("DELETE "+adminAPIPrefix+"/tokens/{id}/signing", h.disableSigning)
+	mux.HandleFunc("GET "+adminAPIPrefix+"/settings", h.getSettings)
+	mux.HandleFunc("GET "+adminAPIPrefix+"/settings/details", h.settingDetails)
+	mux.HandleFunc("PATCH "+adminAPIPrefix+"/settings", h.updateSettings)
+	mux.HandleFunc("GET "+adminAPIPrefix+"/logs", h.logs)
+	mux.HandleFunc("GET "+adminAPIPrefix+"/metrics", h.metrics)
+	mux.HandleFunc("POST "+adminAPIPrefix+"/metrics/reset", h.resetMetrics)
+	mux.HandleFunc("/admin/api/", func(w http.ResponseWriter, r *http.Request) {
+		models.WriteJSONError(w, http.StatusNotFound, "not_found", "no such admin API endpoint: "+r.Method+" "+r.URL.Path)
+	})
+	return mux
+}
+
+// apiToken is the JSON form of a token. It never includes the secret.
+type apiToken struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	Prefix string `json:"prefix"`
+	// Imported tokens weren't generated by the service; their prefix is a
+	// random display id.
+	Imported   bool       `json:"imported"`
+	Enabled    bool       `json:"enabled"`
+	Expired    bool       `json:"expired"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ReplacedBy int64      `json:"replaced_by,omitempty"`
+	// SigningKeyID is set when the token has a request-signing key.
+	SigningKeyID string `json:"signing_key_id,omitempty"`
+	SignedOnly   bool   `json:"signed_only"`
+	QueryToken   bool   `json:"query_token"`
+}
+
+// issuedSigningKey is returned when a signing key is minted. It is the only
+// time the secret is available.
+type issuedSigningKey struct {
+	Token  apiToken `json:"token"`
+	KeyID  string   `json:"key_id"`
+	Secret string   `json:"secret"`
+}
+
+// issuedToken is returned when a secret is minted (create, rotate). It is
+// the only time the secret is available.
+type issuedToken struct {
+	Token  apiToken `json:"token"`
+	Secret string   `json:"secret"`
+}
+
+func toAPIToken(t store.Token) apiToken {
+	out := apiToken{
+		ID:         t.ID,
+		Name:       t.Name,
+		Prefix:     t.Prefix,
+		Imported:   t.Imported,
+		Enabled:    t.Enabled,
+		Expired:    t.Expired(time.Now()),
+		Scopes:     t.Scopes,
+		CreatedAt:  t.CreatedAt.UTC(),
+		ReplacedBy: t.ReplacedBy,
+
+		SigningKeyID: t.SigningKeyID,
+		SignedOnly:   t.SignedOnly,
+		QueryToken:   !t.NoQueryToken,
+	}
+	if out.Scopes == nil {
+		out.Scopes = []string{}
+	}
+	if t.ExpiresAt != nil {
+		e := t.ExpiresAt.UTC()
+		out.ExpiresAt = &e
+	}
+	if t.LastUsedAt != nil {
+		u := t.LastUsedAt.UTC()
+		out.LastUsedAt = &u
+	}
+	return out
+}
+
+func (h *AdminAPIHandler) listTokens(w http.ResponseWriter, r *http.Request) {
+	toks, err := h.store.ListTokens()
+	if err != nil {
+		models.WriteJSONError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	out := make([]apiToken, 0, len(toks))
+	for _, t := range toks {
+		out = append(out, toAPIToken(t))
+	}
+	models.WriteJSON(w, http.StatusOK, map[string]any{"tokens": out})
+}
+
+func (h *AdminAPIHandler) createToken(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if !decodeAPIBody(w, r, &body) {
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		models.WriteJSONError(w, http.StatusBadRequest, "validation", "name is required")
+		return
+	}
+	if body.ExpiresAt != nil && !body.ExpiresAt.After(time.Now()) {
+		models.WriteJSONError(w, http.StatusBadRequest, "validation", "expires_at must be in the future")
+		return
+	}
+	tok, err := h.store.CreateToken(body.Name, store.TokenOptions{Scopes: body.Scopes, ExpiresAt: body.ExpiresAt})
+	if err != nil {
+		models.WriteJSONError(w, http.StatusBadRequest, "validation", err.Error())
+		return
+	}
+	h.audit(r, "token.create", tokenTarget(tok.ID, tok.Name), tokenDetail(tok.Scopes, tok.ExpiresAt))
+	models.WriteJSON(w, http.StatusCreated, issuedToken{Token: toAPIToken(*tok), Secret: tok.Token})
+}
+
+func (h *AdminAPIHandler) getToken(w http.ResponseWriter, r *http.Request) {
+	id, ok := tokenID(w, r)
+	if !ok {
+		return
+	}
+	h.writeToken(w, id)
+}
+
+func (h *AdminAPIHandler) updateToken(w http.ResponseWriter, r *http.Request) {
+	id, ok := tokenID(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Name      *string         `json:"name"`
+		Enabled   *bool           `json:"enabled"`
+		Scopes    *[]string       `json:"scopes"`
+		ExpiresAt json.RawMessage `json:"expires_at"`
+		// SignedOnly refuses bearer use; QueryToken allows ?token=.
+		SignedOnly *bool `json:"signed_only"`
+		QueryToken *bool `json:"query_token"`
+	}
+	if !decodeAPIBody(w, r, &body) {
+		return
+	}
+	u := store.TokenUpdate{Name: body.Name, Enabled: body.Enabled, Scopes: body.Scopes, SignedOnly: body.SignedOnly}
+	if body.QueryToken != nil {
+		noQuery := !*body.QueryToken
+		u.NoQueryToken = &noQuery
+	}
+	if u.Name != nil {
+		name := strings.TrimSpace(*u.Name)
+		if name == "" {
+			models.WriteJSONError(w, http.StatusBadRequest, "validation", "name must not be empty")
+			return
+		}
+		u.Name = &name
+	}
+	switch exp := string(body.ExpiresAt); exp {
+	case "":
+	case "null":
+		u.ClearExpiry = true
+	default:
+		var t time.Time
+		if err := json.Unmarshal(body.ExpiresAt, &t); err != nil {
+			models.WriteJSONError(w, http.StatusBadRequest, "validation", "expires_at must be an RFC 3339 time or null")
+			return
+		}
+		u.ExpiresAt = &t
+	}
+	if err := h.store.UpdateToken(id, u); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	detail, _ := json.Marshal(body)
+	h.audit(r, "token.update", tokenTarget(id, ""), string(detail))
+	h.writeToken(w, id)
+}
+
+func (h *AdminAPIHandler) deleteToken(w http.ResponseWriter, r *http.Request) {
+	id, ok := tokenID(w, r)
+	if !ok {
+		return
+	}
+	if _, err := h.store.GetToken(id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if err := h.store.DeleteToken(id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	h.audit(r, "token.delete", tokenTarget(id, ""), "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminAPIHandler) setTokenEnabled(enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := tokenID(w, r)
+		if !ok {
+			return
+		}
+		if err := h.store.UpdateToken(id, store.TokenUpdate{Enabled: &enabled}); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		action := "token.disable"
+		if enabled {
+			action = "token.enable"
+		}
+		h.audit(r, action, tokenTarget(id, ""), "")
+		h.writeToken(w, id)
+	}
+}
+
+func (h *AdminAPIHandler) rotateToken(w http.ResponseWriter, r *http.Request) {
+	id, ok := tokenID(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		// Grace is a Go duration such as "24h"; empty means no grace.
+		Grace string `json:"grace"`
+	}
+	if r.ContentLength != 0 && !decodeAPIBody(w, r, &body) {
+		return
+	}
+	var grace time.Duration
+	if body.Grace != "" {
+		var err error
+		if grace, err = time.ParseDuration(body.Grace); err != nil || grace < 0 {
+			models.WriteJSONError(w, http.StatusBadRequest, "validation", "grace must be a non-negative duration such as \"24h\"")
+			return
+		}
+	}
+	tok, err := h.store.RotateToken(id, grace)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	h.audit(r, "token.rotate", tokenTarget(id, tok.Name), fmt.Sprintf("replacement=%d grace=%s", tok.ID, grace))
+	models.WriteJSON(w, http.StatusCreated, issuedToken{Token: toAPIToken(*tok), Secret: tok.Token})
+}
+
+// enableSigning issues a new signing key for a token, replacing any previous
+// one.
+func (h *AdminAPIHandler) enableSigning(w http.ResponseWriter, r *http.Request) {
+	id, ok := tokenID(w, r)
+	if !ok {
+		return
+	}
+	keyID, secret, err := h.store.EnableSigning(id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	tok, err := h.store.GetToken(id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	h.audit(r, "token.signing.enable", tokenTarget(id, tok.Name), "key="+keyID)
+	models.WriteJSON(w, http.StatusCreated, issuedSigningKey{Token: toAPIToken(*tok), KeyID: keyID, Secret: secret})
+}
+
+func (h *AdminAPIHandler) disableSigning(w http.ResponseWriter, r *http.Request) {
+	id, ok := tokenID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.store.DisableSigning(id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	h.audit(r, "token.signing.disable", tokenTarget(id, ""), "")
+	h.writeToken(w, id)
+}
+
+func (h *AdminAPIHandler) writeToken(w http.ResponseWriter, id int64) {
+	tok, err := h.store.GetToken(id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	models.WriteJSON(w, http.StatusOK, toAPIToken(*tok))
+}
+
+// apiSetting describes one runtime setting.
+type apiSetting struct {
+	Key     string `json:"key"`
+	Kind    string `json:"kind"`
+	Value   any    `json:"value"`
+	Default string `json:"default"`
+	Source  string `json:"source"`
+	Env     string `json:"env,omitempty"`
+	Help    string `json:"help"`
+}
+
+// getSettings returns the current runtime settings as a key/value object.
+func (h *AdminAPIHandler) getSettings(w http.ResponseWriter, r *http.Request) {
+	out := map[string]any{}
+	for _, e := range h.settings.Entries() {
+		out[e.Key()] = e.JSONValue()
+	}
+	models.WriteJSON(w, http.StatusOK, out)
+}
+
+// settingDetails lists the runtime settings with their defaults and sources.
+func (h *AdminAPIHandler) settingDetails(w http.ResponseWriter, r *http.Request) {
+	out := []apiSetting{}
+	for _, e := range h.settings.Entries() {
+		out = append(out, apiSetting{
+			Key: e.Key(), Kind: e.Kind(), Value: e.JSONValue(), Default: e.Default(),
+			Source: e.Source(), Env: e.Env(), Help: e.Help(),
+		})
+	}
+	models.WriteJSON(w, http.StatusOK, out)
+}
+
+// updateSettings sets the settings in the body; null resets a setting to its
+// default. Every value is validated before any is applied.
+func (h *AdminAPIHandler) updateSettings(w http.ResponseWriter, r *http.Request) {
+	var body map[string]json.RawMessage
+	if !decodeAPIBody(w, r, &body) {
+		return
+	}
+	keys := make([]string, 0, len(body))
+	values := map[string]string{}
+	for key, raw := range body {
+		keys = append(keys, key)
+		if string(raw) == "null" {
+			if _, ok := h.settings.Lookup(key); !ok {
+				models.WriteJSONError(w, http.StatusBadRequest, "validation", fmt.Sprintf("%v: %s", settings.ErrUnknownSetting, key))
+				return
+			}
+			continue
+		}
+		value, err := h.settings.ValidateJSON(key, raw)
+		if err != nil {
+			models.WriteJSONError(w, http.StatusBadRequest, "validation", err.Error())
+			return
+		}
+		values[key] = value
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, set := values[key]
+		var err error
+		if set {
+			_, err = h.settings.Set(key, value)
+		} else {
+			err = h.settings.Reset(key)
+		}
+		if err != nil {
+			models.WriteJSONError(w, http.StatusInternalServerError, "internal", err.Error())
+			return
+		}
+		if set {
+			h.audit(r, "settings.update", key, value)
+		} else {
+			h.audit(r, "settings.reset", key, "")
+		}
+	}
+	h.getSettings(w, r)
+}
+
+// logs takes the same query parameters as /admin/logs/search.
+func (h *AdminAPIHandler) logs(w http.ResponseWriter, r *http.Request) {
+	f, _, err := parseLogFilter(r.URL.Query(), 100)
+	if err != nil {
+		models.WriteJSONError(w, http.StatusBadRequest, "validation", err.Error())
+		return
+	}
+	page, err := h.store.QueryLogs(f)
+	if err != nil {
+		models.WriteJSONError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	if page.Logs == nil {
+		page.Logs = []store.LogEntry{}
+	}
+	models.WriteJSON(w, http.StatusOK, page)
+}
+
+func (h *AdminAPIHandler) metrics(w http.ResponseWriter, r *http.Request) {
+	models.WriteJSON(w, http.StatusOK, metricsResponse(h.collector))
+}
+
+func (h *AdminAPIHandler) resetMetrics(w http.ResponseWriter, r *http.Request) {
+	h.collector.Reset()
+	if err := h.collector.SaveSnapshot(h.store); err != nil {
+		models.WriteJSONError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	h.audit(r, "metrics.reset", "", "")
+	models.WriteJSON(w, http.StatusOK, metricsResponse(h.collector))
+}
+
+// audit records an API action. The actor is the admin-scoped token's name,
+// or "admin-token" for the ADMIN_TOKEN itself.
+func (h *AdminAPIHandler) audit(r *http.Request, action, target, detail string) {
+	actor := "admin-token"
+	if name := middleware.TokenName(r.Context()); name != "" {
+		actor = "token:" + name
+	}
+	recordAudit(h.store, r, actor, action, target, detail)
+}
+
+func tokenID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		models.WriteJSONError(w, http.StatusBadRequest, "validation", "invalid token id")
+		return 0, false
+	}
+	return id, true
+}
+
+// decodeAPIBody parses a JSON request body strictly, writing the error
+// response itself on failure.
+func decodeAPIBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxAdminAPIBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		models.WriteJSONError(w, http.StatusBadRequest, "validation", fmt.Sprintf("invalid JSON body: %v", err))
+		return false
+	}
+	return true
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		models.WriteJSONError(w, http.StatusNotFound, "not_found", "token not found")
+	case errors.Is(err, models.ErrUnknownScope), errors.Is(err, store.ErrNoSigningKey),
+		errors.Is(err, store.ErrNoSecretsKey):
+		models.WriteJSONError(w, http.StatusBadRequest, "validation", err.Error())
+	default:
+		models.WriteJSONError(w, http.StatusInternalServerError, "internal", err.Error())
+	}
+}
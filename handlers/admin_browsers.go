@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zupolgec/curl-impersonate-service/models"
+)
+
+// aliasRow is one alias on the Browsers page.
+type aliasRow struct {
+	Alias, Target string
+}
+
+// profileForm is a custom profile as edited on the Browsers page: headers
+// go one "Name: value" per line and the header order one name per line.
+type profileForm struct {
+	models.Profile
+	HeadersText, OrderText string
+}
+
+func newProfileForm(p models.Profile) profileForm {
+	f := profileForm{Profile: p, OrderText: strings.Join(p.HeaderOrder, "\n")}
+	names := make([]string, 0, len(p.Headers))
+	for name := range p.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f.HeadersText += name + ": " + p.Headers[name] + "\n"
+	}
+	if f.TLS == nil {
+		f.TLS = &models.TLSOptions{}
+	}
+	if f.HTTP2 == nil {
+		f.HTTP2 = &models.HTTP2Options{}
+	}
+	return f
+}
+
+func (h *AdminHandler) browsers(w http.ResponseWriter, r *http.Request) {
+	notice := h.flash.pop(w, r)
+	set := models.CurrentBrowsers()
+	var aliases []aliasRow
+	for alias, target := range models.GetAliases() {
+		aliases = append(aliases, aliasRow{Alias: alias, Target: target})
+	}
+	sort.Slice(aliases, func(i, j int) bool { return aliases[i].Alias < aliases[j].Alias })
+
+	var builtins, profiles []models.BrowserConfig
+	edit := newProfileForm(models.Profile{})
+	for _, b := range models.GetAllBrowsers() {
+		if b.Profile == nil {
+			builtins = append(builtins, b)
+			continue
+		}
+		profiles = append(profiles, b)
+		if b.Name == r.URL.Query().Get("edit") && b.Profile.Source == models.ProfileSourceStore {
+			edit = newProfileForm(*b.Profile)
+		}
+	}
+	h.render(w, r, "browsers", map[string]any{
+		"Browsers":    builtins,
+		"Profiles":    profiles,
+		"Aliases":     aliases,
+		"Pools":       models.GetPools(),
+		"Edit":        edit,
+		"FileDefault": set.Default(),
+		"Default":     models.GetDefaultBrowser(),
+		"Path":        set.Path,
+		"LoadedAt":    set.LoadedAt,
+		"CanReload":   h.opts.ReloadBrowsers != nil,
+		"Notice":      notice,
+	})
+}
+
+func (h *AdminHandler) reloadBrowsers(w http.ResponseWriter, r *http.Request) {
+	if err := h.opts.ReloadBrowsers(); err != nil {
+		h.audit(r, "browsers.reload", "", "failed: "+err.Error())
+		h.redirectBrowsers(w, r, "Reload failed, the loaded browsers are unchanged: "+err.Error())
+		return
+	}
+	h.audit(r, "browsers.reload", "", "")
+	h.redirectBrowsers(w, r, fmt.Sprintf("Reloaded %d browsers.", models.CurrentBrowsers().Len()))
+}
+
+func (h *AdminHandler) saveProfile(w http.ResponseWriter, r *http.Request) {
+	p, err := profileFromForm(r)
+	if err == nil {
+		err = models.CheckProfile(p)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.store.SaveProfile(p); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.applyProfiles()
+	h.audit(r, "profiles.save", p.Name, "base="+p.Base)
+	h.redirectBrowsers(w, r, fmt.Sprintf("Profile %s saved.", p.Name))
+}
+
+func (h *AdminHandler) deleteProfile(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("name")
+	if err := h.store.DeleteProfile(name); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, sql.ErrNoRows) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	h.applyProfiles()
+	h.audit(r, "profiles.delete", name, "")
+	h.redirectBrowsers(w, r, fmt.Sprintf("Profile %s deleted.", name))
+}
+
+// applyProfiles makes the stored profiles current after a change.
+func (h *AdminHandler) applyProfiles() {
+	profiles, err := h.store.ListProfiles()
+	if err == nil {
+		err = models.SetStoredProfiles(profiles)
+	}
+	if err != nil {
+		log.Printf("Warning: browser profiles: %v", err)
+	}
+}
+
+// profileFromForm reads a profile from the Browsers page form.
+func profileFromForm(r *http.Request) (models.Profile, error) {
+	p := models.Profile{
+		Name:           strings.TrimSpace(r.FormValue("name")),
+		Base:           strings.TrimSpace(r.FormValue("base")),
+		AcceptLanguage: strings.TrimSpace(r.FormValue("accept_language")),
+		TLS: &models.TLSOptions{
+			Ciphers:           strings.TrimSpace(r.FormValue("ciphers")),
+			Curves:            strings.TrimSpace(r.FormValue("curves")),
+			SignatureHashes:   strings.TrimSpace(r.FormValue("signature_hashes")),
+			ExtensionOrder:    strings.TrimSpace(r.FormValue("extension_order")),
+			PermuteExtensions: r.FormValue("permute_extensions") == "on",
+		},
+		HTTP2: &models.HTTP2Options{
+			PseudoHeadersOrder: strings.TrimSpace(r.FormValue("pseudo_headers_order")),
+			Settings:           strings.TrimSpace(r.FormValue("http2_settings")),
+		},
+	}
+	if raw := strings.TrimSpace(r.FormValue("window_update")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return p, fmt.Errorf("window_update: want a number")
+		}
+		p.HTTP2.WindowUpdate = n
+	}
+	if *p.TLS == (models.TLSOptions{}) {
+		p.TLS = nil
+	}
+	if *p.HTTP2 == (models.HTTP2Options{}) {
+		p.HTTP2 = nil
+	}
+	for _, line := range strings.Split(r.FormValue("headers"), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return p, fmt.Errorf("headers: want Name: value, got %q", line)
+		}
+		if p.Headers == nil {
+			p.Headers = map[string]string{}
+		}
+		p.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	for _, name := range strings.FieldsFunc(r.FormValue("header_order"), func(r rune) bool { return r == ',' || r == '\n' }) {
+		if name = strings.TrimSpace(name); name != "" {
+			p.HeaderOrder = append(p.HeaderOrder, name)
+		}
+	}
+	return p, nil
+}
+
+func (h *AdminHandler) redirectBrowsers(w http.ResponseWriter, r *http.Request, notice string) {
+	if err := h.flash.set(w, r, notice); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin/browsers", http.StatusSeeOther)
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zupolgec/curl-impersonate-service/capture"
+	"github.com/zupolgec/curl-impersonate-service/models"
+	"github.com/zupolgec/curl-impersonate-service/store"
+)
+
+// captureDurations are the time boxes offered when switching capture on.
+var captureDurations = []struct {
+	Value string
+	Label string
+}{
+	{"15m", "15 minutes"},
+	{"1h", "1 hour"},
+	{"4h", "4 hours"},
+	{"24h", "24 hours"},
+}
+
+func (h *AdminHandler) captures(w http.ResponseWriter, r *http.Request) {
+	sessions, err := h.store.ListCaptureSessions(50)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sessionID, _ := strconv.ParseInt(r.URL.Query().Get("session"), 10, 64)
+	caps, err := h.store.ListCaptures(sessionID, 200)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	toks, err := h.store.ListTokens()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.render(w, r, "captures", map[string]any{
+		"Sessions":  sessions,
+		"Captures":  caps,
+		"SessionID": sessionID,
+		"Tokens":    toks,
+		"Durations": captureDurations,
+	})
+}
+
+func (h *AdminHandler) startCapture(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.FormValue("token_name"))
+	if name == "" {
+		http.Error(w, "token_name is required", http.StatusBadRequest)
+		return
+	}
+	d, err := time.ParseDuration(r.FormValue("duration"))
+	if err != nil || d <= 0 || d > 24*time.Hour {
+		http.Error(w, "duration must be between 1s and 24h", http.StatusBadRequest)
+		return
+	}
+	if _, err := h.store.StartCapture(name, d); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, "capture.start", name, "duration="+d.String())
+	http.Redirect(w, r, "/admin/captures", http.StatusSeeOther)
+}
+
+func (h *AdminHandler) stopCapture(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err := h.store.StopCapture(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, "capture.stop", fmt.Sprintf("session #%d", id), "")
+	http.Redirect(w, r, "/admin/captures", http.StatusSeeOther)
+}
+
+func (h *AdminHandler) captureDetail(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	c, err := h.store.GetCapture(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var timing string
+	if len(c.Timing) > 0 {
+		if b, err := json.MarshalIndent(c.Timing, "", "  "); err == nil {
+			timing = string(b)
+		}
+	}
+	h.render(w, r, "capture", map[string]any{
+		"Capture":   c,
+		"Timing":    timing,
+		"Form":      defaultReplayForm(c),
+		"Browsers":  browserChoices(),
+		"CanReplay": h.replay != nil,
+		"Redacted":  strings.Join(redactedParts(c), " and "),
+	})
+}
+
+// replayForm holds the overrides offered when replaying a capture.
+type replayForm struct {
+	Browser         string
+	Proxy           string
+	Headers         string
+	FollowRedirects bool
+	Insecure        bool
+	Timeout         int
+}
+
+// defaultReplayForm pre-fills the replay form from a capture. Redacted header
+// values can't be replayed and are left out.
+func defaultReplayForm(c *store.Capture) replayForm {
+	f := replayForm{Browser: c.Browser, FollowRedirects: true}
+	var lines []string
+	for name, vals := range c.RequestHeaders {
+		for _, v := range vals {
+			if v != capture.Redacted {
+				lines = append(lines, name+": "+v)
+			}
+		}
+	}
+	sort.Strings(lines)
+	f.Headers = strings.Join(lines, "\n")
+	return f
+}
+
+// redactedParts names the parts of a capture's request that hold redacted
+// values. Replaying them would send the placeholders to the target. Redacted
+// headers are not listed: the replay form leaves them out.
+func redactedParts(c *store.Capture) []string {
+	var parts []string
+	if strings.Contains(c.URL, capture.Redacted) || strings.Contains(c.URL, url.QueryEscape(capture.Redacted)) {
+		parts = append(parts, "URL")
+	}
+	if !c.RequestBodyBase64 && strings.Contains(c.RequestBody, capture.Redacted) {
+		parts = append(parts, "request body")
+	}
+	return parts
+}
+
+// parseHeaderLines parses "Name: value" lines; blank lines are skipped.
+func parseHeaderLines(text string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid header line %q: want \"Name: value\"", line)
+		}
+		out[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return out, nil
+}
+
+// replayComparison is the side-by-side view of a capture and its replay.
+type replayComparison struct {
+	Capture       *store.Capture
+	Form          replayForm
+	Browser       string
+	Response      *models.ImpersonateResponse
+	Body          string
+	BodyTruncated bool
+	Before        *models.Timing
+	Headers       []capture.HeaderDiff
+	BodyDiff      []capture.DiffLine
+	BodyDiffable  bool
+}
+
+func (h *AdminHandler) replayCapture(w http.ResponseWriter, r *http.Request) {
+	if h.replay == nil {
+		http.Error(w, "replay is not available", http.StatusServiceUnavailable)
+		return
+	}
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	c, err := h.store.GetCapture(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if c.RequestTruncated {
+		http.Error(w, "the captured request body was truncated and can't be replayed", http.StatusBadRequest)
+		return
+	}
+	if parts := redactedParts(c); len(parts) > 0 {
+		http.Error(w, "the captured request has redacted values in its "+strings.Join(parts, " and ")+" and can't be replayed", http.StatusBadRequest)
+		return
+	}
+
+	form := replayForm{
+		Browser:         strings.TrimSpace(r.FormValue("browser")),
+		Proxy:           strings.TrimSpace(r.FormValue("proxy")),
+		Headers:         r.FormValue("headers"),
+		FollowRedirects: r.FormValue("follow_redirects") == "on",
+		Insecure:        r.FormValue("insecure") == "on",
+	}
+	form.Timeout, _ = strconv.Atoi(r.FormValue("timeout"))
+	headers, err := parseHeaderLines(form.Headers)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for name, v := range headers {
+		if v == capture.Redacted {
+			http.Error(w, fmt.Sprintf("header %s is redacted: enter its real value or remove it", name), http.StatusBadRequest)
+			return
+		}
+	}
+
+	req := &models.ImpersonateRequest{
+		Browser:         form.Browser,
+		URL:             c.URL,
+		Method:          c.Method,
+		Headers:         headers,
+		FollowRedirects: form.FollowRedirects,
+		Insecure:        form.Insecure,
+		Timeout:         form.Timeout,
+		Proxy:           form.Proxy,
+	}
+	if c.RequestBodyBase64 {
+		req.BodyBase64 = c.RequestBody
+	} else {
+		req.Body = c.RequestBody
+	}
+
+	h.audit(r, "capture.replay", fmt.Sprintf("capture #%d", c.ID), fmt.Sprintf("browser=%s proxy=%t", form.Browser, form.Proxy != ""))
+	resp, browser, err := h.replay.Replay(r.Context(), c.TokenName, req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if re, ok := err.(*requestError); ok {
+			status = re.status
+		}
+		http.Error(w, "replay failed: "+err.Error(), status)
+		return
+	}
+
+	// Give the replay the same redaction and truncation as the capture so
+	// the two sides compare like for like.
+	red := h.replay.capture.Redactor()
+	cmp := replayComparison{Capture: c, Form: form, Browser: browser, Response: resp}
+	resp.Headers = red.ResponseHeaders(resp.Headers)
+	if resp.BodyBase64 {
+		cmp.Body, cmp.BodyTruncated = red.Truncate(resp.Body)
+	} else {
+		cmp.Body, cmp.BodyTruncated = red.Body([]byte(resp.Body))
+	}
+	if len(c.Timing) > 0 {
+		cmp.Before = &models.Timing{}
+		_ = json.Unmarshal(c.Timing, cmp.Before)
+	}
+	cmp.Headers = capture.DiffHeaders(c.ResponseHeaders, resp.Headers)
+	cmp.BodyDiff, cmp.BodyDiffable = capture.DiffLines(c.ResponseBody, cmp.Body)
+
+	h.render(w, r, "replay", map[string]any{
+		"Cmp":      cmp,
+		"Capture":  c,
+		"Form":     form,
+		"Browsers": browserChoices(),
+	})
+}
+
+// browserChoices lists aliases then profile names for the replay form.
+func browserChoices() []string {
+	var out []string
+	for alias := range models.GetAliases() {
+		out = append(out, alias)
+	}
+	sort.Strings(out)
+	var names []string
+	for _, b := range models.GetAllBrowsers() {
+		names = append(names, b.Name)
+	}
+	sort.Strings(names)
+	return append(out, names...)
+}
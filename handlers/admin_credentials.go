@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/zupolgec/curl-impersonate-service/store"
+)
+
+// credentials lists the stored CA bundles and client certificates. Their
+// PEM is never shown: it goes in when uploaded and out only to the executor.
+func (h *AdminHandler) credentials(w http.ResponseWriter, r *http.Request) {
+	list, err := h.store.ListCredentials()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.render(w, r, "credentials", map[string]any{
+		"Credentials": list,
+		"HasKey":      h.store.HasSecretsKey(),
+		"Notice":      h.flash.pop(w, r),
+	})
+}
+
+func (h *AdminHandler) saveCredential(w http.ResponseWriter, r *http.Request) {
+	kind, name := r.FormValue("kind"), strings.TrimSpace(r.FormValue("name"))
+	c, err := store.NewCredential(kind, name, r.FormValue("cert"), r.FormValue("key"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.store.SaveCredential(c); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, store.ErrNoSecretsKey) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	h.audit(r, "credentials.save", kind+"/"+name, "sha256="+c.Fingerprint)
+	h.redirectCredentials(w, r, fmt.Sprintf("%s %s saved.", kindLabel(kind), name))
+}
+
+func (h *AdminHandler) deleteCredential(w http.ResponseWriter, r *http.Request) {
+	kind, name := r.FormValue("kind"), r.FormValue("name")
+	if err := h.store.DeleteCredential(kind, name); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, sql.ErrNoRows) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	h.audit(r, "credentials.delete", kind+"/"+name, "")
+	h.redirectCredentials(w, r, fmt.Sprintf("%s %s deleted.", kindLabel(kind), name))
+}
+
+// kindLabel names a credential kind for notices.
+func kindLabel(kind string) string {
+	if kind == store.CredentialClientCert {
+		return "Client certificate"
+	}
+	return "CA bundle"
+}
+
+func (h *AdminHandler) redirectCredentials(w http.ResponseWriter, r *http.Request, notice string) {
+	if err := h.flash.set(w, r, notice); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin/credentials", http.StatusSeeOther)
+}
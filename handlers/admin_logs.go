@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zupolgec/curl-impersonate-service/models"
+	"github.com/zupolgec/curl-impersonate-service/store"
+)
+
+// maxLogPageSize caps the page size accepted from clients.
+const maxLogPageSize = 2000
+
+// logFilterForm mirrors the filter fields of the logs page so the template can
+// re-populate them and build pagination/export links.
+type logFilterForm struct {
+	Since     string
+	Until     string
+	Token     string
+	Browser   string
+	Host      string
+	Success   string
+	Status    string
+	ErrorType string
+	Replay    string
+	Blocked   string
+	Limit     int
+	Query     url.Values
+}
+
+func (h *AdminHandler) logs(w http.ResponseWriter, r *http.Request) {
+	f, form, err := parseLogFilter(r.URL.Query(), 200)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := h.store.QueryLogs(f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var next string
+	if page.NextCursor != "" {
+		q := cloneValues(form.Query)
+		q.Set("cursor", page.NextCursor)
+		next = "/admin/logs?" + q.Encode()
+	}
+	h.render(w, r, "logs", map[string]any{
+		"Logs":      page.Logs,
+		"Limit":     form.Limit,
+		"Filter":    form,
+		"Paged":     f.Cursor != "",
+		"Next":      next,
+		"ExportCSV": "/admin/logs/export?" + withParam(form.Query, "format", "csv"),
+		"ExportNDJ": "/admin/logs/export?" + withParam(form.Query, "format", "ndjson"),
+	})
+}
+
+// searchLogs is the JSON counterpart of the logs page: same filters, same
+// cursor pagination.
+func (h *AdminHandler) searchLogs(w http.ResponseWriter, r *http.Request) {
+	f, _, err := parseLogFilter(r.URL.Query(), 100)
+	if err != nil {
+		models.WriteJSONError(w, http.StatusBadRequest, "validation", err.Error())
+		return
+	}
+	page, err := h.store.QueryLogs(f)
+	if err != nil {
+		models.WriteJSONError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	if page.Logs == nil {
+		page.Logs = []store.LogEntry{}
+	}
+	models.WriteJSON(w, http.StatusOK, page)
+}
+
+// exportLogs streams the full filtered result set (ignoring pagination) as CSV
+// or NDJSON.
+func (h *AdminHandler) exportLogs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f, _, err := parseLogFilter(q, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.Cursor = ""
+
+	stamp := time.Now().UTC().Format("20060102-150405")
+	switch format := q.Get("format"); format {
+	case "", "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="usage-logs-%s.csv"`, stamp))
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"id", "ts", "token_name", "browser", "method", "target_host", "status_code", "success", "duration_ms", "error_type", "replay", "block_vendor", "block_kind"})
+		err = h.store.EachLog(f, func(e store.LogEntry) error {
+			return cw.Write([]string{
+				strconv.FormatInt(e.ID, 10),
+				e.TS.UTC().Format(time.RFC3339),
+				e.TokenName,
+				e.Browser,
+				e.Method,
+				e.TargetHost,
+				strconv.Itoa(e.StatusCode),
+				strconv.FormatBool(e.Success),
+				strconv.FormatInt(e.DurationMs, 10),
+				e.ErrorType,
+				strconv.FormatBool(e.Replay),
+				e.BlockVendor,
+				e.BlockKind,
+			})
+		})
+		cw.Flush()
+	case "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="usage-logs-%s.ndjson"`, stamp))
+		enc := json.NewEncoder(w)
+		err = h.store.EachLog(f, func(e store.LogEntry) error { return enc.Encode(e) })
+	default:
+		http.Error(w, "unsupported format: "+format, http.StatusBadRequest)
+		return
+	}
+	// Headers are already sent; all we can do is stop and log.
+	if err != nil {
+		log.Printf("usage log export aborted: %v", err)
+	}
+}
+
+// parseLogFilter reads log filters from query parameters. defLimit is the page
+// size used when none is given.
+func parseLogFilter(q url.Values, defLimit int) (store.LogFilter, logFilterForm, error) {
+	form := logFilterForm{
+		Since:     q.Get("since"),
+		Until:     q.Get("until"),
+		Token:     strings.TrimSpace(q.Get("token_name")),
+		Browser:   strings.TrimSpace(q.Get("browser")),
+		Host:      strings.TrimSpace(q.Get("host")),
+		Success:   q.Get("success"),
+		Status:    strings.TrimSpace(q.Get("status")),
+		ErrorType: strings.TrimSpace(q.Get("error_type")),
+		Replay:    q.Get("replay"),
+		Blocked:   q.Get("blocked"),
+		Limit:     defLimit,
+		Query:     url.Values{},
+	}
+	f := store.LogFilter{
+		TokenName:  form.Token,
+		Browser:    form.Browser,
+		TargetHost: form.Host,
+		ErrorType:  form.ErrorType,
+		Cursor:     q.Get("cursor"),
+	}
+
+	var err error
+	if f.Since, err = parseFilterTime(form.Since); err != nil {
+		return f, form, fmt.Errorf("invalid since: %w", err)
+	}
+	if f.Until, err = parseFilterTime(form.Until); err != nil {
+		return f, form, fmt.Errorf("invalid until: %w", err)
+	}
+	switch form.Success {
+	case "":
+	case "true", "false":
+		b := form.Success == "true"
+		f.Success = &b
+	default:
+		return f, form, fmt.Errorf("invalid success: must be true or false")
+	}
+	switch form.Replay {
+	case "":
+	case "true", "false":
+		b := form.Replay == "true"
+		f.Replay = &b
+	default:
+		return f, form, fmt.Errorf("invalid replay: must be true or false")
+	}
+	switch form.Blocked {
+	case "":
+	case "true", "false":
+		b := form.Blocked == "true"
+		f.Blocked = &b
+	default:
+		return f, form, fmt.Errorf("invalid blocked: must be true or false")
+	}
+	if form.Status != "" {
+		if f.StatusCode, err = strconv.Atoi(form.Status); err != nil {
+			return f, form, fmt.Errorf("invalid status: %s", form.Status)
+		}
+	}
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 || n > maxLogPageSize {
+			return f, form, fmt.Errorf("invalid limit: must be 1-%d", maxLogPageSize)
+		}
+		form.Limit = n
+	}
+	f.Limit = form.Limit
+
+	// Keep only the filter params that were set, for links.
+	for _, k := range []string{"since", "until", "token_name", "browser", "host", "success", "status", "error_type", "replay", "blocked", "limit"} {
+		if v := q.Get(k); v != "" {
+			form.Query.Set(k, v)
+		}
+	}
+	return f, form, nil
+}
+
+// parseFilterTime accepts RFC 3339 or the value of an HTML datetime-local
+// input (interpreted as UTC).
+func parseFilterTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, v, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q", v)
+}
+
+func cloneValues(v url.Values) url.Values {
+	out := make(url.Values, len(v))
+	for k, vals := range v {
+		out[k] = append([]string(nil), vals...)
+	}
+	return out
+}
+
+func withParam(v url.Values, key, value string) string {
+	q := cloneValues(v)
+	q.Set(key, value)
+	return q.Encode()
+}
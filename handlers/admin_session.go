@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/zupolgec/curl-impersonate-service/store"
+)
+
+const (
+	sessionCookie = "admin_session"
+	csrfField     = "csrf_token"
+	csrfHeader    = "X-CSRF-Token"
+
+	// bootstrapAdmin is the account signed in with ADMIN_TOKEN as password.
+	bootstrapAdmin = "admin"
+)
+
+type adminSessionKey struct{}
+
+// adminSession returns the session attached by requireRole, if any.
+func adminSession(ctx context.Context) *store.AdminSession {
+	sess, _ := ctx.Value(adminSessionKey{}).(*store.AdminSession)
+	return sess
+}
+
+// handle registers fn on the admin mux behind a session carrying at least
+// role. Unsafe methods must also present the session's CSRF token.
+func (h *AdminHandler) handle(mux *http.ServeMux, pattern, role string, fn http.HandlerFunc) {
+	mux.HandleFunc(pattern, h.requireRole(role, fn))
+}
+
+func (h *AdminHandler) requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var sess *store.AdminSession
+		if c, err := r.Cookie(sessionCookie); err == nil {
+			sess, _ = h.store.GetAdminSession(c.Value)
+		}
+		if sess == nil {
+			if r.Method == http.MethodGet {
+				http.Redirect(w, r, "/admin/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+				return
+			}
+			http.Error(w, "not signed in", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			got := r.Header.Get(csrfHeader)
+			if got == "" {
+				got = r.PostFormValue(csrfField)
+			}
+			if subtle.ConstantTimeCompare([]byte(got), []byte(sess.CSRF)) != 1 {
+				http.Error(w, "invalid or missing CSRF token", http.StatusForbidden)
+				return
+			}
+		}
+		if !store.RoleAtLeast(sess.User.Role, role) {
+			http.Error(w, "your role ("+sess.User.Role+") can't do this; "+role+" or higher is required", http.StatusForbidden)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), adminSessionKey{}, sess)))
+	}
+}
+
+func (h *AdminHandler) loginPage(w http.ResponseWriter, r *http.Request) {
+	next := safeNext(r.URL.Query().Get("next"))
+	data := map[string]any{
+		"Next":  next,
+		"Error": loginError(r.URL.Query().Get("error")),
+	}
+	if h.opts.SSO != nil {
+		data["SSO"] = ssoLoginURL(next)
+	}
+	h.render(w, r, "login", data)
+}
+
+func (h *AdminHandler) login(w http.ResponseWriter, r *http.Request) {
+	username := strings.TrimSpace(r.FormValue("username"))
+	password := r.FormValue("password")
+	next := safeNext(r.FormValue("next"))
+
+	ip := clientIP(r)
+	if !h.logins.allow(ip, username) {
+		h.auditAs(r, username, "login.throttled", "", "")
+		http.Redirect(w, r, "/admin/login?error=throttled&next="+url.QueryEscape(next), http.StatusSeeOther)
+		return
+	}
+
+	var user *store.AdminUser
+	var err error
+	if username == bootstrapAdmin && h.opts.AdminToken != "" &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(h.opts.AdminToken)) == 1 {
+		user, err = h.bootstrapUser()
+	} else {
+		user, err = h.store.AuthenticateAdmin(username, password)
+	}
+	if err != nil {
+		h.logins.fail(ip, username)
+		h.auditAs(r, username, "login.failed", "", "")
+		http.Redirect(w, r, "/admin/login?error=1&next="+url.QueryEscape(next), http.StatusSeeOther)
+		return
+	}
+	if err := h.startSession(w, r, user); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.logins.succeed(ip, username)
+	h.auditAs(r, user.Username, "login", "", "")
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// startSession signs user in on this browser.
+func (h *AdminHandler) startSession(w http.ResponseWriter, r *http.Request, user *store.AdminUser) error {
+	token, err := h.store.CreateAdminSession(user.ID, h.opts.SessionTTL)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/admin/",
+		MaxAge:   int(h.opts.SessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// bootstrapUser returns the owner account used with ADMIN_TOKEN, creating it
+// on first use. It has no password of its own.
+func (h *AdminHandler) bootstrapUser() (*store.AdminUser, error) {
+	if u, err := h.store.GetAdminUserByName(bootstrapAdmin); err == nil {
+		return u, nil
+	}
+	return h.store.CreateAdminUser(bootstrapAdmin, "", store.RoleOwner)
+}
+
+func (h *AdminHandler) logout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		_ = h.store.DeleteAdminSession(c.Value)
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/admin/", MaxAge: -1, HttpOnly: true})
+	h.audit(r, "logout", "", "")
+	http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+}
+
+// audit records an action by the signed-in admin.
+func (h *AdminHandler) audit(r *http.Request, action, target, detail string) {
+	actor := ""
+	if sess := adminSession(r.Context()); sess != nil {
+		actor = sess.User.Username
+	}
+	h.auditAs(r, actor, action, target, detail)
+}
+
+func (h *AdminHandler) auditAs(r *http.Request, actor, action, target, detail string) {
+	recordAudit(h.store, r, actor, action, target, detail)
+}
+
+// recordAudit appends to the audit log. A failure is logged rather than
+// failing the action, which has already happened.
+func recordAudit(st *store.Store, r *http.Request, actor, action, target, detail string) {
+	if err := st.AddAudit(store.AuditEntry{Actor: actor, Action: action, Target: target, Detail: detail, IP: clientIP(r)}); err != nil {
+		log.Printf("audit log write failed (%s by %s): %v", action, actor, err)
+	}
+}
+
+// clientIP is the address the request came from, without its port.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// safeNext only allows redirects back into the admin UI.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/admin/") || strings.HasPrefix(next, "//") || strings.Contains(next, `\`) {
+		return "/admin/"
+	}
+	return next
+}
+
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/zupolgec/curl-impersonate-service/settings"
+)
+
+// settingRow is one runtime setting on the Settings page.
+type settingRow struct {
+	Key, Env, Help, Kind   string
+	Value, Default, Source string
+	// Text is the value as edited: lists go one item per line.
+	Text string
+}
+
+func (h *AdminHandler) settings(w http.ResponseWriter, r *http.Request) {
+	notice := h.flash.pop(w, r)
+	var rows []settingRow
+	for _, e := range h.opts.Settings.Entries() {
+		row := settingRow{
+			Key: e.Key(), Env: e.Env(), Help: e.Help(), Kind: e.Kind(),
+			Value: e.Value(), Default: e.Default(), Source: e.Source(),
+			Text: e.Value(),
+		}
+		if row.Kind == "list" {
+			row.Text = strings.ReplaceAll(row.Value, ",", "\n")
+		}
+		rows = append(rows, row)
+	}
+	h.render(w, r, "settings", map[string]any{
+		"Settings": rows,
+		"Notice":   notice,
+	})
+}
+
+func (h *AdminHandler) saveSetting(w http.ResponseWriter, r *http.Request) {
+	key := r.FormValue("key")
+	if r.FormValue("action") == "reset" {
+		if err := h.opts.Settings.Reset(key); err != nil {
+			writeSettingError(w, err)
+			return
+		}
+		h.audit(r, "settings.reset", key, "")
+		h.redirectSettings(w, r, fmt.Sprintf("%s reset to its default.", key))
+		return
+	}
+	value, err := h.opts.Settings.Validate(key, r.FormValue("value"))
+	if err != nil {
+		writeSettingError(w, err)
+		return
+	}
+	if _, err := h.opts.Settings.Set(key, value); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, "settings.update", key, value)
+	h.redirectSettings(w, r, fmt.Sprintf("%s saved.", key))
+}
+
+func writeSettingError(w http.ResponseWriter, err error) {
+	if errors.Is(err, settings.ErrUnknownSetting) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+func (h *AdminHandler) redirectSettings(w http.ResponseWriter, r *http.Request, notice string) {
+	if err := h.flash.set(w, r, notice); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin/settings", http.StatusSeeOther)
+}
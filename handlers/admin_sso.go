@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zupolgec/curl-impersonate-service/oidc"
+	"github.com/zupolgec/curl-impersonate-service/store"
+)
+
+// SSOOptions enables OpenID Connect sign-in for the admin UI.
+type SSOOptions struct {
+	Client *oidc.Client
+	// UsernameClaim names the admin account; "" means "email". Falls back to
+	// preferred_username, then sub.
+	UsernameClaim string
+	// GroupsClaim holds the user's groups; "" means "groups". Dotted paths
+	// reach into nested claims, e.g. "realm_access.roles".
+	GroupsClaim string
+	// RoleMap maps a group to an admin role. A user gets the highest role of
+	// their groups; "*" matches everyone. Users without a role are refused.
+	RoleMap map[string]string
+}
+
+const (
+	ssoCookie = "admin_sso"
+	ssoTTL    = 10 * time.Minute
+	// ssoMaxPending bounds the sign-ins in flight; anyone can start one, so
+	// new ones are refused while it is reached.
+	ssoMaxPending = 1000
+)
+
+// ssoPending holds in-flight sign-ins between the redirect to the provider
+// and its callback, keyed by state. The browser gets the state in a cookie
+// so a callback only completes in the browser that started it.
+type ssoPending struct {
+	mu    sync.Mutex
+	items map[string]ssoRequest
+}
+
+type ssoRequest struct {
+	nonce    string
+	verifier string
+	next     string
+	expires  time.Time
+}
+
+// full reports whether no more sign-ins can be started, after dropping
+// expired ones.
+func (p *ssoPending) full() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prune()
+	return len(p.items) >= ssoMaxPending
+}
+
+// put records a sign-in, reporting false if too many are in flight.
+func (p *ssoPending) put(state string, req ssoRequest) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prune()
+	if len(p.items) >= ssoMaxPending {
+		return false
+	}
+	p.items[state] = req
+	return true
+}
+
+func (p *ssoPending) prune() {
+	now := time.Now()
+	for k, v := range p.items {
+		if now.After(v.expires) {
+			delete(p.items, k)
+		}
+	}
+}
+
+func (p *ssoPending) take(state string) (ssoRequest, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	req, ok := p.items[state]
+	delete(p.items, state)
+	if !ok || time.Now().After(req.expires) {
+		return ssoRequest{}, false
+	}
+	return req, true
+}
+
+// ssoLogin sends the browser to the identity provider.
+func (h *AdminHandler) ssoLogin(w http.ResponseWriter, r *http.Request) {
+	busy := func() {
+		log.Printf("SSO sign-in refused: %d sign-ins in flight", ssoMaxPending)
+		http.Redirect(w, r, "/admin/login?error=sso", http.StatusSeeOther)
+	}
+	if h.sso.full() {
+		busy()
+		return
+	}
+	req, err := h.opts.SSO.Client.NewAuthRequest(r.Context())
+	if err != nil {
+		log.Printf("SSO sign-in unavailable: %v", err)
+		http.Redirect(w, r, "/admin/login?error=sso", http.StatusSeeOther)
+		return
+	}
+	if !h.sso.put(req.State, ssoRequest{
+		nonce:    req.Nonce,
+		verifier: req.Verifier,
+		next:     safeNext(r.URL.Query().Get("next")),
+		expires:  time.Now().Add(ssoTTL),
+	}) {
+		busy()
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     ssoCookie,
+		Value:    req.State,
+		Path:     "/admin/",
+		MaxAge:   int(ssoTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, req.URL, http.StatusFound)
+}
+
+// ssoCallback completes a sign-in: it checks the state, redeems the code,
+// maps the user's groups to a role and starts an admin session.
+func (h *AdminHandler) ssoCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	http.SetCookie(w, &http.Cookie{Name: ssoCookie, Path: "/admin/", MaxAge: -1, HttpOnly: true})
+	fail := func(reason string) {
+		h.auditAs(r, "", "login.failed", "sso", reason)
+		http.Redirect(w, r, "/admin/login?error=sso", http.StatusSeeOther)
+	}
+
+	c, err := r.Cookie(ssoCookie)
+	state := q.Get("state")
+	if err != nil || state == "" || c.Value != state {
+		fail("state mismatch")
+		return
+	}
+	pending, ok := h.sso.take(state)
+	if !ok {
+		fail("unknown or expired state")
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		fail("provider error: " + e)
+		return
+	}
+	claims, err := h.opts.SSO.Client.Exchange(r.Context(), q.Get("code"), pending.verifier, pending.nonce)
+	if err != nil {
+		log.Printf("SSO sign-in failed: %v", err)
+		fail(err.Error())
+		return
+	}
+
+	username, claim := h.ssoUsername(claims)
+	subject := claims.String("sub")
+	if username == "" || username == bootstrapAdmin || subject == "" {
+		fail("no usable username or subject claim")
+		return
+	}
+	// An email is only an account name once the provider has verified it.
+	if claim == "email" {
+		if v, _ := claims["email_verified"].(bool); !v {
+			fail(username + ": email not verified")
+			return
+		}
+	}
+	groups := claims.Strings(h.opts.SSO.groupsClaim())
+	role := ssoRole(groups, h.opts.SSO.RoleMap)
+	if role == "" {
+		h.auditAs(r, username, "login.denied", "sso", "groups="+strings.Join(groups, ","))
+		http.Redirect(w, r, "/admin/login?error=denied", http.StatusSeeOther)
+		return
+	}
+
+	user, err := h.ssoUser(username, h.opts.SSO.Client.Issuer(), subject, role)
+	if errors.Is(err, errSSONotLinked) {
+		fail(username + ": " + err.Error())
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.startSession(w, r, user); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.auditAs(r, user.Username, "login.sso", "", "role="+user.Role+" groups="+strings.Join(groups, ","))
+	http.Redirect(w, r, pending.next, http.StatusSeeOther)
+}
+
+// ssoUsername picks the account name from the configured claim and returns
+// it with the name of the claim it came from.
+func (h *AdminHandler) ssoUsername(claims oidc.Claims) (string, string) {
+	name := "email"
+	if h.opts.SSO.UsernameClaim != "" {
+		name = h.opts.SSO.UsernameClaim
+	}
+	for _, claim := range []string{name, "preferred_username", "sub"} {
+		if v := strings.TrimSpace(claims.String(claim)); v != "" {
+			return v, claim
+		}
+	}
+	return "", ""
+}
+
+// errSSONotLinked refuses an SSO sign-in to an account that belongs to
+// someone else.
+var errSSONotLinked = errors.New("account exists and is not linked to this identity")
+
+// ssoUser returns the account linked to the provider's subject. On first
+// sign-in it creates one without a password, or takes over a passwordless
+// account no identity is linked to yet (one an owner added for SSO). Accounts
+// with a password, or linked to another identity, are only reached once an
+// owner links them. The provider's groups decide the role on every sign-in,
+// except that the last owner is never demoted.
+func (h *AdminHandler) ssoUser(username, issuer, subject, role string) (*store.AdminUser, error) {
+	u, err := h.store.GetAdminUserBySSO(issuer, subject)
+	if errors.Is(err, sql.ErrNoRows) {
+		u, err = h.store.GetAdminUserByName(username)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			u, err = h.store.CreateAdminUser(username, "", role)
+		case err == nil && (u.HasPassword || u.SSOSubject != ""):
+			return nil, errSSONotLinked
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := h.store.LinkAdminSSO(u.ID, issuer, subject); err != nil {
+			return nil, err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if u.Role != role {
+		switch err := h.store.SetAdminRole(u.ID, role); err {
+		case nil:
+			u.Role = role
+		case store.ErrLastOwner:
+		default:
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+func (o *SSOOptions) groupsClaim() string {
+	if o.GroupsClaim == "" {
+		return "groups"
+	}
+	return o.GroupsClaim
+}
+
+// ssoRole returns the highest role any of groups maps to, or "".
+func ssoRole(groups []string, roleMap map[string]string) string {
+	best := roleMap["*"]
+	for _, g := range groups {
+		if role, ok := roleMap[g]; ok && store.RoleAtLeast(role, store.RoleViewer) &&
+			(best == "" || store.RoleAtLeast(role, best)) {
+			best = role
+		}
+	}
+	return best
+}
+
+// loginError turns a login page error code into a message.
+func loginError(code string) string {
+	switch code {
+	case "":
+		return ""
+	case "sso":
+		return "Single sign-on failed. Try again or contact an administrator."
+	case "denied":
+		return "Your account isn't in any group with access to this admin UI."
+	case "throttled":
+		return "Too many failed sign-in attempts. Try again later."
+	default:
+		return "Invalid username or password."
+	}
+}
+
+// ssoLoginURL is the link on the login page that starts SSO.
+func ssoLoginURL(next string) string {
+	return "/admin/oidc/login?next=" + url.QueryEscape(next)
+}
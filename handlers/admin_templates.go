@@ -30,7 +30,7 @@ const adminTemplates = `
   tr:last-child td { border-bottom:none; }
   code { font-family:ui-monospace,SFMono-Regular,Menlo,monospace; background:color-mix(in srgb,var(--fg) 8%,transparent); padding:1px 6px; border-radius:4px; word-break:break-all; }
   form.inline { display:inline; }
-  input[type=text] { background:var(--bg); border:1px solid var(--border); color:var(--fg); border-radius:6px; padding:8px 10px; font:inherit; }
+  input[type=text], input[type=number], input[type=datetime-local], select { background:var(--bg); border:1px solid var(--border); color:var(--fg); border-radius:6px; padding:8px 10px; font:inherit; }
   textarea { width:100%; background:var(--bg); border:1px solid var(--border); color:var(--fg); border-radius:6px; padding:10px; font:13px/1.5 ui-monospace,monospace; }
   button { background:var(--accent); color:#fff; border:0; border-radius:6px; padding:8px 14px; font:inherit; font-weight:600; cursor:pointer; }
   button.ghost { background:transparent; color:var(--muted); border:1px solid var(--border); }
@@ -40,28 +40,68 @@ const adminTemplates = `
   .banner code { background:var(--bg); }
   h2 { font-size:16px; margin:0 0 14px; }
   .row-actions { display:flex; gap:6px; }
+  .filters { display:flex; flex-wrap:wrap; gap:8px; align-items:flex-end; margin-bottom:16px; }
+  .filters label { display:flex; flex-direction:column; gap:4px; font-size:11px; color:var(--muted); text-transform:uppercase; letter-spacing:.04em; }
+  .filters input[type=text], .filters input[type=number] { width:130px; }
+  .filters label label { display:inline; text-transform:none; margin-right:8px; }
+  .pager { display:flex; justify-content:space-between; align-items:center; margin-top:12px; }
+  svg.chart { width:100%; height:180px; background:var(--panel); border:1px solid var(--border); border-radius:10px; padding:10px; margin-bottom:24px; }
+  svg.chart rect.req { fill:var(--accent); } svg.chart rect.fail { fill:var(--bad); }
+  .cols2 { display:grid; grid-template-columns:repeat(auto-fit,minmax(440px,1fr)); gap:16px; }
+  pre { background:var(--panel); border:1px solid var(--border); border-radius:10px; padding:12px 14px; overflow-x:auto; font:12px/1.5 ui-monospace,monospace; white-space:pre-wrap; word-break:break-all; max-height:480px; }
+  .warn { color:#d29922; }
+  .diff { padding:0; } .diff div { padding:0 14px; white-space:pre-wrap; }
+  .diff .add { background:rgba(63,185,80,.15); } .diff .del { background:rgba(248,81,73,.15); }
+  .who { margin-left:auto; display:flex; align-items:center; gap:8px; }
+  .who a { text-decoration:none; }
+  input[type=password] { background:var(--bg); border:1px solid var(--border); color:var(--fg); border-radius:6px; padding:8px 10px; font:inherit; }
+  .login { max-width:340px; margin:60px auto; }
+  .login label { display:flex; flex-direction:column; gap:4px; margin-bottom:12px; font-size:12px; color:var(--muted); }
+  a.button { display:inline-block; text-decoration:none; background:transparent; color:var(--muted); border:1px solid var(--border); border-radius:6px; padding:8px 14px; font-weight:600; }
 </style>
 </head>
 <body>
 <header>
   <h1>🎭 impersonate</h1>
-  <nav>
+  {{if .User}}<nav>
     <a href="/admin/" class="{{if eq .Page "dashboard"}}active{{end}}">Dashboard</a>
     <a href="/admin/tokens" class="{{if eq .Page "tokens"}}active{{end}}">Tokens</a>
-    <a href="/admin/cors" class="{{if eq .Page "cors"}}active{{end}}">CORS</a>
+    {{if .HasSettings}}<a href="/admin/settings" class="{{if eq .Page "settings"}}active{{end}}">Settings</a>{{end}}
+    <a href="/admin/browsers" class="{{if eq .Page "browsers"}}active{{end}}">Browsers</a>
+    <a href="/admin/credentials" class="{{if eq .Page "credentials"}}active{{end}}">Credentials</a>
     <a href="/admin/logs" class="{{if eq .Page "logs"}}active{{end}}">Logs</a>
+    <a href="/admin/analytics" class="{{if eq .Page "analytics"}}active{{end}}">Analytics</a>
+    <a href="/admin/captures" class="{{if or (eq .Page "captures") (eq .Page "capture") (eq .Page "replay")}}active{{end}}">Captures</a>
+    <a href="/admin/audit" class="{{if eq .Page "audit"}}active{{end}}">Audit</a>
+    {{if .IsOwner}}<a href="/admin/users" class="{{if eq .Page "users"}}active{{end}}">Users</a>{{end}}
   </nav>
+  <div class="who"><a href="/admin/account" class="muted">{{.User.Username}}</a> <span class="muted">({{.User.Role}})</span>
+    <form class="inline" method="post" action="/admin/logout">{{template "csrf" .CSRF}}<button class="ghost" type="submit">Sign out</button></form>
+  </div>{{end}}
 </header>
 <main>
 {{if eq .Page "dashboard"}}{{template "dashboard" .}}{{end}}
 {{if eq .Page "tokens"}}{{template "tokens" .}}{{end}}
-{{if eq .Page "cors"}}{{template "cors" .}}{{end}}
+{{if eq .Page "settings"}}{{template "settings" .}}{{end}}
+{{if eq .Page "browsers"}}{{template "browsers" .}}{{end}}
+{{if eq .Page "credentials"}}{{template "credentials" .}}{{end}}
 {{if eq .Page "logs"}}{{template "logs" .}}{{end}}
+{{if eq .Page "analytics"}}{{template "analytics" .}}{{end}}
+{{if eq .Page "captures"}}{{template "captures" .}}{{end}}
+{{if eq .Page "capture"}}{{template "capture" .Capture}}{{if .Timing}}<h2 style="margin-top:20px">Timing</h2><pre>{{.Timing}}</pre>{{end}}{{if and .CanReplay .CanOperate}}{{template "replayform" .}}{{end}}{{end}}
+{{if eq .Page "replay"}}{{template "replay" .}}{{end}}
+{{if eq .Page "login"}}{{template "login" .}}{{end}}
+{{if eq .Page "audit"}}{{template "audit" .}}{{end}}
+{{if eq .Page "account"}}{{template "account" .}}{{end}}
+{{if eq .Page "users"}}{{template "users" .}}{{end}}
 </main>
 </body>
 </html>{{end}}
 
 {{define "dashboard"}}
+{{if .Reset}}<div class="banner">Metrics counters reset.</div>{{end}}
+{{template "expiring" .Expiring}}
+<h2>Since start <span class="muted" style="font-size:13px; font-weight:400">(this process)</span></h2>
 <div class="grid">
   <div class="card"><div class="n">{{.Total}}</div><div class="l">Requests</div></div>
   <div class="card"><div class="n ok">{{.Success}}</div><div class="l">Success</div></div>
@@ -69,6 +109,19 @@ const adminTemplates = `
   <div class="card"><div class="n">{{printf "%.0f" .Avg}}<span class="muted" style="font-size:14px"> ms</span></div><div class="l">Avg duration</div></div>
   <div class="card"><div class="n">{{.Uptime}}<span class="muted" style="font-size:14px"> s</span></div><div class="l">Uptime</div></div>
 </div>
+<h2>All time <span class="muted" style="font-size:13px; font-weight:400">(since {{fmtTime .AllTime.Since}})</span></h2>
+<div class="grid">
+  <div class="card"><div class="n">{{.AllTime.RequestsTotal}}</div><div class="l">Requests</div></div>
+  <div class="card"><div class="n ok">{{.AllTime.RequestsSuccess}}</div><div class="l">Success</div></div>
+  <div class="card"><div class="n bad">{{.AllTime.RequestsFailed}}</div><div class="l">Failed</div></div>
+  <div class="card"><div class="n">{{printf "%.0f" .AllTime.AverageDurationMs}}<span class="muted" style="font-size:14px"> ms</span></div><div class="l">Avg duration</div></div>
+  <div class="card"><div class="l">Top browsers</div>
+    {{range $i, $b := .AllBrowsers}}{{if lt $i 3}}<div><code>{{$b.Name}}</code> <span class="muted">{{$b.Count}}</span></div>{{end}}{{else}}<div class="muted">—</div>{{end}}
+  </div>
+</div>
+{{if .CanOperate}}<form method="post" action="/admin/metrics/reset" style="margin:-8px 0 24px" onsubmit="return confirm('Reset all metrics counters, including all-time totals?')">{{template "csrf" $.CSRF}}
+  <button class="danger" type="submit">Reset counters</button>
+</form>{{end}}
 <h2>Recent requests</h2>
 {{template "logtable" .Logs}}
 {{end}}
@@ -76,52 +129,473 @@ const adminTemplates = `
 {{define "tokens"}}
 <h2>API tokens</h2>
 {{if .Created}}
-<div class="banner">New token created. Copy it now — it won't be shown again:<br><code>{{.Created}}</code></div>
+<div class="banner">New token issued. Copy it now — it won't be shown again:<br><code>{{.Created}}</code></div>
+{{end}}
+{{if .SigningSecret}}
+<div class="banner">New signing key issued. Copy the secret now — it won't be shown again:<br>
+  Key ID <code>{{.SigningKey}}</code><br>Secret <code>{{.SigningSecret}}</code></div>
 {{end}}
-<form method="post" action="/admin/tokens" style="margin-bottom:20px; display:flex; gap:8px;">
-  <input type="text" name="name" placeholder="Token name (e.g. scraper-prod)" required>
+{{template "expiring" .Expiring}}
+{{if .CanOperate}}<form class="filters" method="post" action="/admin/tokens">{{template "csrf" $.CSRF}}
+  <label>Name<input type="text" name="name" placeholder="e.g. scraper-prod" required></label>
+  <label>Expires (UTC, optional)<input type="datetime-local" name="expires"></label>
+  <label>Scopes (none = all but admin)<span>{{range .Scopes}}<label><input type="checkbox" name="scope" value="{{.}}"> {{.}}</label>{{end}}</span></label>
   <button type="submit">Create token</button>
-</form>
+</form>{{end}}
 <table>
-  <tr><th>Name</th><th>Token</th><th>Status</th><th>Created</th><th>Last used</th><th></th></tr>
+  <tr><th>Name</th><th>Token</th><th>Scopes</th><th>Status</th><th>Expires</th><th>Created</th><th>Last used</th><th></th></tr>
   {{range .Tokens}}
   <tr>
     <td>{{.Name}}</td>
-    <td><code>{{slice .Token 0 8}}…{{slice .Token 56 64}}</code></td>
-    <td>{{if .Enabled}}<span class="ok">enabled</span>{{else}}<span class="muted">disabled</span>{{end}}</td>
+    <td><code>{{.Prefix}}{{if not .Imported}}…{{end}}</code>{{if .Imported}} <small>imported</small>{{end}}</td>
+    <td>{{if .Scopes}}{{join .Scopes ", "}}{{else}}<span class="muted">all</span>{{end}}</td>
+    <td>{{if .Expired $.Now}}<span class="bad">expired</span>{{else if not .Enabled}}<span class="muted">disabled</span>{{else if .ReplacedBy}}<span class="warn">rotated</span>{{else}}<span class="ok">enabled</span>{{end}}
+      {{if .SignedOnly}}<br><span class="muted">signed only</span>{{else if .SigningKeyID}}<br><span class="muted">signing on</span>{{end}}
+      {{if .NoQueryToken}}<br><span class="muted">no ?token=</span>{{end}}</td>
+    <td class="muted">{{fmtTimePtr .ExpiresAt}}</td>
     <td class="muted">{{fmtTime .CreatedAt}}</td>
     <td class="muted">{{fmtTimePtr .LastUsedAt}}</td>
-    <td><div class="row-actions">
-      <form class="inline" method="post" action="/admin/tokens/toggle">
+    <td>{{if $.CanOperate}}<div class="row-actions">
+      <form class="inline" method="post" action="/admin/tokens/toggle">{{template "csrf" $.CSRF}}
         <input type="hidden" name="id" value="{{.ID}}">
         <input type="hidden" name="enabled" value="{{if .Enabled}}false{{else}}true{{end}}">
         <button class="ghost" type="submit">{{if .Enabled}}Disable{{else}}Enable{{end}}</button>
       </form>
-      <form class="inline" method="post" action="/admin/tokens/delete" onsubmit="return confirm('Delete this token?')">
+      {{if not .ReplacedBy}}
+      <form class="inline" method="post" action="/admin/tokens/rotate" onsubmit="return confirm('Issue a replacement for this token?')">{{template "csrf" $.CSRF}}
+        <input type="hidden" name="id" value="{{.ID}}">
+        <select name="grace" title="How long the old value keeps working">{{range $.Graces}}<option value="{{.Value}}" {{if eq .Value "24h"}}selected{{end}}>grace: {{.Label}}</option>{{end}}</select>
+        <button class="ghost" type="submit">Rotate</button>
+      </form>
+      {{end}}
+      <form class="inline" method="post" action="/admin/tokens/signing"{{if .SigningKeyID}} onsubmit="return confirm('Replace the signing secret? The current one stops working.')"{{end}}>{{template "csrf" $.CSRF}}
+        <input type="hidden" name="id" value="{{.ID}}">
+        <button class="ghost" type="submit">{{if .SigningKeyID}}New signing key{{else}}Enable signing{{end}}</button>
+      </form>
+      {{if .SigningKeyID}}
+      <form class="inline" method="post" action="/admin/tokens/signing" onsubmit="return confirm('Remove the signing key of this token?')">{{template "csrf" $.CSRF}}
+        <input type="hidden" name="id" value="{{.ID}}">
+        <input type="hidden" name="action" value="disable">
+        <button class="ghost" type="submit">Disable signing</button>
+      </form>
+      {{end}}
+      <form class="inline" method="post" action="/admin/tokens/delete" onsubmit="return confirm('Delete this token?')">{{template "csrf" $.CSRF}}
         <input type="hidden" name="id" value="{{.ID}}">
         <button class="danger" type="submit">Delete</button>
       </form>
-    </div></td>
+    </div>
+    <details><summary class="muted">Edit</summary>
+      <form class="filters" method="post" action="/admin/tokens/update" style="margin-top:8px">{{template "csrf" $.CSRF}}
+        {{$t := .}}
+        <input type="hidden" name="id" value="{{.ID}}">
+        <label>Name<input type="text" name="name" value="{{.Name}}"></label>
+        <label>Expires (UTC)<input type="datetime-local" name="expires" value="{{inputTime .ExpiresAt}}"></label>
+        <label>Scopes<span>{{range $.Scopes}}<label><input type="checkbox" name="scope" value="{{.}}" {{if has $t.Scopes .}}checked{{end}}> {{.}}</label>{{end}}</span></label>
+        <label>Authentication<span>
+          <label><input type="checkbox" name="query_token" {{if not .NoQueryToken}}checked{{end}}> allow ?token=</label>
+          {{if .SigningKeyID}}<label><input type="checkbox" name="signed_only" {{if .SignedOnly}}checked{{end}}> signed requests only</label>{{end}}
+        </span></label>
+        <button class="ghost" type="submit">Save</button>
+      </form>
+    </details>{{end}}</td>
   </tr>
   {{else}}
-  <tr><td colspan="6" class="muted">No tokens yet. Create one above.</td></tr>
+  <tr><td colspan="8" class="muted">No tokens yet. Create one above.</td></tr>
   {{end}}
 </table>
 {{end}}
 
-{{define "cors"}}
-<h2>CORS origins</h2>
-{{if .Saved}}<div class="banner">CORS settings saved.</div>{{end}}
-<p class="muted">One origin per line, or <code>*</code> to allow any origin.</p>
-<form method="post" action="/admin/cors">
-  <textarea name="origins" rows="6">{{.Origins}}</textarea>
-  <div style="margin-top:12px"><button type="submit">Save</button></div>
-</form>
+{{define "expiring"}}
+{{if .}}
+<div class="banner"><strong class="warn">Expiring soon:</strong>
+  {{range $i, $t := .}}{{if $i}}, {{end}}<code>{{$t.Name}}</code> ({{$t.Prefix}}{{if not $t.Imported}}…{{end}}) on {{fmtTimePtr $t.ExpiresAt}}{{end}}
+</div>
+{{end}}
+{{end}}
+
+{{define "settings"}}
+<h2>Settings</h2>
+{{if .Notice}}<div class="banner">{{.Notice}}</div>{{end}}
+<p class="muted">Changes take effect immediately and are kept across restarts. Values set here override the
+environment; reset a setting to return to its default. Lists take one item per line.</p>
+<table>
+  <tr><th>Setting</th><th>Value</th><th>Source</th><th>Default</th>{{if .CanOperate}}<th></th>{{end}}</tr>
+  {{range .Settings}}
+  <tr>
+    <td><code>{{.Key}}</code><div class="muted">{{.Help}}</div>{{if .Env}}<div class="muted">env: <code>{{.Env}}</code></div>{{end}}</td>
+    <td><form id="set-{{.Key}}" method="post" action="/admin/settings">{{template "csrf" $.CSRF}}
+      <input type="hidden" name="key" value="{{.Key}}">
+      {{if eq .Kind "bool"}}<select name="value"{{if not $.CanOperate}} disabled{{end}}>
+        <option value="true" {{if eq .Value "true"}}selected{{end}}>true</option>
+        <option value="false" {{if eq .Value "false"}}selected{{end}}>false</option>
+      </select>
+      {{else if eq .Kind "list"}}<textarea name="value" rows="3"{{if not $.CanOperate}} readonly{{end}}>{{.Text}}</textarea>
+      {{else}}<input type="{{if eq .Kind "int"}}number{{else}}text{{end}}" name="value" value="{{.Value}}"{{if not $.CanOperate}} readonly{{end}}>{{end}}
+    </form></td>
+    <td>{{if eq .Source "admin"}}<strong class="warn">admin</strong>{{else}}<span class="muted">{{.Source}}</span>{{end}}</td>
+    <td class="muted"><code>{{if .Default}}{{.Default}}{{else}}(empty){{end}}</code></td>
+    {{if $.CanOperate}}<td><div class="row-actions">
+      <button type="submit" form="set-{{.Key}}">Save</button>
+      {{if eq .Source "admin"}}<form class="inline" method="post" action="/admin/settings">{{template "csrf" $.CSRF}}
+        <input type="hidden" name="key" value="{{.Key}}">
+        <input type="hidden" name="action" value="reset">
+        <button class="ghost" type="submit">Reset</button>
+      </form>{{end}}
+    </div></td>{{end}}
+  </tr>
+  {{end}}
+</table>
+{{end}}
+
+{{define "browsers"}}
+<h2>Browsers</h2>
+{{if .Notice}}<div class="banner">{{.Notice}}</div>{{end}}
+<p class="muted">Loaded from <code>{{.Path}}</code> at {{fmtTime .LoadedAt}}. Default: <code>{{.Default}}</code>
+{{if ne .FileDefault .Default}}(browsers.json says <code>{{.FileDefault}}</code>){{end}}.
+Edit the file and reload to apply changes; an invalid file is rejected and the loaded browsers are kept.</p>
+{{if and .CanReload .CanOperate}}<form method="post" action="/admin/browsers/reload" style="margin-bottom:16px">{{template "csrf" $.CSRF}}
+  <button type="submit">Reload browsers.json</button>
+</form>{{end}}
+<h2>Aliases</h2>
+<table>
+  <tr><th>Alias</th><th>Browser</th></tr>
+  {{range .Aliases}}<tr><td><code>{{.Alias}}</code></td><td><code>{{.Target}}</code></td></tr>
+  {{else}}<tr><td colspan="2" class="muted">No aliases.</td></tr>{{end}}
+</table>
+{{if .Pools}}<h2>Pools</h2>
+<table>
+  <tr><th>Pool</th><th>Members (weight)</th></tr>
+  {{range $name, $members := .Pools}}<tr><td><code>{{$name}}</code></td><td>{{range $member, $weight := $members}}<code>{{$member}}</code> <span class="muted">({{$weight}})</span> {{end}}</td></tr>{{end}}
+</table>{{end}}
+<h2>Custom profiles</h2>
+<p class="muted">A custom profile is a built-in browser with its own headers and, optionally, TLS and HTTP/2
+settings. Profiles from browsers.json are edited there; the others are stored here.</p>
+<table>
+  <tr><th>Name</th><th>Base</th><th>Accept-Language</th><th>Headers</th><th>TLS / HTTP2</th><th>Source</th>{{if .CanOperate}}<th></th>{{end}}</tr>
+  {{range .Profiles}}<tr>
+    <td><code>{{.Name}}</code></td>
+    <td><code>{{.Base}}</code></td>
+    <td>{{.Profile.AcceptLanguage}}</td>
+    <td>{{range $name, $value := .Profile.Headers}}<div><code>{{$name}}: {{$value}}</code></div>{{end}}
+      {{if .Profile.HeaderOrder}}<div class="muted">order: {{join .Profile.HeaderOrder ", "}}</div>{{end}}</td>
+    <td class="muted">{{if .Profile.TLS}}TLS{{end}} {{if .Profile.HTTP2}}HTTP2{{end}}</td>
+    <td class="muted">{{.Profile.Source}}</td>
+    {{if $.CanOperate}}<td>{{if eq .Profile.Source "store"}}<div class="row-actions">
+      <a class="button" href="/admin/browsers?edit={{.Name}}#profile-form">Edit</a>
+      <form class="inline" method="post" action="/admin/browsers/profiles/delete" onsubmit="return confirm('Delete profile {{.Name}}?')">{{template "csrf" $.CSRF}}
+        <input type="hidden" name="name" value="{{.Name}}">
+        <button class="danger" type="submit">Delete</button>
+      </form>
+    </div>{{end}}</td>{{end}}
+  </tr>
+  {{else}}<tr><td colspan="7" class="muted">No custom profiles.</td></tr>{{end}}
+</table>
+{{if .CanOperate}}{{with .Edit}}<h2 id="profile-form">{{if .Name}}Edit {{.Name}}{{else}}New profile{{end}}</h2>
+<form class="filters" method="post" action="/admin/browsers/profiles">{{template "csrf" $.CSRF}}
+  <label>Name<input type="text" name="name" value="{{.Name}}" required></label>
+  <label>Base<select name="base">
+    {{range $.Aliases}}<option value="{{.Alias}}" {{if eq $.Edit.Base .Alias}}selected{{end}}>{{.Alias}}</option>{{end}}
+    {{range $.Browsers}}<option value="{{.Name}}" {{if eq $.Edit.Base .Name}}selected{{end}}>{{.Name}}</option>{{end}}
+  </select></label>
+  <label>Accept-Language<input type="text" name="accept_language" value="{{.AcceptLanguage}}" placeholder="de-DE,de;q=0.9"></label>
+  <label>Headers <span class="muted">(Name: value per line; empty value removes)</span><textarea name="headers" rows="4">{{.HeadersText}}</textarea></label>
+  <label>Header order <span class="muted">(one name per line)</span><textarea name="header_order" rows="4">{{.OrderText}}</textarea></label>
+  <label>TLS ciphers<input type="text" name="ciphers" value="{{.TLS.Ciphers}}"></label>
+  <label>TLS curves<input type="text" name="curves" value="{{.TLS.Curves}}" placeholder="X25519:P-256"></label>
+  <label>Signature hashes<input type="text" name="signature_hashes" value="{{.TLS.SignatureHashes}}"></label>
+  <label>Extension order<input type="text" name="extension_order" value="{{.TLS.ExtensionOrder}}" placeholder="0-23-65281"></label>
+  <label>Permute extensions<input type="checkbox" name="permute_extensions" {{if .TLS.PermuteExtensions}}checked{{end}}></label>
+  <label>HTTP/2 pseudo-header order<input type="text" name="pseudo_headers_order" value="{{.HTTP2.PseudoHeadersOrder}}" placeholder="masp"></label>
+  <label>HTTP/2 settings<input type="text" name="http2_settings" value="{{.HTTP2.Settings}}" placeholder="1:65536;4:6291456"></label>
+  <label>HTTP/2 window update<input type="number" name="window_update" min="0" value="{{if .HTTP2.WindowUpdate}}{{.HTTP2.WindowUpdate}}{{end}}"></label>
+  <button type="submit">Save profile</button>
+</form>{{end}}{{end}}
+<h2>Built-in browsers</h2>
+<table>
+  <tr><th>Name</th><th>Browser</th><th>Version</th><th>OS</th><th>Device</th></tr>
+  {{range .Browsers}}<tr><td><code>{{.Name}}</code></td><td>{{.Browser.Name}}</td><td>{{.Browser.Version}}</td><td>{{.Browser.OS}}</td><td class="muted">{{.Browser.Device}}</td></tr>{{end}}
+</table>
+{{end}}
+
+{{define "credentials"}}
+<h2>TLS credentials</h2>
+{{if .Notice}}<div class="banner">{{.Notice}}</div>{{end}}
+<p class="muted">CA bundles verify targets with a private CA instead of the system CAs; client certificates
+authenticate to targets that require mutual TLS. Requests name them in <code>ca_bundle</code> and
+<code>client_cert</code>. They are stored encrypted with <code>SECRETS_KEY</code> and are never shown again.</p>
+{{if not .HasKey}}<div class="banner">Set <code>SECRETS_KEY</code> to store and use TLS credentials.</div>{{end}}
+<table>
+  <tr><th>Kind</th><th>Name</th><th>Subject</th><th>Certificates</th><th>Expires</th><th>SHA-256</th><th>Updated</th>{{if .CanOperate}}<th></th>{{end}}</tr>
+  {{range .Credentials}}<tr>
+    <td class="muted">{{.Kind}}</td>
+    <td><code>{{.Name}}</code></td>
+    <td>{{.Subject}}</td>
+    <td>{{.Certs}}</td>
+    <td>{{fmtTime .NotAfter}}</td>
+    <td class="muted"><code title="{{.Fingerprint}}">{{slice .Fingerprint 0 16}}…</code></td>
+    <td class="muted">{{fmtTime .UpdatedAt}}</td>
+    {{if $.CanOperate}}<td><form class="inline" method="post" action="/admin/credentials/delete" onsubmit="return confirm('Delete {{.Kind}} {{.Name}}?')">{{template "csrf" $.CSRF}}
+      <input type="hidden" name="kind" value="{{.Kind}}">
+      <input type="hidden" name="name" value="{{.Name}}">
+      <button class="danger" type="submit">Delete</button>
+    </form></td>{{end}}
+  </tr>
+  {{else}}<tr><td colspan="8" class="muted">No TLS credentials.</td></tr>{{end}}
+</table>
+{{if and .CanOperate .HasKey}}<h2 style="margin-top:24px">Upload</h2>
+<p class="muted">Uploading with an existing kind and name replaces it.</p>
+<form class="filters" method="post" action="/admin/credentials" autocomplete="off">{{template "csrf" $.CSRF}}
+  <label>Kind<select name="kind">
+    <option value="ca_bundle">CA bundle</option>
+    <option value="client_cert">Client certificate</option>
+  </select></label>
+  <label>Name<input type="text" name="name" required pattern="[A-Za-z0-9][A-Za-z0-9_.\-]{0,63}"></label>
+  <label>Certificates <span class="muted">(PEM; a client certificate may include its chain)</span><textarea name="cert" rows="6" required></textarea></label>
+  <label>Private key <span class="muted">(PEM, unencrypted; client certificates only)</span><textarea name="key" rows="6"></textarea></label>
+  <button type="submit">Save</button>
+</form>{{end}}
 {{end}}
 
 {{define "logs"}}
-<h2>Usage logs <span class="muted" style="font-size:13px; font-weight:400">(most recent {{.Limit}})</span></h2>
+<h2>Usage logs <span class="muted" style="font-size:13px; font-weight:400">({{if .Paged}}older entries, {{else}}most recent {{end}}{{.Limit}} per page)</span></h2>
+<form class="filters" method="get" action="/admin/logs">
+  <label>From (UTC)<input type="datetime-local" name="since" value="{{.Filter.Since}}"></label>
+  <label>To (UTC)<input type="datetime-local" name="until" value="{{.Filter.Until}}"></label>
+  <label>Token<input type="text" name="token_name" value="{{.Filter.Token}}"></label>
+  <label>Browser<input type="text" name="browser" value="{{.Filter.Browser}}"></label>
+  <label>Host<input type="text" name="host" value="{{.Filter.Host}}"></label>
+  <label>Result<select name="success">
+    <option value="">any</option>
+    <option value="true" {{if eq .Filter.Success "true"}}selected{{end}}>success</option>
+    <option value="false" {{if eq .Filter.Success "false"}}selected{{end}}>failed</option>
+  </select></label>
+  <label>Status<input type="number" name="status" value="{{.Filter.Status}}" min="100" max="599"></label>
+  <label>Error type<input type="text" name="error_type" value="{{.Filter.ErrorType}}"></label>
+  <label>Replays<select name="replay">
+    <option value="">include</option>
+    <option value="false" {{if eq .Filter.Replay "false"}}selected{{end}}>exclude</option>
+    <option value="true" {{if eq .Filter.Replay "true"}}selected{{end}}>only</option>
+  </select></label>
+  <label>Blocks<select name="blocked">
+    <option value="">include</option>
+    <option value="false" {{if eq .Filter.Blocked "false"}}selected{{end}}>exclude</option>
+    <option value="true" {{if eq .Filter.Blocked "true"}}selected{{end}}>only</option>
+  </select></label>
+  <button type="submit">Filter</button>
+  <a class="button" href="/admin/logs">Reset</a>
+</form>
 {{template "logtable" .Logs}}
+<div class="pager">
+  <div class="row-actions">
+    <a class="button" href="{{.ExportCSV}}">Export CSV</a>
+    <a class="button" href="{{.ExportNDJ}}">Export NDJSON</a>
+  </div>
+  {{if .Next}}<a class="button" href="{{.Next}}">Older →</a>{{end}}
+</div>
+{{end}}
+
+{{define "analytics"}}
+<h2>Analytics</h2>
+<form class="filters" method="get" action="/admin/analytics">
+  <label>Range<select name="range">
+    {{range .Ranges}}<option value="{{.Key}}" {{if eq .Key $.Range}}selected{{end}}>{{.Label}}</option>{{end}}
+  </select></label>
+  <label>Token<input type="text" name="token_name" value="{{.TokenName}}" placeholder="all tokens"></label>
+  <button type="submit">Show</button>
+</form>
+<div class="grid">
+  <div class="card"><div class="n">{{.Total.Requests}}</div><div class="l">Requests</div></div>
+  <div class="card"><div class="n bad">{{.Total.Failed}}</div><div class="l">Failed</div></div>
+  <div class="card"><div class="n">{{printf "%.0f" .Total.AvgMs}}<span class="muted" style="font-size:14px"> ms</span></div><div class="l">Avg duration</div></div>
+  <div class="card"><div class="n">{{printf "%.0f" .Total.P95Ms}}<span class="muted" style="font-size:14px"> ms</span></div><div class="l">p95 duration</div></div>
+</div>
+<svg class="chart" viewBox="0 0 952 160" preserveAspectRatio="none">
+  {{range .Bars}}<g><title>{{.Title}}</title><rect class="req" x="{{.X}}" y="{{.Y}}" width="{{.Width}}" height="{{.Height}}"></rect><rect class="fail" x="{{.X}}" y="{{.FailY}}" width="{{.Width}}" height="{{.FailH}}"></rect></g>{{end}}
+</svg>
+<p class="muted">Aggregated hourly from usage logs; the current hour appears once it has elapsed. Red marks failed requests.</p>
+<div class="cols2">
+  {{template "breakdown" (index .Breakdowns "token_name")}}
+  {{template "breakdown" (index .Breakdowns "browser")}}
+  {{template "breakdown" (index .Breakdowns "target_host")}}
+  {{template "breakdown" (index .Breakdowns "error_type")}}
+</div>
+<h2 style="margin-top:24px">Blocks by host and browser</h2>
+<table>
+  <tr><th>Host</th><th>Browser</th><th>Blocked</th><th>Requests</th><th>Vendors</th></tr>
+  {{range .Blocks}}
+  <tr>
+    <td><code>{{.TargetHost}}</code></td>
+    <td>{{.Browser}}</td>
+    <td class="bad">{{.Blocked}}</td>
+    <td class="muted">{{.Requests}}</td>
+    <td>{{.Vendors}}</td>
+  </tr>
+  {{else}}
+  <tr><td colspan="5" class="muted">No block pages detected.</td></tr>
+  {{end}}
+</table>
+<p class="muted">Responses detected as bot challenges or block pages, from the raw usage logs: blocks older than the log retention drop out.</p>
+{{end}}
+
+{{define "breakdown"}}
+<table>
+  <tr><th>Key</th><th>Requests</th><th>Failed</th><th>Avg ms</th><th>p95 ms</th></tr>
+  {{range .}}
+  <tr>
+    <td>{{if .Key}}<code>{{.Key}}</code>{{else}}<span class="muted">—</span>{{end}}</td>
+    <td>{{.Requests}}</td>
+    <td class="{{if .Failed}}bad{{else}}muted{{end}}">{{.Failed}}</td>
+    <td class="muted">{{printf "%.0f" .AvgMs}}</td>
+    <td class="muted">{{printf "%.0f" .P95Ms}}</td>
+  </tr>
+  {{else}}
+  <tr><td colspan="5" class="muted">No data in this range.</td></tr>
+  {{end}}
+</table>
+{{end}}
+
+{{define "captures"}}
+<h2>Debug captures</h2>
+<p class="muted">While a capture is on, every request made with that token is stored in full
+(URL, headers, bodies truncated to the size cap, timings) with credentials redacted.
+Captures expire automatically.</p>
+{{if .CanOperate}}<form method="post" action="/admin/captures" class="filters">{{template "csrf" $.CSRF}}
+  <label>Token<select name="token_name" required>
+    {{range .Tokens}}<option value="{{.Name}}">{{.Name}}</option>{{end}}
+  </select></label>
+  <label>For<select name="duration">
+    {{range .Durations}}<option value="{{.Value}}">{{.Label}}</option>{{end}}
+  </select></label>
+  <button type="submit">Start capture</button>
+</form>{{end}}
+<table style="margin-bottom:24px">
+  <tr><th>Token</th><th>Started</th><th>Ends</th><th>Captured</th><th>Status</th><th></th></tr>
+  {{range .Sessions}}
+  <tr>
+    <td>{{.TokenName}}</td>
+    <td class="muted">{{fmtTime .CreatedAt}}</td>
+    <td class="muted">{{fmtTime .ExpiresAt}}</td>
+    <td><a href="/admin/captures?session={{.ID}}">{{.Count}}</a></td>
+    <td>{{if .Active}}<span class="warn">recording</span>{{else}}<span class="muted">ended</span>{{end}}</td>
+    <td>{{if and .Active $.CanOperate}}<form class="inline" method="post" action="/admin/captures/stop">{{template "csrf" $.CSRF}}
+      <input type="hidden" name="id" value="{{.ID}}">
+      <button class="danger" type="submit">Stop</button>
+    </form>{{end}}</td>
+  </tr>
+  {{else}}
+  <tr><td colspan="6" class="muted">No capture sessions.</td></tr>
+  {{end}}
+</table>
+<h2>{{if .SessionID}}Session captures <a class="muted" style="font-size:13px; font-weight:400" href="/admin/captures">show all</a>{{else}}Recent captures{{end}}</h2>
+<table>
+  <tr><th>Time</th><th>Token</th><th>Browser</th><th>Request</th><th>Status</th><th>ms</th></tr>
+  {{range .Captures}}
+  <tr>
+    <td class="muted"><a href="/admin/captures/{{.ID}}">{{fmtTime .TS}}</a></td>
+    <td>{{.TokenName}}</td>
+    <td>{{.Browser}}</td>
+    <td><code>{{.Method}} {{.URL}}</code></td>
+    <td>{{if .BlockKind}}<span class="bad" title="Detected block page">{{.StatusCode}} {{.BlockVendor}} {{.BlockKind}}</span>{{else if .Success}}<span class="ok">{{.StatusCode}}</span>{{else}}<span class="bad">{{if .ErrorType}}{{.ErrorType}}{{else}}error{{end}}</span>{{end}}</td>
+    <td class="muted">{{.DurationMs}}</td>
+  </tr>
+  {{else}}
+  <tr><td colspan="6" class="muted">Nothing captured.</td></tr>
+  {{end}}
+</table>
+{{end}}
+
+{{define "capture"}}
+<h2>Capture #{{.ID}} <span class="muted" style="font-size:13px; font-weight:400">{{fmtTime .TS}} · token {{.TokenName}} · {{.Browser}} · {{.DurationMs}} ms · expires {{fmtTime .ExpiresAt}}</span></h2>
+<div class="cols2">
+  <div>
+    <h2>Request</h2>
+    <pre>{{.Method}} {{.URL}}
+{{range $k, $vals := .RequestHeaders}}{{range $vals}}{{$k}}: {{.}}
+{{end}}{{end}}</pre>
+    {{if .RequestBody}}<pre>{{.RequestBody}}</pre>{{end}}
+    {{if .RequestBodyBase64}}<p class="muted">Body is base64-encoded.</p>{{end}}
+    {{if .RequestTruncated}}<p class="warn">Body truncated to the capture size cap.</p>{{end}}
+  </div>
+  <div>
+    <h2>Response</h2>
+    {{if .Success}}
+    <pre>{{.StatusCode}}
+{{range $k, $vals := .ResponseHeaders}}{{range $vals}}{{$k}}: {{.}}
+{{end}}{{end}}</pre>
+    {{if .ResponseBody}}<pre>{{.ResponseBody}}</pre>{{end}}
+    {{if .ResponseBase64}}<p class="muted">Body is base64-encoded.</p>{{end}}
+    {{if .ResponseTruncated}}<p class="warn">Body truncated to the capture size cap.</p>{{end}}
+    {{else}}
+    <pre class="bad">{{.ErrorType}}: {{.Error}}</pre>
+    {{end}}
+  </div>
+</div>
+{{end}}
+
+{{define "replayform"}}
+<h2 style="margin-top:20px">Replay</h2>
+{{if .Capture.RequestTruncated}}
+<p class="warn">The request body was truncated when captured, so this request can't be replayed.</p>
+{{else if .Redacted}}
+<p class="warn">The captured request has redacted values in its {{.Redacted}}, so it can't be replayed.</p>
+{{else}}
+<p class="muted">Runs the request again through the normal pipeline as token <code>{{.Capture.TokenName}}</code>. Redacted headers are left out; add them back below if needed.</p>
+<form class="filters" method="post" action="/admin/captures/{{.Capture.ID}}/replay">{{template "csrf" $.CSRF}}
+  <label>Browser<select name="browser">
+    {{range .Browsers}}<option value="{{.}}" {{if eq . $.Form.Browser}}selected{{end}}>{{.}}</option>{{end}}
+  </select></label>
+  <label>Proxy<input type="text" name="proxy" value="{{.Form.Proxy}}" placeholder="http://host:port"></label>
+  <label>Timeout (s)<input type="number" name="timeout" min="0" value="{{if .Form.Timeout}}{{.Form.Timeout}}{{end}}"></label>
+  <label><span>Follow redirects</span><input type="checkbox" name="follow_redirects" {{if .Form.FollowRedirects}}checked{{end}}></label>
+  <label><span>Insecure</span><input type="checkbox" name="insecure" {{if .Form.Insecure}}checked{{end}}></label>
+  <textarea name="headers" rows="8" placeholder="Name: value">{{.Form.Headers}}</textarea>
+  <button type="submit">Replay</button>
+</form>
+{{end}}
+{{end}}
+
+{{define "replay"}}
+{{with .Cmp}}
+<h2>Replay of capture <a href="/admin/captures/{{.Capture.ID}}">#{{.Capture.ID}}</a> <span class="muted" style="font-size:13px; font-weight:400">{{.Capture.Method}} {{.Capture.URL}}</span></h2>
+<table>
+  <tr><th></th><th>Captured</th><th>Replay</th></tr>
+  <tr><td class="muted">Browser</td><td>{{.Capture.Browser}}</td><td>{{.Browser}}</td></tr>
+  <tr><td class="muted">Status</td>
+    <td>{{if .Capture.Success}}{{.Capture.StatusCode}}{{else}}<span class="bad">{{.Capture.ErrorType}}</span>{{end}}</td>
+    <td>{{if .Response.Success}}{{.Response.StatusCode}}{{else}}<span class="bad">{{.Response.ErrorType}}</span>{{end}}</td></tr>
+  {{$after := .Response.Timing}}
+  {{with .Before}}{{if $after}}
+  <tr><td class="muted">Name lookup (s)</td><td>{{.NameLookup}}</td><td>{{$after.NameLookup}}</td></tr>
+  <tr><td class="muted">Connect (s)</td><td>{{.Connect}}</td><td>{{$after.Connect}}</td></tr>
+  <tr><td class="muted">TLS handshake (s)</td><td>{{.AppConnect}}</td><td>{{$after.AppConnect}}</td></tr>
+  <tr><td class="muted">First byte (s)</td><td>{{.StartTransfer}}</td><td>{{$after.StartTransfer}}</td></tr>
+  <tr><td class="muted">Total (s)</td><td>{{.Total}}</td><td>{{$after.Total}}</td></tr>
+  {{end}}{{end}}
+</table>
+{{if not .Response.Success}}<pre class="bad">{{.Response.Error}}</pre>{{end}}
+
+<h2 style="margin-top:20px">Response headers</h2>
+<table>
+  <tr><th>Header</th><th>Captured</th><th>Replay</th></tr>
+  {{range .Headers}}
+  <tr class="{{if eq .Change "added"}}ok{{else if eq .Change "removed"}}bad{{else if eq .Change "changed"}}warn{{else}}muted{{end}}" title="{{.Change}}">
+    <td><code>{{.Name}}</code></td><td>{{join .Before ", "}}</td><td>{{join .After ", "}}</td>
+  </tr>
+  {{end}}
+</table>
+
+<h2 style="margin-top:20px">Response body</h2>
+{{if or .Capture.ResponseTruncated .BodyTruncated}}<p class="warn">At least one body was truncated to the capture size cap; differences near the end may be artificial.</p>{{end}}
+{{if and .BodyDiffable (not .BodyDiff)}}
+<p class="ok">Bodies are identical.</p>
+{{else if .BodyDiffable}}
+<pre class="diff">{{range .BodyDiff}}<div class="{{if eq .Op "+"}}add{{else if eq .Op "-"}}del{{end}}">{{.Op}} {{.Text}}</div>{{end}}</pre>
+{{else}}
+<div class="cols2"><pre>{{.Capture.ResponseBody}}</pre><pre>{{.Body}}</pre></div>
+<p class="muted">Bodies are too large for a line diff; shown side by side.</p>
+{{end}}
+{{template "replayform" $}}
+{{end}}
 {{end}}
 
 {{define "logtable"}}
@@ -132,7 +606,7 @@ const adminTemplates = `
     <td class="muted">{{fmtTime .TS}}</td>
     <td>{{.TokenName}}</td>
     <td>{{.Browser}}</td>
-    <td>{{.Method}}</td>
+    <td>{{.Method}}{{if .Replay}} <span class="warn" title="Replayed from the admin UI">replay</span>{{end}}</td>
     <td><code>{{.TargetHost}}</code></td>
     <td>{{if .Success}}<span class="ok">{{.StatusCode}}</span>{{else}}<span class="bad">{{if .ErrorType}}{{.ErrorType}}{{else}}error{{end}}</span>{{end}}</td>
     <td class="muted">{{.DurationMs}}</td>
@@ -142,4 +616,118 @@ const adminTemplates = `
   {{end}}
 </table>
 {{end}}
+
+{{define "csrf"}}<input type="hidden" name="csrf_token" value="{{.}}">{{end}}
+
+{{define "login"}}
+<div class="login card">
+  <h2>Sign in</h2>
+  {{if .Error}}<p class="bad">{{.Error}}</p>{{end}}
+  {{if .SSO}}<p><a class="button" href="{{.SSO}}">Sign in with single sign-on</a></p>
+  <p class="muted">or with a password:</p>{{end}}
+  <form method="post" action="/admin/login">
+    <input type="hidden" name="next" value="{{.Next}}">
+    <label>Username<input type="text" name="username" autocomplete="username" required autofocus></label>
+    <label>Password<input type="password" name="password" autocomplete="current-password" required></label>
+    <button type="submit">Sign in</button>
+  </form>
+</div>
+{{end}}
+
+{{define "audit"}}
+<h2>Audit log</h2>
+<p class="muted">Every admin action, newest first. Entries can't be edited or deleted.</p>
+<form class="filters" method="get" action="/admin/audit">
+  <label>From (UTC)<input type="datetime-local" name="since" value="{{.Filter.Since}}"></label>
+  <label>To (UTC)<input type="datetime-local" name="until" value="{{.Filter.Until}}"></label>
+  <label>Actor<input type="text" name="actor" value="{{.Filter.Actor}}"></label>
+  <label>Action<select name="action">
+    <option value="">any</option>
+    {{range .Actions}}<option value="{{.}}" {{if eq . $.Filter.Action}}selected{{end}}>{{.}}</option>{{end}}
+  </select></label>
+  <button type="submit">Filter</button>
+  <a class="button" href="/admin/audit">Clear</a>
+</form>
+<table>
+  <tr><th>Time</th><th>Actor</th><th>Action</th><th>Target</th><th>Detail</th><th>IP</th></tr>
+  {{range .Entries}}
+  <tr>
+    <td class="muted">{{fmtTime .TS}}</td>
+    <td>{{.Actor}}</td>
+    <td><code>{{.Action}}</code></td>
+    <td>{{.Target}}</td>
+    <td class="muted">{{.Detail}}</td>
+    <td class="muted">{{.IP}}</td>
+  </tr>
+  {{else}}
+  <tr><td colspan="6" class="muted">No matching entries.</td></tr>
+  {{end}}
+</table>
+<div class="pager">
+  <span>{{if .Paged}}<a href="/admin/audit?{{.Filter.Query.Encode}}">« newest</a>{{end}}</span>
+  <span>{{if .Next}}<a href="{{.Next}}">older »</a>{{end}}</span>
+</div>
+{{end}}
+
+{{define "account"}}
+<h2>Your account</h2>
+{{if .Saved}}<div class="banner">Password changed. Other sessions were signed out.</div>{{end}}
+<table style="margin-bottom:24px">
+  <tr><th>Username</th><td>{{.User.Username}}</td></tr>
+  <tr><th>Role</th><td>{{.User.Role}}</td></tr>
+  <tr><th>Created</th><td class="muted">{{fmtTime .User.CreatedAt}}</td></tr>
+  <tr><th>Last sign-in</th><td class="muted">{{fmtTimePtr .User.LastLoginAt}}</td></tr>
+</table>
+<h2>Change password</h2>
+<form class="filters" method="post" action="/admin/account/password">{{template "csrf" .CSRF}}
+  <label>Current password<input type="password" name="current" autocomplete="current-password" required></label>
+  <label>New password (min {{.MinPassword}})<input type="password" name="password" autocomplete="new-password" minlength="{{.MinPassword}}" required></label>
+  <button type="submit">Change password</button>
+</form>
+{{end}}
+
+{{define "users"}}
+<h2>Admin users</h2>
+{{if .Notice}}<div class="banner">{{.Notice}}</div>{{end}}
+<p class="muted">Viewers can read everything; operators can also change tokens, settings and captures;
+owners can also manage admin users. Accounts without a password can only sign in through single sign-on
+or, for <code>admin</code>, with <code>ADMIN_TOKEN</code>.{{if .SSO}} Single sign-on reaches an account with a
+password only once it is linked to the user's subject (<code>sub</code>) at the identity provider.{{end}}</p>
+<form class="filters" method="post" action="/admin/users">{{template "csrf" .CSRF}}
+  <label>Username<input type="text" name="username" required></label>
+  <label>Password (min {{.MinPassword}}, optional)<input type="password" name="password" autocomplete="new-password"></label>
+  <label>Role<select name="role">{{range .Roles}}<option value="{{.}}">{{.}}</option>{{end}}</select></label>
+  <button type="submit">Add user</button>
+</form>
+<table>
+  <tr><th>Username</th><th>Role</th><th>Created</th><th>Last sign-in</th>{{if .SSO}}<th>SSO subject</th>{{end}}<th></th></tr>
+  {{range .Users}}
+  <tr>
+    <td>{{.Username}}{{if eq .ID $.User.ID}} <span class="muted">(you)</span>{{end}}{{if not .HasPassword}} <span class="muted">(SSO only)</span>{{end}}</td>
+    <td>{{$u := .}}<form class="inline" method="post" action="/admin/users/role">{{template "csrf" $.CSRF}}
+      <input type="hidden" name="id" value="{{.ID}}">
+      <select name="role" onchange="this.form.submit()">{{range $.Roles}}<option value="{{.}}" {{if eq . $u.Role}}selected{{end}}>{{.}}</option>{{end}}</select>
+    </form></td>
+    <td class="muted">{{fmtTime .CreatedAt}}</td>
+    <td class="muted">{{fmtTimePtr .LastLoginAt}}</td>
+    {{if $.SSO}}<td><form class="inline" method="post" action="/admin/users/sso">{{template "csrf" $.CSRF}}
+      <input type="hidden" name="id" value="{{.ID}}">
+      <input type="text" name="subject" value="{{.SSOSubject}}" placeholder="not linked" title="Issuer: {{.SSOIssuer}}">
+      <button class="ghost" type="submit">{{if .SSOSubject}}Update{{else}}Link{{end}}</button>
+    </form></td>{{end}}
+    <td><div class="row-actions">
+      <form class="inline" method="post" action="/admin/users/password">{{template "csrf" $.CSRF}}
+        <input type="hidden" name="id" value="{{.ID}}">
+        <input type="password" name="password" placeholder="new password" autocomplete="new-password" minlength="{{$.MinPassword}}" required>
+        <button class="ghost" type="submit">Set password</button>
+      </form>
+      <form class="inline" method="post" action="/admin/users/delete" onsubmit="return confirm('Delete this admin user?')">{{template "csrf" $.CSRF}}
+        <input type="hidden" name="id" value="{{.ID}}">
+        <button class="danger" type="submit">Delete</button>
+      </form>
+    </div></td>
+  </tr>
+  {{end}}
+</table>
+{{end}}
 `
@@ -1,14 +1,32 @@
 package handlers
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/zupolgec/curl-impersonate-service/config"
+	"github.com/zupolgec/curl-impersonate-service/executor/executortest"
 	"github.com/zupolgec/curl-impersonate-service/metrics"
+	"github.com/zupolgec/curl-impersonate-service/models"
+	"github.com/zupolgec/curl-impersonate-service/oidc"
+	"github.com/zupolgec/curl-impersonate-service/oidc/oidctest"
+	"github.com/zupolgec/curl-impersonate-service/settings"
 	"github.com/zupolgec/curl-impersonate-service/store"
 )
 
@@ -19,7 +37,30 @@ func newTestAdmin(t *testing.T) (http.Handler, *store.Store) {
 		t.Fatalf("store.Open: %v", err)
 	}
 	t.Cleanup(func() { _ = st.Close() })
-	return NewAdminHandler(st, metrics.NewCollector()), st
+	return signedIn(t, st, NewAdminHandler(st, metrics.NewCollector(), nil, AdminOptions{}), store.RoleOwner), st
+}
+
+// signedIn wraps an admin handler so every request carries the session cookie
+// and CSRF header of a fresh account with the given role.
+func signedIn(t *testing.T, st *store.Store, h http.Handler, role string) http.Handler {
+	t.Helper()
+	u, err := st.CreateAdminUser("test-"+role, "", role)
+	if err != nil {
+		t.Fatalf("CreateAdminUser: %v", err)
+	}
+	token, err := st.CreateAdminSession(u.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateAdminSession: %v", err)
+	}
+	sess, err := st.GetAdminSession(token)
+	if err != nil {
+		t.Fatalf("GetAdminSession: %v", err)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.AddCookie(&http.Cookie{Name: sessionCookie, Value: token})
+		r.Header.Set(csrfHeader, sess.CSRF)
+		h.ServeHTTP(w, r)
+	})
 }
 
 func TestAdminDashboardRenders(t *testing.T) {
@@ -51,19 +92,1027 @@ func TestAdminCreatesToken(t *testing.T) {
 	if len(toks) != 1 || toks[0].Name != "my-token" {
 		t.Fatalf("token not created: %+v", toks)
 	}
+	if loc := w.Header().Get("Location"); loc != "/admin/tokens" {
+		t.Fatalf("Location = %q, secret must not travel in the URL", loc)
+	}
+
+	// The secret is revealed exactly once, via the flash cookie.
+	reveal := func() string {
+		req := httptest.NewRequest(http.MethodGet, "/admin/tokens", nil)
+		for _, c := range w.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		return rw.Body.String()
+	}
+	if body := reveal(); !strings.Contains(body, "Copy it now") || !strings.Contains(body, toks[0].Prefix) {
+		t.Fatal("new token not revealed")
+	}
+	if body := reveal(); strings.Contains(body, "Copy it now") {
+		t.Fatal("token revealed twice")
+	}
+}
+
+// newTestRuntime returns runtime settings backed by st with the config
+// package defaults.
+func newTestRuntime(t *testing.T, st *store.Store) *settings.Runtime {
+	t.Helper()
+	rt := settings.NewRuntime(&config.Config{
+		MaxTimeout: 120, DefaultTimeout: 30,
+		MaxRequestBodySize: 10 << 20, MaxResponseBodySize: 50 << 20,
+		CORSAllowedOrigins: []string{"*"}, LogRetentionHours: 72,
+	}, st)
+	rt.Load()
+	return rt
+}
+
+func TestAdminSettingsPage(t *testing.T) {
+	st, err := store.Open(filepath.Join(t.TempDir(), "admin.db"))
+	if err != nil {
+		t.Fatalf("store.Open: %v", err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+	rt := newTestRuntime(t, st)
+	admin := NewAdminHandler(st, metrics.NewCollector(), nil, AdminOptions{Settings: rt.Registry})
+	h := signedIn(t, st, admin, store.RoleOperator)
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/settings", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := post(url.Values{"key": {"cors_allowed_origins"}, "value": {"https://a.com\r\n https://b.com"}}); w.Code != http.StatusSeeOther {
+		t.Fatalf("save: %d %s", w.Code, w.Body.String())
+	}
+	if got := rt.CORSAllowedOrigins.Get(); fmt.Sprint(got) != "[https://a.com https://b.com]" {
+		t.Fatalf("origins = %v", got)
+	}
+	if got := st.GetSetting("cors_allowed_origins", ""); got != "https://a.com,https://b.com" {
+		t.Fatalf("stored origins = %q", got)
+	}
+	if w := post(url.Values{"key": {"max_timeout"}, "value": {"0"}}); w.Code != http.StatusBadRequest {
+		t.Fatalf("out of range: %d", w.Code)
+	}
+	if w := post(url.Values{"key": {"nope"}, "value": {"1"}}); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown key: %d", w.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/settings", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if body := w.Body.String(); w.Code != http.StatusOK || !strings.Contains(body, "ssrf_allow_private") || !strings.Contains(body, ">admin</strong>") {
+		t.Fatalf("settings page: %d", w.Code)
+	}
+
+	if w := post(url.Values{"key": {"cors_allowed_origins"}, "action": {"reset"}}); w.Code != http.StatusSeeOther {
+		t.Fatalf("reset: %d", w.Code)
+	}
+	if got := rt.CORSAllowedOrigins.Get(); fmt.Sprint(got) != "[*]" || rt.CORSAllowedOrigins.Source() != settings.SourceDefault {
+		t.Fatalf("after reset: %v from %s", got, rt.CORSAllowedOrigins.Source())
+	}
+	if page, err := st.QueryAudit(store.AuditFilter{Action: "settings.reset"}); err != nil || len(page.Entries) != 1 {
+		t.Fatalf("reset audit: %+v, %v", page, err)
+	}
+
+	// Viewers can read the page but not change settings.
+	viewer := signedIn(t, st, admin, store.RoleViewer)
+	req = httptest.NewRequest(http.MethodPost, "/admin/settings", strings.NewReader("key=max_timeout&value=5"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	viewer.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden || rt.MaxTimeout.Get() != 120 {
+		t.Fatalf("viewer save: %d, max_timeout=%d", w.Code, rt.MaxTimeout.Get())
+	}
+}
+
+func TestAdminBrowsersReload(t *testing.T) {
+	if err := models.LoadBrowsers("../browsers.json", nil); err != nil {
+		t.Fatalf("LoadBrowsers: %v", err)
+	}
+	st, err := store.Open(filepath.Join(t.TempDir(), "admin.db"))
+	if err != nil {
+		t.Fatalf("store.Open: %v", err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+	reloadErr := errors.New("invalid browsers.json: default: required")
+	reloads := 0
+	admin := NewAdminHandler(st, metrics.NewCollector(), nil, AdminOptions{ReloadBrowsers: func() error {
+		reloads++
+		return reloadErr
+	}})
+	h := signedIn(t, st, admin, store.RoleOperator)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/browsers", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if body := w.Body.String(); w.Code != http.StatusOK || !strings.Contains(body, "chrome-latest") || !strings.Contains(body, "Reload browsers.json") {
+		t.Fatalf("browsers page: %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/admin/browsers/reload", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusSeeOther || reloads != 1 {
+		t.Fatalf("reload: %d, %d reloads", w.Code, reloads)
+	}
+	if page, err := st.QueryAudit(store.AuditFilter{Action: "browsers.reload"}); err != nil || len(page.Entries) != 1 || !strings.Contains(page.Entries[0].Detail, "default: required") {
+		t.Fatalf("reload audit: %+v, %v", page, err)
+	}
+
+	// Custom profiles are saved, listed and deleted.
+	t.Cleanup(func() { _ = models.SetStoredProfiles(nil) })
+	postProfile := func(path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	form := url.Values{
+		"name": {"chrome-de"}, "base": {"chrome-latest"}, "accept_language": {"de-DE,de;q=0.9"},
+		"headers": {"Sec-Ch-Ua-Platform: \"Windows\"\r\nPriority:"}, "header_order": {"sec-ch-ua-platform\naccept-language"},
+		"curves": {"X25519:P-256"}, "window_update": {"15663105"},
+	}
+	if w := postProfile("/admin/browsers/profiles", form); w.Code != http.StatusSeeOther {
+		t.Fatalf("save profile: %d %s", w.Code, w.Body.String())
+	}
+	config, err := models.GetBrowserConfig("chrome-de")
+	if err != nil || config.Base != "chrome136" || config.Profile.Headers["Priority"] != "" || config.Profile.TLS.Curves != "X25519:P-256" || config.Profile.HTTP2.WindowUpdate != 15663105 {
+		t.Fatalf("chrome-de = %+v, %v", config, err)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/admin/browsers?edit=chrome-de", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if body := w.Body.String(); !strings.Contains(body, "Edit chrome-de") || !strings.Contains(body, `value="X25519:P-256"`) {
+		t.Fatalf("edit form missing")
+	}
+	form.Set("base", "netscape4")
+	if w := postProfile("/admin/browsers/profiles", form); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown base: %d", w.Code)
+	}
+	if w := postProfile("/admin/browsers/profiles/delete", url.Values{"name": {"chrome-de"}}); w.Code != http.StatusSeeOther {
+		t.Fatalf("delete profile: %d", w.Code)
+	}
+	if _, err := models.GetBrowserConfig("chrome-de"); err == nil {
+		t.Fatal("deleted profile still resolves")
+	}
+	if page, err := st.QueryAudit(store.AuditFilter{Action: "profiles.delete"}); err != nil || len(page.Entries) != 1 {
+		t.Fatalf("delete audit: %+v, %v", page, err)
+	}
+
+	// Viewers can see the browsers but not reload them.
+	viewer := signedIn(t, st, admin, store.RoleViewer)
+	req = httptest.NewRequest(http.MethodPost, "/admin/browsers/reload", nil)
+	w = httptest.NewRecorder()
+	viewer.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden || reloads != 1 {
+		t.Fatalf("viewer reload: %d", w.Code)
+	}
+}
+
+func TestAdminLogSearchAndExport(t *testing.T) {
+	h, st := newTestAdmin(t)
+	for _, host := range []string{"a.com", "b.com", "a.com"} {
+		_ = st.AddLog(store.LogEntry{TokenName: "ci", Browser: "chrome136", Method: "GET", TargetHost: host, StatusCode: 200, Success: true})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/logs/search?host=a.com&limit=1", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("search status = %d, want 200", w.Code)
+	}
+	var page store.LogPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(page.Logs) != 1 || page.NextCursor == "" {
+		t.Fatalf("page = %+v, want 1 row and a cursor", page)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/logs/export?format=csv&host=a.com", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "id,ts,") {
+		t.Fatalf("csv export = %q, want header + 2 rows", w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/logs?host=a.com&limit=1", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "cursor=") {
+		t.Fatalf("logs page status = %d, want 200 with a next-page link", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/logs/search?success=maybe", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("bad filter status = %d, want 400", w.Code)
+	}
+}
+
+func TestAdminAnalyticsRenders(t *testing.T) {
+	h, _ := newTestAdmin(t)
+	for _, rng := range []string{"", "7d", "365d"} {
+		req := httptest.NewRequest(http.MethodGet, "/admin/analytics?range="+rng, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<svg") {
+			t.Fatalf("range %q: status = %d, want 200 with chart", rng, w.Code)
+		}
+	}
 }
 
-func TestAdminCORSProviderReflectsSetting(t *testing.T) {
+func TestAdminResetMetrics(t *testing.T) {
+	st, err := store.Open(filepath.Join(t.TempDir(), "admin.db"))
+	if err != nil {
+		t.Fatalf("store.Open: %v", err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+	collector := metrics.NewCollector()
+	collector.RecordRequest("chrome136", true, time.Millisecond)
+	h := signedIn(t, st, NewAdminHandler(st, collector, nil, AdminOptions{}), store.RoleOperator)
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/metrics/reset", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, want 303", w.Code)
+	}
+
+	restored := metrics.NewCollector()
+	_ = restored.LoadSnapshot(st)
+	if all := restored.AllTime(); all.RequestsTotal != 0 {
+		t.Fatalf("persisted all-time total = %d, want 0 after reset", all.RequestsTotal)
+	}
+}
+
+func TestAdminCaptureLifecycle(t *testing.T) {
 	h, st := newTestAdmin(t)
-	provider := CORSOriginProvider(st, []string{"*"})
 
-	form := url.Values{"origins": {"https://a.com, https://b.com"}}
-	req := httptest.NewRequest(http.MethodPost, "/admin/cors", strings.NewReader(form.Encode()))
+	form := url.Values{"token_name": {"ci"}, "duration": {"1h"}}
+	req := httptest.NewRequest(http.MethodPost, "/admin/captures", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("start status = %d, want 303", w.Code)
+	}
+	sess, ok := st.ActiveCapture("ci")
+	if !ok {
+		t.Fatal("capture session not active")
+	}
+	id, _ := st.AddCapture(store.Capture{SessionID: sess.ID, TokenName: "ci", Method: "GET", URL: "https://a.com/", Success: true,
+		StatusCode: 200, ResponseBody: "<h1>hi</h1>", ExpiresAt: time.Now().Add(time.Hour)})
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/captures/"+strconv.FormatInt(id, 10), nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "&lt;h1&gt;hi&lt;/h1&gt;") {
+		t.Fatalf("detail status = %d, body escaped? %v", w.Code, strings.Contains(w.Body.String(), "<h1>hi"))
+	}
+
+	form = url.Values{"id": {strconv.FormatInt(sess.ID, 10)}}
+	req = httptest.NewRequest(http.MethodPost, "/admin/captures/stop", strings.NewReader(form.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	h.ServeHTTP(httptest.NewRecorder(), req)
+	if _, ok := st.ActiveCapture("ci"); ok {
+		t.Fatal("capture still active after stop")
+	}
+}
+
+func TestAdminReplayCapture(t *testing.T) {
+	st, err := store.Open(filepath.Join(t.TempDir(), "admin.db"))
+	if err != nil {
+		t.Fatalf("store.Open: %v", err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+	collector := metrics.NewCollector()
+	h := signedIn(t, st, NewAdminHandler(st, collector, NewImpersonateHandler(&config.Config{}, newTestRuntime(t, st), collector, st, &executortest.Fake{}), AdminOptions{}), store.RoleOperator)
+
+	replayWith := func(c store.Capture, headers string) *httptest.ResponseRecorder {
+		c.ExpiresAt = time.Now().Add(time.Hour)
+		id, err := st.AddCapture(c)
+		if err != nil {
+			t.Fatalf("AddCapture: %v", err)
+		}
+		form := url.Values{"browser": {"chrome"}, "headers": {headers}, "follow_redirects": {"on"}}
+		req := httptest.NewRequest(http.MethodPost, "/admin/captures/"+strconv.FormatInt(id, 10)+"/replay", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	replay := func(c store.Capture) *httptest.ResponseRecorder { return replayWith(c, "Accept: text/html") }
+
+	// Truncated request bodies can't be replayed faithfully.
+	if w := replay(store.Capture{TokenName: "ci", Method: "POST", URL: "https://a.com/", RequestTruncated: true}); w.Code != http.StatusBadRequest {
+		t.Fatalf("truncated replay status = %d, want 400", w.Code)
+	}
+	id, _ := st.AddCapture(store.Capture{TokenName: "ci", Method: "GET", URL: "https://a.com/", ExpiresAt: time.Now().Add(time.Hour),
+		RequestHeaders: map[string][]string{"Accept": {"text/html"}, "Authorization": {"[REDACTED]"}}})
+	req := httptest.NewRequest(http.MethodGet, "/admin/captures/"+strconv.FormatInt(id, 10), nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if body := w.Body.String(); !strings.Contains(body, "Accept: text/html") || strings.Contains(body, "Authorization: [REDACTED]</textarea>") {
+		t.Fatalf("replay form not pre-filled correctly: %d", w.Code)
+	}
+
+	// Redacted values would reach the target as placeholders.
+	for _, c := range []store.Capture{
+		{TokenName: "ci", Method: "GET", URL: "https://a.com/?api_key=%5BREDACTED%5D"},
+		{TokenName: "ci", Method: "GET", URL: "https://%5BREDACTED%5D@a.com/"},
+		{TokenName: "ci", Method: "POST", URL: "https://a.com/", RequestBody: `{"password":"[REDACTED]"}`},
+	} {
+		if w := replay(c); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "redacted") {
+			t.Fatalf("replay of %s %q = %d %q, want a 400", c.URL, c.RequestBody, w.Code, w.Body.String())
+		}
+	}
+	if w := replayWith(store.Capture{TokenName: "ci", Method: "GET", URL: "https://a.com/"}, "Authorization: [REDACTED]"); w.Code != http.StatusBadRequest {
+		t.Fatalf("redacted header replay status = %d, want 400", w.Code)
+	}
+	id, _ = st.AddCapture(store.Capture{TokenName: "ci", Method: "POST", URL: "https://a.com/", ExpiresAt: time.Now().Add(time.Hour),
+		RequestBody: `{"password":"[REDACTED]"}`})
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/captures/"+strconv.FormatInt(id, 10), nil))
+	if body := w.Body.String(); !strings.Contains(body, "redacted values in its request body") || strings.Contains(body, `/replay"`) {
+		t.Fatalf("capture page offers replay of a redacted body: %d", w.Code)
+	}
+
+	// Replays go through the same SSRF checks as /impersonate.
+	w = replay(store.Capture{TokenName: "ci", Method: "GET", URL: "https://127.0.0.1/"})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "replay failed") {
+		t.Fatalf("blocked replay status = %d body = %q", w.Code, w.Body.String())
+	}
+}
+
+func TestAdminReplayThroughProxy(t *testing.T) {
+	target, proxy := executortest.NewUpstream(), executortest.NewUpstream()
+	defer target.Close()
+	defer proxy.Close()
+	fake := &executortest.Fake{}
+	imp, st, _ := newTestImpersonate(t, fake, false)
+	h := signedIn(t, st, NewAdminHandler(st, metrics.NewCollector(), imp, AdminOptions{}), store.RoleOperator)
+
+	replay := func(rawURL, proxyURL string) *httptest.ResponseRecorder {
+		id, err := st.AddCapture(store.Capture{TokenName: "ci", Method: "GET", URL: rawURL, ExpiresAt: time.Now().Add(time.Hour)})
+		if err != nil {
+			t.Fatalf("AddCapture: %v", err)
+		}
+		form := url.Values{"browser": {"firefox-latest"}, "proxy": {proxyURL}}
+		req := httptest.NewRequest(http.MethodPost, "/admin/captures/"+strconv.FormatInt(id, 10)+"/replay", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := replay(target.URL+"/anything", proxy.URL); w.Code != http.StatusOK {
+		t.Fatalf("proxied replay = %d %s", w.Code, w.Body.String())
+	}
+	if proxy.Hits("/anything") != 1 || target.Hits("/anything") != 0 {
+		t.Fatalf("proxy hits = %d, target hits = %d, want 1 and 0", proxy.Hits("/anything"), target.Hits("/anything"))
+	}
+	if calls := fake.Calls(); len(calls) != 1 || calls[0].Request.Proxy != proxy.URL {
+		t.Fatalf("executor calls = %+v", calls)
+	}
+
+	// Proxies get the target's SSRF checks: no loopback or private proxies
+	// unless those are allowed.
+	if _, err := imp.settings.Set("ssrf_allow_private", "false"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	for _, p := range []string{proxy.URL, "http://10.0.0.5:3128", "ftp://203.0.113.7"} {
+		if w := replay("http://203.0.113.7/", p); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "replay failed") {
+			t.Fatalf("replay through %s = %d %q, want a 400", p, w.Code, w.Body.String())
+		}
+	}
+	if n := len(fake.Calls()); n != 1 {
+		t.Fatalf("rejected proxies reached the executor: %d calls", n)
+	}
+}
+
+func TestAdminTokenScopesAndRotation(t *testing.T) {
+	h, st := newTestAdmin(t)
+
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	expires := time.Now().UTC().Add(72 * time.Hour).Format("2006-01-02T15:04")
+	if w := post("/admin/tokens", url.Values{"name": {"ci"}, "scope": {"metrics", "browsers"}, "expires": {expires}}); w.Code != http.StatusSeeOther {
+		t.Fatalf("create status = %d: %s", w.Code, w.Body.String())
+	}
+	if w := post("/admin/tokens", url.Values{"name": {"bad"}, "scope": {"root"}}); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown scope status = %d, want 400", w.Code)
+	}
+	toks, _ := st.ListTokens()
+	if len(toks) != 1 || len(toks[0].Scopes) != 2 || toks[0].ExpiresAt == nil {
+		t.Fatalf("token = %+v", toks)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/tokens", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), "Expiring soon") {
+		t.Fatal("upcoming expiry not shown")
+	}
+
+	if w := post("/admin/tokens/rotate", url.Values{"id": {strconv.FormatInt(toks[0].ID, 10)}, "grace": {"1h"}}); w.Code != http.StatusSeeOther {
+		t.Fatalf("rotate status = %d", w.Code)
+	}
+	toks, _ = st.ListTokens()
+	if len(toks) != 2 || toks[1].ReplacedBy != toks[0].ID {
+		t.Fatalf("after rotation: %+v", toks)
+	}
+}
+
+func TestAdminTokenSigning(t *testing.T) {
+	h, st := newTestAdmin(t)
+	tok, _ := st.CreateToken("signer", store.TokenOptions{})
+	id := strconv.FormatInt(tok.ID, 10)
+
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	// Signing secrets are sealed with SECRETS_KEY, so there must be one.
+	if w := post("/admin/tokens/signing", url.Values{"id": {id}}); w.Code != http.StatusBadRequest {
+		t.Fatalf("enable signing without secrets key: %d %s", w.Code, w.Body.String())
+	}
+	if err := st.SetSecretsKey(make([]byte, 32)); err != nil {
+		t.Fatalf("SetSecretsKey: %v", err)
+	}
+	w := post("/admin/tokens/signing", url.Values{"id": {id}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("enable signing: %d %s", w.Code, w.Body.String())
+	}
+	// The secret is revealed once, on the next page view.
+	req := httptest.NewRequest(http.MethodGet, "/admin/tokens", nil)
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	page := httptest.NewRecorder()
+	h.ServeHTTP(page, req)
+	got, _ := st.GetToken(tok.ID)
+	_, secret, _ := st.SigningKey(got.SigningKeyID, models.ScopeImpersonate)
+	if got.SigningKeyID == "" || !strings.Contains(page.Body.String(), string(secret)) {
+		t.Fatalf("signing secret not revealed (key %q)", got.SigningKeyID)
+	}
+
+	if w := post("/admin/tokens/update", url.Values{"id": {id}, "name": {"signer"}, "signed_only": {"on"}}); w.Code != http.StatusSeeOther {
+		t.Fatalf("update: %d %s", w.Code, w.Body.String())
+	}
+	got, _ = st.GetToken(tok.ID)
+	if !got.SignedOnly || !got.NoQueryToken {
+		t.Fatalf("after update: signed_only=%v no_query_token=%v", got.SignedOnly, got.NoQueryToken)
+	}
+
+	api := NewAdminAPIHandler(st, metrics.NewCollector(), newTestRuntime(t, st).Registry)
+	call := func(method, path, body string) (*httptest.ResponseRecorder, map[string]any) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		var out map[string]any
+		_ = json.Unmarshal(w.Body.Bytes(), &out)
+		return w, out
+	}
+	w, out := call(http.MethodPost, "/admin/api/v1/tokens/"+id+"/signing", "")
+	if w.Code != http.StatusCreated || out["secret"] == "" || out["key_id"] == got.SigningKeyID {
+		t.Fatalf("API enable signing: %d %s", w.Code, w.Body.String())
+	}
+	if w, out := call(http.MethodDelete, "/admin/api/v1/tokens/"+id+"/signing", ""); w.Code != http.StatusOK || out["signed_only"] != false || out["signing_key_id"] != nil {
+		t.Fatalf("API disable signing: %d %s", w.Code, w.Body.String())
+	}
+	if w, out := call(http.MethodPatch, "/admin/api/v1/tokens/"+id, `{"signed_only":true}`); w.Code != http.StatusBadRequest || out["error_type"] != "validation" {
+		t.Fatalf("signed_only without key: %d %s", w.Code, w.Body.String())
+	}
+	if w, out := call(http.MethodPatch, "/admin/api/v1/tokens/"+id, `{"query_token":true}`); w.Code != http.StatusOK || out["query_token"] != true {
+		t.Fatalf("query_token: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAdminAPITokensAndSettings(t *testing.T) {
+	_, st := newTestAdmin(t)
+	rt := newTestRuntime(t, st)
+	api := NewAdminAPIHandler(st, metrics.NewCollector(), rt.Registry)
+
+	call := func(method, path, body string) (*httptest.ResponseRecorder, map[string]any) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		var out map[string]any
+		_ = json.Unmarshal(w.Body.Bytes(), &out)
+		return w, out
+	}
+
+	w, out := call(http.MethodPost, "/admin/api/v1/tokens", `{"name":"infra","scopes":["metrics"]}`)
+	if w.Code != http.StatusCreated || out["secret"] == "" {
+		t.Fatalf("create: %d %s", w.Code, w.Body.String())
+	}
+	secret := out["secret"].(string)
+	id := strconv.FormatInt(int64(out["token"].(map[string]any)["id"].(float64)), 10)
+
+	if w, _ := call(http.MethodGet, "/admin/api/v1/tokens", ""); strings.Contains(w.Body.String(), secret) {
+		t.Fatal("list leaks the secret")
+	}
+	if w, out := call(http.MethodPatch, "/admin/api/v1/tokens/"+id, `{"name":"infra-2","expires_at":"2999-01-01T00:00:00Z"}`); w.Code != http.StatusOK || out["name"] != "infra-2" || out["expires_at"] == nil {
+		t.Fatalf("patch: %d %s", w.Code, w.Body.String())
+	}
+	if w, out := call(http.MethodPost, "/admin/api/v1/tokens/"+id+"/disable", ""); w.Code != http.StatusOK || out["enabled"] != false {
+		t.Fatalf("disable: %d %s", w.Code, w.Body.String())
+	}
+	if w, out := call(http.MethodPost, "/admin/api/v1/tokens/"+id+"/rotate", `{"grace":"1h"}`); w.Code != http.StatusCreated || out["secret"] == secret {
+		t.Fatalf("rotate: %d %s", w.Code, w.Body.String())
+	}
+	if w, _ := call(http.MethodDelete, "/admin/api/v1/tokens/"+id, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: %d", w.Code)
+	}
+
+	// Errors use the models.ErrorResponse shape.
+	w, out = call(http.MethodGet, "/admin/api/v1/tokens/"+id, "")
+	if w.Code != http.StatusNotFound || out["success"] != false || out["error_type"] != "not_found" {
+		t.Fatalf("missing token: %d %s", w.Code, w.Body.String())
+	}
+	if w, out := call(http.MethodPost, "/admin/api/v1/tokens", `{"name":"x","scopes":["root"]}`); w.Code != http.StatusBadRequest || out["error_type"] != "validation" {
+		t.Fatalf("bad scope: %d %s", w.Code, w.Body.String())
+	}
+	if w, _ := call(http.MethodPost, "/admin/api/v1/tokens", `{"name":"x","bogus":1}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown field: %d", w.Code)
+	}
+
+	w, out = call(http.MethodPatch, "/admin/api/v1/settings", `{"cors_allowed_origins":["https://a.com"," https://b.com "]}`)
+	if w.Code != http.StatusOK || fmt.Sprint(out["cors_allowed_origins"]) != "[https://a.com https://b.com]" {
+		t.Fatalf("settings: %d %s", w.Code, w.Body.String())
+	}
+	if got := st.GetSetting("cors_allowed_origins", ""); got != "https://a.com,https://b.com" {
+		t.Fatalf("stored origins = %q", got)
+	}
+	if w, out := call(http.MethodPatch, "/admin/api/v1/settings", `{"max_timeout":30,"ssrf_allow_http":"yes"}`); w.Code != http.StatusBadRequest || out["error_type"] != "validation" || rt.MaxTimeout.Get() != 120 {
+		t.Fatalf("invalid settings must change nothing: %d %s", w.Code, w.Body.String())
+	}
+	if w, out := call(http.MethodPatch, "/admin/api/v1/settings", `{"cors_allowed_origins":null,"max_timeout":60}`); w.Code != http.StatusOK || fmt.Sprint(out["cors_allowed_origins"]) != "[*]" || out["max_timeout"] != float64(60) {
+		t.Fatalf("reset and set: %d %s", w.Code, w.Body.String())
+	}
+	req := httptest.NewRequest(http.MethodGet, "/admin/api/v1/settings/details", nil)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	var details []apiSetting
+	if err := json.Unmarshal(w.Body.Bytes(), &details); err != nil || len(details) != len(rt.Entries()) {
+		t.Fatalf("details: %d %s", w.Code, w.Body.String())
+	}
+	if d := details[0]; d.Key != "max_timeout" || d.Source != settings.SourceAdmin || d.Default != "120" || d.Env != "MAX_TIMEOUT" {
+		t.Fatalf("details[0] = %+v", d)
+	}
+	if w, _ := call(http.MethodGet, "/admin/api/v1/metrics", ""); w.Code != http.StatusOK {
+		t.Fatalf("metrics: %d", w.Code)
+	}
+	if w, out := call(http.MethodGet, "/admin/api/v1/nope", ""); w.Code != http.StatusNotFound || out["error_type"] != "not_found" {
+		t.Fatalf("unknown endpoint: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAdminLoginAndSessions(t *testing.T) {
+	st, err := store.Open(filepath.Join(t.TempDir(), "admin.db"))
+	if err != nil {
+		t.Fatalf("store.Open: %v", err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+	h := NewAdminHandler(st, metrics.NewCollector(), nil, AdminOptions{AdminToken: "bootstrap-secret"})
+
+	// Signed-out pages redirect to the login form.
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/tokens", nil))
+	if w.Code != http.StatusSeeOther || !strings.HasPrefix(w.Header().Get("Location"), "/admin/login?next=%2Fadmin%2Ftokens") {
+		t.Fatalf("signed out: %d %q", w.Code, w.Header().Get("Location"))
+	}
+
+	login := func(user, pass string) *httptest.ResponseRecorder {
+		form := url.Values{"username": {user}, "password": {pass}, "next": {"/admin/tokens"}}
+		req := httptest.NewRequest(http.MethodPost, "/admin/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	if w := login("admin", "wrong"); !strings.Contains(w.Header().Get("Location"), "error=1") {
+		t.Fatalf("bad login redirected to %q", w.Header().Get("Location"))
+	}
+	w = login("admin", "bootstrap-secret")
+	if w.Header().Get("Location") != "/admin/tokens" {
+		t.Fatalf("login redirected to %q", w.Header().Get("Location"))
+	}
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly {
+		t.Fatalf("session cookie = %+v", cookie)
+	}
+	sess, err := st.GetAdminSession(cookie.Value)
+	if err != nil || sess.User.Username != "admin" || sess.User.Role != store.RoleOwner {
+		t.Fatalf("bootstrap session = %+v, %v", sess, err)
+	}
+
+	post := func(path string, form url.Values, csrf string) int {
+		if csrf != "" {
+			form.Set(csrfField, csrf)
+		}
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := post("/admin/tokens", url.Values{"name": {"x"}}, ""); code != http.StatusForbidden {
+		t.Fatalf("POST without CSRF token = %d, want 403", code)
+	}
+	if code := post("/admin/tokens", url.Values{"name": {"x"}}, sess.CSRF); code != http.StatusSeeOther {
+		t.Fatalf("POST with CSRF token = %d, want 303", code)
+	}
+	if code := post("/admin/logout", url.Values{}, sess.CSRF); code != http.StatusSeeOther {
+		t.Fatalf("logout = %d", code)
+	}
+	if _, err := st.GetAdminSession(cookie.Value); err == nil {
+		t.Fatal("session survived logout")
+	}
+
+	page, _ := st.QueryAudit(store.AuditFilter{})
+	var actions []string
+	for _, e := range page.Entries {
+		actions = append(actions, e.Action)
+	}
+	if got := strings.Join(actions, ","); got != "logout,token.create,login,login.failed" {
+		t.Fatalf("audit actions = %s", got)
+	}
+}
+
+func TestAdminLoginThrottled(t *testing.T) {
+	st, err := store.Open(filepath.Join(t.TempDir(), "admin.db"))
+	if err != nil {
+		t.Fatalf("store.Open: %v", err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+	if _, err := st.CreateAdminUser("ann", "correct horse battery", store.RoleViewer); err != nil {
+		t.Fatalf("CreateAdminUser: %v", err)
+	}
+	if _, err := st.CreateAdminUser("bob", "correct horse battery", store.RoleViewer); err != nil {
+		t.Fatalf("CreateAdminUser: %v", err)
+	}
+	h := NewAdminHandler(st, metrics.NewCollector(), nil, AdminOptions{})
+	login := func(addr, user, pass string) string {
+		form := url.Values{"username": {user}, "password": {pass}}
+		req := httptest.NewRequest(http.MethodPost, "/admin/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = addr + ":1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Header().Get("Location")
+	}
+
+	for range loginMaxPerUser {
+		if loc := login("192.0.2.1", "ann", "wrong"); !strings.Contains(loc, "error=1") {
+			t.Fatalf("bad login redirected to %q", loc)
+		}
+	}
+	// The username is locked from that address, even with the right password
+	// (in any case), but other accounts are not.
+	if loc := login("192.0.2.1", "ANN", "correct horse battery"); !strings.Contains(loc, "error=throttled") {
+		t.Fatalf("locked login redirected to %q", loc)
+	}
+	if loc := login("192.0.2.1", "bob", "correct horse battery"); loc != "/admin/" {
+		t.Fatalf("other account redirected to %q", loc)
+	}
+	// Failures from one address don't lock the account out elsewhere.
+	if loc := login("198.51.100.7", "ann", "correct horse battery"); loc != "/admin/" {
+		t.Fatalf("login from another address redirected to %q", loc)
+	}
+
+	// Failures from one address add up across usernames.
+	l := newLoginLimiter()
+	l.perIP = 2
+	l.fail("192.0.2.1", "x")
+	l.fail("192.0.2.1", "y")
+	if l.allow("192.0.2.1", "z") || !l.allow("192.0.2.2", "z") {
+		t.Fatal("per-address limit not applied")
+	}
+	l.perIP = loginMaxPerIP
+	for range loginMaxPerUser {
+		l.fail("192.0.2.3", "x")
+	}
+	if l.allow("192.0.2.3", "x") || !l.allow("192.0.2.4", "x") {
+		t.Fatal("username limit not applied per address")
+	}
+	l.succeed("192.0.2.3", "x")
+	if !l.allow("192.0.2.3", "x") {
+		t.Fatal("success did not clear the username")
+	}
+}
+
+func TestAdminRolesAndUserManagement(t *testing.T) {
+	st, err := store.Open(filepath.Join(t.TempDir(), "admin.db"))
+	if err != nil {
+		t.Fatalf("store.Open: %v", err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+	admin := NewAdminHandler(st, metrics.NewCollector(), nil, AdminOptions{})
+	viewer := signedIn(t, st, admin, store.RoleViewer)
+	owner := signedIn(t, st, admin, store.RoleOwner)
+
+	do := func(h http.Handler, method, path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do(viewer, http.MethodGet, "/admin/tokens", nil); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "Create token") {
+		t.Fatalf("viewer tokens page: %d", w.Code)
+	}
+	if w := do(viewer, http.MethodPost, "/admin/tokens", url.Values{"name": {"x"}}); w.Code != http.StatusForbidden {
+		t.Fatalf("viewer create token = %d, want 403", w.Code)
+	}
+	if w := do(viewer, http.MethodGet, "/admin/users", nil); w.Code != http.StatusForbidden {
+		t.Fatalf("viewer users page = %d, want 403", w.Code)
+	}
 
-	got := provider()
-	if len(got) != 2 || got[0] != "https://a.com" || got[1] != "https://b.com" {
-		t.Fatalf("provider() = %v, want [https://a.com https://b.com]", got)
+	if w := do(owner, http.MethodPost, "/admin/users", url.Values{"username": {"carol"}, "role": {store.RoleOperator}}); w.Code != http.StatusSeeOther {
+		t.Fatalf("create user = %d: %s", w.Code, w.Body)
+	}
+	carol, err := st.GetAdminUserByName("carol")
+	if err != nil || carol.Role != store.RoleOperator {
+		t.Fatalf("carol = %+v, %v", carol, err)
+	}
+	id := strconv.FormatInt(carol.ID, 10)
+	if w := do(owner, http.MethodPost, "/admin/users/role", url.Values{"id": {id}, "role": {store.RoleViewer}}); w.Code != http.StatusSeeOther {
+		t.Fatalf("set role = %d", w.Code)
+	}
+	if w := do(owner, http.MethodPost, "/admin/users/delete", url.Values{"id": {id}}); w.Code != http.StatusSeeOther {
+		t.Fatalf("delete user = %d", w.Code)
+	}
+	owners, _ := st.GetAdminUserByName("test-owner")
+	if w := do(owner, http.MethodPost, "/admin/users/delete", url.Values{"id": {strconv.FormatInt(owners.ID, 10)}}); w.Code != http.StatusConflict {
+		t.Fatalf("delete last owner = %d, want 409", w.Code)
+	}
+
+	w := do(viewer, http.MethodGet, "/admin/audit?action=user.", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("audit page = %d", w.Code)
+	}
+	for _, action := range []string{"user.create", "user.role", "user.delete"} {
+		if !strings.Contains(w.Body.String(), action) {
+			t.Fatalf("audit page missing %s", action)
+		}
+	}
+}
+
+func TestAdminSSOSignIn(t *testing.T) {
+	idp := oidctest.NewServer("impersonate", "s3cret")
+	defer idp.Close()
+	client, err := oidc.New(oidc.Config{
+		Issuer:       idp.Issuer(),
+		ClientID:     "impersonate",
+		ClientSecret: "s3cret",
+		RedirectURL:  "https://impersonate.example.com/admin/oidc/callback",
+		Scopes:       []string{"email"},
+	})
+	if err != nil {
+		t.Fatalf("oidc.New: %v", err)
+	}
+	st, err := store.Open(filepath.Join(t.TempDir(), "admin.db"))
+	if err != nil {
+		t.Fatalf("store.Open: %v", err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+	h := NewAdminHandler(st, metrics.NewCollector(), nil, AdminOptions{SSO: &SSOOptions{
+		Client:  client,
+		RoleMap: map[string]string{"staff": store.RoleViewer, "sre": store.RoleOperator},
+	}})
+
+	// signIn runs the browser side of the flow and returns the final response.
+	signIn := func(claims map[string]any, tamperState bool) *httptest.ResponseRecorder {
+		idp.SetClaims(claims)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/oidc/login?next=/admin/logs", nil))
+		if w.Code != http.StatusFound {
+			t.Fatalf("oidc/login = %d: %s", w.Code, w.Body)
+		}
+		cb, err := idp.Authorize(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("Authorize: %v", err)
+		}
+		cbURL, _ := url.Parse(cb)
+		if tamperState {
+			q := cbURL.Query()
+			q.Set("state", "forged")
+			cbURL.RawQuery = q.Encode()
+		}
+		req := httptest.NewRequest(http.MethodGet, cbURL.RequestURI(), nil)
+		for _, c := range w.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		return rw
+	}
+
+	w := signIn(map[string]any{"sub": "1", "email": "ann@example.com", "email_verified": true, "groups": []string{"staff", "sre"}}, false)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/admin/logs" {
+		t.Fatalf("callback = %d → %q", w.Code, w.Header().Get("Location"))
+	}
+	ann, err := st.GetAdminUserByName("ann@example.com")
+	if err != nil || ann.Role != store.RoleOperator {
+		t.Fatalf("provisioned user = %+v, %v; want operator", ann, err)
+	}
+
+	// Group changes at the provider apply on the next sign-in.
+	signIn(map[string]any{"sub": "1", "email": "ann@example.com", "email_verified": true, "groups": []string{"staff"}}, false)
+	if ann, _ = st.GetAdminUserByName("ann@example.com"); ann.Role != store.RoleViewer {
+		t.Fatalf("role after group change = %s, want viewer", ann.Role)
+	}
+
+	if w := signIn(map[string]any{"sub": "2", "email": "eve@example.com", "email_verified": true, "groups": []string{"sales"}}, false); w.Header().Get("Location") != "/admin/login?error=denied" {
+		t.Fatalf("unmapped groups → %q", w.Header().Get("Location"))
+	}
+	if _, err := st.GetAdminUserByName("eve@example.com"); err == nil {
+		t.Fatal("account created for a user without a role")
+	}
+	if w := signIn(map[string]any{"sub": "1", "email": "ann@example.com", "email_verified": true, "groups": []string{"sre"}}, true); w.Header().Get("Location") != "/admin/login?error=sso" {
+		t.Fatalf("forged state → %q", w.Header().Get("Location"))
+	}
+
+	// An email is only trusted as the account name once verified.
+	if w := signIn(map[string]any{"sub": "4", "email": "dan@example.com", "groups": []string{"sre"}}, false); w.Header().Get("Location") != "/admin/login?error=sso" {
+		t.Fatalf("unverified email → %q", w.Header().Get("Location"))
+	}
+
+	// Accounts are matched by subject: another identity claiming ann's
+	// email can't take her account over.
+	if w := signIn(map[string]any{"sub": "9", "email": "ann@example.com", "email_verified": true, "groups": []string{"sre"}}, false); w.Header().Get("Location") != "/admin/login?error=sso" {
+		t.Fatalf("other subject with a taken email → %q", w.Header().Get("Location"))
+	}
+
+	// A password account is only reachable once an owner links it.
+	bob, err := st.CreateAdminUser("bob@example.com", "correct horse battery", store.RoleOwner)
+	if err != nil {
+		t.Fatalf("CreateAdminUser: %v", err)
+	}
+	bobClaims := map[string]any{"sub": "3", "email": "bob@example.com", "email_verified": true, "groups": []string{"staff"}}
+	if w := signIn(bobClaims, false); w.Header().Get("Location") != "/admin/login?error=sso" {
+		t.Fatalf("unlinked password account → %q", w.Header().Get("Location"))
+	}
+	if u, _ := st.GetAdminUser(bob.ID); u.Role != store.RoleOwner {
+		t.Fatalf("refused sign-in changed the role to %s", u.Role)
+	}
+	if err := st.LinkAdminSSO(bob.ID, idp.Issuer(), "3"); err != nil {
+		t.Fatalf("LinkAdminSSO: %v", err)
+	}
+	if w := signIn(bobClaims, false); w.Header().Get("Location") != "/admin/logs" {
+		t.Fatalf("linked password account → %q", w.Header().Get("Location"))
+	}
+
+	// A passwordless account an owner added for SSO is linked on first use.
+	carol, _ := st.CreateAdminUser("carol@example.com", "", store.RoleViewer)
+	signIn(map[string]any{"sub": "5", "email": "carol@example.com", "email_verified": true, "groups": []string{"staff"}}, false)
+	if u, _ := st.GetAdminUserBySSO(idp.Issuer(), "5"); u == nil || u.ID != carol.ID {
+		t.Fatalf("passwordless account not linked: %+v", u)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/login", nil))
+	if !strings.Contains(w.Body.String(), "/admin/oidc/login") {
+		t.Fatal("login page has no SSO button")
+	}
+
+	// Unfinished sign-ins are capped, so unauthenticated requests can't grow
+	// the pending set without bound.
+	started := 0
+	for ; started <= ssoMaxPending; started++ {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/oidc/login", nil))
+		if w.Code != http.StatusFound {
+			break
+		}
+	}
+	if started > ssoMaxPending || w.Header().Get("Location") != "/admin/login?error=sso" {
+		t.Fatalf("after %d sign-ins oidc/login = %d → %q", started, w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestSSOPendingCap(t *testing.T) {
+	p := &ssoPending{items: map[string]ssoRequest{}}
+	for i := range ssoMaxPending {
+		if !p.put(strconv.Itoa(i), ssoRequest{expires: time.Now().Add(time.Minute)}) {
+			t.Fatalf("put %d refused", i)
+		}
+	}
+	if !p.full() || p.put("over", ssoRequest{expires: time.Now().Add(time.Minute)}) {
+		t.Fatal("pending sign-ins not capped")
+	}
+	// Expired sign-ins make room again.
+	for k, v := range p.items {
+		v.expires = time.Now().Add(-time.Second)
+		p.items[k] = v
+	}
+	if p.full() || !p.put("next", ssoRequest{expires: time.Now().Add(time.Minute)}) || len(p.items) != 1 {
+		t.Fatalf("expired sign-ins not pruned: %d pending", len(p.items))
+	}
+}
+
+func TestSSORole(t *testing.T) {
+	m := map[string]string{"a": store.RoleViewer, "b": store.RoleOwner, "c": store.RoleOperator}
+	if got := ssoRole([]string{"a", "c"}, m); got != store.RoleOperator {
+		t.Errorf("ssoRole(a,c) = %q, want operator", got)
+	}
+	if got := ssoRole([]string{"x"}, m); got != "" {
+		t.Errorf("ssoRole(x) = %q, want none", got)
+	}
+	m["*"] = store.RoleViewer
+	if got := ssoRole(nil, m); got != store.RoleViewer {
+		t.Errorf("ssoRole with wildcard = %q, want viewer", got)
+	}
+}
+
+func TestAdminCredentials(t *testing.T) {
+	h, st := newTestAdmin(t)
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "partner-ca"}, NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	certPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+
+	// Without SECRETS_KEY nothing can be stored.
+	if w := post("/admin/credentials", url.Values{"kind": {"ca_bundle"}, "name": {"partner-ca"}, "cert": {certPEM}}); w.Code != http.StatusBadRequest {
+		t.Fatalf("save without key: %d", w.Code)
+	}
+	if err := st.SetSecretsKey(make([]byte, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if w := post("/admin/credentials", url.Values{"kind": {"ca_bundle"}, "name": {"partner-ca"}, "cert": {certPEM}}); w.Code != http.StatusSeeOther {
+		t.Fatalf("save CA bundle: %d %s", w.Code, w.Body.String())
+	}
+	if w := post("/admin/credentials", url.Values{"kind": {"client_cert"}, "name": {"partner"}, "cert": {certPEM}, "key": {keyPEM}}); w.Code != http.StatusSeeOther {
+		t.Fatalf("save client cert: %d %s", w.Code, w.Body.String())
+	}
+	if w := post("/admin/credentials", url.Values{"kind": {"client_cert"}, "name": {"nokey"}, "cert": {certPEM}}); w.Code != http.StatusBadRequest {
+		t.Fatalf("client cert without key: %d", w.Code)
+	}
+
+	// The page describes them but never shows the PEM.
+	req := httptest.NewRequest(http.MethodGet, "/admin/credentials", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if body := w.Body.String(); !strings.Contains(body, "CN=partner-ca") || strings.Contains(body, "BEGIN") {
+		t.Fatalf("credentials page: %d", w.Code)
+	}
+	if page, err := st.QueryAudit(store.AuditFilter{Action: "credentials.save"}); err != nil || len(page.Entries) != 2 || strings.Contains(page.Entries[0].Detail, "BEGIN") {
+		t.Fatalf("save audit: %+v, %v", page, err)
+	}
+
+	// Requests get them decrypted, by name.
+	ih := NewImpersonateHandler(&config.Config{}, newTestRuntime(t, st), metrics.NewCollector(), st, &executortest.Fake{})
+	creds, err := ih.credentials(&models.ImpersonateRequest{CABundle: "partner-ca", ClientCert: "partner"})
+	if err != nil || creds.CAPEM != certPEM || creds.CertPEM != certPEM || creds.KeyPEM != keyPEM {
+		t.Fatalf("credentials = %+v, %v", creds, err)
+	}
+	if _, err := ih.credentials(&models.ImpersonateRequest{ClientCert: "partner-ca"}); err == nil || !strings.Contains(err.Error(), `unknown client_cert "partner-ca"`) {
+		t.Fatalf("unknown client_cert: %v", err)
+	}
+
+	if w := post("/admin/credentials/delete", url.Values{"kind": {"ca_bundle"}, "name": {"partner-ca"}}); w.Code != http.StatusSeeOther {
+		t.Fatalf("delete: %d", w.Code)
+	}
+	if w := post("/admin/credentials/delete", url.Values{"kind": {"ca_bundle"}, "name": {"partner-ca"}}); w.Code != http.StatusNotFound {
+		t.Fatalf("delete missing: %d", w.Code)
 	}
 }
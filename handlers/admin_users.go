@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/zupolgec/curl-impersonate-service/store"
+)
+
+// auditFilterForm mirrors the audit page filters for re-populating the form
+// and building pagination links.
+type auditFilterForm struct {
+	Since  string
+	Until  string
+	Actor  string
+	Action string
+	Query  url.Values
+}
+
+func (h *AdminHandler) auditLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	form := auditFilterForm{
+		Since:  q.Get("since"),
+		Until:  q.Get("until"),
+		Actor:  strings.TrimSpace(q.Get("actor")),
+		Action: strings.TrimSpace(q.Get("action")),
+		Query:  url.Values{},
+	}
+	f := store.AuditFilter{Actor: form.Actor, Action: form.Action, Cursor: q.Get("cursor"), Limit: 100}
+	var err error
+	if f.Since, err = parseFilterTime(form.Since); err != nil {
+		http.Error(w, "invalid since: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if f.Until, err = parseFilterTime(form.Until); err != nil {
+		http.Error(w, "invalid until: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, k := range []string{"since", "until", "actor", "action"} {
+		if v := q.Get(k); v != "" {
+			form.Query.Set(k, v)
+		}
+	}
+
+	page, err := h.store.QueryAudit(f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	actions, err := h.store.AuditActions()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var next string
+	if page.NextCursor != "" {
+		next = "/admin/audit?" + withParam(form.Query, "cursor", page.NextCursor)
+	}
+	h.render(w, r, "audit", map[string]any{
+		"Entries": page.Entries,
+		"Actions": actions,
+		"Filter":  form,
+		"Paged":   f.Cursor != "",
+		"Next":    next,
+	})
+}
+
+func (h *AdminHandler) account(w http.ResponseWriter, r *http.Request) {
+	h.render(w, r, "account", map[string]any{
+		"Saved":       r.URL.Query().Get("saved") == "1",
+		"MinPassword": store.MinPasswordLength,
+	})
+}
+
+// changePassword sets the signed-in admin's password after checking the
+// current one, then starts a fresh session since the change ends all others.
+func (h *AdminHandler) changePassword(w http.ResponseWriter, r *http.Request) {
+	user := adminSession(r.Context()).User
+	if err := h.store.VerifyAdminPassword(user.ID, r.FormValue("current")); err != nil {
+		http.Error(w, "current password is incorrect", http.StatusForbidden)
+		return
+	}
+	if err := h.store.SetAdminPassword(user.ID, r.FormValue("password")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.audit(r, "user.password", user.Username, "")
+	if err := h.startSession(w, r, &user); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin/account?saved=1", http.StatusSeeOther)
+}
+
+func (h *AdminHandler) users(w http.ResponseWriter, r *http.Request) {
+	users, err := h.store.ListAdminUsers()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.render(w, r, "users", map[string]any{
+		"Users":       users,
+		"SSO":         h.opts.SSO != nil,
+		"Roles":       store.Roles,
+		"MinPassword": store.MinPasswordLength,
+		"Notice":      h.flash.pop(w, r),
+	})
+}
+
+func (h *AdminHandler) createUser(w http.ResponseWriter, r *http.Request) {
+	u, err := h.store.CreateAdminUser(r.FormValue("username"), r.FormValue("password"), r.FormValue("role"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.audit(r, "user.create", u.Username, "role="+u.Role)
+	h.redirectUsers(w, r, fmt.Sprintf("Added %s (%s).", u.Username, u.Role))
+}
+
+func (h *AdminHandler) setUserRole(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.formUser(w, r)
+	if !ok {
+		return
+	}
+	role := r.FormValue("role")
+	if err := h.store.SetAdminRole(u.ID, role); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	h.audit(r, "user.role", u.Username, u.Role+" → "+role)
+	h.redirectUsers(w, r, fmt.Sprintf("%s is now %s.", u.Username, role))
+}
+
+func (h *AdminHandler) resetUserPassword(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.formUser(w, r)
+	if !ok {
+		return
+	}
+	if err := h.store.SetAdminPassword(u.ID, r.FormValue("password")); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	h.audit(r, "user.password", u.Username, "")
+	h.redirectUsers(w, r, fmt.Sprintf("Password set for %s; their sessions were signed out.", u.Username))
+}
+
+// linkUserSSO links an account to a subject at the configured identity
+// provider, which is how an account with a password is opened to single
+// sign-on. An empty subject unlinks it.
+func (h *AdminHandler) linkUserSSO(w http.ResponseWriter, r *http.Request) {
+	if h.opts.SSO == nil {
+		http.Error(w, "single sign-on is not configured", http.StatusBadRequest)
+		return
+	}
+	u, ok := h.formUser(w, r)
+	if !ok {
+		return
+	}
+	subject := strings.TrimSpace(r.FormValue("subject"))
+	if err := h.store.LinkAdminSSO(u.ID, h.opts.SSO.Client.Issuer(), subject); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	if subject == "" {
+		h.audit(r, "user.sso_unlink", u.Username, "")
+		h.redirectUsers(w, r, fmt.Sprintf("%s is no longer linked to single sign-on.", u.Username))
+		return
+	}
+	h.audit(r, "user.sso_link", u.Username, "sub="+subject)
+	h.redirectUsers(w, r, fmt.Sprintf("%s is linked to single sign-on subject %s.", u.Username, subject))
+}
+
+func (h *AdminHandler) deleteUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.formUser(w, r)
+	if !ok {
+		return
+	}
+	if err := h.store.DeleteAdminUser(u.ID); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	h.audit(r, "user.delete", u.Username, "")
+	h.redirectUsers(w, r, fmt.Sprintf("Deleted %s.", u.Username))
+}
+
+// formUser loads the account named by the form's id field.
+func (h *AdminHandler) formUser(w http.ResponseWriter, r *http.Request) (*store.AdminUser, bool) {
+	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	u, err := h.store.GetAdminUser(id)
+	if err != nil {
+		writeUserError(w, err)
+		return nil, false
+	}
+	return u, true
+}
+
+func writeUserError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "no such admin user", http.StatusNotFound)
+	case errors.Is(err, store.ErrLastOwner):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func (h *AdminHandler) redirectUsers(w http.ResponseWriter, r *http.Request, notice string) {
+	if err := h.flash.set(w, r, notice); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
@@ -10,6 +10,7 @@ func BrowsersHandler(w http.ResponseWriter, r *http.Request) {
 	response := models.BrowsersResponse{
 		Browsers: models.GetAllBrowsers(),
 		Aliases:  models.GetAliases(),
+		Pools:    models.GetPools(),
 		Default:  models.GetDefaultBrowser(),
 	}
 	models.WriteJSON(w, http.StatusOK, response)
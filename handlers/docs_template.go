@@ -48,6 +48,18 @@ either a bearer header or a query parameter:</p>
 <pre><code>Authorization: Bearer &lt;token&gt;
 # or
 ?token=&lt;token&gt;</code></pre>
+<p>Tokens may be limited to some endpoints (scopes <code>impersonate</code>,
+<code>browsers</code>, <code>metrics</code>, <code>docs</code>) and may expire.
+Out-of-scope calls get <code>403</code>; expired tokens get <code>401</code> with
+<code>authentication token has expired</code>.</p>
+<p>Instead of the token, a request can be signed with the token's signing key
+(issued in the admin UI) using four headers: <code>X-Signature-Key</code> (key ID),
+<code>X-Signature-Timestamp</code> (Unix seconds), <code>X-Signature-Nonce</code>
+(16–128 random characters, never reused) and <code>X-Signature</code>, the hex
+HMAC-SHA256 of:</p>
+<pre><code>v1\n&lt;METHOD&gt;\n&lt;path?query&gt;\n&lt;timestamp&gt;\n&lt;nonce&gt;\n&lt;hex SHA-256 of body&gt;</code></pre>
+<p>Stale timestamps and reused nonces get <code>401</code>. Some deployments or
+tokens refuse <code>?token=</code>, and some tokens accept signed requests only.</p>
 {{if .AdminEnabled}}<p class="muted">Tokens are managed from the <a href="/admin/">admin UI</a>.</p>{{end}}
 
 <h2>Endpoints</h2>
@@ -56,17 +68,17 @@ either a bearer header or a query parameter:</p>
 <p>Liveness check, no auth. Returns <code>{"status":"ok","version":"…"}</code>.</p>
 
 <h3><span class="method">GET</span> <code>/browsers</code></h3>
-<p>Lists available browser profiles and aliases.</p>
+<p>Lists available browser profiles and aliases. Custom profiles show the built-in browser they extend as <code>base</code>.</p>
 
 <h3><span class="method">GET</span> <code>/metrics</code></h3>
-<p>Service metrics: request counts, success/failure, average duration, per-browser usage.</p>
+<p>Service metrics: request counts, success/failure, average duration, per-browser usage and detected blocks per browser, host and vendor.</p>
 
 <h3><span class="method">POST</span> <code>/impersonate</code></h3>
 <p>Performs an HTTP request impersonating the chosen browser.</p>
 <table>
   <tr><th>Field</th><th>Type</th><th>Default</th><th>Description</th></tr>
   <tr><td><code>url</code></td><td>string</td><td>—</td><td>Target URL (required)</td></tr>
-  <tr><td><code>browser</code></td><td>string</td><td><code>{{.Default}}</code></td><td>Profile or alias</td></tr>
+  <tr><td><code>browser</code></td><td>string</td><td><code>{{.Default}}</code></td><td>Profile, alias, pool or selector (<code>random</code>, <code>random:chrome</code>, <code>random:mobile</code>, <code>os:macos</code>)</td></tr>
   <tr><td><code>method</code></td><td>string</td><td><code>GET</code></td><td>HTTP method</td></tr>
   <tr><td><code>headers</code></td><td>object</td><td>—</td><td>Custom request headers</td></tr>
   <tr><td><code>query_params</code></td><td>object</td><td>—</td><td>Query params merged into the URL</td></tr>
@@ -75,6 +87,14 @@ either a bearer header or a query parameter:</p>
   <tr><td><code>follow_redirects</code></td><td>bool</td><td><code>true</code></td><td>Follow redirects</td></tr>
   <tr><td><code>insecure</code></td><td>bool</td><td><code>false</code></td><td>Skip TLS verification</td></tr>
   <tr><td><code>timeout</code></td><td>int</td><td><code>30</code></td><td>Timeout (seconds)</td></tr>
+  <tr><td><code>session</code></td><td>string</td><td>—</td><td>Pick the same browser from a pool or selector for every request with this value</td></tr>
+  <tr><td><code>fallback_browsers</code></td><td>array</td><td>—</td><td>Up to 5 browsers tried in order while the target blocks the request; the response lists them in <code>attempts</code></td></tr>
+  <tr><td><code>block_on</code></td><td>object</td><td>403, 429, 503, <code>ssl</code>, <code>network</code></td><td>What counts as a block: <code>status_codes</code>, <code>error_types</code>, <code>headers</code> and <code>body_patterns</code> (regular expressions)</td></tr>
+  <tr><td><code>retry</code></td><td>object</td><td>—</td><td>Retry transient failures: <code>max_attempts</code> (3), <code>backoff_ms</code> (500), <code>max_backoff_ms</code> (10000), <code>on_error_types</code> (<code>timeout</code>, <code>network</code>, <code>dns</code>), <code>on_status_codes</code> (429, 502, 503, 504), <code>allow_non_idempotent</code>. Honours <code>Retry-After</code>; all tries share <code>timeout</code> and are listed in <code>attempts</code></td></tr>
+  <tr><td><code>include_cert_info</code></td><td>bool</td><td><code>false</code></td><td>Return the target's certificate chain in <code>certificates</code> (subject, issuer, SANs, validity, SHA-256 fingerprint), also when it fails verification</td></tr>
+  <tr><td><code>include_cert_pem</code></td><td>bool</td><td><code>false</code></td><td>Add each certificate's PEM; requires <code>include_cert_info</code></td></tr>
+  <tr><td><code>ca_bundle</code></td><td>string</td><td>—</td><td>Stored CA bundle to verify the target with instead of the system CAs</td></tr>
+  <tr><td><code>client_cert</code></td><td>string</td><td>—</td><td>Stored client certificate to present to the target (mutual TLS)</td></tr>
 </table>
 
 <h3>Example</h3>
@@ -84,15 +104,25 @@ either a bearer header or a query parameter:</p>
   -d '{"browser":"{{.Default}}","url":"https://example.com"}'</code></pre>
 
 <p>Success responses always return <code>200</code> with a JSON envelope
-(<code>success</code>, <code>status_code</code>, <code>headers</code>,
-<code>body</code>, <code>timing</code>, …). Network problems return
+(<code>success</code>, <code>browser</code> (the browser used), <code>status_code</code>, <code>headers</code>,
+<code>body</code>, <code>timing</code>, <code>connection</code>, …).
+<code>timing</code> splits the request into queue, DNS, connect, TLS
+(<code>appconnect</code>), <code>pretransfer</code>, first byte and redirect
+times, in seconds; <code>connection</code> has the remote and local
+address, HTTP and TLS versions, cipher, ALPN, connection reuse and bytes
+sent and received. Network problems return
 <code>success:false</code> with an <code>error_type</code> of
 <code>network</code>, <code>dns</code>, <code>timeout</code>, <code>ssl</code> or
-<code>size</code>.</p>
+<code>size</code>, an <code>error_code</code> with the cause
+(<code>connect_refused</code>, <code>tls_handshake</code>, <code>cert_invalid</code>, …)
+and curl's <code>curl_code</code>. Bot challenges and block pages add a <code>block</code>
+field with the <code>vendor</code> and <code>kind</code>
+(<code>captcha</code>, <code>js-challenge</code>, <code>rate-limit</code> or
+<code>ban</code>).</p>
 
 {{if .AdminEnabled}}
 <h3><span class="method">GET</span> <code>/admin/</code></h3>
-<p>Admin dashboard (separate Basic-auth login): manage tokens, CORS and usage logs.</p>
+<p>Admin dashboard (separate account sign-in): manage tokens, runtime settings and usage logs.</p>
 {{end}}
 
 <h2>Browsers</h2>
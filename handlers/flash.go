@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	flashCookie = "admin_flash"
+	flashTTL    = 5 * time.Minute
+)
+
+// flashes carries one-time messages (such as a freshly created token) across
+// the post/redirect/get cycle. The message stays server-side; the browser
+// only holds a random id in a short-lived cookie, so secrets never appear in
+// URLs, access logs or history.
+type flashes struct {
+	mu    sync.Mutex
+	items map[string]flashItem
+}
+
+type flashItem struct {
+	msg     string
+	expires time.Time
+}
+
+func newFlashes() *flashes {
+	return &flashes{items: make(map[string]flashItem)}
+}
+
+// set stores msg and points the browser at it.
+func (f *flashes) set(w http.ResponseWriter, r *http.Request, msg string) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	id := hex.EncodeToString(b)
+	now := time.Now()
+
+	f.mu.Lock()
+	for k, it := range f.items {
+		if now.After(it.expires) {
+			delete(f.items, k)
+		}
+	}
+	f.items[id] = flashItem{msg: msg, expires: now.Add(flashTTL)}
+	f.mu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookie,
+		Value:    id,
+		Path:     "/admin/",
+		MaxAge:   int(flashTTL / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return nil
+}
+
+// pop returns the pending message, if any, and forgets it.
+func (f *flashes) pop(w http.ResponseWriter, r *http.Request) string {
+	c, err := r.Cookie(flashCookie)
+	if err != nil {
+		return ""
+	}
+	http.SetCookie(w, &http.Cookie{Name: flashCookie, Path: "/admin/", MaxAge: -1, HttpOnly: true})
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	it, ok := f.items[c.Value]
+	delete(f.items, c.Value)
+	if !ok || time.Now().After(it.expires) {
+		return ""
+	}
+	return it.msg
+}
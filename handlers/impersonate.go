@@ -1,48 +1,84 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
+	"sync/atomic"
 	"time"
 
+	"github.com/zupolgec/curl-impersonate-service/capture"
 	"github.com/zupolgec/curl-impersonate-service/config"
+	"github.com/zupolgec/curl-impersonate-service/detect"
 	"github.com/zupolgec/curl-impersonate-service/executor"
 	"github.com/zupolgec/curl-impersonate-service/metrics"
 	"github.com/zupolgec/curl-impersonate-service/middleware"
 	"github.com/zupolgec/curl-impersonate-service/models"
 	"github.com/zupolgec/curl-impersonate-service/security"
+	"github.com/zupolgec/curl-impersonate-service/settings"
 	"github.com/zupolgec/curl-impersonate-service/store"
 )
 
 type ImpersonateHandler struct {
-	cfg       *config.Config
+	settings  *settings.Runtime
 	collector *metrics.Collector
-	guard     *security.Guard
+	guard     atomic.Pointer[security.Guard]
 	store     *store.Store
+	capture   *capture.Recorder
+	executor  executor.Executor
 }
 
-func NewImpersonateHandler(cfg *config.Config, collector *metrics.Collector, st *store.Store) *ImpersonateHandler {
-	return &ImpersonateHandler{
-		cfg:       cfg,
+// NewImpersonateHandler builds the handler, which runs requests with exec.
+// Limits, the default browser and the SSRF guard follow rt, so changes made
+// in the admin UI apply to the next request.
+func NewImpersonateHandler(cfg *config.Config, rt *settings.Runtime, collector *metrics.Collector, st *store.Store, exec executor.Executor) *ImpersonateHandler {
+	h := &ImpersonateHandler{
+		settings:  rt,
 		collector: collector,
-		guard: security.NewGuard(security.Config{
-			AllowPrivate:    cfg.SSRFAllowPrivate,
-			AllowHTTP:       cfg.SSRFAllowHTTP,
-			AllowIPLiterals: cfg.SSRFAllowIP,
-			DenyHosts:       cfg.SSRFDenyHosts,
-			AllowHosts:      cfg.SSRFAllowHosts,
-		}),
 		store:     st,
+		executor:  exec,
+		capture: capture.NewRecorder(st, capture.NewRedactor(capture.Config{
+			MaxBodyBytes:  cfg.CaptureMaxBodyBytes,
+			RedactHeaders: cfg.CaptureRedactHeaders,
+			RedactFields:  cfg.CaptureRedactFields,
+		}), time.Duration(cfg.CaptureRetentionHours)*time.Hour, cfg.CaptureMaxPerSession),
 	}
+	h.rebuildGuard()
+	rt.SSRFAllowPrivate.OnChange(func(bool) { h.rebuildGuard() })
+	rt.SSRFAllowHTTP.OnChange(func(bool) { h.rebuildGuard() })
+	rt.SSRFAllowIP.OnChange(func(bool) { h.rebuildGuard() })
+	rt.SSRFDenyHosts.OnChange(func([]string) { h.rebuildGuard() })
+	rt.SSRFAllowHosts.OnChange(func([]string) { h.rebuildGuard() })
+	return h
+}
+
+// rebuildGuard replaces the SSRF guard with one built from the current
+// settings. Requests in flight keep the guard they started with.
+func (h *ImpersonateHandler) rebuildGuard() {
+	rt := h.settings
+	h.guard.Store(security.NewGuard(security.Config{
+		AllowPrivate:    rt.SSRFAllowPrivate.Get(),
+		AllowHTTP:       rt.SSRFAllowHTTP.Get(),
+		AllowIPLiterals: rt.SSRFAllowIP.Get(),
+		DenyHosts:       rt.SSRFDenyHosts.Get(),
+		AllowHosts:      rt.SSRFAllowHosts.Get(),
+	}))
 }
 
 func (h *ImpersonateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
+	// The server's write timeout is fixed at startup; make room for the
+	// current max_timeout in case it was raised since.
+	maxTimeout := time.Duration(h.settings.MaxTimeout.Get()+10) * time.Second
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(maxTimeout))
 
 	// Read and parse request body
-	bodyBytes, err := io.ReadAll(io.LimitReader(r.Body, h.cfg.MaxRequestBodySize))
+	bodyBytes, err := io.ReadAll(io.LimitReader(r.Body, h.settings.MaxRequestBodySize.Get()))
 	if err != nil {
 		models.WriteJSONError(w, http.StatusBadRequest, "validation", "failed to read request body")
 		return
@@ -55,64 +91,252 @@ func (h *ImpersonateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate request
-	if err := req.Validate(h.cfg.MaxTimeout); err != nil {
-		models.WriteJSONError(w, http.StatusBadRequest, "validation", err.Error())
+	response, _, err := h.execute(r.Context(), middleware.TokenName(r.Context()), &req, false)
+	if r.Context().Err() != nil {
+		return // the client has gone
+	}
+	if err != nil {
+		writeRequestError(w, err)
 		return
 	}
 
-	// SSRF protection: block internal/metadata destinations.
-	if err := h.guard.ValidateURL(req.URL); err != nil {
-		models.WriteJSONError(w, http.StatusBadRequest, "validation", err.Error())
+	// Return response (always 200, even for network errors)
+	models.WriteJSON(w, http.StatusOK, response)
+}
+
+// Replay runs req on behalf of tokenName exactly like a normal API call (same
+// validation, SSRF guard and executor) but marks it as a replay in the usage
+// log and never captures it. It returns the browser actually used.
+func (h *ImpersonateHandler) Replay(ctx context.Context, tokenName string, req *models.ImpersonateRequest) (*models.ImpersonateResponse, string, error) {
+	return h.execute(ctx, tokenName, req, true)
+}
+
+// requestError is a failure to run a request, carrying the HTTP status and
+// error_type to report to the client.
+type requestError struct {
+	status    int
+	errorType string
+	msg       string
+}
+
+func (e *requestError) Error() string { return e.msg }
+
+func validationError(msg string) error {
+	return &requestError{status: http.StatusBadRequest, errorType: "validation", msg: msg}
+}
+
+func writeRequestError(w http.ResponseWriter, err error) {
+	if re, ok := err.(*requestError); ok {
+		models.WriteJSONError(w, re.status, re.errorType, re.msg)
 		return
 	}
+	models.WriteJSONError(w, http.StatusInternalServerError, "internal", err.Error())
+}
+
+// execute validates req, runs it, retrying transient failures and falling
+// back to other browsers while the target blocks it, classifies block pages
+// and records metrics, usage and (for normal calls) debug captures. Once ctx
+// is done no further attempts are made.
+func (h *ImpersonateHandler) execute(ctx context.Context, tokenName string, req *models.ImpersonateRequest, replay bool) (*models.ImpersonateResponse, string, error) {
+	start := time.Now()
+
+	// Validate request
+	req.Timeout = h.settings.Timeout(req.Timeout)
+	if err := req.Validate(h.settings.MaxTimeout.Get()); err != nil {
+		return nil, "", validationError(err.Error())
+	}
 
-	// Resolve browser name
-	browserName := models.ResolveBrowserName(req.Browser)
+	// SSRF protection: block internal/metadata destinations.
+	guard := h.guard.Load()
+	if err := guard.ValidateURL(req.URL); err != nil {
+		return nil, "", validationError(err.Error())
+	}
+	if req.Proxy != "" {
+		if err := guard.ValidateProxy(req.Proxy); err != nil {
+			return nil, "", validationError(err.Error())
+		}
+	}
 
-	// Get browser config
-	browserConfig, err := models.GetBrowserConfig(browserName)
+	if req.CABundle != "" || req.ClientCert != "" {
+		creds, err := h.credentials(req)
+		if err != nil {
+			return nil, "", err
+		}
+		req.Credentials = creds
+	}
+
+	// Resolve the browser and any fallbacks: aliases, pools and selectors
+	// become one browser each.
+	names := append([]string{req.Browser}, req.FallbackBrowsers...)
+	configs := make([]models.BrowserConfig, 0, len(names))
+	for _, name := range names {
+		browserConfig, err := models.GetBrowserConfig(models.ResolveBrowserName(name, req.Session))
+		if err != nil {
+			return nil, "", validationError(err.Error())
+		}
+		configs = append(configs, browserConfig)
+	}
+	blocked, err := req.BlockConditions().Compile()
 	if err != nil {
-		models.WriteJSONError(w, http.StatusBadRequest, "validation", err.Error())
-		return
+		return nil, "", validationError(err.Error())
+	}
+
+	// Try each browser in turn while the target blocks us, retrying
+	// transient failures on the same browser first. Together the attempts
+	// stay within the request timeout, and each one is recorded in the
+	// metrics and usage log under its own browser.
+	host := targetHost(req.URL)
+	deadline := start.Add(time.Duration(req.Timeout) * time.Second)
+	retrier := req.Retrier()
+	var response *models.ImpersonateResponse
+	var attempts []models.Attempt
+browsers:
+	for _, browserConfig := range configs {
+		var wait time.Duration
+		for try := 1; ; try++ {
+			if wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return nil, browserConfig.Name, ctx.Err()
+				}
+			}
+			resp, duration, err := h.attempt(tokenName, req, host, browserConfig, deadline, replay)
+			if err != nil {
+				return nil, browserConfig.Name, err
+			}
+			response = resp
+			attempt := models.Attempt{
+				Browser:    browserConfig.Name,
+				StatusCode: resp.StatusCode,
+				Error:      resp.Error,
+				ErrorType:  resp.ErrorType,
+				DurationMs: duration.Milliseconds(),
+				WaitMs:     wait.Milliseconds(),
+			}
+
+			// A retry must leave a second for itself after the wait.
+			var retry bool
+			if wait, retry = retrier.Retry(try, resp); retry && time.Until(deadline)-wait >= time.Second {
+				attempts = append(attempts, attempt)
+				continue
+			}
+
+			attempt.Blocked = blocked.Match(resp)
+			if attempt.Blocked == "" && resp.Block != nil {
+				attempt.Blocked = "detected " + resp.Block.Vendor + " " + resp.Block.Kind
+			}
+			attempts = append(attempts, attempt)
+			if attempt.Blocked == "" || time.Until(deadline) < time.Second {
+				break browsers
+			}
+			break
+		}
 	}
+	if len(req.FallbackBrowsers) > 0 || req.Retry != nil {
+		response.Attempts = attempts
+	}
+
+	if !replay {
+		h.capture.Record(tokenName, response.Browser, req, response, time.Since(start))
+	}
+	return response, response.Browser, nil
+}
 
-	// Execute curl-impersonate
-	response, err := executor.Execute(&req, browserConfig, h.cfg.MaxResponseBodySize)
+// credentials decrypts the TLS credentials req names for the executor.
+func (h *ImpersonateHandler) credentials(req *models.ImpersonateRequest) (*models.TLSCredentials, error) {
+	if h.store == nil || !h.store.HasSecretsKey() {
+		return nil, validationError("ca_bundle and client_cert are not available: SECRETS_KEY is not set")
+	}
+	get := func(kind, name string) (store.Credential, error) {
+		c, err := h.store.GetCredential(kind, name)
+		if errors.Is(err, sql.ErrNoRows) {
+			return c, validationError(fmt.Sprintf("unknown %s %q", kind, name))
+		}
+		if err != nil {
+			return c, &requestError{status: http.StatusInternalServerError, errorType: "internal", msg: err.Error()}
+		}
+		return c, nil
+	}
+	creds := &models.TLSCredentials{}
+	if req.CABundle != "" {
+		c, err := get(store.CredentialCABundle, req.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		creds.CAPEM = c.CertPEM
+	}
+	if req.ClientCert != "" {
+		c, err := get(store.CredentialClientCert, req.ClientCert)
+		if err != nil {
+			return nil, err
+		}
+		creds.CertPEM, creds.KeyPEM = c.CertPEM, c.KeyPEM
+	}
+	return creds, nil
+}
+
+// attempt makes one try of req with browserConfig, ending by deadline, and
+// records it in the metrics and usage log. The error is an internal one; a
+// failed request is reported in the response.
+func (h *ImpersonateHandler) attempt(tokenName string, req *models.ImpersonateRequest, host string, browserConfig models.BrowserConfig, deadline time.Time, replay bool) (*models.ImpersonateResponse, time.Duration, error) {
+	start := time.Now()
+	try := *req
+	try.Timeout = int(math.Ceil(time.Until(deadline).Seconds()))
+	resp, err := h.executor.Execute(&try, browserConfig, h.settings.MaxResponseBodySize.Get())
 	if err != nil {
 		// Internal service error
-		h.collector.RecordRequest(browserName, false, time.Since(start))
-		models.WriteJSONError(w, http.StatusInternalServerError, "internal", "failed to execute request: "+err.Error())
-		return
+		h.collector.RecordRequest(browserConfig.Name, false, time.Since(start))
+		return nil, 0, &requestError{status: http.StatusInternalServerError, errorType: "internal", msg: "failed to execute request: " + err.Error()}
 	}
+	resp.Browser = browserConfig.Name
+	resp.Block = detect.Detect(resp)
 
 	// Record metrics
 	duration := time.Since(start)
-	h.collector.RecordRequest(browserName, response.Success, duration)
-	h.recordUsage(r, &req, browserName, response, duration)
-
-	// Return response (always 200, even for network errors)
-	models.WriteJSON(w, http.StatusOK, response)
+	h.collector.RecordRequest(browserConfig.Name, resp.Success, duration)
+	if resp.Success && resp.Timing != nil {
+		h.collector.RecordTiming(*resp.Timing)
+	}
+	if resp.Block != nil {
+		h.collector.RecordBlock(host, browserConfig.Name, resp.Block.Vendor)
+	}
+	h.recordUsage(tokenName, req, host, browserConfig.Name, resp, duration, replay)
+	return resp, duration, nil
 }
 
 // recordUsage persists a usage-log entry. Only the target host is stored, never
-// the full URL, body or headers.
-func (h *ImpersonateHandler) recordUsage(r *http.Request, req *models.ImpersonateRequest, browser string, resp *models.ImpersonateResponse, d time.Duration) {
+// the full URL, body or headers; those are kept only while a debug capture is
+// switched on for the token (see capture.Recorder).
+func (h *ImpersonateHandler) recordUsage(tokenName string, req *models.ImpersonateRequest, host, browser string, resp *models.ImpersonateResponse, d time.Duration, replay bool) {
 	if h.store == nil {
 		return
 	}
-	host := ""
-	if u, err := url.Parse(req.URL); err == nil {
-		host = u.Hostname()
+	var vendor, kind string
+	if resp.Block != nil {
+		vendor, kind = resp.Block.Vendor, resp.Block.Kind
 	}
 	_ = h.store.AddLog(store.LogEntry{
-		TokenName:  middleware.TokenName(r.Context()),
-		Browser:    browser,
-		Method:     req.Method,
-		TargetHost: host,
-		StatusCode: resp.StatusCode,
-		Success:    resp.Success,
-		DurationMs: d.Milliseconds(),
-		ErrorType:  resp.ErrorType,
+		TokenName:   tokenName,
+		Browser:     browser,
+		Method:      req.Method,
+		TargetHost:  host,
+		StatusCode:  resp.StatusCode,
+		Success:     resp.Success,
+		DurationMs:  d.Milliseconds(),
+		ErrorType:   resp.ErrorType,
+		Replay:      replay,
+		BlockVendor: vendor,
+		BlockKind:   kind,
 	})
 }
+
+// targetHost returns the host name rawURL points at.
+func targetHost(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		return u.Hostname()
+	}
+	return ""
+}
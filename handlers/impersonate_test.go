@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zupolgec/curl-impersonate-service/config"
+	"github.com/zupolgec/curl-impersonate-service/executor/executortest"
+	"github.com/zupolgec/curl-impersonate-service/metrics"
+	"github.com/zupolgec/curl-impersonate-service/models"
+	"github.com/zupolgec/curl-impersonate-service/settings"
+	"github.com/zupolgec/curl-impersonate-service/store"
+)
+
+// newTestImpersonate returns an impersonate handler that runs requests with
+// fake. Unless strict is set, the SSRF guard lets them reach loopback
+// targets such as an executortest.Upstream.
+func newTestImpersonate(t *testing.T, fake *executortest.Fake, strict bool) (*ImpersonateHandler, *store.Store, *metrics.Collector) {
+	t.Helper()
+	if err := models.LoadBrowsers("../browsers.json", fake.SupportsTarget); err != nil {
+		t.Fatalf("LoadBrowsers: %v", err)
+	}
+	st, err := store.Open(filepath.Join(t.TempDir(), "impersonate.db"))
+	if err != nil {
+		t.Fatalf("store.Open: %v", err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+	cfg := &config.Config{
+		MaxTimeout: 120, DefaultTimeout: 30,
+		MaxRequestBodySize: 10 << 20, MaxResponseBodySize: 1 << 20,
+		SSRFAllowPrivate: !strict, SSRFAllowHTTP: !strict, SSRFAllowIP: !strict,
+	}
+	rt := settings.NewRuntime(cfg, st)
+	rt.Load()
+	collector := metrics.NewCollector()
+	return NewImpersonateHandler(cfg, rt, collector, st, fake), st, collector
+}
+
+// impersonate posts body to h and decodes the response.
+func impersonate(t *testing.T, h http.Handler, body string) (int, models.ImpersonateResponse) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/impersonate", strings.NewReader(body)))
+	var resp models.ImpersonateResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode %q: %v", w.Body.String(), err)
+	}
+	return w.Code, resp
+}
+
+func TestImpersonateForwardsToUpstream(t *testing.T) {
+	up := executortest.NewUpstream()
+	defer up.Close()
+	fake := &executortest.Fake{}
+	h, st, collector := newTestImpersonate(t, fake, false)
+
+	code, resp := impersonate(t, h, `{"browser":"firefox-latest","url":"`+up.URL+`/anything","method":"POST",
+		"headers":{"X-Test":"yes"},"query_params":{"q":"1"},"body":"hello","proxy":"http://10.0.0.1:3128"}`)
+	if code != http.StatusOK || !resp.Success || resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %+v", code, resp)
+	}
+	if !strings.HasPrefix(resp.Browser, "firefox") {
+		t.Errorf("browser = %q, want a firefox", resp.Browser)
+	}
+	var echo executortest.Echo
+	if err := json.Unmarshal([]byte(resp.Body), &echo); err != nil {
+		t.Fatalf("decode echo %q: %v", resp.Body, err)
+	}
+	if echo.Method != "POST" || echo.Body != "hello" || echo.Args["q"][0] != "1" || echo.Headers["X-Test"][0] != "yes" {
+		t.Errorf("upstream saw %+v", echo)
+	}
+
+	calls := fake.Calls()
+	// The proxy is only for admin replays; API callers can't set it.
+	if len(calls) != 1 || calls[0].Browser.Name != resp.Browser || calls[0].MaxResponseSize != 1<<20 || calls[0].Request.Proxy != "" {
+		t.Fatalf("calls = %+v", calls)
+	}
+	if _, total, success, _, _, _ := collector.GetMetrics(); total != 1 || success != 1 {
+		t.Errorf("metrics total=%d success=%d, want 1 and 1", total, success)
+	}
+	if logs, err := st.ListLogs(10); err != nil || len(logs) != 1 || logs[0].TargetHost != "127.0.0.1" {
+		t.Errorf("usage log = %+v, %v", logs, err)
+	}
+}
+
+func TestImpersonateSSRFStopsBeforeExecutor(t *testing.T) {
+	up := executortest.NewUpstream()
+	defer up.Close()
+	fake := &executortest.Fake{}
+	h, _, _ := newTestImpersonate(t, fake, true)
+
+	code, resp := impersonate(t, h, `{"url":"`+up.URL+`/anything"}`)
+	if code != http.StatusBadRequest || resp.ErrorType != "validation" {
+		t.Fatalf("got %d %+v, want a validation error", code, resp)
+	}
+	if len(fake.Calls()) != 0 || up.Hits("/anything") != 0 {
+		t.Fatalf("blocked request reached the executor: %d calls", len(fake.Calls()))
+	}
+}
+
+func TestImpersonateRetriesTransientFailures(t *testing.T) {
+	up := executortest.NewUpstream()
+	defer up.Close()
+	fake := &executortest.Fake{}
+	h, _, _ := newTestImpersonate(t, fake, false)
+
+	code, resp := impersonate(t, h, `{"url":"`+up.URL+`/flaky/2","retry":{"backoff_ms":1,"max_backoff_ms":1}}`)
+	if code != http.StatusOK || resp.StatusCode != http.StatusOK || resp.Body != "ok" {
+		t.Fatalf("got %d %+v", code, resp)
+	}
+	if len(resp.Attempts) != 3 || resp.Attempts[0].StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("attempts = %+v", resp.Attempts)
+	}
+	if n := up.Hits("/flaky/2"); n != 3 {
+		t.Errorf("upstream hits = %d, want 3", n)
+	}
+
+	// Failures the executor reports, such as refused connections, are
+	// retried like bad statuses.
+	fake.Respond = executortest.Responses(
+		executortest.Failure(models.ErrorCodeConnectRefused, "Connection refused"),
+		executortest.Status(http.StatusOK, "ok"),
+	)
+	_, resp = impersonate(t, h, `{"url":"http://127.0.0.1:1/","retry":{"backoff_ms":1,"max_backoff_ms":1}}`)
+	if !resp.Success || len(resp.Attempts) != 2 || resp.Attempts[0].ErrorType != models.ErrorTypeNetwork {
+		t.Fatalf("got %+v", resp)
+	}
+}
+
+func TestImpersonateStopsRetryingWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fake := &executortest.Fake{}
+	failing := executortest.Responses(executortest.Status(http.StatusServiceUnavailable, "busy"))
+	fake.Respond = func(c executortest.Call) (*models.ImpersonateResponse, error) {
+		cancel() // the client goes away during the first attempt
+		return failing(c)
+	}
+	h, _, _ := newTestImpersonate(t, fake, false)
+
+	body := `{"url":"http://203.0.113.7/","retry":{"max_attempts":5,"backoff_ms":5000,"max_backoff_ms":5000}}`
+	req := httptest.NewRequest(http.MethodPost, "/impersonate", strings.NewReader(body)).WithContext(ctx)
+	w := httptest.NewRecorder()
+	start := time.Now()
+	h.ServeHTTP(w, req)
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("cancelled request took %v", d)
+	}
+	if n := len(fake.Calls()); n != 1 {
+		t.Fatalf("executor calls = %d, want 1", n)
+	}
+	if w.Body.Len() != 0 {
+		t.Fatalf("wrote %q to a cancelled request", w.Body.String())
+	}
+}
+
+func TestImpersonateFallsBackWhenBlocked(t *testing.T) {
+	up := executortest.NewUpstream()
+	defer up.Close()
+	fake := &executortest.Fake{}
+	h, _, _ := newTestImpersonate(t, fake, false)
+
+	// The upstream blocks every browser: each fallback is tried once.
+	_, resp := impersonate(t, h, `{"browser":"chrome-latest","url":"`+up.URL+`/challenge",
+		"fallback_browsers":["firefox-latest","safari-latest"]}`)
+	if len(resp.Attempts) != 3 || resp.Attempts[2].Blocked == "" || up.Hits("/challenge") != 3 {
+		t.Fatalf("attempts = %+v", resp.Attempts)
+	}
+
+	// Only Chrome is blocked: Firefox's response is returned.
+	fake.Respond = func(c executortest.Call) (*models.ImpersonateResponse, error) {
+		if strings.HasPrefix(c.Browser.Name, "chrome") {
+			return executortest.Status(http.StatusForbidden, "blocked"), nil
+		}
+		return executortest.Status(http.StatusOK, "welcome"), nil
+	}
+	before := len(fake.Calls())
+	_, resp = impersonate(t, h, `{"browser":"chrome-latest","url":"`+up.URL+`/","fallback_browsers":["firefox-latest","safari-latest"]}`)
+	if resp.Body != "welcome" || !strings.HasPrefix(resp.Browser, "firefox") || len(resp.Attempts) != 2 {
+		t.Fatalf("got %+v", resp)
+	}
+	if got := fake.Browsers()[before:]; !slices.Equal(got, []string{resp.Attempts[0].Browser, resp.Browser}) {
+		t.Errorf("executor browsers = %v", got)
+	}
+}
+
+func TestImpersonateExecutorFailures(t *testing.T) {
+	up := executortest.NewUpstream()
+	defer up.Close()
+	fake := &executortest.Fake{}
+	h, _, collector := newTestImpersonate(t, fake, false)
+
+	// Transfer failures are reported in a 200 response.
+	_, resp := impersonate(t, h, `{"url":"`+up.URL+`/delay/5s","timeout":1}`)
+	if resp.Success || resp.ErrorType != models.ErrorTypeTimeout {
+		t.Fatalf("slow upstream: got %+v, want a timeout", resp)
+	}
+	_, resp = impersonate(t, h, `{"url":"`+up.URL+`/bytes/2000000"}`)
+	if resp.Success || resp.ErrorType != models.ErrorTypeSize {
+		t.Fatalf("large body: got %+v, want a size error", resp)
+	}
+
+	// An executor that cannot run the request is an internal error.
+	fake.Respond = func(executortest.Call) (*models.ImpersonateResponse, error) {
+		return nil, errors.New("curl is missing")
+	}
+	code, resp := impersonate(t, h, `{"url":"`+up.URL+`/"}`)
+	if code != http.StatusInternalServerError || resp.ErrorType != "internal" || !strings.Contains(resp.Error, "curl is missing") {
+		t.Fatalf("got %d %+v", code, resp)
+	}
+	if _, total, _, failed, _, _ := collector.GetMetrics(); total != 3 || failed != 3 {
+		t.Errorf("metrics total=%d failed=%d, want 3 and 3", total, failed)
+	}
+}
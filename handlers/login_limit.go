@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Failed password sign-ins allowed per window, from one address and for one
+// username from one address. Checking a password is deliberately slow, so
+// these also bound the CPU an attacker can spend on it.
+const (
+	loginWindow     = 15 * time.Minute
+	loginMaxPerIP   = 20
+	loginMaxPerUser = 5
+	// loginMaxTracked is the number of counters kept before expired ones
+	// are pruned.
+	loginMaxTracked = 10000
+)
+
+// loginLimiter counts failed sign-ins per client address and per username
+// and address in fixed windows. Once either count reaches its limit, sign-ins
+// are refused without checking the password until the window ends. Usernames
+// are only counted per address, so failures elsewhere can't lock an account
+// (or the bootstrap ADMIN_TOKEN) out.
+type loginLimiter struct {
+	perIP, perUser int
+	window         time.Duration
+
+	mu    sync.Mutex
+	items map[string]loginFailures
+}
+
+type loginFailures struct {
+	count int
+	reset time.Time
+}
+
+func newLoginLimiter() *loginLimiter {
+	return &loginLimiter{perIP: loginMaxPerIP, perUser: loginMaxPerUser, window: loginWindow, items: map[string]loginFailures{}}
+}
+
+func loginUserKey(ip, username string) string {
+	return "user:" + ip + "/" + strings.ToLower(username)
+}
+
+// allow reports whether a sign-in from ip for username may be tried.
+func (l *loginLimiter) allow(ip, username string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	return l.count("ip:"+ip, now) < l.perIP && l.count(loginUserKey(ip, username), now) < l.perUser
+}
+
+func (l *loginLimiter) count(key string, now time.Time) int {
+	f, ok := l.items[key]
+	if !ok || now.After(f.reset) {
+		return 0
+	}
+	return f.count
+}
+
+// fail records a failed sign-in from ip for username.
+func (l *loginLimiter) fail(ip, username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if len(l.items) >= loginMaxTracked {
+		for k, f := range l.items {
+			if now.After(f.reset) {
+				delete(l.items, k)
+			}
+		}
+	}
+	for _, key := range []string{"ip:" + ip, loginUserKey(ip, username)} {
+		f := l.items[key]
+		if now.After(f.reset) {
+			f = loginFailures{reset: now.Add(l.window)}
+		}
+		f.count++
+		l.items[key] = f
+	}
+}
+
+// succeed clears the failures recorded for username from ip.
+func (l *loginLimiter) succeed(ip, username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.items, loginUserKey(ip, username))
+}
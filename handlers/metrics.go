@@ -16,16 +16,54 @@ func NewMetricsHandler(collector *metrics.Collector) *MetricsHandler {
 }
 
 func (h *MetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	uptime, total, success, failed, avgDuration, browsers := h.collector.GetMetrics()
+	models.WriteJSON(w, http.StatusOK, metricsResponse(h.collector))
+}
+
+// metricsResponse reports the collector's since-start and all-time counters.
+func metricsResponse(collector *metrics.Collector) models.MetricsResponse {
+	uptime, total, success, failed, avgDuration, browsers := collector.GetMetrics()
 
-	response := models.MetricsResponse{
+	all := collector.AllTime()
+
+	return models.MetricsResponse{
 		UptimeSeconds:     uptime,
+		StartedAt:         collector.StartTime().UTC(),
 		RequestsTotal:     total,
 		RequestsSuccess:   success,
 		RequestsFailed:    failed,
 		AverageDurationMs: avgDuration,
 		BrowsersUsed:      browsers,
+		Blocks:            blockMetrics(collector.GetBlocks()),
+		Latency:           latencyMetrics(collector.GetLatency()),
+		AllTime: &models.AllTimeMetrics{
+			Since:             all.Since.UTC(),
+			RequestsTotal:     all.RequestsTotal,
+			RequestsSuccess:   all.RequestsSuccess,
+			RequestsFailed:    all.RequestsFailed,
+			AverageDurationMs: all.AverageDurationMs(),
+			BrowsersUsed:      all.BrowsersUsed,
+			Blocks:            blockMetrics(all.Blocks),
+			Latency:           latencyMetrics(all.Latency),
+		},
 	}
+}
 
-	models.WriteJSON(w, http.StatusOK, response)
+func blockMetrics(b metrics.Blocks) *models.BlockMetrics {
+	return &models.BlockMetrics{Total: b.Total, ByBrowser: b.ByBrowser, ByHost: b.ByHost, ByVendor: b.ByVendor}
+}
+
+func latencyMetrics(l metrics.Latency) *models.LatencyMetrics {
+	avg := l.Average()
+	ms := func(secs float64) float64 { return secs * 1000 }
+	return &models.LatencyMetrics{
+		Samples:         l.Samples,
+		QueueMs:         ms(avg.Queue),
+		NameLookupMs:    ms(avg.NameLookup),
+		ConnectMs:       ms(avg.Connect),
+		AppConnectMs:    ms(avg.AppConnect),
+		PreTransferMs:   ms(avg.PreTransfer),
+		StartTransferMs: ms(avg.StartTransfer),
+		RedirectMs:      ms(avg.Redirect),
+		TotalMs:         ms(avg.Total),
+	}
 }
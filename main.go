@@ -2,39 +2,66 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
+	"maps"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/zupolgec/curl-impersonate-service/config"
+	"github.com/zupolgec/curl-impersonate-service/detect"
+	"github.com/zupolgec/curl-impersonate-service/executor"
 	"github.com/zupolgec/curl-impersonate-service/handlers"
 	"github.com/zupolgec/curl-impersonate-service/metrics"
 	"github.com/zupolgec/curl-impersonate-service/middleware"
 	"github.com/zupolgec/curl-impersonate-service/models"
+	"github.com/zupolgec/curl-impersonate-service/oidc"
+	"github.com/zupolgec/curl-impersonate-service/serve"
+	"github.com/zupolgec/curl-impersonate-service/settings"
 	"github.com/zupolgec/curl-impersonate-service/store"
 )
 
 func main() {
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "JSON or TOML config file; environment variables override it")
+	checkOnly := flag.Bool("check-config", false, "validate the configuration and exit")
+	flag.Parse()
+	if *checkOnly {
+		os.Exit(checkConfig(*configFile))
+	}
+
 	// Load configuration
-	cfg, err := config.Load()
+	cfg, err := config.LoadFile(*configFile)
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		log.Fatalf("Invalid configuration:\n%v", err)
 	}
+	if cfg.File != "" {
+		log.Printf("Config file: %s", cfg.File)
+	}
+	liveCfg := &atomic.Pointer[config.Config]{}
+	liveCfg.Store(cfg)
 
 	log.Printf("Starting curl-impersonate-service v%s", config.Version)
 	log.Printf("Port: %s", cfg.Port)
 	log.Printf("Log Level: %s", cfg.LogLevel)
 
 	// Load browsers configuration
-	if err := models.LoadBrowsers(cfg.BrowsersJSONPath); err != nil {
+	exec := executor.Default()
+	if err := models.LoadBrowsers(cfg.BrowsersJSONPath, exec.SupportsTarget); err != nil {
 		log.Fatalf("Failed to load browsers.json: %v", err)
 	}
-	log.Printf("Loaded %d browser configurations", len(models.GetAllBrowsers()))
+	log.Printf("Loaded %d browser configurations (default %s)", models.CurrentBrowsers().Len(), models.GetDefaultBrowser())
+	if err := detect.Load(cfg.BlockRulesPath); err != nil {
+		log.Fatalf("Failed to load block rules: %v", err)
+	}
+	logBlockRules()
 
 	// Verify curl-impersonate binaries exist
 	if err := verifyBinaries(); err != nil {
@@ -52,23 +79,53 @@ func main() {
 	}
 	defer func() { _ = st.Close() }()
 
+	// CA bundles and client certificates for targets are stored encrypted,
+	// and imported tokens are hashed with a key derived from the same one.
+	if cfg.SecretsKey != nil {
+		if err := st.SetSecretsKey(cfg.SecretsKey); err != nil {
+			log.Fatalf("SECRETS_KEY: %v", err)
+		}
+	}
+
 	// Seed the legacy TOKEN as an API token for backward compatibility.
 	if cfg.Token != "" {
 		if err := st.SeedToken("legacy-env-token", cfg.Token); err != nil {
 			log.Printf("Warning: failed to seed legacy token: %v", err)
 		}
 	}
-	if err := handlers.SeedCORSSetting(st, cfg.CORSAllowedOrigins); err != nil {
-		log.Printf("Warning: failed to seed CORS setting: %v", err)
+
+	// Add the custom browser profiles managed from the admin UI.
+	if profiles, err := st.ListProfiles(); err != nil {
+		log.Printf("Warning: failed to load browser profiles: %v", err)
+	} else if err := models.SetStoredProfiles(profiles); err != nil {
+		log.Printf("Warning: skipping stored browser profiles:\n%v", err)
 	}
 
-	// Start the usage-log retention janitor.
-	retention := time.Duration(cfg.LogRetentionHours) * time.Hour
-	stopJanitor := startLogJanitor(st, retention)
+	// Runtime settings: defaults from the environment, overridden from the
+	// admin UI or API without a restart.
+	rt := settings.NewRuntime(cfg, st)
+	rt.Load()
+
+	// Start the usage-log rollup and retention janitor.
+	stopJanitor := startLogJanitor(st, janitorRetention{
+		logs: func() time.Duration { return time.Duration(rt.LogRetentionHours.Get()) * time.Hour },
+		hourlyRollup: func() time.Duration {
+			return time.Duration(liveCfg.Load().RollupHourlyRetentionDays) * 24 * time.Hour
+		},
+		dailyRollup: func() time.Duration {
+			return time.Duration(liveCfg.Load().RollupDailyRetentionDays) * 24 * time.Hour
+		},
+	})
 	defer stopJanitor()
 
-	// Initialize metrics collector
+	// Initialize metrics collector, restoring all-time counters persisted by
+	// previous runs and snapshotting them periodically.
 	collector := metrics.NewCollector()
+	if err := collector.LoadSnapshot(st); err != nil {
+		log.Printf("Warning: failed to restore metrics: %v", err)
+	}
+	stopSnapshots := startMetricsSnapshots(collector, st)
+	defer stopSnapshots()
 
 	// Setup HTTP router
 	mux := http.NewServeMux()
@@ -76,28 +133,72 @@ func main() {
 	// Public endpoint (no auth)
 	mux.HandleFunc("/health", handlers.HealthHandler)
 
-	// Protected API endpoints, authenticated against datastore tokens.
-	authMw := middleware.AuthMiddleware(st.ValidateToken)
-	mux.Handle("/browsers", authMw(http.HandlerFunc(handlers.BrowsersHandler)))
-	mux.Handle("/metrics", authMw(handlers.NewMetricsHandler(collector)))
-	mux.Handle("/impersonate", authMw(handlers.NewImpersonateHandler(cfg, collector, st)))
+	// Protected API endpoints, authenticated against datastore tokens or
+	// signed requests. Each endpoint requires its own scope.
+	authOpts := middleware.AuthOptions{
+		Validate:   st.ValidateToken,
+		SigningKey: st.SigningKey,
+		Nonces:     middleware.NewNonceCache(100000),
+		MaxSkew:    time.Duration(cfg.SignatureMaxSkewSeconds) * time.Second,
+		MaxBody:    rt.MaxRequestBodySize.Get,
+	}
+	if cfg.AllowQueryToken {
+		authOpts.ValidateQuery = st.ValidateQueryToken
+	}
+	if len(cfg.TLSClientCertMap) > 0 {
+		// A mapped name must pick out one token; shared names would be
+		// refused on every request, so fail now instead.
+		names := slices.Sorted(maps.Values(cfg.TLSClientCertMap))
+		shared, err := st.SharedTokenNames(names)
+		if err != nil {
+			log.Fatalf("Failed to check TLS_CLIENT_CERT_MAP: %v", err)
+		}
+		if len(shared) > 0 {
+			log.Fatalf("TLS_CLIENT_CERT_MAP: token names shared by more than one token: %s", strings.Join(shared, ", "))
+		}
+		authOpts.ClientCert = middleware.ClientCertValidator(cfg.TLSClientCertMap, st.ValidateTokenName)
+	}
+	authMw := func(scope string) func(http.Handler) http.Handler {
+		return middleware.Auth(authOpts, scope)
+	}
+	mux.Handle("/browsers", authMw(models.ScopeBrowsers)(http.HandlerFunc(handlers.BrowsersHandler)))
+	mux.Handle("/metrics", authMw(models.ScopeMetrics)(handlers.NewMetricsHandler(collector)))
+	impersonate := handlers.NewImpersonateHandler(cfg, rt, collector, st, exec)
+	mux.Handle("/impersonate", authMw(models.ScopeImpersonate)(impersonate))
 
 	// API docs at /docs (token-authenticated), toggleable.
 	if cfg.APIDocsEnabled {
-		mux.Handle("/docs", authMw(handlers.NewDocsHandler(cfg.AdminToken != "")))
+		mux.Handle("/docs", authMw(models.ScopeDocs)(handlers.NewDocsHandler(cfg.AdminToken != "")))
 		log.Printf("API docs enabled at /docs")
 	}
 
-	// Admin UI (enabled only when ADMIN_TOKEN is set), protected by Basic auth.
-	if cfg.AdminToken != "" {
-		adminMw := middleware.AdminAuthMiddleware(cfg.AdminToken)
-		mux.Handle("/admin/", adminMw(handlers.NewAdminHandler(st, collector)))
-		log.Printf("Admin UI enabled at /admin/")
+	// Admin UI, enabled when ADMIN_TOKEN is set, admin accounts exist or SSO
+	// is configured. Pages use session sign-in; ADMIN_TOKEN signs in as the
+	// "admin" owner.
+	sso, err := ssoOptions(cfg)
+	if err != nil {
+		log.Fatalf("Invalid OIDC configuration: %v", err)
+	}
+	if admins, _ := st.CountAdminUsers(); cfg.AdminToken != "" || admins > 0 || sso != nil {
+		mux.Handle("/admin/", handlers.NewAdminHandler(st, collector, impersonate, handlers.AdminOptions{
+			AdminToken: cfg.AdminToken,
+			SessionTTL: time.Duration(cfg.AdminSessionHours) * time.Hour,
+			SSO:        sso,
+			Settings:   rt.Registry,
+			ReloadBrowsers: func() error {
+				return reloadBrowsers(liveCfg.Load().BrowsersJSONPath, rt)
+			},
+		}))
+		// The JSON API also accepts admin-scoped API tokens.
+		apiMw := middleware.AdminAPIAuthMiddleware(cfg.AdminToken, st.ValidateToken)
+		mux.Handle("/admin/api/", apiMw(handlers.NewAdminAPIHandler(st, collector, rt.Registry)))
+		log.Printf("Admin UI enabled at /admin/ (JSON API at /admin/api/v1/)")
 	}
 
 	// Apply middleware chain: CORS -> Logging -> Routes. CORS origins are read
-	// live from the datastore so the admin UI can update them without a restart.
-	corsMw := middleware.CORSMiddleware(handlers.CORSOriginProvider(st, cfg.CORSAllowedOrigins))
+	// live from the runtime settings so the admin UI can update them without a
+	// restart.
+	corsMw := middleware.CORSMiddleware(rt.CORSAllowedOrigins.Get)
 	handler := corsMw(middleware.LoggingMiddleware(mux))
 
 	// Create HTTP server
@@ -109,14 +210,50 @@ func main() {
 		IdleTimeout:  120 * time.Second,
 	}
 
+	if cfg.TLSCertFile != "" {
+		tlsCfg, _, err := serve.TLSConfig(serve.TLSOptions{
+			CertFile:     cfg.TLSCertFile,
+			KeyFile:      cfg.TLSKeyFile,
+			ClientCAFile: cfg.TLSClientCAFile,
+			ClientAuth:   cfg.TLSClientAuth,
+		})
+		if err != nil {
+			log.Fatalf("Invalid TLS configuration: %v", err)
+		}
+		server.TLSConfig = tlsCfg
+	}
+	ln, err := serve.Listen(server.Addr, cfg.UnixSocket, cfg.UnixSocketMode)
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
+	}
+
 	// Start server in a goroutine
 	go func() {
-		log.Printf("Server listening on :%s", cfg.Port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		scheme := "HTTP"
+		if server.TLSConfig != nil {
+			scheme = "HTTPS"
+		}
+		log.Printf("Server listening on %s (%s)", ln.Addr(), scheme)
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ServeTLS(ln, "", "")
+		} else {
+			err = server.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server failed: %v", err)
 		}
 	}()
 
+	// SIGHUP reloads the configuration.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadConfig(liveCfg, rt)
+		}
+	}()
+
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -135,26 +272,94 @@ func main() {
 	log.Println("Server exited")
 }
 
-// startLogJanitor periodically purges usage logs older than the retention
-// window. It returns a stop function. A non-positive retention disables purging.
-func startLogJanitor(st *store.Store, retention time.Duration) func() {
-	if retention <= 0 {
-		return func() {}
+// metricsSnapshotInterval is how often the all-time counters are persisted.
+const metricsSnapshotInterval = time.Minute
+
+// startMetricsSnapshots periodically persists the collector's all-time
+// counters. The returned stop function writes a final snapshot.
+func startMetricsSnapshots(c *metrics.Collector, st *store.Store) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(metricsSnapshotInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.SaveSnapshot(st); err != nil {
+					log.Printf("Warning: failed to snapshot metrics: %v", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		if err := c.SaveSnapshot(st); err != nil {
+			log.Printf("Warning: failed to snapshot metrics: %v", err)
+		}
+	}
+}
+
+// ssoOptions builds the admin SSO settings from OIDC_* config, or returns nil
+// when OIDC_ISSUER is unset. The provider itself is contacted lazily.
+func ssoOptions(cfg *config.Config) (*handlers.SSOOptions, error) {
+	if cfg.OIDCIssuer == "" {
+		return nil, nil
+	}
+	client, err := oidc.New(oidc.Config{
+		Issuer:       cfg.OIDCIssuer,
+		ClientID:     cfg.OIDCClientID,
+		ClientSecret: cfg.OIDCClientSecret,
+		RedirectURL:  cfg.OIDCRedirectURL,
+		Scopes:       cfg.OIDCScopes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for group, role := range cfg.OIDCRoleMap {
+		if !slices.Contains(store.Roles, role) {
+			return nil, fmt.Errorf("OIDC_ROLE_MAP: group %q maps to unknown role %q", group, role)
+		}
 	}
+	if len(cfg.OIDCRoleMap) == 0 {
+		log.Printf("Warning: OIDC_ROLE_MAP is empty; nobody can sign in with SSO")
+	}
+	log.Printf("Admin SSO enabled via %s", cfg.OIDCIssuer)
+	return &handlers.SSOOptions{
+		Client:        client,
+		UsernameClaim: cfg.OIDCUsernameClaim,
+		GroupsClaim:   cfg.OIDCGroupsClaim,
+		RoleMap:       cfg.OIDCRoleMap,
+	}, nil
+}
+
+// janitorRetention groups the retention windows enforced by the janitor.
+// They are read on every run, so settings changes and config reloads apply.
+type janitorRetention struct {
+	logs         func() time.Duration
+	hourlyRollup func() time.Duration
+	dailyRollup  func() time.Duration
+}
+
+// startLogJanitor periodically rolls raw usage logs up into hourly and daily
+// aggregates, then purges raw logs older than the retention window (never
+// before they have been rolled up), aggregates past their own windows and
+// expired debug captures. It returns a stop function. A non-positive
+// retention disables that purge.
+func startLogJanitor(st *store.Store, ret janitorRetention) func() {
 	stop := make(chan struct{})
 	go func() {
 		ticker := time.NewTicker(time.Hour)
 		defer ticker.Stop()
-		// Purge once at startup, then hourly.
-		if n, err := st.PurgeLogsOlderThan(retention); err == nil && n > 0 {
-			log.Printf("Purged %d expired usage logs", n)
-		}
+		// Run once at startup, then hourly.
 		for {
+			runJanitor(st, ret)
 			select {
 			case <-ticker.C:
-				if n, err := st.PurgeLogsOlderThan(retention); err == nil && n > 0 {
-					log.Printf("Purged %d expired usage logs", n)
-				}
 			case <-stop:
 				return
 			}
@@ -163,6 +368,143 @@ func startLogJanitor(st *store.Store, retention time.Duration) func() {
 	return func() { close(stop) }
 }
 
+func runJanitor(st *store.Store, ret janitorRetention) {
+	now := time.Now()
+	covered, err := st.RollupCompleted(now)
+	if err != nil {
+		log.Printf("Warning: usage rollup failed: %v", err)
+		return
+	}
+	if logs := ret.logs(); logs > 0 {
+		cutoff := now.Add(-logs)
+		if covered.Before(cutoff) {
+			cutoff = covered
+		}
+		if n, err := st.PurgeLogsBefore(cutoff); err == nil && n > 0 {
+			log.Printf("Purged %d expired usage logs", n)
+		}
+	}
+	if n, err := st.PurgeExpiredCaptures(); err == nil && n > 0 {
+		log.Printf("Purged %d expired debug captures", n)
+	}
+	_, _ = st.PurgeExpiredAdminSessions()
+	if d := ret.hourlyRollup(); d > 0 {
+		_, _ = st.PurgeRollupsOlderThan(store.PeriodHour, d)
+	}
+	if d := ret.dailyRollup(); d > 0 {
+		_, _ = st.PurgeRollupsOlderThan(store.PeriodDay, d)
+	}
+}
+
+// reloadable lists the settings a reload applies; changing any other one
+// needs a restart.
+var reloadable = map[string]bool{
+	"MAX_TIMEOUT": true, "DEFAULT_TIMEOUT": true,
+	"MAX_REQUEST_BODY_SIZE": true, "MAX_RESPONSE_BODY_SIZE": true,
+	"SSRF_ALLOW_PRIVATE": true, "SSRF_ALLOW_HTTP": true, "SSRF_ALLOW_IP": true,
+	"SSRF_DENY_HOSTS": true, "SSRF_ALLOW_HOSTS": true,
+	"CORS_ALLOWED_ORIGINS": true, "LOG_RETENTION_HOURS": true, "BROWSERS_JSON_PATH": true,
+	"BLOCK_RULES_PATH": true, "ROLLUP_HOURLY_RETENTION_DAYS": true, "ROLLUP_DAILY_RETENTION_DAYS": true,
+}
+
+// reloadConfig reads the configuration, browsers.json and the block rules
+// again and applies the reloadable settings. An invalid configuration,
+// browsers.json or rules file is logged and the running one kept.
+func reloadConfig(live *atomic.Pointer[config.Config], rt *settings.Runtime) {
+	old := live.Load()
+	cfg, err := config.LoadFile(old.File)
+	if err != nil {
+		log.Printf("Config reload failed, keeping the running configuration:\n%v", err)
+		return
+	}
+	if err := reloadBrowsers(cfg.BrowsersJSONPath, rt); err != nil {
+		log.Printf("Browsers reload failed, keeping the loaded browsers:\n%v", err)
+	}
+	if err := detect.Load(cfg.BlockRulesPath); err != nil {
+		log.Printf("Block rules reload failed, keeping the loaded rules:\n%v", err)
+	} else {
+		logBlockRules()
+	}
+	var applied, restart []string
+	for _, key := range old.Changed(cfg) {
+		if reloadable[key] {
+			applied = append(applied, key)
+		} else {
+			restart = append(restart, key)
+		}
+	}
+	rt.SetDefaults(cfg)
+	live.Store(cfg)
+	log.Printf("Configuration reloaded; changed: %s", listOrNone(applied))
+	if len(restart) > 0 {
+		log.Printf("Warning: restart to apply %s", strings.Join(restart, ", "))
+	}
+}
+
+// reloadBrowsers loads browsers.json again. The default_browser setting
+// takes its default from the new file.
+func reloadBrowsers(path string, rt *settings.Runtime) error {
+	if err := models.LoadBrowsers(path, executor.Default().SupportsTarget); err != nil {
+		return err
+	}
+	set := models.CurrentBrowsers()
+	rt.DefaultBrowser.SetDefault(set.Default(), settings.SourceDefault)
+	log.Printf("Reloaded %d browser configurations from %s (default %s)", set.Len(), path, models.GetDefaultBrowser())
+	return nil
+}
+
+// logBlockRules logs the block-page detection rules in use.
+func logBlockRules() {
+	if rules := detect.Current(); rules != nil {
+		log.Printf("Loaded %d block detection rules from %s", rules.Len(), rules.Path)
+	} else {
+		log.Printf("Block detection disabled (BLOCK_RULES_PATH is empty)")
+	}
+}
+
+func listOrNone(keys []string) string {
+	if len(keys) == 0 {
+		return "none"
+	}
+	return strings.Join(keys, ", ")
+}
+
+// checkConfig validates the configuration, browsers.json, the block rules
+// and TLS files for --check-config, printing every problem. It returns the
+// exit code.
+func checkConfig(path string) int {
+	cfg, err := config.LoadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		return 1
+	}
+	failed := false
+	if err := models.LoadBrowsers(cfg.BrowsersJSONPath, executor.Default().SupportsTarget); err != nil {
+		fmt.Fprintf(os.Stderr, "BROWSERS_JSON_PATH: %v\n", err)
+		failed = true
+	}
+	if err := detect.Load(cfg.BlockRulesPath); err != nil {
+		fmt.Fprintf(os.Stderr, "BLOCK_RULES_PATH: %v\n", err)
+		failed = true
+	}
+	if cfg.TLSCertFile != "" {
+		if _, _, err := serve.TLSConfig(serve.TLSOptions{
+			CertFile:     cfg.TLSCertFile,
+			KeyFile:      cfg.TLSKeyFile,
+			ClientCAFile: cfg.TLSClientCAFile,
+			ClientAuth:   cfg.TLSClientAuth,
+		}); err != nil {
+			fmt.Fprintf(os.Stderr, "TLS: %v\n", err)
+			failed = true
+		}
+	}
+	if failed {
+		return 1
+	}
+	fmt.Println("Configuration OK")
+	return 0
+}
+
 func verifyBinaries() error {
 	// Check a few key wrapper scripts exist
 	wrapperScripts := []string{
@@ -3,6 +3,8 @@ package metrics
 import (
 	"sync"
 	"time"
+
+	"github.com/zupolgec/curl-impersonate-service/models"
 )
 
 type Collector struct {
@@ -13,13 +15,137 @@ type Collector struct {
 	requestsFailed  int64
 	totalDuration   time.Duration
 	browsersUsed    map[string]int64
+	blocks          Blocks
+	latency         Latency
+
+	// base holds counters restored from a previous run; it is added to the
+	// since-start counters for all-time views.
+	base Snapshot
 }
 
 func NewCollector() *Collector {
+	now := time.Now()
 	return &Collector{
-		startTime:    time.Now(),
+		startTime:    now,
 		browsersUsed: make(map[string]int64),
+		blocks:       newBlocks(),
+		base:         Snapshot{Since: now, Blocks: newBlocks()},
+	}
+}
+
+// maxBlockHosts caps the hosts counted separately in Blocks.ByHost; blocks
+// on further hosts are counted under OtherHosts.
+const maxBlockHosts = 1000
+
+// OtherHosts is the Blocks.ByHost key counting hosts past the cap.
+const OtherHosts = "(other)"
+
+// Blocks count responses detected as bot challenges or block pages.
+type Blocks struct {
+	Total     int64            `json:"total"`
+	ByBrowser map[string]int64 `json:"by_browser"`
+	ByHost    map[string]int64 `json:"by_host"`
+	ByVendor  map[string]int64 `json:"by_vendor"`
+}
+
+func newBlocks() Blocks {
+	return Blocks{ByBrowser: map[string]int64{}, ByHost: map[string]int64{}, ByVendor: map[string]int64{}}
+}
+
+// add returns the sum of b and o in new maps.
+func (b Blocks) add(o Blocks) Blocks {
+	out := newBlocks()
+	out.Total = b.Total + o.Total
+	for _, src := range []Blocks{b, o} {
+		for k, v := range src.ByBrowser {
+			out.ByBrowser[k] += v
+		}
+		for k, v := range src.ByHost {
+			out.ByHost[k] += v
+		}
+		for k, v := range src.ByVendor {
+			out.ByVendor[k] += v
+		}
+	}
+	return out
+}
+
+// Latency sums the upstream timings of successful requests.
+type Latency struct {
+	Samples int64         `json:"samples"`
+	Sum     models.Timing `json:"sum"`
+}
+
+// add returns the sum of l and o.
+func (l Latency) add(o Latency) Latency {
+	s, t := l.Sum, o.Sum
+	return Latency{
+		Samples: l.Samples + o.Samples,
+		Sum: models.Timing{
+			Total:         s.Total + t.Total,
+			NameLookup:    s.NameLookup + t.NameLookup,
+			Connect:       s.Connect + t.Connect,
+			AppConnect:    s.AppConnect + t.AppConnect,
+			PreTransfer:   s.PreTransfer + t.PreTransfer,
+			StartTransfer: s.StartTransfer + t.StartTransfer,
+			Redirect:      s.Redirect + t.Redirect,
+			Queue:         s.Queue + t.Queue,
+		},
+	}
+}
+
+// Average returns the mean timings, in seconds.
+func (l Latency) Average() models.Timing {
+	if l.Samples == 0 {
+		return models.Timing{}
 	}
+	n, s := float64(l.Samples), l.Sum
+	return models.Timing{
+		Total:         s.Total / n,
+		NameLookup:    s.NameLookup / n,
+		Connect:       s.Connect / n,
+		AppConnect:    s.AppConnect / n,
+		PreTransfer:   s.PreTransfer / n,
+		StartTransfer: s.StartTransfer / n,
+		Redirect:      s.Redirect / n,
+		Queue:         s.Queue / n,
+	}
+}
+
+// RecordTiming adds the upstream timings of a successful request.
+func (c *Collector) RecordTiming(t models.Timing) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.latency = c.latency.add(Latency{Samples: 1, Sum: t})
+}
+
+// GetLatency returns the timing sums since start.
+func (c *Collector) GetLatency() Latency {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.latency
+}
+
+// RecordBlock counts a response from host, fetched with browser, that was
+// detected as a block served by vendor.
+func (c *Collector) RecordBlock(host, browser, vendor string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.blocks.Total++
+	c.blocks.ByBrowser[browser]++
+	c.blocks.ByVendor[vendor]++
+	if _, ok := c.blocks.ByHost[host]; !ok && len(c.blocks.ByHost) >= maxBlockHosts {
+		host = OtherHosts
+	}
+	c.blocks.ByHost[host]++
+}
+
+// GetBlocks returns the block counters since start.
+func (c *Collector) GetBlocks() Blocks {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return newBlocks().add(c.blocks)
 }
 
 func (c *Collector) RecordRequest(browser string, success bool, duration time.Duration) {
@@ -1,8 +1,12 @@
 package metrics
 
 import (
+	"fmt"
+	"math"
 	"testing"
 	"time"
+
+	"github.com/zupolgec/curl-impersonate-service/models"
 )
 
 func TestNewCollector(t *testing.T) {
@@ -121,3 +125,120 @@ func TestRecordRequest_Multiple(t *testing.T) {
 		t.Errorf("browsers[ff109] = %d, want 1", browsers["ff109"])
 	}
 }
+
+type memSettings map[string]string
+
+func (m memSettings) GetSetting(key, def string) string {
+	if v, ok := m[key]; ok {
+		return v
+	}
+	return def
+}
+
+func (m memSettings) SetSetting(key, value string) error {
+	m[key] = value
+	return nil
+}
+
+func TestSnapshotSurvivesRestart(t *testing.T) {
+	st := memSettings{}
+
+	first := NewCollector()
+	first.RecordRequest("chrome136", true, 100*time.Millisecond)
+	first.RecordRequest("chrome136", false, 300*time.Millisecond)
+	if err := first.SaveSnapshot(st); err != nil {
+		t.Fatalf("SaveSnapshot: %v", err)
+	}
+
+	second := NewCollector()
+	if err := second.LoadSnapshot(st); err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+	second.RecordRequest("firefox135", true, 200*time.Millisecond)
+
+	_, total, _, _, _, _ := second.GetMetrics()
+	if total != 1 {
+		t.Errorf("since-start total = %d, want 1", total)
+	}
+
+	all := second.AllTime()
+	if all.RequestsTotal != 3 || all.RequestsFailed != 1 {
+		t.Errorf("all-time = %+v, want 3 total, 1 failed", all)
+	}
+	if all.BrowsersUsed["chrome136"] != 2 || all.BrowsersUsed["firefox135"] != 1 {
+		t.Errorf("all-time browsers = %v", all.BrowsersUsed)
+	}
+	if avg := all.AverageDurationMs(); avg != 200 {
+		t.Errorf("all-time avg = %f, want 200", avg)
+	}
+	if !all.Since.Equal(first.AllTime().Since) {
+		t.Errorf("Since = %v, want first boot %v", all.Since, first.AllTime().Since)
+	}
+}
+
+func TestReset(t *testing.T) {
+	st := memSettings{}
+	c := NewCollector()
+	c.RecordRequest("chrome136", true, time.Millisecond)
+	_ = c.SaveSnapshot(st)
+	_ = c.LoadSnapshot(st)
+
+	c.Reset()
+	if all := c.AllTime(); all.RequestsTotal != 0 || len(all.BrowsersUsed) != 0 {
+		t.Errorf("all-time after reset = %+v, want zero", all)
+	}
+	if _, total, _, _, _, _ := c.GetMetrics(); total != 0 {
+		t.Errorf("since-start after reset = %d, want 0", total)
+	}
+}
+
+func TestRecordBlock(t *testing.T) {
+	st := memSettings{}
+	c := NewCollector()
+	c.RecordBlock("a.com", "chrome136", "cloudflare")
+	c.RecordBlock("a.com", "safari260", "cloudflare")
+	_ = c.SaveSnapshot(st)
+
+	c = NewCollector()
+	_ = c.LoadSnapshot(st)
+	c.RecordBlock("b.com", "chrome136", "datadome")
+
+	if b := c.GetBlocks(); b.Total != 1 || b.ByHost["b.com"] != 1 {
+		t.Errorf("since-start blocks = %+v", b)
+	}
+	all := c.AllTime().Blocks
+	if all.Total != 3 || all.ByBrowser["chrome136"] != 2 || all.ByHost["a.com"] != 2 || all.ByVendor["cloudflare"] != 2 {
+		t.Errorf("all-time blocks = %+v", all)
+	}
+
+	for i := range maxBlockHosts + 5 {
+		c.RecordBlock(fmt.Sprintf("h%d.com", i), "chrome136", "akamai")
+	}
+	if b := c.GetBlocks(); len(b.ByHost) != maxBlockHosts+1 || b.ByHost[OtherHosts] != 6 {
+		t.Errorf("hosts = %d, other = %d; want %d and 6", len(b.ByHost), b.ByHost[OtherHosts], maxBlockHosts+1)
+	}
+}
+
+func TestRecordTiming(t *testing.T) {
+	st := memSettings{}
+	c := NewCollector()
+	c.RecordTiming(models.Timing{Total: 0.2, AppConnect: 0.1})
+	_ = c.SaveSnapshot(st)
+
+	c = NewCollector()
+	_ = c.LoadSnapshot(st)
+	c.RecordTiming(models.Timing{Total: 0.4, AppConnect: 0.05})
+
+	if l := c.GetLatency(); l.Samples != 1 || l.Average().Total != 0.4 {
+		t.Errorf("since-start latency = %+v", l)
+	}
+	avg := c.AllTime().Latency.Average()
+	if math.Abs(avg.Total-0.3) > 1e-9 || math.Abs(avg.AppConnect-0.075) > 1e-9 {
+		t.Errorf("all-time average = %+v", avg)
+	}
+
+	c.Reset()
+	if l := c.AllTime().Latency; l.Samples != 0 {
+		t.Errorf("latency after reset = %+v", l)
+	}
+}
@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// snapshotKey is the settings key holding the persisted all-time counters.
+const snapshotKey = "metrics_snapshot"
+
+// Snapshot is a point-in-time copy of the counters. It is what gets persisted
+// across restarts and what the "all-time" views report.
+type Snapshot struct {
+	// Since is when counting started (first boot or last reset).
+	Since           time.Time        `json:"since"`
+	RequestsTotal   int64            `json:"requests_total"`
+	RequestsSuccess int64            `json:"requests_success"`
+	RequestsFailed  int64            `json:"requests_failed"`
+	TotalDurationMs int64            `json:"total_duration_ms"`
+	BrowsersUsed    map[string]int64 `json:"browsers_used"`
+	Blocks          Blocks           `json:"blocks"`
+	Latency         Latency          `json:"latency"`
+}
+
+// AverageDurationMs returns the mean request duration in milliseconds.
+func (s Snapshot) AverageDurationMs() float64 {
+	if s.RequestsTotal == 0 {
+		return 0
+	}
+	return float64(s.TotalDurationMs) / float64(s.RequestsTotal)
+}
+
+// SettingsStore is the subset of the datastore used to persist snapshots.
+type SettingsStore interface {
+	GetSetting(key, def string) string
+	SetSetting(key, value string) error
+}
+
+// AllTime returns the counters accumulated before this process started plus
+// everything recorded since.
+func (c *Collector) AllTime() Snapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	out := Snapshot{
+		Since:           c.base.Since,
+		RequestsTotal:   c.base.RequestsTotal + c.requestsTotal,
+		RequestsSuccess: c.base.RequestsSuccess + c.requestsSuccess,
+		RequestsFailed:  c.base.RequestsFailed + c.requestsFailed,
+		TotalDurationMs: c.base.TotalDurationMs + c.totalDuration.Milliseconds(),
+		BrowsersUsed:    make(map[string]int64, len(c.base.BrowsersUsed)+len(c.browsersUsed)),
+	}
+	for k, v := range c.base.BrowsersUsed {
+		out.BrowsersUsed[k] += v
+	}
+	for k, v := range c.browsersUsed {
+		out.BrowsersUsed[k] += v
+	}
+	out.Blocks = c.base.Blocks.add(c.blocks)
+	out.Latency = c.base.Latency.add(c.latency)
+	return out
+}
+
+// StartTime returns when this process started counting (or was last reset).
+func (c *Collector) StartTime() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.startTime
+}
+
+// Reset zeroes both the since-start and the all-time counters.
+func (c *Collector) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.startTime = now
+	c.requestsTotal, c.requestsSuccess, c.requestsFailed = 0, 0, 0
+	c.totalDuration = 0
+	c.browsersUsed = make(map[string]int64)
+	c.blocks = newBlocks()
+	c.latency = Latency{}
+	c.base = Snapshot{Since: now, Blocks: newBlocks()}
+}
+
+// LoadSnapshot restores the all-time counters persisted by SaveSnapshot. A
+// missing snapshot is not an error.
+func (c *Collector) LoadSnapshot(st SettingsStore) error {
+	raw := st.GetSetting(snapshotKey, "")
+	if raw == "" {
+		return nil
+	}
+	var snap Snapshot
+	if err := json.Unmarshal([]byte(raw), &snap); err != nil {
+		return fmt.Errorf("decode metrics snapshot: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if snap.Since.IsZero() {
+		snap.Since = c.startTime
+	}
+	c.base = snap
+	return nil
+}
+
+// SaveSnapshot persists the current all-time counters.
+func (c *Collector) SaveSnapshot(st SettingsStore) error {
+	raw, err := json.Marshal(c.AllTime())
+	if err != nil {
+		return err
+	}
+	return st.SetSetting(snapshotKey, string(raw))
+}
@@ -1,19 +1,24 @@
 package middleware
 
 import (
+	"context"
 	"crypto/subtle"
+	"errors"
 	"net/http"
+
+	"github.com/zupolgec/curl-impersonate-service/models"
 )
 
-// AdminAuthMiddleware protects the admin UI with HTTP Basic auth. Any username
-// is accepted; the password must equal the fixed admin token. Basic auth keeps
-// the browser flow simple (native login prompt, cached credentials) without
-// sessions or cookies.
+// AdminAuthMiddleware protects a handler with HTTP Basic auth. Any username is
+// accepted; the password must equal the fixed admin token, and nothing is
+// accepted when the token is empty. The admin UI itself now uses account
+// sign-in (see handlers.NewAdminHandler); this remains for deployments that
+// mount admin handlers behind a single shared secret.
 func AdminAuthMiddleware(adminToken string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			_, pass, ok := r.BasicAuth()
-			if !ok || subtle.ConstantTimeCompare([]byte(pass), []byte(adminToken)) != 1 {
+			if !ok || !isAdminToken(pass, adminToken) {
 				w.Header().Set("WWW-Authenticate", `Basic realm="impersonate-admin"`)
 				http.Error(w, "unauthorized", http.StatusUnauthorized)
 				return
@@ -22,3 +27,49 @@ func AdminAuthMiddleware(adminToken string) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// AdminAPIAuthMiddleware protects the JSON admin API. It accepts the admin
+// token (as the Basic auth password or a Bearer token), if one is set, or any
+// API token carrying the admin scope. Failures are JSON errors rather than a
+// browser login prompt.
+func AdminAPIAuthMiddleware(adminToken string, validate TokenValidator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, pass, ok := r.BasicAuth(); ok {
+				if !isAdminToken(pass, adminToken) {
+					models.WriteJSONError(w, http.StatusUnauthorized, "auth", "invalid admin credentials")
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token, provided := bearerToken(r)
+			if !provided || token == "" {
+				models.WriteJSONError(w, http.StatusUnauthorized, "auth", "missing authentication token")
+				return
+			}
+			if isAdminToken(token, adminToken) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			name, err := validate(token, models.ScopeAdmin)
+			if err != nil {
+				status := http.StatusUnauthorized
+				if errors.Is(err, models.ErrTokenScope) {
+					status = http.StatusForbidden
+				}
+				models.WriteJSONError(w, status, "auth", err.Error())
+				return
+			}
+			ctx := context.WithValue(r.Context(), tokenNameKey, name)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// isAdminToken reports whether got is the admin token. An empty admin token
+// means there is none, so nothing matches it.
+func isAdminToken(got, adminToken string) bool {
+	return adminToken != "" && got != "" && subtle.ConstantTimeCompare([]byte(got), []byte(adminToken)) == 1
+}
@@ -2,15 +2,20 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/zupolgec/curl-impersonate-service/models"
 )
 
-// TokenValidator validates an API token value and returns the associated token
-// name and whether it is valid.
-type TokenValidator func(token string) (name string, ok bool)
+// TokenValidator validates an API token value for a scope (see models.Scopes)
+// and returns the associated token name. It fails with models.ErrTokenInvalid,
+// models.ErrTokenExpired or models.ErrTokenScope, or with
+// models.ErrSignatureRequired or ErrQueryTokenDisabled for tokens restricted
+// to other ways of authenticating.
+type TokenValidator func(token, scope string) (name string, err error)
 
 type contextKey string
 
@@ -24,20 +29,49 @@ func TokenName(ctx context.Context) string {
 	return ""
 }
 
+// AuthOptions configures Auth.
+type AuthOptions struct {
+	// Validate checks tokens sent as "Authorization: Bearer".
+	Validate TokenValidator
+	// ValidateQuery checks tokens sent as a `token` query parameter; nil
+	// disables query-string tokens.
+	ValidateQuery TokenValidator
+	// SigningKey resolves signed requests; nil disables request signing.
+	SigningKey SigningKeyLookup
+	// Nonces remembers the nonces of signed requests. Required with
+	// SigningKey.
+	Nonces *NonceCache
+	// MaxSkew is the accepted clock difference for signed requests; zero
+	// means DefaultSignatureSkew.
+	MaxSkew time.Duration
+	// MaxBody returns the cap on the body read to check a signature; nil or
+	// zero means 10MB. It is read per request so the limit can change live.
+	MaxBody func() int64
+	// ClientCert authenticates callers by their verified TLS client
+	// certificate; nil ignores certificates.
+	ClientCert CertValidator
+}
+
 // AuthMiddleware authenticates API requests via a Bearer token or a `token`
-// query parameter, validating against the provided validator.
-func AuthMiddleware(validate TokenValidator) func(http.Handler) http.Handler {
+// query parameter, validating against the provided validator. The token must
+// carry scope.
+func AuthMiddleware(validate TokenValidator, scope string) func(http.Handler) http.Handler {
+	return Auth(AuthOptions{Validate: validate, ValidateQuery: validate}, scope)
+}
+
+// Auth authenticates API requests for scope. A request is checked, in order
+// of precedence, by a mapped TLS client certificate, as a signed request (see
+// SignatureHeader), a Bearer token or a `token` query parameter.
+func Auth(opts AuthOptions, scope string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			token, provided := extractToken(r)
-			if !provided {
+			name, err := authenticate(r, opts, scope)
+			if errors.Is(err, errNoCredentials) {
 				models.WriteJSONError(w, http.StatusUnauthorized, "auth", "missing authentication token")
 				return
 			}
-
-			name, ok := validate(token)
-			if !ok {
-				models.WriteJSONError(w, http.StatusUnauthorized, "auth", "invalid authentication token")
+			if err != nil {
+				writeAuthError(w, err)
 				return
 			}
 
@@ -47,11 +81,52 @@ func AuthMiddleware(validate TokenValidator) func(http.Handler) http.Handler {
 	}
 }
 
-// extractToken pulls the token from the query string or Authorization header.
-func extractToken(r *http.Request) (string, bool) {
-	if q := r.URL.Query().Get("token"); q != "" {
-		return q, true
+var errNoCredentials = errors.New("no credentials")
+
+// authenticate checks the first credential r carries, in order of precedence.
+func authenticate(r *http.Request, opts AuthOptions, scope string) (string, error) {
+	if opts.ClientCert != nil && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		name, err := opts.ClientCert(r.TLS.VerifiedChains[0][0], scope)
+		if !errors.Is(err, ErrCertNotMapped) {
+			return name, err
+		}
+	}
+	if r.Header.Get(SignatureHeader) != "" && opts.SigningKey != nil {
+		return verifySignedRequest(r, opts, scope)
+	}
+	if token, ok := bearerToken(r); ok {
+		return opts.Validate(token, scope)
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		if opts.ValidateQuery == nil {
+			return "", models.ErrQueryTokenDisabled
+		}
+		return opts.ValidateQuery(token, scope)
+	}
+	return "", errNoCredentials
+}
+
+// writeAuthError reports a failed authentication. Token lookups never say
+// more than "invalid" unless the token was recognised.
+func writeAuthError(w http.ResponseWriter, err error) {
+	var sigErr signatureError
+	switch {
+	case errors.Is(err, models.ErrTokenScope):
+		models.WriteJSONError(w, http.StatusForbidden, "auth", err.Error())
+	case errors.Is(err, errBodyTooLarge):
+		models.WriteJSONError(w, http.StatusRequestEntityTooLarge, "auth", err.Error())
+	case errors.Is(err, models.ErrTokenExpired),
+		errors.Is(err, models.ErrSignatureRequired),
+		errors.Is(err, models.ErrQueryTokenDisabled),
+		errors.As(err, &sigErr):
+		models.WriteJSONError(w, http.StatusUnauthorized, "auth", err.Error())
+	default:
+		models.WriteJSONError(w, http.StatusUnauthorized, "auth", models.ErrTokenInvalid.Error())
 	}
+}
+
+// bearerToken reads an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
 		return "", false
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"crypto/x509"
+	"errors"
+)
+
+// CertValidator authenticates a verified client certificate for a scope and
+// returns the token name it maps to. It fails with ErrCertNotMapped for
+// certificates it doesn't know, letting other credentials be checked.
+type CertValidator func(cert *x509.Certificate, scope string) (name string, err error)
+
+// ErrCertNotMapped is returned for client certificates without a token.
+var ErrCertNotMapped = errors.New("client certificate is not mapped to a token")
+
+// CertIdentities returns the identities of a certificate that a
+// ClientCertMap can match, most specific first: "uri:" and "dns:" and
+// "email:" for each SAN, then "cn:" for the subject common name.
+func CertIdentities(cert *x509.Certificate) []string {
+	var ids []string
+	for _, u := range cert.URIs {
+		ids = append(ids, "uri:"+u.String())
+	}
+	for _, d := range cert.DNSNames {
+		ids = append(ids, "dns:"+d)
+	}
+	for _, e := range cert.EmailAddresses {
+		ids = append(ids, "email:"+e)
+	}
+	if cn := cert.Subject.CommonName; cn != "" {
+		ids = append(ids, "cn:"+cn)
+	}
+	return ids
+}
+
+// ClientCertMap maps certificate identities (see CertIdentities) to token
+// names, e.g. "uri:spiffe://prod/scraper" → "scraper-prod".
+type ClientCertMap map[string]string
+
+// TokenFor returns the token name of the first identity of cert in the map.
+func (m ClientCertMap) TokenFor(cert *x509.Certificate) (string, bool) {
+	for _, id := range CertIdentities(cert) {
+		if name, ok := m[id]; ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// ClientCertValidator maps certificates through m and authenticates them as
+// the named token with validate, so the token's state and scopes apply to
+// mTLS callers too.
+func ClientCertValidator(m ClientCertMap, validate TokenValidator) CertValidator {
+	return func(cert *x509.Certificate, scope string) (string, error) {
+		name, ok := m.TokenFor(cert)
+		if !ok {
+			return "", ErrCertNotMapped
+		}
+		return validate(name, scope)
+	}
+}
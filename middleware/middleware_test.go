@@ -1,9 +1,15 @@
 package middleware
 
 import (
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/zupolgec/curl-impersonate-service/models"
 )
 
 func okHandler() http.Handler {
@@ -14,13 +20,19 @@ func okHandler() http.Handler {
 
 func TestAuthMiddleware(t *testing.T) {
 	const token = "secret-token"
-	validate := func(t string) (string, bool) {
-		if t == token {
-			return "test", true
+	validate := func(t, scope string) (string, error) {
+		switch {
+		case t == "expired-token":
+			return "", models.ErrTokenExpired
+		case t != token:
+			return "", models.ErrTokenInvalid
+		case scope != models.ScopeImpersonate:
+			return "", models.ErrTokenScope
 		}
-		return "", false
+		return "test", nil
 	}
-	h := AuthMiddleware(validate)(okHandler())
+	h := AuthMiddleware(validate, models.ScopeImpersonate)(okHandler())
+	metricsOnly := AuthMiddleware(validate, models.ScopeMetrics)(okHandler())
 
 	cases := []struct {
 		name       string
@@ -33,6 +45,7 @@ func TestAuthMiddleware(t *testing.T) {
 		{"wrong query", func(r *http.Request) { r.URL.RawQuery = "token=nope" }, http.StatusUnauthorized},
 		{"missing", func(r *http.Request) {}, http.StatusUnauthorized},
 		{"malformed header", func(r *http.Request) { r.Header.Set("Authorization", "Basic abc") }, http.StatusUnauthorized},
+		{"expired", func(r *http.Request) { r.Header.Set("Authorization", "Bearer expired-token") }, http.StatusUnauthorized},
 	}
 
 	for _, tc := range cases {
@@ -46,6 +59,207 @@ func TestAuthMiddleware(t *testing.T) {
 			}
 		})
 	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://x/", nil)
+	r.Header.Set("Authorization", "Bearer expired-token")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), "expired") {
+		t.Fatalf("expired token body = %q, want a distinct message", w.Body.String())
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "http://x/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	metricsOnly.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("out-of-scope status = %d, want 403", w.Code)
+	}
+}
+
+func TestAuthSignedRequests(t *testing.T) {
+	secret := []byte("signing-secret")
+	lookup := func(keyID, scope string) (string, []byte, error) {
+		if keyID != "sk_1" {
+			return "", nil, models.ErrTokenInvalid
+		}
+		return "signer", secret, nil
+	}
+	validate := func(tok, scope string) (string, error) {
+		switch tok {
+		case "plain":
+			return "plain", nil
+		case "no-query":
+			return "", models.ErrQueryTokenDisabled
+		}
+		return "", models.ErrTokenInvalid
+	}
+	var gotName, gotBody string
+	h := Auth(AuthOptions{
+		Validate:   validate,
+		SigningKey: lookup,
+		Nonces:     NewNonceCache(100),
+	}, models.ScopeImpersonate)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotName = TokenName(r.Context())
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+	}))
+
+	signed := func(body, nonce string, at time.Time) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "http://x/impersonate?debug=1", strings.NewReader(body))
+		ts := strconv.FormatInt(at.Unix(), 10)
+		r.Header.Set(SignatureKeyHeader, "sk_1")
+		r.Header.Set(SignatureTimestampHeader, ts)
+		r.Header.Set(SignatureNonceHeader, nonce)
+		r.Header.Set(SignatureHeader, Sign(secret, SignatureString(http.MethodPost, "/impersonate?debug=1", ts, nonce, []byte(body))))
+		return r
+	}
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := serve(signed(`{"url":"https://example.com"}`, "nonce-0000000001", time.Now())); w.Code != http.StatusOK {
+		t.Fatalf("valid signature: %d %s", w.Code, w.Body)
+	}
+	if gotName != "signer" || gotBody != `{"url":"https://example.com"}` {
+		t.Fatalf("handler saw name %q body %q", gotName, gotBody)
+	}
+
+	cases := map[string]func() *http.Request{
+		"replayed nonce": func() *http.Request { return signed(`{"url":"https://example.com"}`, "nonce-0000000001", time.Now()) },
+		"stale":          func() *http.Request { return signed("{}", "nonce-0000000002", time.Now().Add(-10*time.Minute)) },
+		"future":         func() *http.Request { return signed("{}", "nonce-0000000003", time.Now().Add(10*time.Minute)) },
+		"short nonce":    func() *http.Request { return signed("{}", "abc", time.Now()) },
+		"tampered body": func() *http.Request {
+			r := signed("{}", "nonce-0000000004", time.Now())
+			r.Body = io.NopCloser(strings.NewReader(`{"url":"https://evil.example.com"}`))
+			return r
+		},
+		"tampered path": func() *http.Request {
+			r := signed("{}", "nonce-0000000005", time.Now())
+			r.URL.RawQuery = "debug=0"
+			return r
+		},
+		"unknown key": func() *http.Request {
+			r := signed("{}", "nonce-0000000006", time.Now())
+			r.Header.Set(SignatureKeyHeader, "sk_2")
+			return r
+		},
+		"missing nonce": func() *http.Request {
+			r := signed("{}", "nonce-0000000007", time.Now())
+			r.Header.Del(SignatureNonceHeader)
+			return r
+		},
+	}
+	for name, req := range cases {
+		if w := serve(req()); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: got %d, want 401", name, w.Code)
+		}
+	}
+	// A rejected signature doesn't spend its nonce.
+	if w := serve(signed("{}", "nonce-0000000004", time.Now())); w.Code != http.StatusOK {
+		t.Errorf("nonce of a forged request: got %d, want 200", w.Code)
+	}
+
+	// Query-string tokens: disabled for the deployment, and per token.
+	r := httptest.NewRequest(http.MethodGet, "http://x/?token=plain", nil)
+	if w := serve(r); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "query string") {
+		t.Errorf("query token with ValidateQuery unset: %d %s", w.Code, w.Body)
+	}
+	withQuery := Auth(AuthOptions{Validate: validate, ValidateQuery: validate}, models.ScopeImpersonate)(okHandler())
+	for tok, want := range map[string]int{"plain": http.StatusOK, "no-query": http.StatusUnauthorized} {
+		w := httptest.NewRecorder()
+		withQuery.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://x/?token="+tok, nil))
+		if w.Code != want {
+			t.Errorf("query token %q: got %d, want %d", tok, w.Code, want)
+		}
+	}
+}
+
+func TestNonceCacheFailsClosedWhenFull(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := NewNonceCache(2)
+	c.clock = func() time.Time { return now }
+	if !c.Use("k", "a", now.Add(time.Minute)) || !c.Use("k", "b", now.Add(time.Minute)) {
+		t.Fatal("fresh nonces refused")
+	}
+	if c.Use("k", "a", now.Add(time.Minute)) {
+		t.Fatal("reused nonce accepted")
+	}
+	if c.Use("k", "c", now.Add(time.Minute)) {
+		t.Fatal("full cache accepted a nonce")
+	}
+	now = now.Add(2 * time.Minute)
+	if !c.Use("k", "c", now.Add(time.Minute)) {
+		t.Fatal("cache did not make room after expiry")
+	}
+}
+
+func TestAdminAPIAuthMiddleware(t *testing.T) {
+	validate := func(tok, scope string) (string, error) {
+		switch tok {
+		case "admin-scoped":
+			return "infra", nil
+		case "api-only":
+			return "", models.ErrTokenScope
+		}
+		return "", models.ErrTokenInvalid
+	}
+	var gotName string
+	h := AdminAPIAuthMiddleware("root-secret", validate)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotName = TokenName(r.Context())
+	}))
+
+	cases := []struct {
+		name       string
+		setup      func(*http.Request)
+		wantStatus int
+	}{
+		{"basic admin", func(r *http.Request) { r.SetBasicAuth("any", "root-secret") }, http.StatusOK},
+		{"basic wrong", func(r *http.Request) { r.SetBasicAuth("any", "nope") }, http.StatusUnauthorized},
+		{"bearer admin", func(r *http.Request) { r.Header.Set("Authorization", "Bearer root-secret") }, http.StatusOK},
+		{"admin-scoped token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer admin-scoped") }, http.StatusOK},
+		{"api token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer api-only") }, http.StatusForbidden},
+		{"query token ignored", func(r *http.Request) { r.URL.RawQuery = "token=root-secret" }, http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://x/admin/api/v1/tokens", nil)
+			tc.setup(r)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tc.wantStatus {
+				t.Fatalf("got %d, want %d", w.Code, tc.wantStatus)
+			}
+		})
+	}
+	if gotName != "infra" {
+		t.Fatalf("token name in context = %q, want infra", gotName)
+	}
+
+	// Without ADMIN_TOKEN, empty credentials must not match the unset token.
+	h = AdminAPIAuthMiddleware("", validate)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	for name, setup := range map[string]func(*http.Request){
+		"empty basic password": func(r *http.Request) { r.SetBasicAuth("admin", "") },
+		"empty bearer":         func(r *http.Request) { r.Header.Set("Authorization", "Bearer ") },
+	} {
+		r := httptest.NewRequest(http.MethodGet, "http://x/admin/api/v1/tokens", nil)
+		setup(r)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s without ADMIN_TOKEN: got %d, want 401", name, w.Code)
+		}
+	}
+	r := httptest.NewRequest(http.MethodGet, "http://x/admin/api/v1/tokens", nil)
+	r.Header.Set("Authorization", "Bearer admin-scoped")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("admin-scoped token without ADMIN_TOKEN: got %d, want 200", w.Code)
+	}
 }
 
 func TestResolveAllowedOrigin(t *testing.T) {
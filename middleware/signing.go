@@ -0,0 +1,174 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers of a signed request. The signature is the hex HMAC-SHA256, keyed
+// with the token's signing secret, of the canonical request:
+//
+//	v1\n<METHOD>\n<path and query>\n<timestamp>\n<nonce>\n<hex SHA-256 of body>
+//
+// The timestamp is in Unix seconds; the nonce is a random string of 16 to 128
+// characters, never reused with the same key.
+const (
+	SignatureHeader          = "X-Signature"
+	SignatureKeyHeader       = "X-Signature-Key"
+	SignatureTimestampHeader = "X-Signature-Timestamp"
+	SignatureNonceHeader     = "X-Signature-Nonce"
+)
+
+// DefaultSignatureSkew is the default accepted clock difference between a
+// signed request's timestamp and the server.
+const DefaultSignatureSkew = 5 * time.Minute
+
+// SigningKeyLookup returns the token name and signing secret of a signing
+// key ID, if the token may use scope. It fails like TokenValidator.
+type SigningKeyLookup func(keyID, scope string) (name string, secret []byte, err error)
+
+// signatureError is a signed request that fails verification.
+type signatureError string
+
+func (e signatureError) Error() string { return string(e) }
+
+const (
+	errSignatureHeaders = signatureError("signed requests need X-Signature-Key, X-Signature-Timestamp and X-Signature-Nonce headers")
+	errSignatureNonce   = signatureError("signature nonce must be 16 to 128 characters")
+	errSignatureSkew    = signatureError("signature timestamp is outside the allowed clock skew")
+	errSignatureInvalid = signatureError("invalid request signature")
+	errSignatureReplay  = signatureError("signature nonce has already been used")
+)
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// SignatureString returns the canonical request string that is signed.
+func SignatureString(method, requestURI, timestamp, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return "v1\n" + method + "\n" + requestURI + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(sum[:])
+}
+
+// Sign returns the hex signature of a canonical request string.
+func Sign(secret []byte, canonical string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(canonical))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignedRequest checks r's signature headers and returns the token
+// name. The body is read to hash it and put back for the handler.
+func verifySignedRequest(r *http.Request, opts AuthOptions, scope string) (string, error) {
+	keyID := r.Header.Get(SignatureKeyHeader)
+	ts := r.Header.Get(SignatureTimestampHeader)
+	nonce := r.Header.Get(SignatureNonceHeader)
+	if keyID == "" || ts == "" || nonce == "" {
+		return "", errSignatureHeaders
+	}
+	if len(nonce) < 16 || len(nonce) > 128 {
+		return "", errSignatureNonce
+	}
+	skew := opts.MaxSkew
+	if skew <= 0 {
+		skew = DefaultSignatureSkew
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", errSignatureSkew
+	}
+	signedAt := time.Unix(unix, 0)
+	if d := time.Since(signedAt); d > skew || d < -skew {
+		return "", errSignatureSkew
+	}
+	name, secret, err := opts.SigningKey(keyID, scope)
+	if err != nil {
+		return "", err
+	}
+	var limit int64
+	if opts.MaxBody != nil {
+		limit = opts.MaxBody()
+	}
+	body, err := readBody(r, limit)
+	if err != nil {
+		return "", err
+	}
+	canonical := SignatureString(r.Method, r.URL.RequestURI(), ts, nonce, body)
+	got := strings.ToLower(r.Header.Get(SignatureHeader))
+	if !hmac.Equal([]byte(Sign(secret, canonical)), []byte(got)) {
+		return "", errSignatureInvalid
+	}
+	// Only a valid signature spends the nonce, so forged requests can't
+	// burn nonces a client is about to use.
+	if !opts.Nonces.Use(keyID, nonce, signedAt.Add(skew)) {
+		return "", errSignatureReplay
+	}
+	return name, nil
+}
+
+// readBody reads r's body, up to limit bytes, and replaces it with a copy.
+func readBody(r *http.Request, limit int64) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	if limit <= 0 {
+		limit = 10 << 20
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	_ = r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, errBodyTooLarge
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// NonceCache remembers signed-request nonces until their signatures expire,
+// so each can be used once. It is in memory: replicas keep separate caches,
+// and a restart forgets nonces still within the skew window.
+type NonceCache struct {
+	mu    sync.Mutex
+	max   int
+	seen  map[string]time.Time
+	clock func() time.Time
+}
+
+// NewNonceCache returns a cache holding at most max nonces. When full of
+// unexpired nonces it refuses new ones rather than forget any.
+func NewNonceCache(max int) *NonceCache {
+	return &NonceCache{max: max, seen: map[string]time.Time{}, clock: time.Now}
+}
+
+// Use records nonce for key until the given time and reports whether it was
+// unused.
+func (c *NonceCache) Use(key, nonce string, until time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock()
+	id := key + "\x00" + nonce
+	if exp, ok := c.seen[id]; ok && now.Before(exp) {
+		return false
+	}
+	if len(c.seen) >= c.max {
+		for k, exp := range c.seen {
+			if !now.Before(exp) {
+				delete(c.seen, k)
+			}
+		}
+		if len(c.seen) >= c.max {
+			return false
+		}
+	}
+	c.seen[id] = until
+	return true
+}
@@ -0,0 +1,124 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+)
+
+// MaxFallbackBrowsers caps fallback_browsers, bounding the attempts one
+// request makes.
+const MaxFallbackBrowsers = 5
+
+// BlockConditions decide when a response means the target blocked the
+// browser, so the next fallback browser is tried. Any matching condition
+// counts.
+type BlockConditions struct {
+	StatusCodes []int `json:"status_codes"`
+	// ErrorTypes are error_type or error_code values such as "ssl" or
+	// "connect_refused".
+	ErrorTypes []string `json:"error_types"`
+	// Headers maps response header names to regular expressions matched
+	// against their values.
+	Headers map[string]string `json:"headers"`
+	// BodyPatterns are regular expressions matched against a text body.
+	BodyPatterns []string `json:"body_patterns"`
+}
+
+// DefaultBlockConditions apply when a request with fallback browsers
+// doesn't set block_on.
+var DefaultBlockConditions = BlockConditions{
+	StatusCodes: []int{403, 429, 503},
+	ErrorTypes:  []string{"ssl", "network"},
+}
+
+// Limits on block_on, keeping matching cheap.
+const (
+	maxBlockPatterns      = 20
+	maxBlockPatternLength = 512
+)
+
+// BlockMatcher is a compiled BlockConditions.
+type BlockMatcher struct {
+	cond    BlockConditions
+	headers map[string]*regexp.Regexp
+	body    []*regexp.Regexp
+}
+
+// Compile checks the conditions and compiles their patterns.
+func (c BlockConditions) Compile() (*BlockMatcher, error) {
+	if len(c.Headers)+len(c.BodyPatterns) > maxBlockPatterns {
+		return nil, fmt.Errorf("block_on: at most %d header and body patterns", maxBlockPatterns)
+	}
+	m := &BlockMatcher{cond: c, headers: map[string]*regexp.Regexp{}}
+	compile := func(what, expr string) (*regexp.Regexp, error) {
+		if len(expr) > maxBlockPatternLength {
+			return nil, fmt.Errorf("block_on: %s pattern longer than %d characters", what, maxBlockPatternLength)
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("block_on: %s pattern: %w", what, err)
+		}
+		return re, nil
+	}
+	for name, expr := range c.Headers {
+		re, err := compile("header "+name, expr)
+		if err != nil {
+			return nil, err
+		}
+		m.headers[http.CanonicalHeaderKey(name)] = re
+	}
+	for _, expr := range c.BodyPatterns {
+		re, err := compile("body", expr)
+		if err != nil {
+			return nil, err
+		}
+		m.body = append(m.body, re)
+	}
+	return m, nil
+}
+
+// Match returns why resp counts as a block, or "" if it doesn't.
+func (m *BlockMatcher) Match(resp *ImpersonateResponse) string {
+	if !resp.Success {
+		if slices.Contains(m.cond.ErrorTypes, resp.ErrorType) {
+			return "error_type " + resp.ErrorType
+		}
+		if resp.ErrorCode != "" && slices.Contains(m.cond.ErrorTypes, resp.ErrorCode) {
+			return "error_code " + resp.ErrorCode
+		}
+		return ""
+	}
+	if slices.Contains(m.cond.StatusCodes, resp.StatusCode) {
+		return fmt.Sprintf("status %d", resp.StatusCode)
+	}
+	for name, re := range m.headers {
+		for _, value := range resp.Headers[name] {
+			if re.MatchString(value) {
+				return "header " + name
+			}
+		}
+	}
+	if !resp.BodyBase64 {
+		for _, re := range m.body {
+			if re.MatchString(resp.Body) {
+				return fmt.Sprintf("body matches %q", re.String())
+			}
+		}
+	}
+	return ""
+}
+
+// Attempt is one try of a request with fallback browsers or retries.
+type Attempt struct {
+	Browser    string `json:"browser"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	ErrorType  string `json:"error_type,omitempty"`
+	// Blocked says why the response counted as a block, if it did.
+	Blocked    string `json:"blocked,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+	// WaitMs is the backoff waited before this try, if it is a retry.
+	WaitMs int64 `json:"wait_ms,omitempty"`
+}
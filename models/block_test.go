@@ -0,0 +1,39 @@
+package models
+
+import "testing"
+
+func TestBlockMatcher(t *testing.T) {
+	m, err := BlockConditions{
+		StatusCodes:  []int{403},
+		ErrorTypes:   []string{"ssl", "connect_refused"},
+		Headers:      map[string]string{"cf-mitigated": "challenge"},
+		BodyPatterns: []string{`(?i)just a moment`},
+	}.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		resp ImpersonateResponse
+		want string
+	}{
+		{"status", ImpersonateResponse{Success: true, StatusCode: 403}, "status 403"},
+		{"error type", ImpersonateResponse{ErrorType: "ssl"}, "error_type ssl"},
+		{"error code", ImpersonateResponse{ErrorType: "network", ErrorCode: "connect_refused"}, "error_code connect_refused"},
+		{"other error code", ImpersonateResponse{ErrorType: "network", ErrorCode: "proxy"}, ""},
+		{"other error", ImpersonateResponse{ErrorType: "dns"}, ""},
+		{"header", ImpersonateResponse{Success: true, StatusCode: 200, Headers: map[string][]string{"Cf-Mitigated": {"challenge"}}}, "header Cf-Mitigated"},
+		{"body", ImpersonateResponse{Success: true, StatusCode: 200, Body: "<title>Just a moment...</title>"}, `body matches "(?i)just a moment"`},
+		{"binary body", ImpersonateResponse{Success: true, StatusCode: 200, Body: "just a moment", BodyBase64: true}, ""},
+		{"ok", ImpersonateResponse{Success: true, StatusCode: 200, Body: "hello"}, ""},
+	}
+	for _, tt := range tests {
+		if got := m.Match(&tt.resp); got != tt.want {
+			t.Errorf("%s: Match() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := (BlockConditions{Headers: map[string]string{"x": "["}}).Compile(); err == nil {
+		t.Error("invalid header pattern accepted")
+	}
+}
@@ -1,9 +1,23 @@
 package models
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"log"
+	"maps"
+	"math/rand/v2"
 	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type BrowserInfo struct {
@@ -18,80 +32,511 @@ type BrowserConfig struct {
 	Browser       BrowserInfo `json:"browser"`
 	Binary        string      `json:"binary"`
 	WrapperScript string      `json:"wrapper_script"`
+	// Base and Profile are set for custom profiles: Base is the built-in
+	// browser the profile extends, whose other fields it shares.
+	Base    string   `json:"base,omitempty"`
+	Profile *Profile `json:"profile,omitempty"`
 }
 
+// Target is the libcurl-impersonate target: the browser itself or, for a
+// custom profile, its base.
+func (b BrowserConfig) Target() string {
+	if b.Base != "" {
+		return b.Base
+	}
+	return b.Name
+}
+
+// LatestRule defines an alias, such as "chrome-latest", for the newest
+// browser of a family. Only desktop profiles (no device) are considered
+// unless Mobile is set; OS narrows the choice further.
+type LatestRule struct {
+	Alias  string `json:"alias"`
+	Family string `json:"family"`
+	OS     string `json:"os,omitempty"`
+	Mobile bool   `json:"mobile,omitempty"`
+}
+
+// BrowsersData is the browsers.json document.
 type BrowsersData struct {
 	Browsers []BrowserConfig `json:"browsers"`
+	// Aliases maps extra names to browsers.
+	Aliases map[string]string `json:"aliases,omitempty"`
+	// Latest defines per-family "latest" aliases, resolved on every load.
+	Latest []LatestRule `json:"latest,omitempty"`
+	// Profiles are custom profiles layered on the browsers.
+	Profiles []Profile `json:"profiles,omitempty"`
+	// Pools are weighted sets of browsers, aliases or profiles, requested
+	// by pool name; each request picks one.
+	Pools map[string]map[string]int `json:"pools,omitempty"`
+	// Default is the browser, or alias, used when a request names none.
+	Default string `json:"default"`
+}
+
+// weighted is a browser a pool or selector can pick, with its weight.
+type weighted struct {
+	// member is the name listed in the pool; browser what it resolves to.
+	member, browser string
+	weight          int
+}
+
+// TargetCheck reports whether the executor can impersonate a browser.
+type TargetCheck func(BrowserConfig) error
+
+// BrowserSet is a validated, immutable browsers.json. The current set is
+// replaced as a whole on reload, so readers never see a partial update.
+type BrowserSet struct {
+	// Path and LoadedAt describe where and when the set was loaded.
+	Path     string
+	LoadedAt time.Time
+
+	browsers map[string]BrowserConfig
+	names    []string
+	aliases  map[string]string
+	pools    map[string][]weighted
+	def      string
+	// file is the set as loaded from browsers.json, before stored profiles
+	// were added.
+	file *BrowserSet
 }
 
 var (
-	browsersCache  map[string]BrowserConfig
-	browserAliases = map[string]string{
-		"chrome-latest":  "chrome136",
-		"firefox-latest": "firefox135",
-		"edge-latest":    "edge101",
-		"safari-latest":  "safari260",
-		"tor-latest":     "tor145",
-	}
-	defaultBrowser = "chrome136"
+	browsers atomic.Pointer[BrowserSet]
+	// defaultOverride is a runtime replacement for the browsers.json
+	// default (see SetDefaultBrowser); "" means none.
+	defaultOverride atomic.Value
+
+	// loadMu serializes changes to the current set; storedProfiles are the
+	// profiles from the datastore, layered on every set loaded.
+	loadMu         sync.Mutex
+	storedProfiles []Profile
+
+	browserNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
 )
 
-// LoadBrowsers loads and caches browsers.json
-func LoadBrowsers(path string) error {
+func init() {
+	empty := &BrowserSet{browsers: map[string]BrowserConfig{}, aliases: map[string]string{}, pools: map[string][]weighted{}}
+	empty.file = empty
+	browsers.Store(empty)
+	defaultOverride.Store("")
+}
+
+// LoadBrowsers loads browsers.json and, if it is valid, makes it the
+// current set with the stored profiles added. check, if not nil, rejects
+// browsers the executor can't impersonate. On error the current set is
+// kept. Stored profiles that no longer fit, for example because their base
+// is gone, are logged and skipped.
+func LoadBrowsers(path string, check TargetCheck) error {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return fmt.Errorf("failed to read browsers.json: %w", err)
 	}
+	set, err := ParseBrowsers(data, check)
+	if err != nil {
+		return err
+	}
+	set.Path = path
+
+	loadMu.Lock()
+	defer loadMu.Unlock()
+	full, errs := set.withProfiles(storedProfiles)
+	for _, err := range errs {
+		log.Printf("Warning: skipping stored browser profile: %v", err)
+	}
+	browsers.Store(full)
+	return nil
+}
+
+// SetStoredProfiles replaces the profiles from the datastore and layers
+// them on the current browsers.json. Profiles that don't fit are skipped
+// and reported; the others take effect.
+func SetStoredProfiles(profiles []Profile) error {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+	storedProfiles = slices.Clone(profiles)
+	full, errs := browsers.Load().file.withProfiles(storedProfiles)
+	browsers.Store(full)
+	return errors.Join(errs...)
+}
+
+// CheckProfile reports whether p could be stored: it must be valid, its
+// base a browser or alias in browsers.json, and its name not taken by one.
+func CheckProfile(p Profile) error {
+	_, errs := browsers.Load().file.withProfiles([]Profile{p})
+	return errors.Join(errs...)
+}
+
+// ParseBrowsers parses and validates a browsers.json document, reporting
+// every problem found.
+func ParseBrowsers(data []byte, check TargetCheck) (*BrowserSet, error) {
+	var doc BrowsersData
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse browsers.json: %w", err)
+	}
+
+	set := &BrowserSet{
+		LoadedAt: time.Now(),
+		browsers: map[string]BrowserConfig{},
+		aliases:  map[string]string{},
+		pools:    map[string][]weighted{},
+	}
+	var errs []error
+	fail := func(format string, args ...any) { errs = append(errs, fmt.Errorf(format, args...)) }
+
+	if len(doc.Browsers) == 0 {
+		fail("no browsers defined")
+	}
+	for _, b := range doc.Browsers {
+		switch {
+		case b.Base != "" || b.Profile != nil:
+			fail("browser %s: base and profile belong in profiles", b.Name)
+			continue
+		case !validName(b.Name):
+			fail("browser %q: invalid name", b.Name)
+			continue
+		case set.browsers[b.Name].Name != "":
+			fail("browser %s: defined more than once", b.Name)
+			continue
+		case b.Browser.Name == "":
+			fail("browser %s: browser.name (the family) is required", b.Name)
+		}
+		if check != nil {
+			if err := check(b); err != nil {
+				fail("browser %s: %v", b.Name, err)
+			}
+		}
+		set.browsers[b.Name] = b
+		set.names = append(set.names, b.Name)
+	}
+	sort.Strings(set.names)
+
+	addAlias := func(alias, target, what string) {
+		switch {
+		case !validName(alias):
+			fail("%s %q: invalid name", what, alias)
+		case set.browsers[alias].Name != "":
+			fail("%s %s: clashes with a browser name", what, alias)
+		case set.aliases[alias] != "":
+			fail("%s %s: defined more than once", what, alias)
+		case set.browsers[target].Name == "":
+			fail("%s %s: unknown browser %q", what, alias, target)
+		default:
+			set.aliases[alias] = target
+		}
+	}
+	aliases := make([]string, 0, len(doc.Aliases))
+	for alias := range doc.Aliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		addAlias(alias, doc.Aliases[alias], "alias")
+	}
+	for _, rule := range doc.Latest {
+		target, ok := set.latest(rule)
+		if !ok {
+			fail("latest %s: no browser matches family %q", rule.Alias, rule.Family)
+			continue
+		}
+		addAlias(rule.Alias, target, "latest")
+	}
+	for _, p := range doc.Profiles {
+		p.Source = ProfileSourceFile
+		if err := set.addProfile(p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(doc.Pools)) {
+		members := doc.Pools[name]
+		switch {
+		case !validName(name):
+			fail("pool %q: invalid name", name)
+			continue
+		case set.resolve(name) != "":
+			fail("pool %s: clashes with a browser, alias or profile name", name)
+			continue
+		case len(members) == 0:
+			fail("pool %s: no members", name)
+			continue
+		}
+		var pool []weighted
+		for _, member := range slices.Sorted(maps.Keys(members)) {
+			switch browser := set.resolve(member); {
+			case browser == "":
+				fail("pool %s: unknown browser %q", name, member)
+			case members[member] <= 0:
+				fail("pool %s: weight of %s must be positive", name, member)
+			default:
+				pool = append(pool, weighted{member: member, browser: browser, weight: members[member]})
+			}
+		}
+		set.pools[name] = pool
+	}
 
-	var browsersData BrowsersData
-	if err := json.Unmarshal(data, &browsersData); err != nil {
-		return fmt.Errorf("failed to parse browsers.json: %w", err)
+	switch {
+	case doc.Default == "":
+		fail("default: required")
+	case set.resolve(doc.Default) == "" && len(set.candidates(doc.Default)) == 0:
+		fail("default: unknown browser or selector %q", doc.Default)
+	default:
+		set.def = doc.Default
 	}
 
-	browsersCache = make(map[string]BrowserConfig)
-	for _, browser := range browsersData.Browsers {
-		browsersCache[browser.Name] = browser
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid browsers.json: %w", errors.Join(errs...))
 	}
+	set.file = set
+	return set, nil
+}
 
+// addProfile layers p on the set's built-in browsers.
+func (s *BrowserSet) addProfile(p Profile) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	if s.browsers[p.Name].Name != "" || s.aliases[p.Name] != "" || s.pools[p.Name] != nil {
+		return fmt.Errorf("profile %s: clashes with a browser, alias or pool name", p.Name)
+	}
+	base, ok := s.browsers[s.resolve(p.Base)]
+	switch {
+	case !ok:
+		return fmt.Errorf("profile %s: unknown base %q", p.Name, p.Base)
+	case base.Base != "":
+		return fmt.Errorf("profile %s: base %s is itself a custom profile", p.Name, p.Base)
+	}
+	config := base
+	config.Name, config.Base, config.Profile = p.Name, base.Name, &p
+	s.browsers[p.Name] = config
+	i, _ := slices.BinarySearch(s.names, p.Name)
+	s.names = slices.Insert(s.names, i, p.Name)
 	return nil
 }
 
-// ResolveBrowserName resolves aliases and returns the actual browser name
-func ResolveBrowserName(name string) string {
+// withProfiles returns a copy of s with profiles added from the datastore.
+// Profiles that don't fit are skipped and reported.
+func (s *BrowserSet) withProfiles(profiles []Profile) (*BrowserSet, []error) {
+	out := *s
+	out.browsers = maps.Clone(s.browsers)
+	out.names = slices.Clone(s.names)
+	var errs []error
+	for _, p := range profiles {
+		p.Source = ProfileSourceStore
+		if err := out.addProfile(p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return &out, errs
+}
+
+// latest picks the newest browser matching rule.
+func (s *BrowserSet) latest(rule LatestRule) (string, bool) {
+	best := ""
+	for _, name := range s.names {
+		b := s.browsers[name].Browser
+		if b.Name != rule.Family || (b.Device != "") != rule.Mobile || (rule.OS != "" && b.OS != rule.OS) {
+			continue
+		}
+		if best == "" || compareVersions(b.Version, s.browsers[best].Browser.Version) > 0 {
+			best = name
+		}
+	}
+	return best, best != ""
+}
+
+// compareVersions compares dotted numeric versions such as "136.0.0.0".
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < max(len(as), len(bs)); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
+
+// resolve returns the browser a name or alias refers to, or "".
+func (s *BrowserSet) resolve(name string) string {
+	if target, ok := s.aliases[name]; ok {
+		return target
+	}
+	if _, ok := s.browsers[name]; ok {
+		return name
+	}
+	return ""
+}
+
+// Len returns the number of browsers.
+func (s *BrowserSet) Len() int { return len(s.names) }
+
+// Default returns the default named in browsers.json, which may be an alias.
+func (s *BrowserSet) Default() string { return s.def }
+
+// CurrentBrowsers returns the current browser set.
+func CurrentBrowsers() *BrowserSet {
+	return browsers.Load()
+}
+
+// ResolveBrowserName resolves aliases, pools and selectors and returns the
+// actual browser name. Pools and selectors pick a browser at random or,
+// when session is set, the same browser for every request with that
+// session (while the candidates don't change).
+func ResolveBrowserName(name, session string) string {
+	return browsers.Load().pick(name, session)
+}
+
+// pick resolves a requested name: the default when empty, aliases to their
+// browser, pools and selectors to one of their candidates, anything else
+// unchanged.
+func (s *BrowserSet) pick(name, session string) string {
 	if name == "" {
-		return defaultBrowser
+		name = s.defaultName()
 	}
-	if alias, ok := browserAliases[name]; ok {
-		return alias
+	if browser := s.resolve(name); browser != "" {
+		return browser
 	}
-	return name
+	candidates := s.candidates(name)
+	if len(candidates) == 0 {
+		return name
+	}
+	total := 0
+	for _, c := range candidates {
+		total += c.weight
+	}
+	var n int
+	if session == "" {
+		n = rand.IntN(total)
+	} else {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(name + "\x00" + session))
+		n = int(h.Sum64() % uint64(total))
+	}
+	for _, c := range candidates {
+		if n < c.weight {
+			return c.browser
+		}
+		n -= c.weight
+	}
+	return candidates[len(candidates)-1].browser
+}
+
+// candidates returns the browsers a pool or selector picks from, or nil.
+// Selectors match the built-in browsers: "random", "random:mobile",
+// "random:desktop", "random:<family>" and "os:<os>".
+func (s *BrowserSet) candidates(name string) []weighted {
+	if pool, ok := s.pools[name]; ok {
+		return pool
+	}
+	kind, value, _ := strings.Cut(name, ":")
+	var match func(BrowserInfo) bool
+	switch {
+	case name == "random":
+		match = func(BrowserInfo) bool { return true }
+	case kind == "random" && value == "mobile":
+		match = func(b BrowserInfo) bool { return b.Device != "" }
+	case kind == "random" && value == "desktop":
+		match = func(b BrowserInfo) bool { return b.Device == "" }
+	case kind == "random" && value != "":
+		match = func(b BrowserInfo) bool { return b.Name == value }
+	case kind == "os" && value != "":
+		match = func(b BrowserInfo) bool { return b.OS == value }
+	default:
+		return nil
+	}
+	var out []weighted
+	for _, n := range s.names {
+		if b := s.browsers[n]; b.Base == "" && match(b.Browser) {
+			out = append(out, weighted{member: n, browser: n, weight: 1})
+		}
+	}
+	return out
 }
 
-// GetBrowserConfig returns the browser configuration
+// validName reports whether name can be given to a browser, alias, profile
+// or pool; "random" is reserved for the selector.
+func validName(name string) bool {
+	return browserNamePattern.MatchString(name) && name != "random"
+}
+
+// GetBrowserConfig returns the browser configuration. Pools and selectors
+// pick a browser at random.
 func GetBrowserConfig(name string) (BrowserConfig, error) {
-	resolved := ResolveBrowserName(name)
-	config, ok := browsersCache[resolved]
+	set := browsers.Load()
+	config, ok := set.browsers[set.pick(name, "")]
 	if !ok {
 		return BrowserConfig{}, fmt.Errorf("unknown browser: %s", name)
 	}
 	return config, nil
 }
 
-// GetAllBrowsers returns all available browsers
+// GetAllBrowsers returns all available browsers, sorted by name.
 func GetAllBrowsers() []BrowserConfig {
-	browsers := make([]BrowserConfig, 0, len(browsersCache))
-	for _, browser := range browsersCache {
-		browsers = append(browsers, browser)
+	set := browsers.Load()
+	out := make([]BrowserConfig, 0, len(set.names))
+	for _, name := range set.names {
+		out = append(out, set.browsers[name])
 	}
-	return browsers
+	return out
 }
 
-// GetAliases returns all browser aliases
+// GetAliases returns all browser aliases, including the "latest" ones.
 func GetAliases() map[string]string {
-	return browserAliases
+	set := browsers.Load()
+	out := make(map[string]string, len(set.aliases))
+	for alias, target := range set.aliases {
+		out[alias] = target
+	}
+	return out
 }
 
-// GetDefaultBrowser returns the default browser name
+// GetPools returns the pools with their members' weights.
+func GetPools() map[string]map[string]int {
+	set := browsers.Load()
+	out := make(map[string]map[string]int, len(set.pools))
+	for name, pool := range set.pools {
+		out[name] = make(map[string]int, len(pool))
+		for _, c := range pool {
+			out[name][c.member] = c.weight
+		}
+	}
+	return out
+}
+
+// GetDefaultBrowser returns the default browser name, with an alias
+// resolved; a pool or selector is returned as is. A runtime default that no
+// longer resolves falls back to the browsers.json one.
 func GetDefaultBrowser() string {
-	return defaultBrowser
+	set := browsers.Load()
+	name := set.defaultName()
+	if browser := set.resolve(name); browser != "" {
+		return browser
+	}
+	return name
+}
+
+// defaultName returns the default as configured, unresolved.
+func (s *BrowserSet) defaultName() string {
+	if name := defaultOverride.Load().(string); name != "" && (s.resolve(name) != "" || len(s.candidates(name)) > 0) {
+		return name
+	}
+	return s.def
+}
+
+// SetDefaultBrowser changes the default browser. name must be a loaded
+// browser, or an alias, pool or selector that picks one; an alias keeps
+// following its target when browsers.json is reloaded.
+func SetDefaultBrowser(name string) error {
+	if _, err := GetBrowserConfig(name); err != nil {
+		return err
+	}
+	defaultOverride.Store(name)
+	return nil
 }
@@ -1,9 +1,19 @@
 package models
 
 import (
+	"os"
+	"slices"
+	"strings"
 	"testing"
 )
 
+func TestMain(m *testing.M) {
+	if err := LoadBrowsers("../browsers.json", nil); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
 func TestResolveBrowserName(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -22,7 +32,7 @@ func TestResolveBrowserName(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := ResolveBrowserName(tt.input)
+			result := ResolveBrowserName(tt.input, "")
 			if result != tt.expected {
 				t.Errorf("ResolveBrowserName(%q) = %q, want %q", tt.input, result, tt.expected)
 			}
@@ -48,3 +58,171 @@ func TestGetDefaultBrowser(t *testing.T) {
 		t.Errorf("GetDefaultBrowser() = %q, want %q", defaultBrowser, "chrome136")
 	}
 }
+
+func TestParseBrowsersLatestRules(t *testing.T) {
+	set, err := ParseBrowsers([]byte(`{
+		"default": "chrome-latest",
+		"latest": [
+			{"alias": "chrome-latest", "family": "chrome"},
+			{"alias": "chrome-mobile-latest", "family": "chrome", "mobile": true}
+		],
+		"aliases": {"stable": "chrome99"},
+		"browsers": [
+			{"name": "chrome99", "browser": {"name": "chrome", "version": "99.0.4844.51"}},
+			{"name": "chrome110", "browser": {"name": "chrome", "version": "110.0.0.0"}},
+			{"name": "chrome120_android", "browser": {"name": "chrome", "version": "120.0", "device": "pixel6"}}
+		]
+	}`), nil)
+	if err != nil {
+		t.Fatalf("ParseBrowsers: %v", err)
+	}
+	for name, want := range map[string]string{
+		"chrome-latest":        "chrome110",
+		"chrome-mobile-latest": "chrome120_android",
+		"stable":               "chrome99",
+		"":                     "chrome110",
+	} {
+		if got := set.pick(name, ""); got != want {
+			t.Errorf("resolve(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestParseBrowsersReportsEveryProblem(t *testing.T) {
+	_, err := ParseBrowsers([]byte(`{
+		"default": "netscape",
+		"latest": [{"alias": "opera-latest", "family": "opera"}],
+		"aliases": {"chrome99": "chrome99", "old": "chrome1"},
+		"browsers": [
+			{"name": "chrome99", "browser": {"name": "chrome", "version": "99"}},
+			{"name": "chrome99", "browser": {"name": "chrome", "version": "99"}},
+			{"name": "Bad Name", "browser": {"name": "chrome"}}
+		]
+	}`), func(b BrowserConfig) error {
+		if b.Name == "chrome99" {
+			return nil
+		}
+		return os.ErrNotExist
+	})
+	if err == nil {
+		t.Fatal("ParseBrowsers accepted an invalid document")
+	}
+	for _, want := range []string{
+		"default: unknown browser",
+		"latest opera-latest",
+		"alias chrome99: clashes",
+		"alias old: unknown browser",
+		"browser chrome99: defined more than once",
+		`browser "Bad Name": invalid name`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+	if _, err := ParseBrowsers([]byte(`{"default": "a", "browsers": [{"name": "a", "browser": {"name": "x"}}], "extra": 1}`), nil); err == nil {
+		t.Error("unknown field accepted")
+	}
+}
+
+func TestLoadBrowsersKeepsCurrentSetOnError(t *testing.T) {
+	before := CurrentBrowsers()
+	path := t.TempDir() + "/browsers.json"
+	if err := os.WriteFile(path, []byte(`{"browsers": []}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadBrowsers(path, nil); err == nil {
+		t.Fatal("empty browsers.json accepted")
+	}
+	if CurrentBrowsers() != before {
+		t.Fatal("failed reload replaced the browser set")
+	}
+}
+
+func TestSetDefaultBrowserFollowsAlias(t *testing.T) {
+	t.Cleanup(func() { defaultOverride.Store("") })
+	if err := SetDefaultBrowser("netscape4"); err == nil {
+		t.Fatal("unknown browser accepted")
+	}
+	if err := SetDefaultBrowser("firefox-latest"); err != nil {
+		t.Fatal(err)
+	}
+	if got := GetDefaultBrowser(); got != "firefox135" {
+		t.Fatalf("GetDefaultBrowser() = %q, want firefox135", got)
+	}
+}
+
+func TestSelectorsAndPools(t *testing.T) {
+	set, err := ParseBrowsers([]byte(`{
+		"default": "residential",
+		"pools": {"residential": {"chrome2": 3, "safari-latest": 1}},
+		"latest": [{"alias": "safari-latest", "family": "safari"}],
+		"browsers": [
+			{"name": "chrome1", "browser": {"name": "chrome", "version": "1", "os": "win10"}},
+			{"name": "chrome2", "browser": {"name": "chrome", "version": "2", "os": "macos"}},
+			{"name": "chrome2_android", "browser": {"name": "chrome", "version": "2", "os": "android", "device": "pixel6"}},
+			{"name": "safari1", "browser": {"name": "safari", "version": "1", "os": "macos"}}
+		],
+		"profiles": [{"name": "chrome-de", "base": "chrome2"}]
+	}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for selector, want := range map[string][]string{
+		"random":          {"chrome1", "chrome2", "chrome2_android", "safari1"},
+		"random:chrome":   {"chrome1", "chrome2", "chrome2_android"},
+		"random:mobile":   {"chrome2_android"},
+		"random:desktop":  {"chrome1", "chrome2", "safari1"},
+		"os:macos":        {"chrome2", "safari1"},
+		"residential":     {"chrome2", "safari1"},
+		"":                {"chrome2", "safari1"},
+		"random:netscape": {"random:netscape"},
+		"os:":             {"os:"},
+	} {
+		seen := map[string]int{}
+		for i := 0; i < 200; i++ {
+			seen[set.pick(selector, "")]++
+		}
+		for name := range seen {
+			if !slices.Contains(want, name) {
+				t.Errorf("pick(%q) = %q, want one of %v", selector, name, want)
+			}
+		}
+		if len(seen) != len(want) {
+			t.Errorf("pick(%q) chose %v, want all of %v", selector, seen, want)
+		}
+	}
+
+	// Pools honour their weights.
+	counts := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		counts[set.pick("residential", "")]++
+	}
+	if counts["chrome2"] < 2*counts["safari1"] {
+		t.Errorf("weights ignored: %v", counts)
+	}
+
+	// A session always gets the same browser.
+	first := set.pick("random", "session-1")
+	for i := 0; i < 20; i++ {
+		if got := set.pick("random", "session-1"); got != first {
+			t.Fatalf("session pick changed from %s to %s", first, got)
+		}
+	}
+
+	_, err = ParseBrowsers([]byte(`{
+		"default": "random:netscape",
+		"pools": {"chrome1": {"chrome1": 1}, "empty": {}, "bad": {"nope": 1, "chrome1": 0}},
+		"browsers": [{"name": "chrome1", "browser": {"name": "chrome"}}, {"name": "random", "browser": {"name": "chrome"}}]
+	}`), nil)
+	if err == nil {
+		t.Fatal("invalid pools accepted")
+	}
+	for _, want := range []string{
+		`browser "random": invalid name`, "pool chrome1: clashes", "pool empty: no members",
+		`pool bad: unknown browser "nope"`, "weight of chrome1 must be positive", `unknown browser or selector "random:netscape"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package models
+
+// Error types, reported as error_type: the broad class of a failed request.
+const (
+	ErrorTypeNetwork = "network"
+	ErrorTypeDNS     = "dns"
+	ErrorTypeTimeout = "timeout"
+	ErrorTypeSSL     = "ssl"
+	ErrorTypeSize    = "size"
+)
+
+// Error codes, reported as error_code: why a request failed, in more detail
+// than its error type.
+const (
+	ErrorCodeDNS              = "dns"
+	ErrorCodeConnectRefused   = "connect_refused"
+	ErrorCodeConnectFailed    = "connect_failed"
+	ErrorCodeTimeout          = "timeout"
+	ErrorCodeTLSHandshake     = "tls_handshake"
+	ErrorCodeCertInvalid      = "cert_invalid"
+	ErrorCodeTooManyRedirects = "too_many_redirects"
+	ErrorCodeProxy            = "proxy"
+	ErrorCodeHTTP2Stream      = "http2_stream"
+	ErrorCodeHTTP3            = "http3"
+	ErrorCodeEmptyReply       = "empty_reply"
+	ErrorCodeSendFailed       = "send_failed"
+	ErrorCodeRecvFailed       = "recv_failed"
+	ErrorCodePartialBody      = "partial_body"
+	ErrorCodeBadEncoding      = "bad_encoding"
+	ErrorCodeProtocol         = "protocol"
+	ErrorCodeSize             = "size"
+	ErrorCodeOther            = "curl_error"
+)
+
+// errorCodeTypes maps each error code to its error type.
+var errorCodeTypes = map[string]string{
+	ErrorCodeDNS:              ErrorTypeDNS,
+	ErrorCodeConnectRefused:   ErrorTypeNetwork,
+	ErrorCodeConnectFailed:    ErrorTypeNetwork,
+	ErrorCodeTimeout:          ErrorTypeTimeout,
+	ErrorCodeTLSHandshake:     ErrorTypeSSL,
+	ErrorCodeCertInvalid:      ErrorTypeSSL,
+	ErrorCodeTooManyRedirects: ErrorTypeNetwork,
+	ErrorCodeProxy:            ErrorTypeNetwork,
+	ErrorCodeHTTP2Stream:      ErrorTypeNetwork,
+	ErrorCodeHTTP3:            ErrorTypeNetwork,
+	ErrorCodeEmptyReply:       ErrorTypeNetwork,
+	ErrorCodeSendFailed:       ErrorTypeNetwork,
+	ErrorCodeRecvFailed:       ErrorTypeNetwork,
+	ErrorCodePartialBody:      ErrorTypeNetwork,
+	ErrorCodeBadEncoding:      ErrorTypeNetwork,
+	ErrorCodeProtocol:         ErrorTypeNetwork,
+	ErrorCodeSize:             ErrorTypeSize,
+	ErrorCodeOther:            ErrorTypeNetwork,
+}
+
+// ErrorTypeOf returns the error type of an error code.
+func ErrorTypeOf(code string) string {
+	if t, ok := errorCodeTypes[code]; ok {
+		return t
+	}
+	return ErrorTypeNetwork
+}
+
+// isErrorName reports whether name is an error type or error code.
+func isErrorName(name string) bool {
+	if _, ok := errorCodeTypes[name]; ok {
+		return true
+	}
+	switch name {
+	case ErrorTypeNetwork, ErrorTypeDNS, ErrorTypeTimeout, ErrorTypeSSL, ErrorTypeSize:
+		return true
+	}
+	return false
+}
+
+// hasError reports whether a failed resp's error type or code is in names.
+func (r *ImpersonateResponse) hasError(names []string) bool {
+	for _, name := range names {
+		if name == r.ErrorType || (r.ErrorCode != "" && name == r.ErrorCode) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Profile is a custom browser profile: a built-in browser (its base) with
+// its own headers and, optionally, different TLS and HTTP/2 settings.
+// Profiles are defined in browsers.json or stored from the admin UI and are
+// requested by name like any browser.
+type Profile struct {
+	Name string `json:"name"`
+	// Base is the built-in browser, or an alias of one, the profile extends.
+	Base string `json:"base"`
+	// Headers are sent with every request unless the request sets the same
+	// header. An empty value removes a header the base sends.
+	Headers map[string]string `json:"headers,omitempty"`
+	// HeaderOrder lists header names in the order they are sent; headers
+	// not listed follow in alphabetical order.
+	HeaderOrder []string `json:"header_order,omitempty"`
+	// AcceptLanguage sets the Accept-Language header.
+	AcceptLanguage string        `json:"accept_language,omitempty"`
+	TLS            *TLSOptions   `json:"tls,omitempty"`
+	HTTP2          *HTTP2Options `json:"http2,omitempty"`
+	// Source is where the profile was defined: ProfileSourceFile or
+	// ProfileSourceStore. It is set when the profile is loaded.
+	Source string `json:"source,omitempty"`
+}
+
+// Where a profile was defined.
+const (
+	ProfileSourceFile  = "file"
+	ProfileSourceStore = "store"
+)
+
+// TLSOptions change the base's TLS ClientHello. Empty fields keep the
+// base's value.
+type TLSOptions struct {
+	// Ciphers is a colon-separated cipher list, e.g.
+	// "TLS_AES_128_GCM_SHA256:ECDHE-ECDSA-AES128-GCM-SHA256".
+	Ciphers string `json:"ciphers,omitempty"`
+	// Curves is a colon-separated list of groups, e.g. "X25519:P-256".
+	Curves string `json:"curves,omitempty"`
+	// SignatureHashes is a colon-separated list of signature algorithms.
+	SignatureHashes string `json:"signature_hashes,omitempty"`
+	// ExtensionOrder is a dash-separated list of extension ids.
+	ExtensionOrder string `json:"extension_order,omitempty"`
+	// PermuteExtensions shuffles the extensions like Chrome 110+.
+	PermuteExtensions bool `json:"permute_extensions,omitempty"`
+}
+
+// HTTP2Options change the base's HTTP/2 fingerprint. Empty fields keep the
+// base's value.
+type HTTP2Options struct {
+	// PseudoHeadersOrder orders :method, :authority, :scheme and :path by
+	// their first letters, e.g. "masp".
+	PseudoHeadersOrder string `json:"pseudo_headers_order,omitempty"`
+	// Settings is the SETTINGS frame as id:value pairs, e.g.
+	// "1:65536;2:0;4:6291456;6:262144".
+	Settings string `json:"settings,omitempty"`
+	// WindowUpdate is the connection WINDOW_UPDATE increment.
+	WindowUpdate int `json:"window_update,omitempty"`
+}
+
+var (
+	headerNamePattern     = regexp.MustCompile(`^[A-Za-z0-9!#$%&'*+.^_|~-]+$`)
+	tlsListPattern        = regexp.MustCompile(`^[A-Za-z0-9_.+-]+(:[A-Za-z0-9_.+-]+)*$`)
+	extensionOrderPattern = regexp.MustCompile(`^[0-9]+(-[0-9]+)*$`)
+	h2SettingsPattern     = regexp.MustCompile(`^[0-9]+:[0-9]+(;[0-9]+:[0-9]+)*$`)
+)
+
+// Validate checks the profile on its own, reporting every problem found.
+// Whether its base exists is checked when it is added to a browser set.
+func (p *Profile) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) { errs = append(errs, fmt.Errorf(format, args...)) }
+
+	if !validName(p.Name) {
+		fail("invalid name %q", p.Name)
+	}
+	if p.Base == "" {
+		fail("base is required")
+	}
+	for name, value := range p.Headers {
+		if !headerNamePattern.MatchString(name) {
+			fail("invalid header name %q", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			fail("header %s: value contains a line break", name)
+		}
+		if p.AcceptLanguage != "" && strings.EqualFold(name, "Accept-Language") {
+			fail("set Accept-Language in headers or accept_language, not both")
+		}
+	}
+	seen := map[string]bool{}
+	for _, name := range p.HeaderOrder {
+		key := strings.ToLower(name)
+		if !headerNamePattern.MatchString(name) {
+			fail("header_order: invalid header name %q", name)
+		} else if seen[key] {
+			fail("header_order: %s listed more than once", name)
+		}
+		seen[key] = true
+	}
+	if strings.ContainsAny(p.AcceptLanguage, "\r\n") {
+		fail("accept_language contains a line break")
+	}
+	if t := p.TLS; t != nil {
+		for _, f := range []struct{ name, value string }{
+			{"ciphers", t.Ciphers}, {"curves", t.Curves}, {"signature_hashes", t.SignatureHashes},
+		} {
+			if f.value != "" && !tlsListPattern.MatchString(f.value) {
+				fail("tls.%s: want a colon-separated list of names", f.name)
+			}
+		}
+		if t.ExtensionOrder != "" && !extensionOrderPattern.MatchString(t.ExtensionOrder) {
+			fail("tls.extension_order: want dash-separated extension ids")
+		}
+	}
+	if h := p.HTTP2; h != nil {
+		if h.PseudoHeadersOrder != "" && !isPermutation(h.PseudoHeadersOrder, "masp") {
+			fail(`http2.pseudo_headers_order: want an ordering of "masp"`)
+		}
+		if h.Settings != "" && !h2SettingsPattern.MatchString(h.Settings) {
+			fail("http2.settings: want id:value pairs separated by ;")
+		}
+		if h.WindowUpdate < 0 {
+			fail("http2.window_update: must not be negative")
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("profile %s: %w", p.Name, errors.Join(errs...))
+	}
+	return nil
+}
+
+func isPermutation(s, letters string) bool {
+	if len(s) != len(letters) {
+		return false
+	}
+	for _, c := range letters {
+		if strings.Count(s, string(c)) != 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestHeaders merges a request's headers with the browser's custom
+// profile, if any, the request's winning. It returns "Name: value" lines in
+// the profile's header order, then alphabetical order; a profile header with
+// an empty value becomes "Name:", which removes it.
+func (b BrowserConfig) RequestHeaders(req map[string]string) []string {
+	type header struct{ name, line string }
+	merged := map[string]header{}
+	if p := b.Profile; p != nil {
+		for name, value := range p.Headers {
+			line := name + ":"
+			if value != "" {
+				line += " " + value
+			}
+			merged[strings.ToLower(name)] = header{name, line}
+		}
+		if p.AcceptLanguage != "" {
+			merged["accept-language"] = header{"Accept-Language", "Accept-Language: " + p.AcceptLanguage}
+		}
+	}
+	for name, value := range req {
+		merged[strings.ToLower(name)] = header{name, fmt.Sprintf("%s: %s", name, value)}
+	}
+
+	out := make([]string, 0, len(merged))
+	if p := b.Profile; p != nil {
+		for _, name := range p.HeaderOrder {
+			key := strings.ToLower(name)
+			if h, ok := merged[key]; ok {
+				out = append(out, h.line)
+				delete(merged, key)
+			}
+		}
+	}
+	rest := make([]string, 0, len(merged))
+	for key := range merged {
+		rest = append(rest, key)
+	}
+	sort.Strings(rest)
+	for _, key := range rest {
+		out = append(out, merged[key].line)
+	}
+	return out
+}
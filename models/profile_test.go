@@ -0,0 +1,108 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBrowsersProfiles(t *testing.T) {
+	set, err := ParseBrowsers([]byte(`{
+		"default": "chrome-de",
+		"latest": [{"alias": "chrome-latest", "family": "chrome"}],
+		"browsers": [
+			{"name": "chrome1", "browser": {"name": "chrome", "version": "1"}, "wrapper_script": "curl_chrome1"},
+			{"name": "chrome2", "browser": {"name": "chrome", "version": "2"}, "wrapper_script": "curl_chrome2"}
+		],
+		"profiles": [{"name": "chrome-de", "base": "chrome-latest", "accept_language": "de-DE"}]
+	}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := set.browsers["chrome-de"]
+	if got.Base != "chrome2" || got.WrapperScript != "curl_chrome2" || got.Target() != "chrome2" || got.Profile.Source != ProfileSourceFile {
+		t.Fatalf("chrome-de = %+v", got)
+	}
+	if set.resolve(set.Default()) != "chrome-de" {
+		t.Fatalf("default = %q", set.Default())
+	}
+
+	_, err = ParseBrowsers([]byte(`{
+		"default": "chrome2",
+		"browsers": [
+			{"name": "chrome1", "browser": {"name": "chrome"}, "base": "chrome0"},
+			{"name": "chrome2", "browser": {"name": "chrome"}}
+		],
+		"profiles": [
+			{"name": "a", "base": "nope"},
+			{"name": "chrome2", "base": "chrome2"},
+			{"name": "b", "base": "chrome1", "http2": {"pseudo_headers_order": "mas"}, "tls": {"ciphers": "A B"}}
+		]
+	}`), nil)
+	if err == nil {
+		t.Fatal("invalid profiles accepted")
+	}
+	for _, want := range []string{
+		"base and profile belong in profiles", `unknown base "nope"`, "clashes with a browser",
+		"http2.pseudo_headers_order", "tls.ciphers",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestStoredProfiles(t *testing.T) {
+	t.Cleanup(func() { _ = SetStoredProfiles(nil) })
+
+	if err := CheckProfile(Profile{Name: "chrome136", Base: "chrome131"}); err == nil {
+		t.Fatal("profile named after a browser accepted")
+	}
+	if err := CheckProfile(Profile{Name: "chrome-de", Base: "chrome-latest", HeaderOrder: []string{"a", "A"}}); err == nil {
+		t.Fatal("duplicate header_order accepted")
+	}
+
+	err := SetStoredProfiles([]Profile{
+		{Name: "chrome-de", Base: "chrome-latest", AcceptLanguage: "de-DE"},
+		{Name: "broken", Base: "netscape4"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("SetStoredProfiles() error = %v, want the broken profile reported", err)
+	}
+	config, err := GetBrowserConfig("chrome-de")
+	if err != nil || config.Base != "chrome136" || config.Profile.Source != ProfileSourceStore {
+		t.Fatalf("chrome-de = %+v, %v", config, err)
+	}
+
+	// Reloading browsers.json keeps the stored profiles.
+	if err := LoadBrowsers("../browsers.json", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetBrowserConfig("chrome-de"); err != nil {
+		t.Fatalf("stored profile lost on reload: %v", err)
+	}
+	if err := SetStoredProfiles(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetBrowserConfig("chrome-de"); err == nil {
+		t.Fatal("removed profile still resolves")
+	}
+}
+
+func TestRequestHeaders(t *testing.T) {
+	plain := BrowserConfig{Name: "chrome136"}
+	if got, want := plain.RequestHeaders(map[string]string{"B": "2", "A": "1"}), []string{"A: 1", "B: 2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("RequestHeaders() = %q, want %q", got, want)
+	}
+
+	profiled := BrowserConfig{Name: "p", Base: "chrome136", Profile: &Profile{
+		Headers:        map[string]string{"Sec-Ch-Ua-Platform": `"macOS"`, "X-Client": "web", "Priority": ""},
+		HeaderOrder:    []string{"sec-ch-ua-platform", "Accept-Language", "X-Missing"},
+		AcceptLanguage: "de-DE",
+	}}
+	got := profiled.RequestHeaders(map[string]string{"x-client": "api"})
+	want := []string{`Sec-Ch-Ua-Platform: "macOS"`, "Accept-Language: de-DE", "Priority:", "x-client: api"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("RequestHeaders() = %q, want %q", got, want)
+	}
+}
@@ -16,6 +16,45 @@ type ImpersonateRequest struct {
 	FollowRedirects bool              `json:"follow_redirects"`
 	Insecure        bool              `json:"insecure"`
 	Timeout         int               `json:"timeout"`
+	// Session, when set, makes pools and selectors such as "random:chrome"
+	// pick the same browser for every request with the same session.
+	Session string `json:"session"`
+	// FallbackBrowsers are tried in order when a response matches BlockOn.
+	FallbackBrowsers []string `json:"fallback_browsers"`
+	// BlockOn replaces DefaultBlockConditions for this request.
+	BlockOn *BlockConditions `json:"block_on"`
+	// Retry, when set, retries transient failures with backoff.
+	Retry *RetryPolicy `json:"retry"`
+	// IncludeCertInfo returns the certificate chain the target presented;
+	// IncludeCertPEM adds each certificate's PEM.
+	IncludeCertInfo bool `json:"include_cert_info"`
+	IncludeCertPEM  bool `json:"include_cert_pem"`
+	// CABundle and ClientCert name TLS credentials stored in the admin UI:
+	// a CA bundle that replaces the system CAs to verify the target, and a
+	// client certificate for mutual TLS.
+	CABundle   string `json:"ca_bundle"`
+	ClientCert string `json:"client_cert"`
+	// Credentials holds the PEM that CABundle and ClientCert name, resolved
+	// by the handler for the executor. It is never serialized.
+	Credentials *TLSCredentials `json:"-"`
+	// Proxy is an upstream proxy URL (http, https, socks4, socks5). Only
+	// replays from the admin UI set it; it is not part of the API.
+	Proxy string `json:"-"`
+}
+
+// TLSCredentials are the PEM-encoded TLS credentials of a request.
+type TLSCredentials struct {
+	CAPEM   string
+	CertPEM string
+	KeyPEM  string
+}
+
+// BlockConditions returns the conditions that trigger a fallback.
+func (r *ImpersonateRequest) BlockConditions() BlockConditions {
+	if r.BlockOn != nil {
+		return *r.BlockOn
+	}
+	return DefaultBlockConditions
 }
 
 // Validate validates the request
@@ -40,6 +79,29 @@ func (r *ImpersonateRequest) Validate(maxTimeout int) error {
 		return fmt.Errorf("timeout exceeds maximum allowed (%d seconds)", maxTimeout)
 	}
 
+	if len(r.FallbackBrowsers) > MaxFallbackBrowsers {
+		return fmt.Errorf("at most %d fallback_browsers", MaxFallbackBrowsers)
+	}
+	for _, name := range r.FallbackBrowsers {
+		if name == "" {
+			return fmt.Errorf("fallback_browsers: empty browser name")
+		}
+	}
+	if _, err := r.BlockConditions().Compile(); err != nil {
+		return err
+	}
+	if r.Retry != nil {
+		if err := r.Retry.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.CABundle != "" && r.Insecure {
+		return fmt.Errorf("ca_bundle and insecure are mutually exclusive")
+	}
+	if r.IncludeCertPEM && !r.IncludeCertInfo {
+		return fmt.Errorf("include_cert_pem requires include_cert_info")
+	}
+
 	return nil
 }
 
@@ -29,6 +29,53 @@ func TestImpersonateRequest_Validate(t *testing.T) {
 			maxTimeout: 120,
 			wantErr:    true,
 		},
+		{
+			name: "too many fallback browsers",
+			req: ImpersonateRequest{
+				URL:              "https://example.com",
+				FallbackBrowsers: []string{"a", "b", "c", "d", "e", "f"},
+			},
+			maxTimeout: 120,
+			wantErr:    true,
+		},
+		{
+			name: "invalid block pattern",
+			req: ImpersonateRequest{
+				URL:              "https://example.com",
+				FallbackBrowsers: []string{"firefox-latest"},
+				BlockOn:          &BlockConditions{BodyPatterns: []string{"("}},
+			},
+			maxTimeout: 120,
+			wantErr:    true,
+		},
+		{
+			name: "invalid retry policy",
+			req: ImpersonateRequest{
+				URL:   "https://example.com",
+				Retry: &RetryPolicy{MaxAttempts: 20},
+			},
+			maxTimeout: 120,
+			wantErr:    true,
+		},
+		{
+			name: "ca_bundle with insecure",
+			req: ImpersonateRequest{
+				URL:      "https://example.com",
+				CABundle: "partner-ca",
+				Insecure: true,
+			},
+			maxTimeout: 120,
+			wantErr:    true,
+		},
+		{
+			name: "cert PEM without cert info",
+			req: ImpersonateRequest{
+				URL:            "https://example.com",
+				IncludeCertPEM: true,
+			},
+			maxTimeout: 120,
+			wantErr:    true,
+		},
 		{
 			name: "timeout exceeds max",
 			req: ImpersonateRequest{
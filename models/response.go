@@ -3,25 +3,91 @@ package models
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 )
 
+// Timing holds curl's transfer timings in seconds. Queue and Redirect are
+// spent before the final transfer; the others are counted from its start:
+// NameLookup ends with DNS, Connect with TCP, AppConnect with the TLS
+// handshake, PreTransfer when the request is about to be sent and
+// StartTransfer with the first response byte.
 type Timing struct {
 	Total         float64 `json:"total"`
 	NameLookup    float64 `json:"namelookup"`
 	Connect       float64 `json:"connect"`
+	AppConnect    float64 `json:"appconnect"`
+	PreTransfer   float64 `json:"pretransfer"`
 	StartTransfer float64 `json:"starttransfer"`
+	Redirect      float64 `json:"redirect"`
+	Queue         float64 `json:"queue"`
+}
+
+// Connection describes the connection of the final transfer.
+type Connection struct {
+	RemoteIP   string `json:"remote_ip,omitempty"`
+	RemotePort int    `json:"remote_port,omitempty"`
+	LocalIP    string `json:"local_ip,omitempty"`
+	LocalPort  int    `json:"local_port,omitempty"`
+	// HTTPVersion is the negotiated version: "1.0", "1.1", "2" or "3".
+	HTTPVersion string `json:"http_version,omitempty"`
+	TLSVersion  string `json:"tls_version,omitempty"`
+	TLSCipher   string `json:"tls_cipher,omitempty"`
+	ALPN        string `json:"alpn,omitempty"`
+	// Reused is set when no new connection had to be opened.
+	Reused bool `json:"reused"`
+	// BytesUploaded and BytesDownloaded count headers and body, as sent and
+	// received on the wire.
+	BytesUploaded   int64 `json:"bytes_uploaded"`
+	BytesDownloaded int64 `json:"bytes_downloaded"`
 }
 
 type ImpersonateResponse struct {
-	Success    bool                `json:"success"`
+	Success bool `json:"success"`
+	// Browser is the browser actually used, after resolving aliases, pools
+	// and selectors.
+	Browser    string              `json:"browser,omitempty"`
 	StatusCode int                 `json:"status_code,omitempty"`
 	Headers    map[string][]string `json:"headers,omitempty"`
 	Body       string              `json:"body,omitempty"`
 	BodyBase64 bool                `json:"body_base64,omitempty"`
 	FinalURL   string              `json:"final_url,omitempty"`
 	Timing     *Timing             `json:"timing,omitempty"`
-	Error      string              `json:"error,omitempty"`
-	ErrorType  string              `json:"error_type,omitempty"`
+	Connection *Connection         `json:"connection,omitempty"`
+	// Certificates is the chain the target presented, leaf first, when
+	// the request set include_cert_info.
+	Certificates []Certificate `json:"certificates,omitempty"`
+	Error        string        `json:"error,omitempty"`
+	ErrorType    string        `json:"error_type,omitempty"`
+	// ErrorCode details ErrorType, e.g. "connect_refused" or
+	// "cert_invalid", and CurlCode is curl's own error code.
+	ErrorCode string `json:"error_code,omitempty"`
+	CurlCode  int    `json:"curl_code,omitempty"`
+	// Block is set when the response is a bot challenge or block page.
+	Block *Block `json:"block,omitempty"`
+	// Attempts lists every try, in order, when the request had fallback
+	// browsers or a retry policy.
+	Attempts []Attempt `json:"attempts,omitempty"`
+}
+
+// Certificate describes a certificate of the target's TLS chain.
+type Certificate struct {
+	Subject           string    `json:"subject"`
+	Issuer            string    `json:"issuer"`
+	SANs              []string  `json:"sans,omitempty"`
+	SerialNumber      string    `json:"serial_number"`
+	NotBefore         time.Time `json:"not_before"`
+	NotAfter          time.Time `json:"not_after"`
+	FingerprintSHA256 string    `json:"fingerprint_sha256"`
+	PEM               string    `json:"pem,omitempty"`
+}
+
+// Block identifies a bot challenge or block page: who served it, what kind
+// it is ("captcha", "js-challenge", "rate-limit" or "ban") and the rule that
+// matched.
+type Block struct {
+	Vendor string `json:"vendor"`
+	Kind   string `json:"kind"`
+	Rule   string `json:"rule"`
 }
 
 type ErrorResponse struct {
@@ -36,18 +102,60 @@ type HealthResponse struct {
 }
 
 type BrowsersResponse struct {
-	Browsers []BrowserConfig   `json:"browsers"`
-	Aliases  map[string]string `json:"aliases"`
-	Default  string            `json:"default"`
+	Browsers []BrowserConfig           `json:"browsers"`
+	Aliases  map[string]string         `json:"aliases"`
+	Pools    map[string]map[string]int `json:"pools,omitempty"`
+	Default  string                    `json:"default"`
 }
 
+// MetricsResponse reports counters since the process started; AllTime adds
+// everything persisted from previous runs.
 type MetricsResponse struct {
 	UptimeSeconds     int64            `json:"uptime_seconds"`
+	StartedAt         time.Time        `json:"started_at"`
 	RequestsTotal     int64            `json:"requests_total"`
 	RequestsSuccess   int64            `json:"requests_success"`
 	RequestsFailed    int64            `json:"requests_failed"`
 	AverageDurationMs float64          `json:"average_duration_ms"`
 	BrowsersUsed      map[string]int64 `json:"browsers_used"`
+	Blocks            *BlockMetrics    `json:"blocks"`
+	Latency           *LatencyMetrics  `json:"latency"`
+	AllTime           *AllTimeMetrics  `json:"all_time,omitempty"`
+}
+
+// BlockMetrics count responses detected as bot challenges or block pages.
+type BlockMetrics struct {
+	Total     int64            `json:"total"`
+	ByBrowser map[string]int64 `json:"by_browser"`
+	ByHost    map[string]int64 `json:"by_host"`
+	ByVendor  map[string]int64 `json:"by_vendor"`
+}
+
+// AllTimeMetrics are counters accumulated since Since (first boot or the last
+// explicit reset), surviving restarts.
+type AllTimeMetrics struct {
+	Since             time.Time        `json:"since"`
+	RequestsTotal     int64            `json:"requests_total"`
+	RequestsSuccess   int64            `json:"requests_success"`
+	RequestsFailed    int64            `json:"requests_failed"`
+	AverageDurationMs float64          `json:"average_duration_ms"`
+	BrowsersUsed      map[string]int64 `json:"browsers_used"`
+	Blocks            *BlockMetrics    `json:"blocks"`
+	Latency           *LatencyMetrics  `json:"latency"`
+}
+
+// LatencyMetrics average the upstream timings of successful requests, in
+// milliseconds.
+type LatencyMetrics struct {
+	Samples         int64   `json:"samples"`
+	QueueMs         float64 `json:"queue_ms"`
+	NameLookupMs    float64 `json:"namelookup_ms"`
+	ConnectMs       float64 `json:"connect_ms"`
+	AppConnectMs    float64 `json:"appconnect_ms"`
+	PreTransferMs   float64 `json:"pretransfer_ms"`
+	StartTransferMs float64 `json:"starttransfer_ms"`
+	RedirectMs      float64 `json:"redirect_ms"`
+	TotalMs         float64 `json:"total_ms"`
 }
 
 // Helper functions to create responses
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits on retry.
+const (
+	MaxRetryAttempts = 10
+	maxRetryBackoff  = time.Minute
+)
+
+// RetryPolicy retries a request on transient failures. All attempts share
+// the request timeout.
+type RetryPolicy struct {
+	// MaxAttempts counts the first try. Default 3.
+	MaxAttempts int `json:"max_attempts"`
+	// BackoffMs is the wait before the first retry; it doubles with each
+	// retry up to MaxBackoffMs. Waits are jittered. Defaults 500 and 10000.
+	BackoffMs    int `json:"backoff_ms"`
+	MaxBackoffMs int `json:"max_backoff_ms"`
+	// OnErrorTypes are error types or error codes; the default is timeout,
+	// network and dns.
+	OnErrorTypes []string `json:"on_error_types"`
+	// OnStatusCodes defaults to 429, 502, 503 and 504.
+	OnStatusCodes []int `json:"on_status_codes"`
+	// AllowNonIdempotent retries methods such as POST, which are otherwise
+	// tried once.
+	AllowNonIdempotent bool `json:"allow_non_idempotent"`
+}
+
+// idempotentMethods are retried without AllowNonIdempotent.
+var idempotentMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete,
+}
+
+// withDefaults fills in the unset fields.
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = 3
+	}
+	if p.BackoffMs == 0 {
+		p.BackoffMs = 500
+	}
+	if p.MaxBackoffMs == 0 {
+		p.MaxBackoffMs = max(10000, p.BackoffMs)
+	}
+	if p.OnErrorTypes == nil {
+		p.OnErrorTypes = []string{"timeout", "network", "dns"}
+	}
+	if p.OnStatusCodes == nil {
+		p.OnStatusCodes = []int{429, 502, 503, 504}
+	}
+	return p
+}
+
+// Validate checks the policy.
+func (p RetryPolicy) Validate() error {
+	p = p.withDefaults()
+	if p.MaxAttempts < 1 || p.MaxAttempts > MaxRetryAttempts {
+		return fmt.Errorf("retry.max_attempts must be 1-%d", MaxRetryAttempts)
+	}
+	limit := int(maxRetryBackoff.Milliseconds())
+	if p.BackoffMs < 0 || p.BackoffMs > limit {
+		return fmt.Errorf("retry.backoff_ms must be 0-%d", limit)
+	}
+	if p.MaxBackoffMs < p.BackoffMs || p.MaxBackoffMs > limit {
+		return fmt.Errorf("retry.max_backoff_ms must be between backoff_ms and %d", limit)
+	}
+	for _, t := range p.OnErrorTypes {
+		if !isErrorName(t) || t == ErrorTypeSize || t == ErrorCodeSize {
+			return fmt.Errorf("retry.on_error_types: %q is not a retryable error type or code", t)
+		}
+	}
+	for _, code := range p.OnStatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("retry.on_status_codes: invalid status %d", code)
+		}
+	}
+	return nil
+}
+
+// Retrier decides on the retries of one request.
+type Retrier struct {
+	policy RetryPolicy
+	// once is set when the method may not be retried.
+	once bool
+}
+
+// Retrier returns the request's retry decisions. A request without a retry
+// policy is tried once.
+func (r *ImpersonateRequest) Retrier() *Retrier {
+	if r.Retry == nil {
+		return &Retrier{once: true}
+	}
+	p := r.Retry.withDefaults()
+	return &Retrier{
+		policy: p,
+		once:   !p.AllowNonIdempotent && !slices.Contains(idempotentMethods, strings.ToUpper(r.Method)),
+	}
+}
+
+// Retry reports whether the attempt-th try (from 1), which got resp, should
+// be retried, and how long to wait first. A Retry-After header longer than
+// the backoff is honoured.
+func (rt *Retrier) Retry(attempt int, resp *ImpersonateResponse) (time.Duration, bool) {
+	if rt.once || attempt >= rt.policy.MaxAttempts {
+		return 0, false
+	}
+	if resp.Success {
+		if !slices.Contains(rt.policy.OnStatusCodes, resp.StatusCode) {
+			return 0, false
+		}
+	} else if !resp.hasError(rt.policy.OnErrorTypes) {
+		return 0, false
+	}
+
+	// Exponential backoff with equal jitter: half the step, plus a random
+	// part of the other half.
+	step := time.Duration(rt.policy.BackoffMs) * time.Millisecond << min(attempt-1, 20)
+	step = min(step, time.Duration(rt.policy.MaxBackoffMs)*time.Millisecond)
+	wait := step / 2
+	if step > 1 {
+		wait += rand.N(step - step/2)
+	}
+	if after, ok := retryAfter(resp, time.Now()); ok {
+		wait = max(wait, after)
+	}
+	return wait, true
+}
+
+// retryAfter reads a Retry-After header, in seconds or as an HTTP date.
+func retryAfter(resp *ImpersonateResponse, now time.Time) (time.Duration, bool) {
+	values := resp.Headers["Retry-After"]
+	if len(values) == 0 {
+		return 0, false
+	}
+	v := strings.TrimSpace(values[0])
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
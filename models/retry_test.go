@@ -0,0 +1,83 @@
+package models
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		wantErr bool
+	}{
+		{"defaults", RetryPolicy{}, false},
+		{"too many attempts", RetryPolicy{MaxAttempts: 11}, true},
+		{"negative backoff", RetryPolicy{BackoffMs: -1}, true},
+		{"max below backoff", RetryPolicy{BackoffMs: 2000, MaxBackoffMs: 1000}, true},
+		{"unknown error type", RetryPolicy{OnErrorTypes: []string{"size"}}, true},
+		{"invalid status", RetryPolicy{OnStatusCodes: []int{99}}, true},
+		{"custom", RetryPolicy{MaxAttempts: 5, BackoffMs: 100, OnErrorTypes: []string{"ssl"}, OnStatusCodes: []int{500}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRetrier(t *testing.T) {
+	get := &ImpersonateRequest{Method: "GET", Retry: &RetryPolicy{MaxAttempts: 3, BackoffMs: 100, MaxBackoffMs: 150}}
+	rt := get.Retrier()
+
+	timeout := &ImpersonateResponse{ErrorType: "timeout"}
+	// 100ms, then 200ms capped at 150ms, each jittered down to half.
+	if wait, ok := rt.Retry(1, timeout); !ok || wait < 50*time.Millisecond || wait >= 100*time.Millisecond {
+		t.Errorf("try 1: Retry() = %v, %v; want a wait in [50ms, 100ms)", wait, ok)
+	}
+	if wait, ok := rt.Retry(2, timeout); !ok || wait < 75*time.Millisecond || wait >= 150*time.Millisecond {
+		t.Errorf("try 2: Retry() = %v, %v; want a wait in [75ms, 150ms)", wait, ok)
+	}
+	if _, ok := rt.Retry(3, timeout); ok {
+		t.Error("retried past max_attempts")
+	}
+	if _, ok := rt.Retry(1, &ImpersonateResponse{ErrorType: "size"}); ok {
+		t.Error("retried a size error")
+	}
+	if _, ok := rt.Retry(1, &ImpersonateResponse{Success: true, StatusCode: 404}); ok {
+		t.Error("retried a 404")
+	}
+
+	limited := &ImpersonateResponse{Success: true, StatusCode: 429, Headers: map[string][]string{"Retry-After": {"2"}}}
+	if wait, ok := rt.Retry(1, limited); !ok || wait != 2*time.Second {
+		t.Errorf("Retry-After: Retry() = %v, %v; want 2s", wait, ok)
+	}
+
+	post := &ImpersonateRequest{Method: "post", Retry: &RetryPolicy{}}
+	if _, ok := post.Retrier().Retry(1, timeout); ok {
+		t.Error("retried a POST without allow_non_idempotent")
+	}
+	post.Retry.AllowNonIdempotent = true
+	if _, ok := post.Retrier().Retry(1, timeout); !ok {
+		t.Error("did not retry a POST with allow_non_idempotent")
+	}
+
+	if _, ok := (&ImpersonateRequest{Method: "GET"}).Retrier().Retry(1, timeout); ok {
+		t.Error("retried without a retry policy")
+	}
+}
+
+func TestRetryAfterDate(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	resp := &ImpersonateResponse{Headers: map[string][]string{"Retry-After": {now.Add(90 * time.Second).Format(http.TimeFormat)}}}
+	if d, ok := retryAfter(resp, now); !ok || d != 90*time.Second {
+		t.Errorf("retryAfter() = %v, %v; want 1m30s", d, ok)
+	}
+	resp.Headers["Retry-After"] = []string{"soon"}
+	if _, ok := retryAfter(resp, now); ok {
+		t.Error("accepted an invalid Retry-After")
+	}
+}
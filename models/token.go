@@ -0,0 +1,63 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// API token scopes. Each names an endpoint (or family of endpoints) a token
+// may call. A token without scopes may call all of them except the admin API,
+// which always needs ScopeAdmin explicitly.
+const (
+	ScopeImpersonate = "impersonate"
+	ScopeBrowsers    = "browsers"
+	ScopeMetrics     = "metrics"
+	ScopeDocs        = "docs"
+	ScopeBatch       = "batch"
+	ScopeJobs        = "jobs"
+	ScopeAdmin       = "admin"
+)
+
+// Scopes lists every known scope, in display order.
+var Scopes = []string{ScopeImpersonate, ScopeBrowsers, ScopeMetrics, ScopeDocs, ScopeBatch, ScopeJobs, ScopeAdmin}
+
+// Token validation failures. They are distinct so clients can tell an expired
+// token (rotate it) from a wrong one.
+var (
+	ErrTokenInvalid = errors.New("invalid authentication token")
+	ErrTokenExpired = errors.New("authentication token has expired")
+	ErrTokenScope   = errors.New("token is not allowed to access this endpoint")
+
+	// ErrSignatureRequired is returned for bearer use of a token that only
+	// accepts signed requests.
+	ErrSignatureRequired = errors.New("this token only accepts signed requests")
+	// ErrQueryTokenDisabled is returned for a token sent as ?token= when the
+	// deployment or the token forbids it.
+	ErrQueryTokenDisabled = errors.New("tokens in the query string are disabled; use the Authorization header")
+)
+
+// ErrUnknownScope is returned by ParseScopes for names not in Scopes.
+var ErrUnknownScope = errors.New("unknown scope")
+
+// ParseScopes normalizes a list of scope names, rejecting unknown ones.
+func ParseScopes(in []string) ([]string, error) {
+	var out []string
+	seen := map[string]bool{}
+	for _, s := range in {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == "" || seen[s] {
+			continue
+		}
+		known := false
+		for _, k := range Scopes {
+			known = known || k == s
+		}
+		if !known {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownScope, s)
+		}
+		seen[s] = true
+		out = append(out, s)
+	}
+	return out, nil
+}
@@ -0,0 +1,240 @@
+// Package oidc is a minimal OpenID Connect relying party for the admin UI:
+// provider discovery, the authorization-code flow with PKCE, and ID token
+// verification (RS256 and ES256) against the provider's published keys.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config describes the identity provider and this client's registration.
+type Config struct {
+	// Issuer is the provider's issuer URL; discovery reads
+	// <Issuer>/.well-known/openid-configuration.
+	Issuer       string
+	ClientID     string
+	ClientSecret string // empty for public clients
+	// RedirectURL is the registered callback, e.g.
+	// https://impersonate.example.com/admin/oidc/callback.
+	RedirectURL string
+	// Scopes requested in addition to "openid".
+	Scopes []string
+	// HTTPClient talks to the provider. nil uses a client with a 10s timeout.
+	HTTPClient *http.Client
+}
+
+// Client runs the authorization-code flow against one provider. Discovery
+// and key fetches happen lazily and are cached, so a provider that is down at
+// startup doesn't prevent the service from starting.
+type Client struct {
+	cfg  Config
+	http *http.Client
+
+	mu        sync.Mutex
+	meta      *providerMetadata
+	metaErr   error // the last failed discovery, kept for discoveryRetry
+	metaFetch time.Time
+	keys      map[string]any // kid → *rsa.PublicKey or *ecdsa.PublicKey
+	keysFetch time.Time
+}
+
+// discoveryRetry limits discovery attempts while the provider is failing,
+// since unauthenticated sign-in attempts trigger them.
+const discoveryRetry = 30 * time.Second
+
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// New validates cfg and returns a Client. It makes no network calls.
+func New(cfg Config) (*Client, error) {
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("oidc: issuer, client ID and redirect URL are required")
+	}
+	for _, raw := range []string{cfg.Issuer, cfg.RedirectURL} {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("oidc: invalid URL %q", raw)
+		}
+	}
+	hc := cfg.HTTPClient
+	if hc == nil {
+		hc = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Client{cfg: cfg, http: hc}, nil
+}
+
+// AuthRequest is one pending sign-in. State, Nonce and Verifier must be kept
+// (server-side) until the callback and passed to Exchange.
+type AuthRequest struct {
+	URL      string
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// Issuer is the provider's issuer URL, without a trailing slash.
+func (c *Client) Issuer() string { return c.cfg.Issuer }
+
+// NewAuthRequest returns the provider URL to send the browser to, with a
+// fresh state, nonce and PKCE (S256) code verifier.
+func (c *Client) NewAuthRequest(ctx context.Context) (*AuthRequest, error) {
+	meta, err := c.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req := &AuthRequest{State: randomString(), Nonce: randomString(), Verifier: randomString()}
+	sum := sha256.Sum256([]byte(req.Verifier))
+
+	scopes := append([]string{"openid"}, c.cfg.Scopes...)
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.cfg.ClientID},
+		"redirect_uri":          {c.cfg.RedirectURL},
+		"scope":                 {strings.Join(dedupe(scopes), " ")},
+		"state":                 {req.State},
+		"nonce":                 {req.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	req.URL = meta.AuthorizationEndpoint + sep + q.Encode()
+	return req, nil
+}
+
+// Exchange redeems an authorization code and returns the verified claims of
+// the ID token. nonce and verifier come from the matching AuthRequest.
+func (c *Client) Exchange(ctx context.Context, code, verifier, nonce string) (Claims, error) {
+	meta, err := c.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.cfg.RedirectURL},
+		"code_verifier": {verifier},
+		"client_id":     {c.cfg.ClientID},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.cfg.ClientID), url.QueryEscape(c.cfg.ClientSecret))
+	}
+
+	var tok struct {
+		IDToken   string `json:"id_token"`
+		Error     string `json:"error"`
+		ErrorDesc string `json:"error_description"`
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tok); err != nil {
+		return nil, fmt.Errorf("oidc: token response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || tok.Error != "" {
+		return nil, fmt.Errorf("oidc: token endpoint returned HTTP %d: %s %s", resp.StatusCode, tok.Error, tok.ErrorDesc)
+	}
+	if tok.IDToken == "" {
+		return nil, errors.New("oidc: token response has no id_token")
+	}
+	return c.verify(ctx, tok.IDToken, nonce, time.Now())
+}
+
+// metadata returns the discovery document, fetching it on first use.
+func (c *Client) metadata(ctx context.Context) (*providerMetadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.meta != nil {
+		return c.meta, nil
+	}
+	if c.metaErr != nil && time.Since(c.metaFetch) < discoveryRetry {
+		return nil, c.metaErr
+	}
+	meta, err := c.discover(ctx)
+	if err != nil {
+		c.metaErr, c.metaFetch = err, time.Now()
+		return nil, err
+	}
+	c.meta, c.metaErr = meta, nil
+	return c.meta, nil
+}
+
+func (c *Client) discover(ctx context.Context) (*providerMetadata, error) {
+	var meta providerMetadata
+	if err := c.getJSON(ctx, c.cfg.Issuer+"/.well-known/openid-configuration", &meta); err != nil {
+		return nil, fmt.Errorf("oidc: discovery: %w", err)
+	}
+	if strings.TrimSuffix(meta.Issuer, "/") != c.cfg.Issuer {
+		return nil, fmt.Errorf("oidc: discovery issuer %q does not match %q", meta.Issuer, c.cfg.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery document is missing endpoints")
+	}
+	return &meta, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: HTTP %d", u, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// randomString returns 32 random bytes, base64url-encoded (43 characters,
+// a valid PKCE verifier).
+func randomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func dedupe(in []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, s := range in {
+		if s != "" && !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,163 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zupolgec/curl-impersonate-service/oidc/oidctest"
+)
+
+func newTestClient(t *testing.T) (*Client, *oidctest.Server) {
+	t.Helper()
+	idp := oidctest.NewServer("impersonate", "s3cret")
+	t.Cleanup(idp.Close)
+	c, err := New(Config{
+		Issuer:       idp.Issuer(),
+		ClientID:     "impersonate",
+		ClientSecret: "s3cret",
+		RedirectURL:  "https://impersonate.example.com/admin/oidc/callback",
+		Scopes:       []string{"email", "groups"},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return c, idp
+}
+
+func TestAuthorizationCodeFlowWithPKCE(t *testing.T) {
+	c, idp := newTestClient(t)
+	idp.SetClaims(map[string]any{
+		"sub":          "u-42",
+		"email":        "alice@example.com",
+		"groups":       []string{"staff", "impersonate-admins"},
+		"realm_access": map[string]any{"roles": []string{"ops"}},
+	})
+	ctx := context.Background()
+
+	req, err := c.NewAuthRequest(ctx)
+	if err != nil {
+		t.Fatalf("NewAuthRequest: %v", err)
+	}
+	u, _ := url.Parse(req.URL)
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" || q.Get("scope") != "openid email groups" {
+		t.Fatalf("auth URL = %s", req.URL)
+	}
+	if strings.Contains(req.URL, req.Verifier) {
+		t.Fatal("code verifier leaked into the auth URL")
+	}
+
+	cb, err := idp.Authorize(req.URL)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	back, _ := url.Parse(cb)
+	if back.Query().Get("state") != req.State {
+		t.Fatalf("state = %q, want %q", back.Query().Get("state"), req.State)
+	}
+	code := back.Query().Get("code")
+
+	// A wrong verifier fails PKCE at the provider.
+	if _, err := c.Exchange(ctx, code, "wrong-verifier", req.Nonce); err == nil {
+		t.Fatal("exchange with wrong verifier succeeded")
+	}
+
+	cb, _ = idp.Authorize(req.URL)
+	back, _ = url.Parse(cb)
+	claims, err := c.Exchange(ctx, back.Query().Get("code"), req.Verifier, req.Nonce)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if claims.String("email") != "alice@example.com" {
+		t.Fatalf("email = %q", claims.String("email"))
+	}
+	if g := claims.Strings("groups"); len(g) != 2 || g[1] != "impersonate-admins" {
+		t.Fatalf("groups = %v", g)
+	}
+	if r := claims.Strings("realm_access.roles"); len(r) != 1 || r[0] != "ops" {
+		t.Fatalf("nested roles = %v", r)
+	}
+
+	// Codes are single-use.
+	if _, err := c.Exchange(ctx, back.Query().Get("code"), req.Verifier, req.Nonce); err == nil {
+		t.Fatal("code redeemed twice")
+	}
+}
+
+func TestVerifyRejectsBadTokens(t *testing.T) {
+	c, idp := newTestClient(t)
+	ctx := context.Background()
+	now := time.Now()
+	valid := func() map[string]any {
+		return map[string]any{
+			"iss": idp.Issuer(), "aud": "impersonate", "sub": "u-1", "nonce": "n",
+			"iat": now.Unix(), "exp": now.Add(time.Minute).Unix(),
+		}
+	}
+	if _, err := c.verify(ctx, idp.SignIDToken(valid()), "n", now); err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+
+	cases := map[string]func(m map[string]any){
+		"wrong issuer":   func(m map[string]any) { m["iss"] = "https://evil.example.com" },
+		"wrong audience": func(m map[string]any) { m["aud"] = "someone-else" },
+		"expired":        func(m map[string]any) { m["exp"] = now.Add(-time.Hour).Unix() },
+		"wrong nonce":    func(m map[string]any) { m["nonce"] = "other" },
+		"no subject":     func(m map[string]any) { delete(m, "sub") },
+		"foreign azp":    func(m map[string]any) { m["aud"] = []string{"impersonate", "x"}; m["azp"] = "x" },
+	}
+	for name, mutate := range cases {
+		m := valid()
+		mutate(m)
+		if _, err := c.verify(ctx, idp.SignIDToken(m), "n", now); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+
+	tok := idp.SignIDToken(valid())
+	parts := strings.Split(tok, ".")
+	if _, err := c.verify(ctx, parts[0]+"."+parts[1]+".AAAA", "n", now); err == nil {
+		t.Error("bad signature accepted")
+	}
+	// alg "none" is never accepted.
+	none := "eyJhbGciOiJub25lIiwia2lkIjoib2lkY3Rlc3Qta2V5In0." + parts[1] + "."
+	if _, err := c.verify(ctx, none, "n", now); err == nil {
+		t.Error("unsigned token accepted")
+	}
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	if _, err := New(Config{ClientID: "x", RedirectURL: "https://a/cb"}); err == nil {
+		t.Error("missing issuer accepted")
+	}
+	if _, err := New(Config{Issuer: "idp.example.com", ClientID: "x", RedirectURL: "https://a/cb"}); err == nil {
+		t.Error("issuer without scheme accepted")
+	}
+}
+
+func TestFailedDiscoveryIsNotRetriedAtOnce(t *testing.T) {
+	var hits atomic.Int32
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer idp.Close()
+	c, err := New(Config{Issuer: idp.URL, ClientID: "x", RedirectURL: "https://a/cb"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for range 3 {
+		if _, err := c.NewAuthRequest(context.Background()); err == nil {
+			t.Fatal("NewAuthRequest succeeded against a failing provider")
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Fatalf("discovery requests = %d, want 1", n)
+	}
+}
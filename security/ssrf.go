@@ -122,6 +122,48 @@ func (g *Guard) ValidateURL(rawURL string) error {
 	return nil
 }
 
+// proxySchemes are the proxy URL schemes curl understands and we allow.
+var proxySchemes = map[string]bool{
+	"http": true, "https": true, "socks4": true, "socks4a": true, "socks5": true, "socks5h": true,
+}
+
+// ValidateProxy validates an upstream proxy URL. Proxies may be given by IP,
+// and the target allowlist does not apply to them, but the denylist and the
+// internal-address checks do: a proxy on an internal address would otherwise
+// be a way around the target checks.
+func (g *Guard) ValidateProxy(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid proxy URL")
+	}
+	if !proxySchemes[strings.ToLower(u.Scheme)] {
+		return fmt.Errorf("proxy scheme not allowed: use http, https, socks4 or socks5")
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return fmt.Errorf("invalid proxy URL: missing host")
+	}
+	if _, denied := g.DenyHosts[host]; denied {
+		return fmt.Errorf("proxy host is denied: %s", host)
+	}
+	if g.AllowPrivate {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return g.checkIP(ip, host)
+	}
+	ips, err := g.resolver(host)
+	if err != nil || len(ips) == 0 {
+		return fmt.Errorf("could not resolve proxy host: %s", host)
+	}
+	for _, ip := range ips {
+		if err := g.checkIP(ip, host); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // checkIP rejects addresses that target the host itself or internal networks.
 func (g *Guard) checkIP(ip net.IP, host string) error {
 	if g.AllowPrivate {
@@ -115,3 +115,20 @@ func TestValidateURL_AllowAndDenyLists(t *testing.T) {
 		t.Fatalf("expected allowlisted host to pass, got %v", err)
 	}
 }
+
+func TestValidateProxy(t *testing.T) {
+	g := newTestGuard(false, map[string][]net.IP{
+		"proxy.example.com": {net.ParseIP("203.0.113.7")},
+		"internal.example":  {net.ParseIP("10.1.2.3")},
+	})
+	for _, raw := range []string{"http://proxy.example.com:8080", "socks5://proxy.example.com:1080"} {
+		if err := g.ValidateProxy(raw); err != nil {
+			t.Errorf("ValidateProxy(%q) = %v, want nil", raw, err)
+		}
+	}
+	for _, raw := range []string{"ftp://proxy.example.com", "http://internal.example:3128", "http://127.0.0.1:8080", "http://"} {
+		if err := g.ValidateProxy(raw); err == nil {
+			t.Errorf("ValidateProxy(%q) = nil, want error", raw)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package serve
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+)
+
+// Listen opens the listener to serve on: the Unix domain socket at socket
+// when set, otherwise TCP addr. A stale socket file left by a previous run
+// is removed; the new one gets mode.
+func Listen(addr, socket string, mode fs.FileMode) (net.Listener, error) {
+	if socket == "" {
+		return net.Listen("tcp", addr)
+	}
+	if st, err := os.Lstat(socket); err == nil {
+		if st.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", socket)
+		}
+		// Only remove the file if nothing is listening on it.
+		if conn, err := net.Dial("unix", socket); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", socket)
+		}
+		if err := os.Remove(socket); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socket, mode); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
@@ -0,0 +1,223 @@
+package serve
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zupolgec/curl-impersonate-service/middleware"
+	"github.com/zupolgec/curl-impersonate-service/models"
+)
+
+// testCert issues a certificate for cn signed by parent (self-signed when
+// parent is nil) and returns it with its key.
+func testCert(t *testing.T, cn string, serial int64, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func writePEM(t *testing.T, path string, cert *x509.Certificate, key *ecdsa.PrivateKey) {
+	t.Helper()
+	out := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if err := os.WriteFile(path, out, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if key != nil {
+		der, _ := x509.MarshalECPrivateKey(key)
+		keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		if err := os.WriteFile(path+".key", keyPEM, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMutualTLSMapsCertificateToToken(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := testCert(t, "test-ca", 1, nil, nil)
+	srvCert, srvKey := testCert(t, "localhost", 2, ca, caKey)
+	cliCert, cliKey := testCert(t, "worker-1", 3, ca, caKey)
+	writePEM(t, filepath.Join(dir, "ca.pem"), ca, nil)
+	writePEM(t, filepath.Join(dir, "server.pem"), srvCert, srvKey)
+
+	tlsCfg, _, err := TLSConfig(TLSOptions{
+		CertFile:     filepath.Join(dir, "server.pem"),
+		KeyFile:      filepath.Join(dir, "server.pem.key"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+		ClientAuth:   ClientAuthRequire,
+	})
+	if err != nil {
+		t.Fatalf("TLSConfig: %v", err)
+	}
+	ln, err := Listen("127.0.0.1:0", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validate := func(name, scope string) (string, error) { return name, nil }
+	auth := middleware.Auth(middleware.AuthOptions{
+		Validate:   func(string, string) (string, error) { return "", models.ErrTokenInvalid },
+		ClientCert: middleware.ClientCertValidator(middleware.ClientCertMap{"cn:worker-1": "scraper"}, validate),
+	}, models.ScopeImpersonate)
+	srv := &http.Server{TLSConfig: tlsCfg, Handler: auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, middleware.TokenName(r.Context()))
+	}))}
+	go func() { _ = srv.ServeTLS(ln, "", "") }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	client := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+	}
+	url := "https://" + ln.Addr().String() + "/"
+
+	resp, err := client(tls.Certificate{Certificate: [][]byte{cliCert.Raw}, PrivateKey: cliKey}).Get(url)
+	if err != nil {
+		t.Fatalf("mTLS request: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "scraper" {
+		t.Fatalf("mTLS request: %d %q, want 200 scraper", resp.StatusCode, body)
+	}
+	if _, err := client().Get(url); err == nil {
+		t.Fatal("request without a client certificate succeeded")
+	}
+}
+
+func TestCertReloaderPicksUpRenewal(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "server.pem")
+	ca, caKey := testCert(t, "test-ca", 1, nil, nil)
+	first, key := testCert(t, "localhost", 10, ca, caKey)
+	writePEM(t, path, first, key)
+
+	r, err := NewCertReloader(path, path+".key")
+	if err != nil {
+		t.Fatalf("NewCertReloader: %v", err)
+	}
+	now := time.Now()
+	r.now = func() time.Time { return now }
+	serial := func() int64 {
+		c, _ := r.GetCertificate(nil)
+		leaf, _ := x509.ParseCertificate(c.Certificate[0])
+		return leaf.SerialNumber.Int64()
+	}
+
+	renewed, key2 := testCert(t, "localhost", 11, ca, caKey)
+	writePEM(t, path, renewed, key2)
+	later := time.Now().Add(time.Minute)
+	_ = os.Chtimes(path, later, later)
+	if got := serial(); got != 10 {
+		t.Fatalf("reloaded before the check interval: serial %d", got)
+	}
+	now = now.Add(certCheckInterval)
+	if got := serial(); got != 11 {
+		t.Fatalf("serial after renewal = %d, want 11", got)
+	}
+
+	// A broken renewal keeps the working certificate.
+	if err := os.WriteFile(path, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Minute)
+	_ = os.Chtimes(path, later, later)
+	now = now.Add(certCheckInterval)
+	if got := serial(); got != 11 {
+		t.Fatalf("serial after broken renewal = %d, want 11", got)
+	}
+}
+
+func TestTLSConfigValidation(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := testCert(t, "test-ca", 1, nil, nil)
+	cert, key := testCert(t, "localhost", 2, ca, caKey)
+	writePEM(t, filepath.Join(dir, "server.pem"), cert, key)
+	base := TLSOptions{CertFile: filepath.Join(dir, "server.pem"), KeyFile: filepath.Join(dir, "server.pem.key")}
+
+	if cfg, _, err := TLSConfig(base); err != nil || cfg.ClientAuth != tls.NoClientCert {
+		t.Fatalf("plain TLS: %v", err)
+	}
+	bad := []TLSOptions{
+		{CertFile: base.CertFile},
+		{CertFile: base.CertFile, KeyFile: base.KeyFile, ClientAuth: ClientAuthRequire},
+		{CertFile: base.CertFile, KeyFile: base.KeyFile, ClientAuth: "sometimes", ClientCAFile: base.CertFile},
+		{CertFile: base.CertFile, KeyFile: base.CertFile},
+	}
+	for i, opts := range bad {
+		if _, _, err := TLSConfig(opts); err == nil {
+			t.Errorf("case %d: invalid options accepted", i)
+		}
+	}
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "impersonate.sock")
+
+	// A stale socket from a crashed run is replaced.
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: socket, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	ln, err := Listen("", socket, 0o600)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+	st, err := os.Stat(socket)
+	if err != nil || st.Mode().Perm() != 0o600 {
+		t.Fatalf("socket mode = %v, %v", st.Mode(), err)
+	}
+	if _, err := Listen("", socket, 0o600); err == nil {
+		t.Fatal("second listener on a live socket succeeded")
+	}
+
+	go func() { _ = http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})) }()
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatalf("dial socket: %v", err)
+	}
+	_ = conn.Close()
+
+	file := filepath.Join(t.TempDir(), "regular")
+	_ = os.WriteFile(file, nil, 0o600)
+	if _, err := Listen("", file, 0o600); err == nil {
+		t.Fatal("regular file replaced by a socket")
+	}
+}
@@ -0,0 +1,152 @@
+// Package serve sets up the listeners the service is served on: plain or
+// TLS over TCP, or a Unix domain socket, with certificates reloaded from disk
+// when they change.
+package serve
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Client certificate modes for TLSOptions.ClientAuth.
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
+// TLSOptions configures native TLS.
+type TLSOptions struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile holds the CAs that client certificates must chain to.
+	ClientCAFile string
+	// ClientAuth is ClientAuthNone, ClientAuthOptional (verify a certificate
+	// if one is sent) or ClientAuthRequire. "" means optional when
+	// ClientCAFile is set, otherwise none.
+	ClientAuth string
+}
+
+// certCheckInterval limits how often the certificate files are checked for
+// changes.
+const certCheckInterval = 10 * time.Second
+
+// CertReloader serves a certificate from files and reloads it when the files
+// change, so renewed certificates are picked up without a restart. A renewal
+// that fails to load keeps the previous certificate.
+type CertReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+	now     func() time.Time
+}
+
+// NewCertReloader loads the certificate and key, failing if they're invalid.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile, now: time.Now}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the certificate files now.
+func (r *CertReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.load()
+}
+
+func (r *CertReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("load TLS certificate: %w", err)
+	}
+	r.cert = &cert
+	r.modTime = r.filesModTime()
+	r.checked = r.now()
+	return nil
+}
+
+// filesModTime is the later modification time of the two files.
+func (r *CertReloader) filesModTime() time.Time {
+	var latest time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		if st, err := os.Stat(f); err == nil && st.ModTime().After(latest) {
+			latest = st.ModTime()
+		}
+	}
+	return latest
+}
+
+// GetCertificate is a tls.Config.GetCertificate callback.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now := r.now(); now.Sub(r.checked) >= certCheckInterval {
+		r.checked = now
+		if !r.filesModTime().Equal(r.modTime) {
+			if err := r.load(); err != nil {
+				log.Printf("Warning: keeping the current TLS certificate: %v", err)
+			} else {
+				log.Printf("Reloaded TLS certificate from %s", r.certFile)
+			}
+		}
+	}
+	return r.cert, nil
+}
+
+// TLSConfig builds the server TLS configuration and the reloader behind it.
+func TLSConfig(opts TLSOptions) (*tls.Config, *CertReloader, error) {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, nil, errors.New("TLS needs both a certificate and a key file")
+	}
+	reloader, err := NewCertReloader(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	mode := opts.ClientAuth
+	if mode == "" {
+		mode = ClientAuthNone
+		if opts.ClientCAFile != "" {
+			mode = ClientAuthOptional
+		}
+	}
+	switch mode {
+	case ClientAuthNone:
+		return cfg, reloader, nil
+	case ClientAuthOptional:
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, nil, fmt.Errorf("unknown client certificate mode %q (want none, optional or require)", mode)
+	}
+	if opts.ClientCAFile == "" {
+		return nil, nil, fmt.Errorf("client certificate mode %q needs a client CA file", mode)
+	}
+	pem, err := os.ReadFile(opts.ClientCAFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, nil, fmt.Errorf("client CA file %s holds no PEM certificates", opts.ClientCAFile)
+	}
+	cfg.ClientCAs = pool
+	return cfg, reloader, nil
+}
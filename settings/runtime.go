@@ -0,0 +1,125 @@
+package settings
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/zupolgec/curl-impersonate-service/config"
+	"github.com/zupolgec/curl-impersonate-service/models"
+)
+
+// Runtime holds the service settings that can change while it runs. Their
+// defaults come from config (and so from the environment).
+type Runtime struct {
+	*Registry
+
+	MaxTimeout          *Setting[int]
+	DefaultTimeout      *Setting[int]
+	MaxRequestBodySize  *Setting[int64]
+	MaxResponseBodySize *Setting[int64]
+	DefaultBrowser      *Setting[string]
+
+	SSRFAllowPrivate *Setting[bool]
+	SSRFAllowHTTP    *Setting[bool]
+	SSRFAllowIP      *Setting[bool]
+	SSRFDenyHosts    *Setting[[]string]
+	SSRFAllowHosts   *Setting[[]string]
+
+	CORSAllowedOrigins *Setting[[]string]
+	LogRetentionHours  *Setting[int]
+}
+
+// NewRuntime registers the runtime settings with defaults from cfg. Call
+// Load to apply stored overrides.
+func NewRuntime(cfg *config.Config, st Store) *Runtime {
+	const mb = 1 << 20
+	rt := &Runtime{
+		Registry: NewRegistry(st),
+
+		MaxTimeout: Int("max_timeout", "MAX_TIMEOUT", 0, 1, 3600,
+			"Longest request timeout a client may ask for, in seconds."),
+		DefaultTimeout: Int("default_timeout", "DEFAULT_TIMEOUT", 0, 1, 3600,
+			"Timeout for requests that don't set one, in seconds (capped at max_timeout)."),
+		MaxRequestBodySize: Int64("max_request_body_size", "MAX_REQUEST_BODY_SIZE", 0, 1024, 1024*mb,
+			"Largest accepted API request body, in bytes."),
+		MaxResponseBodySize: Int64("max_response_body_size", "MAX_RESPONSE_BODY_SIZE", 0, 1024, 1024*mb,
+			"Largest upstream response body returned, in bytes."),
+		DefaultBrowser: String("default_browser", "", models.CurrentBrowsers().Default(),
+			"Browser, alias, pool or selector used when a request doesn't name one (default from browsers.json).", validBrowser),
+
+		SSRFAllowPrivate: Bool("ssrf_allow_private", "SSRF_ALLOW_PRIVATE", false,
+			"Allow targets on loopback, private and link-local addresses."),
+		SSRFAllowHTTP: Bool("ssrf_allow_http", "SSRF_ALLOW_HTTP", false,
+			"Allow plain http:// targets."),
+		SSRFAllowIP: Bool("ssrf_allow_ip", "SSRF_ALLOW_IP", false,
+			"Allow targets addressed by raw IP."),
+		SSRFDenyHosts: List("ssrf_deny_hosts", "SSRF_DENY_HOSTS", []string{},
+			"Hostnames that are always blocked.", nil),
+		SSRFAllowHosts: List("ssrf_allow_hosts", "SSRF_ALLOW_HOSTS", []string{},
+			"If set, only these hostnames may be targeted.", nil),
+
+		CORSAllowedOrigins: List("cors_allowed_origins", "CORS_ALLOWED_ORIGINS", []string{},
+			`Origins allowed to call the API from a browser; "*" allows any.`, validOrigins),
+		LogRetentionHours: Int("log_retention_hours", "LOG_RETENTION_HOURS", 0, 0, 24*3650,
+			"How long raw usage logs are kept, in hours (0 keeps them forever)."),
+	}
+	rt.Add(
+		rt.MaxTimeout, rt.DefaultTimeout, rt.MaxRequestBodySize, rt.MaxResponseBodySize, rt.DefaultBrowser,
+		rt.SSRFAllowPrivate, rt.SSRFAllowHTTP, rt.SSRFAllowIP, rt.SSRFDenyHosts, rt.SSRFAllowHosts,
+		rt.CORSAllowedOrigins, rt.LogRetentionHours,
+	)
+	rt.SetDefaults(cfg)
+	rt.DefaultBrowser.OnChange(func(name string) { _ = models.SetDefaultBrowser(name) })
+	return rt
+}
+
+// SetDefaults takes the defaults from cfg, as when the configuration is
+// reloaded. Settings without an admin override change immediately.
+func (rt *Runtime) SetDefaults(cfg *config.Config) {
+	rt.MaxTimeout.SetDefault(cfg.MaxTimeout, cfg.Source("MAX_TIMEOUT"))
+	rt.DefaultTimeout.SetDefault(cfg.DefaultTimeout, cfg.Source("DEFAULT_TIMEOUT"))
+	rt.MaxRequestBodySize.SetDefault(cfg.MaxRequestBodySize, cfg.Source("MAX_REQUEST_BODY_SIZE"))
+	rt.MaxResponseBodySize.SetDefault(cfg.MaxResponseBodySize, cfg.Source("MAX_RESPONSE_BODY_SIZE"))
+	rt.SSRFAllowPrivate.SetDefault(cfg.SSRFAllowPrivate, cfg.Source("SSRF_ALLOW_PRIVATE"))
+	rt.SSRFAllowHTTP.SetDefault(cfg.SSRFAllowHTTP, cfg.Source("SSRF_ALLOW_HTTP"))
+	rt.SSRFAllowIP.SetDefault(cfg.SSRFAllowIP, cfg.Source("SSRF_ALLOW_IP"))
+	rt.SSRFDenyHosts.SetDefault(nonNil(cfg.SSRFDenyHosts), cfg.Source("SSRF_DENY_HOSTS"))
+	rt.SSRFAllowHosts.SetDefault(nonNil(cfg.SSRFAllowHosts), cfg.Source("SSRF_ALLOW_HOSTS"))
+	rt.CORSAllowedOrigins.SetDefault(nonNil(cfg.CORSAllowedOrigins), cfg.Source("CORS_ALLOWED_ORIGINS"))
+	rt.LogRetentionHours.SetDefault(cfg.LogRetentionHours, cfg.Source("LOG_RETENTION_HOURS"))
+}
+
+// Timeout returns the timeout for a request that asked for requested
+// seconds: the default when unset, capped at the maximum.
+func (rt *Runtime) Timeout(requested int) int {
+	if requested > 0 {
+		return requested
+	}
+	return min(rt.DefaultTimeout.Get(), rt.MaxTimeout.Get())
+}
+
+func validBrowser(name string) error {
+	if _, err := models.GetBrowserConfig(name); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validOrigins(origins []string) error {
+	if len(origins) == 0 {
+		return errors.New(`list at least one origin, or "*"`)
+	}
+	for _, o := range origins {
+		if o != "*" && !strings.HasPrefix(o, "http://") && !strings.HasPrefix(o, "https://") {
+			return errors.New("origins look like https://app.example.com")
+		}
+	}
+	return nil
+}
+
+func nonNil(v []string) []string {
+	if v == nil {
+		return []string{}
+	}
+	return v
+}
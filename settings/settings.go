@@ -0,0 +1,379 @@
+// Package settings is a registry of typed runtime settings. Each setting has
+// a default (from the environment or built in), may be overridden from the
+// admin UI or API with the override stored in the datastore, and notifies
+// listeners when its value changes so it takes effect without a restart.
+package settings
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/zupolgec/curl-impersonate-service/config"
+)
+
+// Sources of a setting's effective value. The first three are where the
+// default came from (see config.Config.Source).
+const (
+	SourceDefault = config.SourceDefault
+	SourceEnv     = config.SourceEnv
+	SourceFile    = config.SourceFile
+	SourceAdmin   = "admin"
+)
+
+// Store persists admin overrides. It is satisfied by *store.Store.
+type Store interface {
+	GetSetting(key, def string) string
+	SetSetting(key, value string) error
+	DeleteSetting(key string) error
+}
+
+// ErrUnknownSetting is returned for keys that aren't registered.
+var ErrUnknownSetting = errors.New("unknown setting")
+
+// Entry is a registered setting, independent of its type.
+type Entry interface {
+	Key() string
+	// Env is the environment variable (or config file key) that provides
+	// the default.
+	Env() string
+	Help() string
+	// Kind is "int", "bool", "string" or "list".
+	Kind() string
+	// Value and Default are the current and default values in text form.
+	Value() string
+	Default() string
+	// JSONValue is the current value for JSON output.
+	JSONValue() any
+	Source() string
+
+	// parse validates a text value and returns its canonical form.
+	parse(raw string) (string, error)
+	// parseJSON does the same for a JSON value.
+	parseJSON(raw json.RawMessage) (string, error)
+	// apply sets the value from a canonical text form; "" with override
+	// false restores the default.
+	apply(raw string, override bool)
+}
+
+// Setting is a typed setting. Read it with Get; OnChange listeners run after
+// every change.
+type Setting[T any] struct {
+	key, env, help, kind string
+
+	def      T
+	parseFn  func(string) (T, error)
+	formatFn func(T) string
+	validate func(T) error
+	// clean, if set, normalizes JSON input the way parseFn does text.
+	clean func(T) T
+
+	mu        sync.RWMutex
+	value     T
+	override  bool
+	defSource string
+	listeners []func(T)
+}
+
+func newSetting[T any](kind, key, env, help string, def T, parse func(string) (T, error), format func(T) string, validate func(T) error) *Setting[T] {
+	return &Setting[T]{
+		key: key, env: env, help: help, kind: kind,
+		def: def, value: def, defSource: SourceDefault,
+		parseFn: parse, formatFn: format, validate: validate,
+	}
+}
+
+// Get returns the current value.
+func (s *Setting[T]) Get() T {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.value
+}
+
+// OnChange registers fn to run with the new value after every change.
+func (s *Setting[T]) OnChange(fn func(T)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// SetDefault changes the default, recording where it came from. Unless
+// the setting is overridden, the new default takes effect and listeners run.
+func (s *Setting[T]) SetDefault(v T, source string) {
+	s.mu.Lock()
+	s.def, s.defSource = v, source
+	if s.override {
+		s.mu.Unlock()
+		return
+	}
+	s.value = v
+	listeners := slices.Clone(s.listeners)
+	s.mu.Unlock()
+	for _, fn := range listeners {
+		fn(v)
+	}
+}
+
+func (s *Setting[T]) Key() string    { return s.key }
+func (s *Setting[T]) Env() string    { return s.env }
+func (s *Setting[T]) Help() string   { return s.help }
+func (s *Setting[T]) Kind() string   { return s.kind }
+func (s *Setting[T]) Value() string  { return s.formatFn(s.Get()) }
+func (s *Setting[T]) JSONValue() any { return s.Get() }
+
+func (s *Setting[T]) Default() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.formatFn(s.def)
+}
+
+func (s *Setting[T]) Source() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.override {
+		return SourceAdmin
+	}
+	return s.defSource
+}
+
+func (s *Setting[T]) parse(raw string) (string, error) {
+	v, err := s.parseFn(strings.TrimSpace(raw))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", s.key, err)
+	}
+	return s.check(v)
+}
+
+func (s *Setting[T]) parseJSON(raw json.RawMessage) (string, error) {
+	var v T
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return "", fmt.Errorf("%s: want a %s value", s.key, s.kind)
+	}
+	if s.clean != nil {
+		v = s.clean(v)
+	}
+	return s.check(v)
+}
+
+func (s *Setting[T]) check(v T) (string, error) {
+	if s.validate != nil {
+		if err := s.validate(v); err != nil {
+			return "", fmt.Errorf("%s: %w", s.key, err)
+		}
+	}
+	return s.formatFn(v), nil
+}
+
+func (s *Setting[T]) apply(raw string, override bool) {
+	var v T
+	if override {
+		parsed, err := s.parseFn(raw)
+		if err != nil {
+			return
+		}
+		v = parsed
+	}
+	s.mu.Lock()
+	if !override {
+		v = s.def
+	}
+	s.value, s.override = v, override
+	listeners := slices.Clone(s.listeners)
+	s.mu.Unlock()
+	for _, fn := range listeners {
+		fn(v)
+	}
+}
+
+// Int is an integer setting limited to [min, max].
+func Int(key, env string, def, min, max int, help string) *Setting[int] {
+	return newSetting("int", key, env, help, def, strconv.Atoi, strconv.Itoa, func(v int) error {
+		if v < min || v > max {
+			return fmt.Errorf("must be between %d and %d", min, max)
+		}
+		return nil
+	})
+}
+
+// Int64 is a 64-bit integer setting limited to [min, max].
+func Int64(key, env string, def, min, max int64, help string) *Setting[int64] {
+	parse := func(s string) (int64, error) { return strconv.ParseInt(s, 10, 64) }
+	format := func(v int64) string { return strconv.FormatInt(v, 10) }
+	return newSetting("int", key, env, help, def, parse, format, func(v int64) error {
+		if v < min || v > max {
+			return fmt.Errorf("must be between %d and %d", min, max)
+		}
+		return nil
+	})
+}
+
+// Bool is a boolean setting.
+func Bool(key, env string, def bool, help string) *Setting[bool] {
+	return newSetting("bool", key, env, help, def, strconv.ParseBool, strconv.FormatBool, nil)
+}
+
+// String is a text setting; validate may be nil.
+func String(key, env, def, help string, validate func(string) error) *Setting[string] {
+	parse := func(s string) (string, error) { return s, nil }
+	format := func(s string) string { return s }
+	return newSetting("string", key, env, help, def, parse, format, validate)
+}
+
+// List is a list of strings, written comma- or newline-separated. Items are
+// trimmed and empty ones dropped; validate may be nil.
+func List(key, env string, def []string, help string, validate func([]string) error) *Setting[[]string] {
+	parse := func(s string) ([]string, error) {
+		return splitList(s), nil
+	}
+	format := func(v []string) string { return strings.Join(v, ",") }
+	check := func(v []string) error {
+		for _, item := range v {
+			if item == "" || strings.ContainsAny(item, ",\n") {
+				return fmt.Errorf("invalid item %q", item)
+			}
+		}
+		if validate != nil {
+			return validate(v)
+		}
+		return nil
+	}
+	set := newSetting("list", key, env, help, def, parse, format, check)
+	set.clean = func(v []string) []string {
+		out := []string{}
+		for _, item := range v {
+			if item = strings.TrimSpace(item); item != "" {
+				out = append(out, item)
+			}
+		}
+		return out
+	}
+	return set
+}
+
+func splitList(s string) []string {
+	out := []string{}
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// Registry holds settings in registration order.
+type Registry struct {
+	store   Store
+	mu      sync.Mutex
+	entries []Entry
+	byKey   map[string]Entry
+}
+
+// NewRegistry returns an empty registry persisting overrides in st.
+func NewRegistry(st Store) *Registry {
+	return &Registry{store: st, byKey: map[string]Entry{}}
+}
+
+// Add registers settings. Keys must be unique.
+func (r *Registry) Add(entries ...Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range entries {
+		if _, dup := r.byKey[e.Key()]; dup {
+			panic("settings: duplicate key " + e.Key())
+		}
+		r.entries = append(r.entries, e)
+		r.byKey[e.Key()] = e
+	}
+}
+
+// Entries returns the registered settings in registration order.
+func (r *Registry) Entries() []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.entries)
+}
+
+// Lookup returns the setting with the given key.
+func (r *Registry) Lookup(key string) (Entry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.byKey[key]
+	return e, ok
+}
+
+// Load applies the overrides stored in the datastore. An override that no
+// longer validates (for example after a limit changed) is logged and
+// ignored, leaving the default in effect.
+func (r *Registry) Load() {
+	for _, e := range r.Entries() {
+		raw := r.store.GetSetting(e.Key(), "")
+		if raw == "" {
+			continue
+		}
+		canonical, err := e.parse(raw)
+		if err != nil {
+			log.Printf("Warning: ignoring stored setting: %v", err)
+			continue
+		}
+		e.apply(canonical, true)
+	}
+}
+
+// Set validates raw, stores it as an override and applies it. It returns
+// the canonical value.
+func (r *Registry) Set(key, raw string) (string, error) {
+	e, ok := r.Lookup(key)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownSetting, key)
+	}
+	canonical, err := e.parse(raw)
+	if err != nil {
+		return "", err
+	}
+	return canonical, r.save(e, canonical)
+}
+
+// Validate checks raw for key without applying it, returning the canonical
+// value.
+func (r *Registry) Validate(key, raw string) (string, error) {
+	e, ok := r.Lookup(key)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownSetting, key)
+	}
+	return e.parse(raw)
+}
+
+// ValidateJSON is Validate for a JSON value.
+func (r *Registry) ValidateJSON(key string, raw json.RawMessage) (string, error) {
+	e, ok := r.Lookup(key)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownSetting, key)
+	}
+	return e.parseJSON(raw)
+}
+
+func (r *Registry) save(e Entry, canonical string) error {
+	if err := r.store.SetSetting(e.Key(), canonical); err != nil {
+		return err
+	}
+	e.apply(canonical, true)
+	return nil
+}
+
+// Reset removes the override of key, restoring its default.
+func (r *Registry) Reset(key string) error {
+	e, ok := r.Lookup(key)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownSetting, key)
+	}
+	if err := r.store.DeleteSetting(key); err != nil {
+		return err
+	}
+	e.apply("", false)
+	return nil
+}
@@ -0,0 +1,143 @@
+package settings
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/zupolgec/curl-impersonate-service/config"
+)
+
+// memStore is an in-memory Store.
+type memStore map[string]string
+
+func (m memStore) GetSetting(key, def string) string {
+	if v, ok := m[key]; ok {
+		return v
+	}
+	return def
+}
+
+func (m memStore) SetSetting(key, value string) error { m[key] = value; return nil }
+func (m memStore) DeleteSetting(key string) error     { delete(m, key); return nil }
+
+func TestRegistrySetResetAndSource(t *testing.T) {
+	st := memStore{}
+	reg := NewRegistry(st)
+	limit := Int("limit", "LIMIT", 10, 1, 100, "")
+	hosts := List("hosts", "", []string{}, "", nil)
+	reg.Add(limit, hosts)
+
+	var seen []int
+	limit.OnChange(func(v int) { seen = append(seen, v) })
+
+	if limit.Source() != SourceDefault {
+		t.Fatalf("source = %s, want default", limit.Source())
+	}
+	limit.SetDefault(10, SourceEnv)
+	if limit.Source() != SourceEnv || fmt.Sprint(seen) != "[10]" {
+		t.Fatalf("source = %s, want env; listener saw %v", limit.Source(), seen)
+	}
+
+	if v, err := reg.Set("limit", " 42 "); err != nil || v != "42" {
+		t.Fatalf("Set = %q, %v", v, err)
+	}
+	if limit.Get() != 42 || limit.Source() != SourceAdmin || st["limit"] != "42" {
+		t.Fatalf("after Set: %d from %s, stored %q", limit.Get(), limit.Source(), st["limit"])
+	}
+	if v, err := reg.Set("hosts", "a.com,\n b.com ,"); err != nil || v != "a.com,b.com" {
+		t.Fatalf("Set list = %q, %v", v, err)
+	}
+
+	if err := reg.Reset("limit"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if _, ok := st["limit"]; ok || limit.Get() != 10 || limit.Source() != SourceEnv {
+		t.Fatalf("after Reset: %d from %s", limit.Get(), limit.Source())
+	}
+	if fmt.Sprint(seen) != "[10 42 10]" {
+		t.Fatalf("listener saw %v", seen)
+	}
+
+	// A new default doesn't replace an override.
+	if _, err := reg.Set("limit", "50"); err != nil {
+		t.Fatal(err)
+	}
+	limit.SetDefault(20, SourceFile)
+	if limit.Get() != 50 || limit.Default() != "20" {
+		t.Fatalf("override lost: %d, default %s", limit.Get(), limit.Default())
+	}
+	if err := reg.Reset("limit"); err != nil || limit.Get() != 20 || limit.Source() != SourceFile {
+		t.Fatalf("after Reset: %d from %s, %v", limit.Get(), limit.Source(), err)
+	}
+}
+
+func TestRegistryValidation(t *testing.T) {
+	reg := NewRegistry(memStore{})
+	limit := Int("limit", "", 10, 1, 100, "")
+	flag := Bool("flag", "", false, "")
+	hosts := List("hosts", "", []string{}, "", nil)
+	reg.Add(limit, flag, hosts)
+
+	for _, tc := range []struct{ key, raw string }{
+		{"limit", "0"},
+		{"limit", "many"},
+		{"flag", "maybe"},
+	} {
+		if _, err := reg.Set(tc.key, tc.raw); err == nil {
+			t.Errorf("Set(%s, %q) succeeded", tc.key, tc.raw)
+		}
+	}
+	if limit.Get() != 10 || flag.Get() {
+		t.Fatal("invalid values were applied")
+	}
+	if _, err := reg.Set("nope", "1"); !errors.Is(err, ErrUnknownSetting) {
+		t.Fatalf("unknown key: %v", err)
+	}
+
+	if v, err := reg.ValidateJSON("hosts", json.RawMessage(`[" a.com ", ""]`)); err != nil || v != "a.com" {
+		t.Fatalf("ValidateJSON list = %q, %v", v, err)
+	}
+	if _, err := reg.ValidateJSON("hosts", json.RawMessage(`["a.com,b.com"]`)); err == nil {
+		t.Fatal("list item with a comma accepted")
+	}
+	if _, err := reg.ValidateJSON("limit", json.RawMessage(`"12"`)); err == nil {
+		t.Fatal("string accepted for an int")
+	}
+}
+
+func TestRegistryLoadIgnoresInvalidOverrides(t *testing.T) {
+	st := memStore{"limit": "500", "flag": "true"}
+	reg := NewRegistry(st)
+	limit := Int("limit", "", 10, 1, 100, "")
+	flag := Bool("flag", "", false, "")
+	reg.Add(limit, flag)
+	reg.Load()
+
+	if limit.Get() != 10 || limit.Source() != SourceDefault {
+		t.Fatalf("limit = %d from %s, want the default", limit.Get(), limit.Source())
+	}
+	if !flag.Get() || flag.Source() != SourceAdmin {
+		t.Fatalf("flag = %v from %s, want the override", flag.Get(), flag.Source())
+	}
+}
+
+func TestRuntime(t *testing.T) {
+	rt := NewRuntime(&config.Config{MaxTimeout: 60, DefaultTimeout: 90, CORSAllowedOrigins: []string{"*"}}, memStore{})
+	if got := rt.Timeout(0); got != 60 {
+		t.Fatalf("Timeout(0) = %d, want the default capped at 60", got)
+	}
+	if got := rt.Timeout(5); got != 5 {
+		t.Fatalf("Timeout(5) = %d", got)
+	}
+	if _, err := rt.Set("cors_allowed_origins", "app.example.com"); err == nil {
+		t.Fatal("origin without a scheme accepted")
+	}
+	if _, err := rt.Set("cors_allowed_origins", ""); err == nil {
+		t.Fatal("empty origin list accepted")
+	}
+	if _, err := rt.Set("default_browser", "netscape4"); err == nil {
+		t.Fatal("unknown browser accepted")
+	}
+}
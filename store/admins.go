@@ -0,0 +1,419 @@
+package store
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Admin roles, from least to most privileged. Viewers can read everything,
+// operators can also change tokens, settings and captures, and owners can
+// also manage admin accounts.
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleOwner    = "owner"
+)
+
+// Roles lists the admin roles in increasing order of privilege.
+var Roles = []string{RoleViewer, RoleOperator, RoleOwner}
+
+// RoleAtLeast reports whether role grants at least the privileges of min.
+func RoleAtLeast(role, min string) bool {
+	return roleRank(role) >= roleRank(min) && roleRank(min) > 0
+}
+
+func roleRank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Admin account errors.
+var (
+	ErrBadCredentials = errors.New("invalid username or password")
+	ErrLastOwner      = errors.New("at least one owner account must remain")
+)
+
+// AdminUser is an admin UI account.
+type AdminUser struct {
+	ID          int64
+	Username    string
+	Role        string
+	CreatedAt   time.Time
+	LastLoginAt *time.Time
+	// HasPassword is set for accounts that can sign in with a password.
+	HasPassword bool
+	// SSOIssuer and SSOSubject identify the single sign-on identity linked
+	// to the account, if any.
+	SSOIssuer  string
+	SSOSubject string
+}
+
+// AdminSession is a logged-in admin browser session.
+type AdminSession struct {
+	User      AdminUser
+	CSRF      string
+	ExpiresAt time.Time
+}
+
+// passwordIterations is the PBKDF2-SHA256 work factor for new password
+// hashes. Stored hashes record their own count, so it can be raised later.
+var passwordIterations = 600_000
+
+// pbkdf2Key derives password hashes; tests count its calls.
+var pbkdf2Key = pbkdf2.Key[hash.Hash]
+
+// hashPassword returns "pbkdf2-sha256$<iterations>$<salt>$<key>".
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2Key(sha256.New, password, salt, passwordIterations, 32)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// checkPassword verifies password against a hash from hashPassword.
+func checkPassword(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iter, err := strconv.Atoi(parts[1])
+	if err != nil || iter <= 0 {
+		return false
+	}
+	enc := base64.RawStdEncoding
+	salt, err1 := enc.DecodeString(parts[2])
+	want, err2 := enc.DecodeString(parts[3])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	got, err := pbkdf2Key(sha256.New, password, salt, iter, len(want))
+	return err == nil && subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// MinPasswordLength is the shortest accepted admin password.
+const MinPasswordLength = 12
+
+func validateAdmin(username, password, role string) error {
+	if username == "" {
+		return fmt.Errorf("username is required")
+	}
+	if password != "" && len(password) < MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	if roleRank(role) == 0 {
+		return fmt.Errorf("unknown role: %s", role)
+	}
+	return nil
+}
+
+// CreateAdminUser adds an admin account. An empty password creates an
+// account that can only sign in through single sign-on.
+func (s *Store) CreateAdminUser(username, password, role string) (*AdminUser, error) {
+	username = strings.TrimSpace(username)
+	if err := validateAdmin(username, password, role); err != nil {
+		return nil, err
+	}
+	hash := ""
+	if password != "" {
+		var err error
+		if hash, err = hashPassword(password); err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now().Unix()
+	res, err := s.db.Exec(`INSERT INTO admin_users (username, password_hash, role, created_at) VALUES (?, ?, ?, ?)`,
+		username, hash, role, now)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return nil, fmt.Errorf("username already exists: %s", username)
+		}
+		return nil, err
+	}
+	id, _ := res.LastInsertId()
+	return &AdminUser{ID: id, Username: username, Role: role, CreatedAt: time.Unix(now, 0)}, nil
+}
+
+// dummyPasswordHash is checked against when there is no account to check,
+// so failing takes the same time either way.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := hashPassword("no such account")
+	return hash
+})
+
+// AuthenticateAdmin checks a username and password, recording the login.
+func (s *Store) AuthenticateAdmin(username, password string) (*AdminUser, error) {
+	var u AdminUser
+	var hash string
+	var created int64
+	var lastLogin sql.NullInt64
+	err := s.db.QueryRow(`SELECT id, username, role, password_hash, created_at, last_login_at FROM admin_users WHERE username = ?`,
+		strings.TrimSpace(username)).Scan(&u.ID, &u.Username, &u.Role, &hash, &created, &lastLogin)
+	if err != nil || hash == "" {
+		// Take as long as a wrong password, so a response time doesn't tell
+		// which usernames exist.
+		checkPassword(dummyPasswordHash(), password)
+		return nil, ErrBadCredentials
+	}
+	if !checkPassword(hash, password) {
+		return nil, ErrBadCredentials
+	}
+	u.CreatedAt = time.Unix(created, 0)
+	u.LastLoginAt = timePtr(lastLogin)
+	_, _ = s.db.Exec(`UPDATE admin_users SET last_login_at = ? WHERE id = ?`, time.Now().Unix(), u.ID)
+	return &u, nil
+}
+
+// VerifyAdminPassword reports ErrBadCredentials unless password is the
+// account's current password.
+func (s *Store) VerifyAdminPassword(id int64, password string) error {
+	var hash string
+	err := s.db.QueryRow(`SELECT password_hash FROM admin_users WHERE id = ?`, id).Scan(&hash)
+	if err != nil || hash == "" || !checkPassword(hash, password) {
+		return ErrBadCredentials
+	}
+	return nil
+}
+
+// GetAdminUser returns the account with the given ID, or sql.ErrNoRows.
+func (s *Store) GetAdminUser(id int64) (*AdminUser, error) {
+	return s.getAdminUser(`id = ?`, id)
+}
+
+// GetAdminUserByName returns the account with the given username, or
+// sql.ErrNoRows.
+func (s *Store) GetAdminUserByName(username string) (*AdminUser, error) {
+	return s.getAdminUser(`username = ?`, username)
+}
+
+// GetAdminUserBySSO returns the account linked to an identity provider's
+// subject, or sql.ErrNoRows.
+func (s *Store) GetAdminUserBySSO(issuer, subject string) (*AdminUser, error) {
+	if subject == "" {
+		return nil, sql.ErrNoRows
+	}
+	return s.getAdminUser(`sso_issuer = ? AND sso_subject = ?`, issuer, subject)
+}
+
+// LinkAdminSSO links an account to an identity provider's subject, so single
+// sign-on with that identity signs in as the account. An empty subject
+// unlinks it.
+func (s *Store) LinkAdminSSO(id int64, issuer, subject string) error {
+	if subject == "" {
+		issuer = ""
+	}
+	res, err := s.db.Exec(`UPDATE admin_users SET sso_issuer = ?, sso_subject = ? WHERE id = ?`, issuer, subject, id)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return fmt.Errorf("that identity is already linked to another account")
+		}
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// adminUserColumns are the admin_users columns scanAdminUser reads.
+const adminUserColumns = `id, username, role, created_at, last_login_at, password_hash != '', sso_issuer, sso_subject`
+
+func scanAdminUser(row interface{ Scan(...any) error }) (*AdminUser, error) {
+	var u AdminUser
+	var created int64
+	var lastLogin sql.NullInt64
+	if err := row.Scan(&u.ID, &u.Username, &u.Role, &created, &lastLogin, &u.HasPassword, &u.SSOIssuer, &u.SSOSubject); err != nil {
+		return nil, err
+	}
+	u.CreatedAt = time.Unix(created, 0)
+	u.LastLoginAt = timePtr(lastLogin)
+	return &u, nil
+}
+
+func (s *Store) getAdminUser(where string, args ...any) (*AdminUser, error) {
+	return scanAdminUser(s.db.QueryRow(`SELECT `+adminUserColumns+` FROM admin_users WHERE `+where, args...))
+}
+
+// ListAdminUsers returns all admin accounts ordered by username.
+func (s *Store) ListAdminUsers() ([]AdminUser, error) {
+	rows, err := s.db.Query(`SELECT ` + adminUserColumns + ` FROM admin_users ORDER BY username`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var out []AdminUser
+	for rows.Next() {
+		u, err := scanAdminUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *u)
+	}
+	return out, rows.Err()
+}
+
+// CountAdminUsers returns the number of admin accounts.
+func (s *Store) CountAdminUsers() (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(1) FROM admin_users`).Scan(&n)
+	return n, err
+}
+
+// SetAdminRole changes an account's role. The last owner can't be demoted.
+func (s *Store) SetAdminRole(id int64, role string) error {
+	if roleRank(role) == 0 {
+		return fmt.Errorf("unknown role: %s", role)
+	}
+	if role != RoleOwner {
+		if err := s.keepAnOwner(id); err != nil {
+			return err
+		}
+	}
+	res, err := s.db.Exec(`UPDATE admin_users SET role = ? WHERE id = ?`, role, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SetAdminPassword replaces an account's password and ends its sessions.
+func (s *Store) SetAdminPassword(id int64, password string) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec(`UPDATE admin_users SET password_hash = ? WHERE id = ?`, hash, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	_, err = s.db.Exec(`DELETE FROM admin_sessions WHERE user_id = ?`, id)
+	return err
+}
+
+// DeleteAdminUser removes an account and its sessions. The last owner can't
+// be deleted.
+func (s *Store) DeleteAdminUser(id int64) error {
+	if err := s.keepAnOwner(id); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(`DELETE FROM admin_sessions WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`DELETE FROM admin_users WHERE id = ?`, id)
+	return err
+}
+
+// keepAnOwner fails if id is the only owner account.
+func (s *Store) keepAnOwner(id int64) error {
+	var others, isOwner int
+	if err := s.db.QueryRow(`SELECT COUNT(1) FROM admin_users WHERE role = ? AND id != ?`, RoleOwner, id).Scan(&others); err != nil {
+		return err
+	}
+	if err := s.db.QueryRow(`SELECT COUNT(1) FROM admin_users WHERE role = ? AND id = ?`, RoleOwner, id).Scan(&isOwner); err != nil {
+		return err
+	}
+	if isOwner == 1 && others == 0 {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func sessionKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAdminSession starts a session for userID lasting ttl and returns the
+// cookie value. Only its hash is stored.
+func (s *Store) CreateAdminSession(userID int64, ttl time.Duration) (string, error) {
+	token, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+	csrf, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	_, err = s.db.Exec(`INSERT INTO admin_sessions (id, user_id, csrf, created_at, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		sessionKey(token), userID, csrf, now.Unix(), now.Add(ttl).Unix())
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// GetAdminSession returns the live session for a cookie value, or
+// sql.ErrNoRows if it is unknown or expired.
+func (s *Store) GetAdminSession(token string) (*AdminSession, error) {
+	var sess AdminSession
+	var created, expires int64
+	var lastLogin sql.NullInt64
+	err := s.db.QueryRow(
+		`SELECT u.id, u.username, u.role, u.created_at, u.last_login_at, s.csrf, s.expires_at
+		 FROM admin_sessions s JOIN admin_users u ON u.id = s.user_id
+		 WHERE s.id = ? AND s.expires_at > ?`, sessionKey(token), time.Now().Unix(),
+	).Scan(&sess.User.ID, &sess.User.Username, &sess.User.Role, &created, &lastLogin, &sess.CSRF, &expires)
+	if err != nil {
+		return nil, err
+	}
+	sess.User.CreatedAt = time.Unix(created, 0)
+	sess.User.LastLoginAt = timePtr(lastLogin)
+	sess.ExpiresAt = time.Unix(expires, 0)
+	return &sess, nil
+}
+
+// DeleteAdminSession ends a session (logout).
+func (s *Store) DeleteAdminSession(token string) error {
+	_, err := s.db.Exec(`DELETE FROM admin_sessions WHERE id = ?`, sessionKey(token))
+	return err
+}
+
+// PurgeExpiredAdminSessions deletes sessions past their expiry.
+func (s *Store) PurgeExpiredAdminSessions() (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM admin_sessions WHERE expires_at <= ?`, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"strings"
+	"time"
+)
+
+// AuditEntry is one admin action. The audit log is append-only: the table
+// rejects updates and deletes.
+type AuditEntry struct {
+	ID     int64     `json:"id"`
+	TS     time.Time `json:"ts"`
+	Actor  string    `json:"actor"`
+	Action string    `json:"action"`
+	Target string    `json:"target,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+	IP     string    `json:"ip,omitempty"`
+}
+
+// AuditFilter narrows an audit-log query. Zero values mean "no constraint".
+type AuditFilter struct {
+	Since  time.Time
+	Until  time.Time
+	Actor  string
+	Action string // exact action, or a prefix ending in "." such as "token."
+
+	// Cursor continues a previous page; it is the NextCursor of that page.
+	Cursor string
+	// Limit caps the page size. Non-positive means 100.
+	Limit int
+}
+
+// AuditPage is one page of an audit-log query, newest first.
+type AuditPage struct {
+	Entries    []AuditEntry `json:"entries"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// AddAudit appends an entry to the audit log. The timestamp is set to now
+// unless e.TS is already set.
+func (s *Store) AddAudit(e AuditEntry) error {
+	ts := e.TS
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	_, err := s.db.Exec(`INSERT INTO audit_log (ts, actor, action, target, detail, ip) VALUES (?, ?, ?, ?, ?, ?)`,
+		ts.Unix(), e.Actor, e.Action, e.Target, e.Detail, e.IP)
+	return err
+}
+
+// QueryAudit returns one page of audit entries matching f, newest first.
+func (s *Store) QueryAudit(f AuditFilter) (*AuditPage, error) {
+	limit := f.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	var conds []string
+	var args []any
+	if !f.Since.IsZero() {
+		conds, args = append(conds, "ts >= ?"), append(args, f.Since.Unix())
+	}
+	if !f.Until.IsZero() {
+		conds, args = append(conds, "ts < ?"), append(args, f.Until.Unix())
+	}
+	if f.Actor != "" {
+		conds, args = append(conds, "actor = ?"), append(args, f.Actor)
+	}
+	if strings.HasSuffix(f.Action, ".") {
+		conds, args = append(conds, "substr(action, 1, ?) = ?"), append(args, len(f.Action), f.Action)
+	} else if f.Action != "" {
+		conds, args = append(conds, "action = ?"), append(args, f.Action)
+	}
+	if f.Cursor != "" {
+		ts, id, err := decodeLogCursor(f.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		conds, args = append(conds, "(ts < ? OR (ts = ? AND id < ?))"), append(args, ts, ts, id)
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	rows, err := s.db.Query(`SELECT id, ts, actor, action, target, detail, ip FROM audit_log`+where+
+		` ORDER BY ts DESC, id DESC LIMIT ?`, append(args, limit+1)...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	page := &AuditPage{}
+	for rows.Next() {
+		var e AuditEntry
+		var ts int64
+		if err := rows.Scan(&e.ID, &ts, &e.Actor, &e.Action, &e.Target, &e.Detail, &e.IP); err != nil {
+			return nil, err
+		}
+		e.TS = time.Unix(ts, 0)
+		page.Entries = append(page.Entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(page.Entries) > limit {
+		page.Entries = page.Entries[:limit]
+		last := page.Entries[limit-1]
+		page.NextCursor = encodeLogCursor(last.TS.Unix(), last.ID)
+	}
+	return page, nil
+}
+
+// AuditActions returns the distinct actions recorded so far, for filters.
+func (s *Store) AuditActions() ([]string, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT action FROM audit_log ORDER BY action`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var out []string
+	for rows.Next() {
+		var a string
+		if err := rows.Scan(&a); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
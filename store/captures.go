@@ -0,0 +1,241 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// CaptureSession is a time-boxed switch that records full request/response
+// pairs for one token.
+type CaptureSession struct {
+	ID        int64
+	TokenName string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Count     int64
+}
+
+// Active reports whether the session is still recording.
+func (c CaptureSession) Active() bool { return time.Now().Before(c.ExpiresAt) }
+
+// Capture is one recorded request/response pair. Secrets are redacted and
+// bodies truncated before it reaches the store.
+type Capture struct {
+	ID                int64               `json:"id"`
+	SessionID         int64               `json:"session_id"`
+	TS                time.Time           `json:"ts"`
+	TokenName         string              `json:"token_name"`
+	Browser           string              `json:"browser"`
+	Method            string              `json:"method"`
+	URL               string              `json:"url"`
+	RequestHeaders    map[string][]string `json:"request_headers"`
+	RequestBody       string              `json:"request_body,omitempty"`
+	RequestBodyBase64 bool                `json:"request_body_base64,omitempty"`
+	RequestTruncated  bool                `json:"request_truncated,omitempty"`
+	StatusCode        int                 `json:"status_code"`
+	ResponseHeaders   map[string][]string `json:"response_headers"`
+	ResponseBody      string              `json:"response_body,omitempty"`
+	ResponseBase64    bool                `json:"response_body_base64,omitempty"`
+	ResponseTruncated bool                `json:"response_truncated,omitempty"`
+	Success           bool                `json:"success"`
+	Error             string              `json:"error,omitempty"`
+	ErrorType         string              `json:"error_type,omitempty"`
+	// Timing is the executor's timing block, stored verbatim as JSON.
+	Timing     json.RawMessage `json:"timing,omitempty"`
+	DurationMs int64           `json:"duration_ms"`
+	ExpiresAt  time.Time       `json:"expires_at"`
+}
+
+// StartCapture opens a capture session for tokenName lasting d. Any session
+// already active for that token is ended first.
+func (s *Store) StartCapture(tokenName string, d time.Duration) (*CaptureSession, error) {
+	now := time.Now()
+	if _, err := s.db.Exec(`UPDATE capture_sessions SET expires_at = ? WHERE token_name = ? AND expires_at > ?`,
+		now.Unix(), tokenName, now.Unix()); err != nil {
+		return nil, err
+	}
+	res, err := s.db.Exec(`INSERT INTO capture_sessions (token_name, created_at, expires_at) VALUES (?, ?, ?)`,
+		tokenName, now.Unix(), now.Add(d).Unix())
+	if err != nil {
+		return nil, err
+	}
+	id, _ := res.LastInsertId()
+	return &CaptureSession{ID: id, TokenName: tokenName, CreatedAt: time.Unix(now.Unix(), 0), ExpiresAt: time.Unix(now.Add(d).Unix(), 0)}, nil
+}
+
+// StopCapture ends a capture session now. Captures already recorded are kept
+// until they expire.
+func (s *Store) StopCapture(id int64) error {
+	_, err := s.db.Exec(`UPDATE capture_sessions SET expires_at = ? WHERE id = ? AND expires_at > ?`,
+		time.Now().Unix(), id, time.Now().Unix())
+	return err
+}
+
+// ActiveCapture returns the active capture session for tokenName, if any.
+func (s *Store) ActiveCapture(tokenName string) (*CaptureSession, bool) {
+	var c CaptureSession
+	var created, expires int64
+	err := s.db.QueryRow(
+		`SELECT cs.id, cs.token_name, cs.created_at, cs.expires_at,
+		        (SELECT COUNT(1) FROM captures WHERE session_id = cs.id)
+		 FROM capture_sessions cs WHERE cs.token_name = ? AND cs.expires_at > ?
+		 ORDER BY cs.id DESC LIMIT 1`, tokenName, time.Now().Unix(),
+	).Scan(&c.ID, &c.TokenName, &created, &expires, &c.Count)
+	if err != nil {
+		return nil, false
+	}
+	c.CreatedAt, c.ExpiresAt = time.Unix(created, 0), time.Unix(expires, 0)
+	return &c, true
+}
+
+// ListCaptureSessions returns recent capture sessions, newest first.
+func (s *Store) ListCaptureSessions(limit int) ([]CaptureSession, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	rows, err := s.db.Query(
+		`SELECT cs.id, cs.token_name, cs.created_at, cs.expires_at,
+		        (SELECT COUNT(1) FROM captures WHERE session_id = cs.id)
+		 FROM capture_sessions cs ORDER BY cs.id DESC LIMIT ?`, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var out []CaptureSession
+	for rows.Next() {
+		var c CaptureSession
+		var created, expires int64
+		if err := rows.Scan(&c.ID, &c.TokenName, &created, &expires, &c.Count); err != nil {
+			return nil, err
+		}
+		c.CreatedAt, c.ExpiresAt = time.Unix(created, 0), time.Unix(expires, 0)
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// AddCapture stores a captured request/response pair.
+func (s *Store) AddCapture(c Capture) (int64, error) {
+	reqHeaders, err := json.Marshal(c.RequestHeaders)
+	if err != nil {
+		return 0, err
+	}
+	respHeaders, err := json.Marshal(c.ResponseHeaders)
+	if err != nil {
+		return 0, err
+	}
+	ts := c.TS
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	res, err := s.db.Exec(
+		`INSERT INTO captures (session_id, ts, token_name, browser, method, url,
+		   request_headers, request_body, request_body_base64, request_truncated,
+		   status_code, response_headers, response_body, response_body_base64, response_truncated,
+		   success, error, error_type, timing, duration_ms, expires_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.SessionID, ts.Unix(), c.TokenName, c.Browser, c.Method, c.URL,
+		string(reqHeaders), c.RequestBody, boolInt(c.RequestBodyBase64), boolInt(c.RequestTruncated),
+		c.StatusCode, string(respHeaders), c.ResponseBody, boolInt(c.ResponseBase64), boolInt(c.ResponseTruncated),
+		boolInt(c.Success), c.Error, c.ErrorType, string(c.Timing), c.DurationMs, c.ExpiresAt.Unix(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+const captureColumns = `id, session_id, ts, token_name, browser, method, url,
+	request_headers, request_body, request_body_base64, request_truncated,
+	status_code, response_headers, response_body, response_body_base64, response_truncated,
+	success, error, error_type, timing, duration_ms, expires_at`
+
+// ListCaptures returns recent unexpired captures, optionally for one session
+// (sessionID > 0), newest first.
+func (s *Store) ListCaptures(sessionID int64, limit int) ([]Capture, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	query := `SELECT ` + captureColumns + ` FROM captures WHERE expires_at > ?`
+	args := []any{time.Now().Unix()}
+	if sessionID > 0 {
+		query += ` AND session_id = ?`
+		args = append(args, sessionID)
+	}
+	rows, err := s.db.Query(query+` ORDER BY id DESC LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var out []Capture
+	for rows.Next() {
+		c, err := scanCapture(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// GetCapture returns one unexpired capture by id.
+func (s *Store) GetCapture(id int64) (*Capture, error) {
+	rows, err := s.db.Query(`SELECT `+captureColumns+` FROM captures WHERE id = ? AND expires_at > ?`, id, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, sql.ErrNoRows
+	}
+	c, err := scanCapture(rows)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// PurgeExpiredCaptures deletes expired captures and ended sessions that no
+// longer hold any, returning the number of captures removed.
+func (s *Store) PurgeExpiredCaptures() (int64, error) {
+	now := time.Now().Unix()
+	res, err := s.db.Exec(`DELETE FROM captures WHERE expires_at <= ?`, now)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	_, err = s.db.Exec(
+		`DELETE FROM capture_sessions WHERE expires_at <= ?
+		 AND NOT EXISTS (SELECT 1 FROM captures WHERE session_id = capture_sessions.id)`, now)
+	return n, err
+}
+
+func scanCapture(rows *sql.Rows) (Capture, error) {
+	var c Capture
+	var ts, expires int64
+	var reqHeaders, respHeaders, timing string
+	var reqB64, reqTrunc, respB64, respTrunc, success int
+	if err := rows.Scan(&c.ID, &c.SessionID, &ts, &c.TokenName, &c.Browser, &c.Method, &c.URL,
+		&reqHeaders, &c.RequestBody, &reqB64, &reqTrunc,
+		&c.StatusCode, &respHeaders, &c.ResponseBody, &respB64, &respTrunc,
+		&success, &c.Error, &c.ErrorType, &timing, &c.DurationMs, &expires); err != nil {
+		return c, err
+	}
+	c.TS, c.ExpiresAt = time.Unix(ts, 0), time.Unix(expires, 0)
+	c.RequestBodyBase64, c.RequestTruncated = reqB64 == 1, reqTrunc == 1
+	c.ResponseBase64, c.ResponseTruncated = respB64 == 1, respTrunc == 1
+	c.Success = success == 1
+	_ = json.Unmarshal([]byte(reqHeaders), &c.RequestHeaders)
+	_ = json.Unmarshal([]byte(respHeaders), &c.ResponseHeaders)
+	if timing != "" && timing != "null" {
+		c.Timing = json.RawMessage(timing)
+	}
+	return c, nil
+}
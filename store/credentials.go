@@ -0,0 +1,233 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Kinds of TLS credential, named after the request fields that use them.
+const (
+	CredentialCABundle   = "ca_bundle"
+	CredentialClientCert = "client_cert"
+)
+
+// ErrNoSecretsKey is returned when TLS credentials are stored or read
+// without a secrets key.
+var ErrNoSecretsKey = errors.New("no secrets key: set SECRETS_KEY")
+
+var credentialNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// Credential is a TLS credential for targets: a CA bundle to verify them
+// with, or a client certificate and key for mutual TLS. The PEM is stored
+// encrypted; the other fields describe it and are stored in the clear.
+type Credential struct {
+	Kind string
+	Name string
+	// Subject and Fingerprint (SHA-256) are the first certificate's;
+	// NotAfter is the earliest expiry of all of them.
+	Subject     string
+	Fingerprint string
+	Certs       int
+	NotAfter    time.Time
+	UpdatedAt   time.Time
+	// CertPEM holds the certificates, and KeyPEM the client certificate's
+	// private key. Only GetCredential fills them in.
+	CertPEM string `json:"-"`
+	KeyPEM  string `json:"-"`
+}
+
+// sealedCredential is the plaintext of Credential's encrypted column.
+type sealedCredential struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key,omitempty"`
+}
+
+// NewCredential checks a CA bundle (keyPEM empty) or client certificate and
+// key, and describes it.
+func NewCredential(kind, name, certPEM, keyPEM string) (Credential, error) {
+	c := Credential{Kind: kind, Name: name}
+	if !credentialNamePattern.MatchString(name) {
+		return c, fmt.Errorf("name must be 1-64 letters, digits, '.', '_' or '-'")
+	}
+	var certs []*x509.Certificate
+	var certOut strings.Builder
+	for rest := []byte(certPEM); ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return c, fmt.Errorf("certificate: unexpected PEM block %q", block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return c, fmt.Errorf("certificate %d: %w", len(certs)+1, err)
+		}
+		certs = append(certs, cert)
+		_ = pem.Encode(&certOut, block)
+	}
+	if len(certs) == 0 {
+		return c, fmt.Errorf("certificate: no PEM certificate found")
+	}
+
+	switch kind {
+	case CredentialCABundle:
+		if strings.TrimSpace(keyPEM) != "" {
+			return c, fmt.Errorf("a CA bundle has no private key")
+		}
+	case CredentialClientCert:
+		keyPEM = strings.TrimSpace(keyPEM) + "\n"
+		if _, err := tls.X509KeyPair([]byte(certOut.String()), []byte(keyPEM)); err != nil {
+			return c, fmt.Errorf("key: %w", err)
+		}
+	default:
+		return c, fmt.Errorf("unknown credential kind %q", kind)
+	}
+
+	sum := sha256.Sum256(certs[0].Raw)
+	c.Subject = certs[0].Subject.String()
+	c.Fingerprint = strings.ToUpper(hex.EncodeToString(sum[:]))
+	c.Certs = len(certs)
+	c.NotAfter = certs[0].NotAfter
+	for _, cert := range certs[1:] {
+		if cert.NotAfter.Before(c.NotAfter) {
+			c.NotAfter = cert.NotAfter
+		}
+	}
+	c.CertPEM, c.KeyPEM = certOut.String(), keyPEM
+	if kind == CredentialCABundle {
+		c.KeyPEM = ""
+	}
+	return c, nil
+}
+
+// SetSecretsKey sets the 32-byte AES-256-GCM key that encrypts stored TLS
+// credentials and request-signing secrets, sealing any signing secrets
+// stored before it was set. Imported API tokens are hashed with a key
+// derived from it too. Call it before seeding tokens and serving requests.
+func (s *Store) SetSecretsKey(key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	s.secrets = gcm
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("api token key"))
+	s.tokenKeys.secrets = mac.Sum(nil)
+	return s.sealSigningSecrets()
+}
+
+// HasSecretsKey reports whether TLS credentials can be stored and read.
+func (s *Store) HasSecretsKey() bool { return s.secrets != nil }
+
+// credentialAD binds a sealed credential to its kind and name, so it can't
+// be moved to another row.
+func credentialAD(kind, name string) []byte { return []byte(kind + "/" + name) }
+
+// SaveCredential creates or replaces a credential built by NewCredential.
+func (s *Store) SaveCredential(c Credential) error {
+	if s.secrets == nil {
+		return ErrNoSecretsKey
+	}
+	plain, err := json.Marshal(sealedCredential{Cert: c.CertPEM, Key: c.KeyPEM})
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, s.secrets.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := s.secrets.Seal(nonce, nonce, plain, credentialAD(c.Kind, c.Name))
+	_, err = s.db.Exec(`INSERT INTO tls_credentials (kind, name, subject, fingerprint, certs, not_after, sealed, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(kind, name) DO UPDATE SET subject = excluded.subject, fingerprint = excluded.fingerprint,
+			certs = excluded.certs, not_after = excluded.not_after, sealed = excluded.sealed, updated_at = excluded.updated_at`,
+		c.Kind, c.Name, c.Subject, c.Fingerprint, c.Certs, c.NotAfter.Unix(), sealed, time.Now().Unix())
+	return err
+}
+
+// ListCredentials describes the stored credentials, by kind and name,
+// without their PEM.
+func (s *Store) ListCredentials() ([]Credential, error) {
+	rows, err := s.db.Query(`SELECT kind, name, subject, fingerprint, certs, not_after, updated_at
+		FROM tls_credentials ORDER BY kind, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Credential
+	for rows.Next() {
+		var c Credential
+		var notAfter, updated int64
+		if err := rows.Scan(&c.Kind, &c.Name, &c.Subject, &c.Fingerprint, &c.Certs, &notAfter, &updated); err != nil {
+			return nil, err
+		}
+		c.NotAfter, c.UpdatedAt = time.Unix(notAfter, 0), time.Unix(updated, 0)
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// GetCredential returns a credential with its decrypted PEM, or
+// sql.ErrNoRows if there is none of that kind and name.
+func (s *Store) GetCredential(kind, name string) (Credential, error) {
+	c := Credential{Kind: kind, Name: name}
+	if s.secrets == nil {
+		return c, ErrNoSecretsKey
+	}
+	var sealed []byte
+	var notAfter, updated int64
+	err := s.db.QueryRow(`SELECT subject, fingerprint, certs, not_after, sealed, updated_at
+		FROM tls_credentials WHERE kind = ? AND name = ?`, kind, name).
+		Scan(&c.Subject, &c.Fingerprint, &c.Certs, &notAfter, &sealed, &updated)
+	if err != nil {
+		return c, err
+	}
+	c.NotAfter, c.UpdatedAt = time.Unix(notAfter, 0), time.Unix(updated, 0)
+
+	n := s.secrets.NonceSize()
+	if len(sealed) < n {
+		return c, fmt.Errorf("credential %s: corrupt", name)
+	}
+	plain, err := s.secrets.Open(nil, sealed[:n], sealed[n:], credentialAD(kind, name))
+	if err != nil {
+		return c, fmt.Errorf("credential %s: cannot decrypt, was SECRETS_KEY changed?", name)
+	}
+	var sc sealedCredential
+	if err := json.Unmarshal(plain, &sc); err != nil {
+		return c, err
+	}
+	c.CertPEM, c.KeyPEM = sc.Cert, sc.Key
+	return c, nil
+}
+
+// DeleteCredential removes a credential, returning sql.ErrNoRows if there is
+// none of that kind and name.
+func (s *Store) DeleteCredential(kind, name string) error {
+	res, err := s.db.Exec(`DELETE FROM tls_credentials WHERE kind = ? AND name = ?`, kind, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogEntry is a single usage-log record.
+type LogEntry struct {
+	ID         int64     `json:"id"`
+	TS         time.Time `json:"ts"`
+	TokenName  string    `json:"token_name"`
+	Browser    string    `json:"browser"`
+	Method     string    `json:"method"`
+	TargetHost string    `json:"target_host"`
+	StatusCode int       `json:"status_code"`
+	Success    bool      `json:"success"`
+	DurationMs int64     `json:"duration_ms"`
+	ErrorType  string    `json:"error_type,omitempty"`
+	// Replay marks requests re-run from the admin UI.
+	Replay bool `json:"replay,omitempty"`
+	// BlockVendor and BlockKind are set when the response was detected as a
+	// bot challenge or block page.
+	BlockVendor string `json:"block_vendor,omitempty"`
+	BlockKind   string `json:"block_kind,omitempty"`
+}
+
+// LogFilter narrows a usage-log query. Zero values mean "no constraint".
+type LogFilter struct {
+	Since      time.Time
+	Until      time.Time
+	TokenName  string
+	Browser    string
+	TargetHost string
+	Success    *bool
+	StatusCode int
+	ErrorType  string
+	Replay     *bool
+	Blocked    *bool
+	// BlockVendor narrows to blocks served by this vendor.
+	BlockVendor string
+
+	// Cursor continues a previous page; it is the NextCursor of that page.
+	Cursor string
+	// Limit caps the page size. Non-positive means 100.
+	Limit int
+}
+
+// LogPage is one page of a usage-log query, newest first.
+type LogPage struct {
+	Logs []LogEntry `json:"logs"`
+	// NextCursor is empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+const logColumns = `id, ts, token_name, browser, method, target_host, status_code, success, duration_ms, error_type, replay, block_vendor, block_kind`
+
+// AddLog inserts a usage-log entry. The timestamp is set to now unless e.TS
+// is already set.
+func (s *Store) AddLog(e LogEntry) error {
+	success := boolInt(e.Success)
+	ts := e.TS
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	_, err := s.db.Exec(
+		`INSERT INTO usage_logs (ts, token_name, browser, method, target_host, status_code, success, duration_ms, error_type, replay, block_vendor, block_kind)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		ts.Unix(), e.TokenName, e.Browser, e.Method, e.TargetHost,
+		e.StatusCode, success, e.DurationMs, e.ErrorType, boolInt(e.Replay), e.BlockVendor, e.BlockKind,
+	)
+	return err
+}
+
+// ListLogs returns the most recent usage logs, up to limit.
+func (s *Store) ListLogs(limit int) ([]LogEntry, error) {
+	page, err := s.QueryLogs(LogFilter{Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	return page.Logs, nil
+}
+
+// QueryLogs returns one page of usage logs matching f, newest first. Pages are
+// keyed on (ts, id) so they stay stable while new rows are being inserted.
+func (s *Store) QueryLogs(f LogFilter) (*LogPage, error) {
+	limit := f.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	where, args, err := f.where()
+	if err != nil {
+		return nil, err
+	}
+	// Fetch one extra row to know whether another page follows.
+	rows, err := s.db.Query(
+		`SELECT `+logColumns+` FROM usage_logs`+where+` ORDER BY ts DESC, id DESC LIMIT ?`,
+		append(args, limit+1)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	page := &LogPage{}
+	for rows.Next() {
+		e, err := scanLog(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Logs = append(page.Logs, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(page.Logs) > limit {
+		page.Logs = page.Logs[:limit]
+		last := page.Logs[limit-1]
+		page.NextCursor = encodeLogCursor(last.TS.Unix(), last.ID)
+	}
+	return page, nil
+}
+
+// exportPageSize is how many rows EachLog reads per query.
+const exportPageSize = 500
+
+// EachLog streams every usage log matching f, newest first, calling fn for
+// each row. f.Limit is ignored. Used for exports, which may be large: rows
+// are read a page at a time through the QueryLogs cursor, so the connection
+// is released while fn runs (the store has a single one).
+func (s *Store) EachLog(f LogFilter, fn func(LogEntry) error) error {
+	f.Limit = exportPageSize
+	for {
+		page, err := s.QueryLogs(f)
+		if err != nil {
+			return err
+		}
+		for _, e := range page.Logs {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		f.Cursor = page.NextCursor
+	}
+}
+
+// PurgeLogsOlderThan deletes usage logs older than the given duration and
+// returns the number of rows removed.
+func (s *Store) PurgeLogsOlderThan(d time.Duration) (int64, error) {
+	return s.PurgeLogsBefore(time.Now().Add(-d))
+}
+
+// PurgeLogsBefore deletes usage logs older than t and returns the number of
+// rows removed.
+func (s *Store) PurgeLogsBefore(t time.Time) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM usage_logs WHERE ts < ?`, t.Unix())
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// where builds the WHERE clause (with leading space) and its arguments.
+func (f LogFilter) where() (string, []any, error) {
+	var conds []string
+	var args []any
+	add := func(cond string, vals ...any) {
+		conds = append(conds, cond)
+		args = append(args, vals...)
+	}
+
+	if !f.Since.IsZero() {
+		add("ts >= ?", f.Since.Unix())
+	}
+	if !f.Until.IsZero() {
+		add("ts < ?", f.Until.Unix())
+	}
+	if f.TokenName != "" {
+		add("token_name = ?", f.TokenName)
+	}
+	if f.Browser != "" {
+		add("browser = ?", f.Browser)
+	}
+	if f.TargetHost != "" {
+		add("target_host = ?", f.TargetHost)
+	}
+	if f.Success != nil {
+		add("success = ?", boolInt(*f.Success))
+	}
+	if f.StatusCode != 0 {
+		add("status_code = ?", f.StatusCode)
+	}
+	if f.ErrorType != "" {
+		add("error_type = ?", f.ErrorType)
+	}
+	if f.Replay != nil {
+		add("replay = ?", boolInt(*f.Replay))
+	}
+	if f.Blocked != nil {
+		if *f.Blocked {
+			add("block_kind != ''")
+		} else {
+			add("block_kind = ''")
+		}
+	}
+	if f.BlockVendor != "" {
+		add("block_vendor = ?", f.BlockVendor)
+	}
+	if f.Cursor != "" {
+		ts, id, err := decodeLogCursor(f.Cursor)
+		if err != nil {
+			return "", nil, err
+		}
+		add("(ts < ? OR (ts = ? AND id < ?))", ts, ts, id)
+	}
+
+	if len(conds) == 0 {
+		return "", nil, nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args, nil
+}
+
+func encodeLogCursor(ts, id int64) string {
+	return strconv.FormatInt(ts, 10) + "_" + strconv.FormatInt(id, 10)
+}
+
+func decodeLogCursor(c string) (ts, id int64, err error) {
+	tsStr, idStr, ok := strings.Cut(c, "_")
+	if ok {
+		ts, err = strconv.ParseInt(tsStr, 10, 64)
+		if err == nil {
+			id, err = strconv.ParseInt(idStr, 10, 64)
+		}
+	}
+	if !ok || err != nil {
+		return 0, 0, fmt.Errorf("invalid cursor")
+	}
+	return ts, id, nil
+}
+
+func scanLog(rows *sql.Rows) (LogEntry, error) {
+	var e LogEntry
+	var ts int64
+	var success, replay int
+	if err := rows.Scan(&e.ID, &ts, &e.TokenName, &e.Browser, &e.Method, &e.TargetHost,
+		&e.StatusCode, &success, &e.DurationMs, &e.ErrorType, &replay, &e.BlockVendor, &e.BlockKind); err != nil {
+		return e, err
+	}
+	e.TS = time.Unix(ts, 0)
+	e.Success = success == 1
+	e.Replay = replay == 1
+	return e, nil
+}
+
+// BlockGroup counts the requests to one host with one browser and how many
+// of them were blocked.
+type BlockGroup struct {
+	TargetHost string `json:"target_host"`
+	Browser    string `json:"browser"`
+	Requests   int64  `json:"requests"`
+	Blocked    int64  `json:"blocked"`
+	// Vendors lists the vendors that served the blocks, comma-separated.
+	Vendors string `json:"vendors"`
+}
+
+// BlockBreakdown returns the host and browser pairs with blocks since t,
+// most blocked first, up to limit. It reads the raw usage logs, so it
+// covers at most the log retention.
+func (s *Store) BlockBreakdown(since time.Time, limit int) ([]BlockGroup, error) {
+	rows, err := s.db.Query(
+		`SELECT target_host, browser, COUNT(1), SUM(block_kind != ''),
+		        COALESCE(GROUP_CONCAT(DISTINCT NULLIF(block_vendor, '')), '')
+		 FROM usage_logs WHERE ts >= ?
+		 GROUP BY target_host, browser HAVING SUM(block_kind != '') > 0
+		 ORDER BY 4 DESC, 3 DESC LIMIT ?`,
+		since.Unix(), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var out []BlockGroup
+	for rows.Next() {
+		var g BlockGroup
+		if err := rows.Scan(&g.TargetHost, &g.Browser, &g.Requests, &g.Blocked, &g.Vendors); err != nil {
+			return nil, err
+		}
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/zupolgec/curl-impersonate-service/models"
+)
+
+// ListProfiles returns the stored custom browser profiles, sorted by name.
+func (s *Store) ListProfiles() ([]models.Profile, error) {
+	rows, err := s.db.Query(`SELECT spec FROM browser_profiles ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []models.Profile
+	for rows.Next() {
+		var spec string
+		if err := rows.Scan(&spec); err != nil {
+			return nil, err
+		}
+		var p models.Profile
+		if err := json.Unmarshal([]byte(spec), &p); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// SaveProfile creates or replaces the profile with p's name. It doesn't
+// validate p; see models.CheckProfile.
+func (s *Store) SaveProfile(p models.Profile) error {
+	p.Source = ""
+	spec, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO browser_profiles (name, spec, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET spec = excluded.spec, updated_at = excluded.updated_at`,
+		p.Name, string(spec), time.Now().Unix())
+	return err
+}
+
+// DeleteProfile removes a stored profile, returning sql.ErrNoRows if there
+// is none with that name.
+func (s *Store) DeleteProfile(name string) error {
+	res, err := s.db.Exec(`DELETE FROM browser_profiles WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
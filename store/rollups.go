@@ -0,0 +1,312 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Rollup periods. Buckets are aligned to UTC.
+const (
+	PeriodHour = "hour"
+	PeriodDay  = "day"
+)
+
+// Settings keys holding the end (exclusive, unix seconds) of the last
+// rolled-up bucket for each period.
+const (
+	hourWatermarkKey = "rollup_watermark_hour"
+	dayWatermarkKey  = "rollup_watermark_day"
+)
+
+// RollupPoint is the aggregate of one time bucket across all groups.
+type RollupPoint struct {
+	Bucket   time.Time `json:"bucket"`
+	Requests int64     `json:"requests"`
+	Success  int64     `json:"success"`
+	Failed   int64     `json:"failed"`
+	AvgMs    float64   `json:"avg_ms"`
+	P50Ms    float64   `json:"p50_ms"`
+	P95Ms    float64   `json:"p95_ms"`
+}
+
+// RollupGroup is the aggregate of one dimension value over a time range.
+type RollupGroup struct {
+	Key      string  `json:"key"`
+	Requests int64   `json:"requests"`
+	Failed   int64   `json:"failed"`
+	AvgMs    float64 `json:"avg_ms"`
+	P95Ms    float64 `json:"p95_ms"`
+}
+
+// RollupQuery selects aggregate rows. Since/Until are bucket bounds; TokenName
+// optionally narrows to one token.
+type RollupQuery struct {
+	Period    string
+	Since     time.Time
+	Until     time.Time
+	TokenName string
+}
+
+// rollupDimensions are the columns a breakdown may group by.
+var rollupDimensions = map[string]bool{
+	"token_name":  true,
+	"browser":     true,
+	"target_host": true,
+	"error_type":  true,
+}
+
+func periodDuration(period string) (time.Duration, error) {
+	switch period {
+	case PeriodHour:
+		return time.Hour, nil
+	case PeriodDay:
+		return 24 * time.Hour, nil
+	}
+	return 0, fmt.Errorf("unknown rollup period: %s", period)
+}
+
+// RollupCompleted aggregates every fully elapsed hour and day not yet rolled
+// up. It returns the point before which raw logs are fully covered by both
+// hourly and daily rollups and may therefore be purged.
+func (s *Store) RollupCompleted(now time.Time) (time.Time, error) {
+	hourEnd, err := s.rollupPeriod(PeriodHour, hourWatermarkKey, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	dayEnd, err := s.rollupPeriod(PeriodDay, dayWatermarkKey, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if hourEnd.Before(dayEnd) {
+		return hourEnd, nil
+	}
+	return dayEnd, nil
+}
+
+// rollupPeriod advances one period's watermark to the last complete bucket.
+func (s *Store) rollupPeriod(period, key string, now time.Time) (time.Time, error) {
+	step, err := periodDuration(period)
+	if err != nil {
+		return time.Time{}, err
+	}
+	end := now.UTC().Truncate(step)
+
+	var from time.Time
+	if v := s.GetSetting(key, ""); v != "" {
+		sec, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		from = time.Unix(sec, 0).UTC()
+	} else {
+		// First run: start from the oldest raw log still on disk.
+		var oldest *int64
+		if err := s.db.QueryRow(`SELECT MIN(ts) FROM usage_logs`).Scan(&oldest); err != nil {
+			return time.Time{}, err
+		}
+		from = end
+		if oldest != nil {
+			from = time.Unix(*oldest, 0).UTC().Truncate(step)
+		}
+	}
+
+	if from.Before(end) {
+		if err := s.RollupUsage(period, from, end); err != nil {
+			return time.Time{}, err
+		}
+	}
+	if from.After(end) {
+		end = from
+	}
+	if err := s.SetSetting(key, strconv.FormatInt(end.Unix(), 10)); err != nil {
+		return time.Time{}, err
+	}
+	return end, nil
+}
+
+type rollupKey struct {
+	bucket                          int64
+	token, browser, host, errorType string
+}
+
+type rollupAcc struct {
+	success, failed int64
+	sum             int64
+	durations       []int64
+}
+
+// RollupUsage (re)computes the aggregate rows of the given period for raw logs
+// in [from, to). Existing rows for those buckets are replaced, so calling it
+// twice over the same range is safe.
+func (s *Store) RollupUsage(period string, from, to time.Time) error {
+	step, err := periodDuration(period)
+	if err != nil {
+		return err
+	}
+	stepSec := int64(step / time.Second)
+
+	rows, err := s.db.Query(
+		`SELECT ts, token_name, browser, target_host, error_type, success, duration_ms
+		 FROM usage_logs WHERE ts >= ? AND ts < ?`, from.Unix(), to.Unix(),
+	)
+	if err != nil {
+		return err
+	}
+	groups := make(map[rollupKey]*rollupAcc)
+	for rows.Next() {
+		var ts, dur int64
+		var success int
+		var k rollupKey
+		if err := rows.Scan(&ts, &k.token, &k.browser, &k.host, &k.errorType, &success, &dur); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		k.bucket = ts - ts%stepSec
+		acc := groups[k]
+		if acc == nil {
+			acc = &rollupAcc{}
+			groups[k] = acc
+		}
+		if success == 1 {
+			acc.success++
+		} else {
+			acc.failed++
+		}
+		acc.sum += dur
+		acc.durations = append(acc.durations, dur)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return err
+	}
+	_ = rows.Close()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec(`DELETE FROM usage_rollups WHERE period = ? AND bucket >= ? AND bucket < ?`,
+		period, from.Unix(), to.Unix()); err != nil {
+		return err
+	}
+	for k, acc := range groups {
+		sort.Slice(acc.durations, func(i, j int) bool { return acc.durations[i] < acc.durations[j] })
+		if _, err := tx.Exec(
+			`INSERT INTO usage_rollups (period, bucket, token_name, browser, target_host, error_type,
+			   requests, success, failed, duration_sum_ms, p50_ms, p95_ms)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			period, k.bucket, k.token, k.browser, k.host, k.errorType,
+			acc.success+acc.failed, acc.success, acc.failed, acc.sum,
+			percentile(acc.durations, 0.50), percentile(acc.durations, 0.95),
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// percentile returns the nearest-rank percentile of sorted values.
+func percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
+
+// PurgeRollupsOlderThan deletes aggregate rows of the given period older than d.
+func (s *Store) PurgeRollupsOlderThan(period string, d time.Duration) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM usage_rollups WHERE period = ? AND bucket < ?`,
+		period, time.Now().Add(-d).Unix())
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+func (q RollupQuery) where() (string, []any) {
+	where := ` WHERE period = ? AND bucket >= ? AND bucket < ?`
+	args := []any{q.Period, q.Since.Unix(), q.Until.Unix()}
+	if q.TokenName != "" {
+		where += ` AND token_name = ?`
+		args = append(args, q.TokenName)
+	}
+	return where, args
+}
+
+// UsageSeries returns one point per bucket in the query range, oldest first.
+// Buckets without traffic are omitted. Percentiles across groups are
+// request-weighted averages of the per-group percentiles, which is an
+// approximation but stable and cheap.
+func (s *Store) UsageSeries(q RollupQuery) ([]RollupPoint, error) {
+	where, args := q.where()
+	rows, err := s.db.Query(
+		`SELECT bucket, SUM(requests), SUM(success), SUM(failed), SUM(duration_sum_ms),
+		        SUM(p50_ms * requests), SUM(p95_ms * requests)
+		 FROM usage_rollups`+where+` GROUP BY bucket ORDER BY bucket`, args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var out []RollupPoint
+	for rows.Next() {
+		var p RollupPoint
+		var bucket, sum, p50w, p95w int64
+		if err := rows.Scan(&bucket, &p.Requests, &p.Success, &p.Failed, &sum, &p50w, &p95w); err != nil {
+			return nil, err
+		}
+		p.Bucket = time.Unix(bucket, 0).UTC()
+		if p.Requests > 0 {
+			n := float64(p.Requests)
+			p.AvgMs, p.P50Ms, p.P95Ms = float64(sum)/n, float64(p50w)/n, float64(p95w)/n
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// UsageBreakdown totals the query range by one dimension (token_name, browser,
+// target_host or error_type), busiest first, up to limit groups.
+func (s *Store) UsageBreakdown(q RollupQuery, dimension string, limit int) ([]RollupGroup, error) {
+	if !rollupDimensions[dimension] {
+		return nil, fmt.Errorf("unknown dimension: %s", dimension)
+	}
+	if limit <= 0 {
+		limit = 20
+	}
+	where, args := q.where()
+	rows, err := s.db.Query(
+		`SELECT `+dimension+`, SUM(requests), SUM(failed), SUM(duration_sum_ms), SUM(p95_ms * requests)
+		 FROM usage_rollups`+where+` GROUP BY `+dimension+` ORDER BY SUM(requests) DESC LIMIT ?`,
+		append(args, limit)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var out []RollupGroup
+	for rows.Next() {
+		var g RollupGroup
+		var sum, p95w int64
+		if err := rows.Scan(&g.Key, &g.Requests, &g.Failed, &sum, &p95w); err != nil {
+			return nil, err
+		}
+		if g.Requests > 0 {
+			g.AvgMs, g.P95Ms = float64(sum)/float64(g.Requests), float64(p95w)/float64(g.Requests)
+		}
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
@@ -1,13 +1,13 @@
 // Package store provides SQLite-backed persistence for API tokens, settings
-// (such as CORS origins) and request usage logs.
+// (such as CORS origins), request usage logs and their long-lived rollups,
+// opt-in debug captures, custom browser profiles, encrypted TLS credentials
+// for targets, and admin accounts, sessions and audit log.
 package store
 
 import (
-	"crypto/rand"
+	"crypto/cipher"
 	"database/sql"
-	"encoding/hex"
 	"fmt"
-	"time"
 
 	_ "modernc.org/sqlite"
 )
@@ -15,40 +15,28 @@ import (
 // Store wraps the SQLite database.
 type Store struct {
 	db *sql.DB
-}
-
-// Token is an API token record.
-type Token struct {
-	ID         int64
-	Name       string
-	Token      string
-	Enabled    bool
-	CreatedAt  time.Time
-	LastUsedAt *time.Time
-}
-
-// LogEntry is a single usage-log record.
-type LogEntry struct {
-	ID         int64
-	TS         time.Time
-	TokenName  string
-	Browser    string
-	Method     string
-	TargetHost string
-	StatusCode int
-	Success    bool
-	DurationMs int64
-	ErrorType  string
+	// secrets encrypts stored TLS credentials; see SetSecretsKey.
+	secrets cipher.AEAD
+	// tokenKeys hash imported API tokens.
+	tokenKeys *tokenKeys
 }
 
 const schema = `
-CREATE TABLE IF NOT EXISTS api_tokens (
-    id           INTEGER PRIMARY KEY AUTOINCREMENT,
-    name         TEXT    NOT NULL,
-    token        TEXT    NOT NULL UNIQUE,
-    enabled      INTEGER NOT NULL DEFAULT 1,
-    created_at   INTEGER NOT NULL,
-    last_used_at INTEGER
+CREATE TABLE IF NOT EXISTS browser_profiles (
+    name       TEXT    PRIMARY KEY,
+    spec       TEXT    NOT NULL,
+    updated_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS tls_credentials (
+    kind        TEXT    NOT NULL,
+    name        TEXT    NOT NULL,
+    subject     TEXT    NOT NULL,
+    fingerprint TEXT    NOT NULL,
+    certs       INTEGER NOT NULL,
+    not_after   INTEGER NOT NULL,
+    sealed      BLOB    NOT NULL,
+    updated_at  INTEGER NOT NULL,
+    PRIMARY KEY (kind, name)
 );
 CREATE TABLE IF NOT EXISTS settings (
     key   TEXT PRIMARY KEY,
@@ -64,9 +52,98 @@ CREATE TABLE IF NOT EXISTS usage_logs (
     status_code INTEGER,
     success     INTEGER,
     duration_ms INTEGER,
-    error_type  TEXT
+    error_type  TEXT,
+    replay      INTEGER NOT NULL DEFAULT 0,
+    block_vendor TEXT NOT NULL DEFAULT '',
+    block_kind   TEXT NOT NULL DEFAULT ''
 );
 CREATE INDEX IF NOT EXISTS idx_usage_logs_ts ON usage_logs(ts);
+CREATE INDEX IF NOT EXISTS idx_usage_logs_token_ts ON usage_logs(token_name, ts);
+CREATE INDEX IF NOT EXISTS idx_usage_logs_browser_ts ON usage_logs(browser, ts);
+CREATE INDEX IF NOT EXISTS idx_usage_logs_host_ts ON usage_logs(target_host, ts);
+CREATE INDEX IF NOT EXISTS idx_usage_logs_error_ts ON usage_logs(error_type, ts);
+CREATE TABLE IF NOT EXISTS usage_rollups (
+    period          TEXT    NOT NULL,
+    bucket          INTEGER NOT NULL,
+    token_name      TEXT    NOT NULL,
+    browser         TEXT    NOT NULL,
+    target_host     TEXT    NOT NULL,
+    error_type      TEXT    NOT NULL,
+    requests        INTEGER NOT NULL,
+    success         INTEGER NOT NULL,
+    failed          INTEGER NOT NULL,
+    duration_sum_ms INTEGER NOT NULL,
+    p50_ms          INTEGER NOT NULL,
+    p95_ms          INTEGER NOT NULL,
+    PRIMARY KEY (period, bucket, token_name, browser, target_host, error_type)
+);
+CREATE INDEX IF NOT EXISTS idx_usage_rollups_token ON usage_rollups(period, token_name, bucket);
+CREATE TABLE IF NOT EXISTS capture_sessions (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    token_name TEXT    NOT NULL,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_capture_sessions_token ON capture_sessions(token_name, expires_at);
+CREATE TABLE IF NOT EXISTS captures (
+    id                   INTEGER PRIMARY KEY AUTOINCREMENT,
+    session_id           INTEGER NOT NULL,
+    ts                   INTEGER NOT NULL,
+    token_name           TEXT    NOT NULL,
+    browser              TEXT    NOT NULL,
+    method               TEXT    NOT NULL,
+    url                  TEXT    NOT NULL,
+    request_headers      TEXT    NOT NULL,
+    request_body         TEXT    NOT NULL,
+    request_body_base64  INTEGER NOT NULL,
+    request_truncated    INTEGER NOT NULL,
+    status_code          INTEGER NOT NULL,
+    response_headers     TEXT    NOT NULL,
+    response_body        TEXT    NOT NULL,
+    response_body_base64 INTEGER NOT NULL,
+    response_truncated   INTEGER NOT NULL,
+    success              INTEGER NOT NULL,
+    error                TEXT    NOT NULL,
+    error_type           TEXT    NOT NULL,
+    timing               TEXT    NOT NULL,
+    duration_ms          INTEGER NOT NULL,
+    expires_at           INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_captures_session ON captures(session_id, id);
+CREATE INDEX IF NOT EXISTS idx_captures_expires ON captures(expires_at);
+CREATE TABLE IF NOT EXISTS admin_users (
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    username      TEXT    NOT NULL UNIQUE,
+    password_hash TEXT    NOT NULL,
+    role          TEXT    NOT NULL,
+    created_at    INTEGER NOT NULL,
+    last_login_at INTEGER,
+    sso_issuer    TEXT    NOT NULL DEFAULT '',
+    sso_subject   TEXT    NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS admin_sessions (
+    id         TEXT    PRIMARY KEY,
+    user_id    INTEGER NOT NULL,
+    csrf       TEXT    NOT NULL,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_admin_sessions_user ON admin_sessions(user_id);
+CREATE TABLE IF NOT EXISTS audit_log (
+    id     INTEGER PRIMARY KEY AUTOINCREMENT,
+    ts     INTEGER NOT NULL,
+    actor  TEXT    NOT NULL,
+    action TEXT    NOT NULL,
+    target TEXT    NOT NULL,
+    detail TEXT    NOT NULL,
+    ip     TEXT    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_ts ON audit_log(ts);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor_ts ON audit_log(actor, ts);
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END;
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END;
 `
 
 // Open opens (and migrates) the SQLite database at path.
@@ -81,117 +158,74 @@ func Open(path string) (*Store, error) {
 		_ = db.Close()
 		return nil, fmt.Errorf("migrate schema: %w", err)
 	}
-	return &Store{db: db}, nil
-}
-
-// Close closes the database.
-func (s *Store) Close() error { return s.db.Close() }
-
-// generateToken returns a cryptographically random token.
-func generateToken() (string, error) {
-	b := make([]byte, 32)
-	if _, err := rand.Read(b); err != nil {
-		return "", err
-	}
-	return hex.EncodeToString(b), nil
-}
-
-// CreateToken creates a new random API token with the given name.
-func (s *Store) CreateToken(name string) (*Token, error) {
-	tok, err := generateToken()
-	if err != nil {
-		return nil, err
-	}
-	now := time.Now().Unix()
-	res, err := s.db.Exec(
-		`INSERT INTO api_tokens (name, token, enabled, created_at) VALUES (?, ?, 1, ?)`,
-		name, tok, now,
-	)
+	keys, err := loadTokenKeys(db)
 	if err != nil {
-		return nil, err
-	}
-	id, _ := res.LastInsertId()
-	return &Token{ID: id, Name: name, Token: tok, Enabled: true, CreatedAt: time.Unix(now, 0)}, nil
-}
-
-// SeedToken inserts a token with an explicit value if it does not already
-// exist. Used to preserve the legacy TOKEN env value across restarts.
-func (s *Store) SeedToken(name, value string) error {
-	var exists int
-	if err := s.db.QueryRow(`SELECT COUNT(1) FROM api_tokens WHERE token = ?`, value).Scan(&exists); err != nil {
-		return err
-	}
-	if exists > 0 {
-		return nil
+		_ = db.Close()
+		return nil, fmt.Errorf("load token key: %w", err)
 	}
-	_, err := s.db.Exec(
-		`INSERT INTO api_tokens (name, token, enabled, created_at) VALUES (?, ?, 1, ?)`,
-		name, value, time.Now().Unix(),
-	)
-	return err
-}
-
-// ValidateToken returns the token name if the value matches an enabled token,
-// updating its last-used timestamp.
-func (s *Store) ValidateToken(value string) (string, bool) {
-	if value == "" {
-		return "", false
+	if err := migrateTokens(db, keys); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("migrate tokens: %w", err)
 	}
-	var name string
-	err := s.db.QueryRow(
-		`SELECT name FROM api_tokens WHERE token = ? AND enabled = 1`, value,
-	).Scan(&name)
-	if err != nil {
-		return "", false
+	if err := addMissingColumns(db); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("migrate schema: %w", err)
 	}
-	_, _ = s.db.Exec(`UPDATE api_tokens SET last_used_at = ? WHERE token = ?`, time.Now().Unix(), value)
-	return name, true
-}
-
-// ListTokens returns all API tokens ordered by creation time.
-func (s *Store) ListTokens() ([]Token, error) {
-	rows, err := s.db.Query(
-		`SELECT id, name, token, enabled, created_at, last_used_at FROM api_tokens ORDER BY created_at DESC`,
-	)
-	if err != nil {
-		return nil, err
+	if _, err := db.Exec(addedIndexes); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("migrate schema: %w", err)
 	}
-	defer func() { _ = rows.Close() }()
+	return &Store{db: db, tokenKeys: keys}, nil
+}
+
+// addedColumns lists columns introduced after a table was first created.
+// CREATE TABLE IF NOT EXISTS leaves existing tables alone, so databases from
+// older versions get them added here.
+var addedColumns = []struct{ table, column, ddl string }{
+	{"usage_logs", "replay", "INTEGER NOT NULL DEFAULT 0"},
+	{"usage_logs", "block_vendor", "TEXT NOT NULL DEFAULT ''"},
+	{"usage_logs", "block_kind", "TEXT NOT NULL DEFAULT ''"},
+	{"api_tokens", "expires_at", "INTEGER"},
+	{"api_tokens", "scopes", "TEXT NOT NULL DEFAULT ''"},
+	{"api_tokens", "replaced_by", "INTEGER NOT NULL DEFAULT 0"},
+	{"api_tokens", "signing_key_id", "TEXT"},
+	{"api_tokens", "signing_secret", "TEXT"},
+	{"api_tokens", "signed_only", "INTEGER NOT NULL DEFAULT 0"},
+	{"api_tokens", "no_query_token", "INTEGER NOT NULL DEFAULT 0"},
+	{"admin_users", "sso_issuer", "TEXT NOT NULL DEFAULT ''"},
+	{"admin_users", "sso_subject", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// addedIndexes index columns from addedColumns, so they run after it.
+const addedIndexes = `
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_tokens_signing_key ON api_tokens(signing_key_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_admin_users_sso ON admin_users(sso_issuer, sso_subject) WHERE sso_subject != '';
+`
 
-	var out []Token
-	for rows.Next() {
-		var t Token
-		var enabled int
-		var created int64
-		var lastUsed sql.NullInt64
-		if err := rows.Scan(&t.ID, &t.Name, &t.Token, &enabled, &created, &lastUsed); err != nil {
-			return nil, err
+func addMissingColumns(db *sql.DB) error {
+	for _, c := range addedColumns {
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(1) FROM pragma_table_info(?) WHERE name = ?`, c.table, c.column).Scan(&n); err != nil {
+			return err
 		}
-		t.Enabled = enabled == 1
-		t.CreatedAt = time.Unix(created, 0)
-		if lastUsed.Valid {
-			u := time.Unix(lastUsed.Int64, 0)
-			t.LastUsedAt = &u
+		if n > 0 {
+			continue
+		}
+		if _, err := db.Exec(`ALTER TABLE ` + c.table + ` ADD COLUMN ` + c.column + ` ` + c.ddl); err != nil {
+			return fmt.Errorf("add %s.%s: %w", c.table, c.column, err)
 		}
-		out = append(out, t)
 	}
-	return out, rows.Err()
+	return nil
 }
 
-// DeleteToken removes a token by id.
-func (s *Store) DeleteToken(id int64) error {
-	_, err := s.db.Exec(`DELETE FROM api_tokens WHERE id = ?`, id)
-	return err
-}
+// Close closes the database.
+func (s *Store) Close() error { return s.db.Close() }
 
-// SetTokenEnabled enables or disables a token by id.
-func (s *Store) SetTokenEnabled(id int64, enabled bool) error {
-	v := 0
-	if enabled {
-		v = 1
+func boolInt(b bool) int {
+	if b {
+		return 1
 	}
-	_, err := s.db.Exec(`UPDATE api_tokens SET enabled = ? WHERE id = ?`, v, id)
-	return err
+	return 0
 }
 
 // GetSetting returns a setting value, or def if unset.
@@ -203,6 +237,12 @@ func (s *Store) GetSetting(key, def string) string {
 	return v
 }
 
+// DeleteSetting removes a setting; deleting a missing key is not an error.
+func (s *Store) DeleteSetting(key string) error {
+	_, err := s.db.Exec(`DELETE FROM settings WHERE key = ?`, key)
+	return err
+}
+
 // SetSetting upserts a setting value.
 func (s *Store) SetSetting(key, value string) error {
 	_, err := s.db.Exec(
@@ -212,60 +252,3 @@ func (s *Store) SetSetting(key, value string) error {
 	)
 	return err
 }
-
-// AddLog inserts a usage-log entry. The timestamp is set to now.
-func (s *Store) AddLog(e LogEntry) error {
-	success := 0
-	if e.Success {
-		success = 1
-	}
-	_, err := s.db.Exec(
-		`INSERT INTO usage_logs (ts, token_name, browser, method, target_host, status_code, success, duration_ms, error_type)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		time.Now().Unix(), e.TokenName, e.Browser, e.Method, e.TargetHost,
-		e.StatusCode, success, e.DurationMs, e.ErrorType,
-	)
-	return err
-}
-
-// ListLogs returns the most recent usage logs, up to limit.
-func (s *Store) ListLogs(limit int) ([]LogEntry, error) {
-	if limit <= 0 {
-		limit = 100
-	}
-	rows, err := s.db.Query(
-		`SELECT id, ts, token_name, browser, method, target_host, status_code, success, duration_ms, error_type
-		 FROM usage_logs ORDER BY ts DESC, id DESC LIMIT ?`, limit,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer func() { _ = rows.Close() }()
-
-	var out []LogEntry
-	for rows.Next() {
-		var e LogEntry
-		var ts int64
-		var success int
-		if err := rows.Scan(&e.ID, &ts, &e.TokenName, &e.Browser, &e.Method, &e.TargetHost,
-			&e.StatusCode, &success, &e.DurationMs, &e.ErrorType); err != nil {
-			return nil, err
-		}
-		e.TS = time.Unix(ts, 0)
-		e.Success = success == 1
-		out = append(out, e)
-	}
-	return out, rows.Err()
-}
-
-// PurgeLogsOlderThan deletes usage logs older than the given duration and
-// returns the number of rows removed.
-func (s *Store) PurgeLogsOlderThan(d time.Duration) (int64, error) {
-	cutoff := time.Now().Add(-d).Unix()
-	res, err := s.db.Exec(`DELETE FROM usage_logs WHERE ts < ?`, cutoff)
-	if err != nil {
-		return 0, err
-	}
-	n, _ := res.RowsAffected()
-	return n, nil
-}
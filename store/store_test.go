@@ -1,9 +1,26 @@
 package store
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/zupolgec/curl-impersonate-service/models"
 )
 
 func openTestStore(t *testing.T) *Store {
@@ -19,7 +36,7 @@ func openTestStore(t *testing.T) *Store {
 func TestTokenLifecycle(t *testing.T) {
 	s := openTestStore(t)
 
-	tok, err := s.CreateToken("ci")
+	tok, err := s.CreateToken("ci", TokenOptions{})
 	if err != nil {
 		t.Fatalf("CreateToken: %v", err)
 	}
@@ -27,19 +44,19 @@ func TestTokenLifecycle(t *testing.T) {
 		t.Fatalf("token too short: %q", tok.Token)
 	}
 
-	name, ok := s.ValidateToken(tok.Token)
-	if !ok || name != "ci" {
-		t.Fatalf("ValidateToken = %q,%v want ci,true", name, ok)
+	name, err := s.ValidateToken(tok.Token, models.ScopeImpersonate)
+	if err != nil || name != "ci" {
+		t.Fatalf("ValidateToken = %q,%v want ci,nil", name, err)
 	}
 
-	if _, ok := s.ValidateToken("bogus"); ok {
+	if _, err := s.ValidateToken("bogus", models.ScopeImpersonate); err == nil {
 		t.Fatal("bogus token validated")
 	}
 
 	if err := s.SetTokenEnabled(tok.ID, false); err != nil {
 		t.Fatalf("SetTokenEnabled: %v", err)
 	}
-	if _, ok := s.ValidateToken(tok.Token); ok {
+	if _, err := s.ValidateToken(tok.Token, models.ScopeImpersonate); err == nil {
 		t.Fatal("disabled token validated")
 	}
 
@@ -67,8 +84,368 @@ func TestSeedTokenIdempotent(t *testing.T) {
 	if len(toks) != 1 {
 		t.Fatalf("expected 1 token after duplicate seed, got %d", len(toks))
 	}
+	if name, err := s.ValidateToken("fixed-value", models.ScopeImpersonate); err != nil || name != "legacy" {
+		t.Fatalf("ValidateToken = %q, %v", name, err)
+	}
+	if _, err := s.ValidateToken("fixed-valuf", models.ScopeImpersonate); err == nil {
+		t.Fatal("wrong value validated")
+	}
 }
 
+func TestImportedTokensKeyed(t *testing.T) {
+	s := openTestStore(t)
+
+	// A seed stored by an earlier version, with a clear prefix and a fast
+	// hash, is rehashed.
+	old := Token{Name: "legacy"}
+	if err := insertToken(s.db, &old, "short", nil); err != nil {
+		t.Fatalf("insertToken: %v", err)
+	}
+	if err := s.SeedToken("legacy", "short"); err != nil {
+		t.Fatalf("SeedToken: %v", err)
+	}
+	stored := func() (prefix, salt, digest string) {
+		t.Helper()
+		if err := s.db.QueryRow(`SELECT prefix, salt, hash FROM api_tokens WHERE id = ?`, old.ID).Scan(&prefix, &salt, &digest); err != nil {
+			t.Fatalf("read token: %v", err)
+		}
+		return prefix, salt, digest
+	}
+	prefix, salt, digest := stored()
+	if strings.Contains(prefix, "short") || salt != "" || !strings.HasPrefix(digest, tokenKeyStored+"$") {
+		t.Fatalf("stored prefix=%q salt=%q hash=%q, want a display id and an HMAC", prefix, salt, digest)
+	}
+	toks, _ := s.ListTokens()
+	if len(toks) != 1 || !toks[0].Imported || toks[0].Prefix != prefix {
+		t.Fatalf("ListTokens = %+v", toks)
+	}
+
+	// Unknown values are refused by lookup alone, without password hashing.
+	defer func(f func(func() hash.Hash, string, []byte, int, int) ([]byte, error)) { pbkdf2Key = f }(pbkdf2Key)
+	var derivations int
+	pbkdf2Key = func(h func() hash.Hash, password string, salt []byte, iter, keyLength int) ([]byte, error) {
+		derivations++
+		return pbkdf2.Key(h, password, salt, iter, keyLength)
+	}
+	for i := range 50 {
+		if _, err := s.ValidateToken(fmt.Sprintf("guess-%d", i), models.ScopeImpersonate); err == nil {
+			t.Fatal("unknown token validated")
+		}
+	}
+	if derivations != 0 {
+		t.Fatalf("unknown tokens ran PBKDF2 %d times", derivations)
+	}
+
+	// Once SECRETS_KEY is set, the token moves to the key derived from it.
+	if err := s.SetSecretsKey(make([]byte, 32)); err != nil {
+		t.Fatalf("SetSecretsKey: %v", err)
+	}
+	for range 2 {
+		if name, err := s.ValidateToken("short", models.ScopeImpersonate); err != nil || name != "legacy" {
+			t.Fatalf("ValidateToken = %q, %v", name, err)
+		}
+	}
+	if _, _, digest := stored(); !strings.HasPrefix(digest, tokenKeySecrets+"$") {
+		t.Fatalf("hash after SECRETS_KEY = %q", digest)
+	}
+	if err := s.DeleteToken(old.ID); err != nil {
+		t.Fatalf("DeleteToken: %v", err)
+	}
+	if _, err := s.ValidateToken("short", models.ScopeImpersonate); err == nil {
+		t.Fatal("deleted token validated")
+	}
+}
+
+func TestTokensStoredHashed(t *testing.T) {
+	s := openTestStore(t)
+	tok, err := s.CreateToken("ci", TokenOptions{})
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(1) FROM api_tokens WHERE hash = ? OR salt = ?`, tok.Token, tok.Token).Scan(&n); err != nil || n != 0 {
+		t.Fatalf("plaintext token found in store (n=%d, err=%v)", n, err)
+	}
+	toks, _ := s.ListTokens()
+	if len(toks) != 1 || toks[0].Token != "" || toks[0].Prefix != tok.Token[:TokenPrefixLen] {
+		t.Fatalf("ListTokens = %+v, want prefix only", toks)
+	}
+	// A value sharing the prefix must not validate.
+	if _, err := s.ValidateToken(tok.Token[:TokenPrefixLen]+"0000", models.ScopeImpersonate); err == nil {
+		t.Fatal("prefix collision validated")
+	}
+}
+
+func TestMigratePlaintextTokens(t *testing.T) {
+	generated := strings.Repeat("ab12", 16)
+	path := filepath.Join(t.TempDir(), "old.db")
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE api_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL, token TEXT NOT NULL UNIQUE,
+		enabled INTEGER NOT NULL DEFAULT 1, created_at INTEGER NOT NULL, last_used_at INTEGER);
+		INSERT INTO api_tokens (name, token, enabled, created_at) VALUES ('legacy-env-token', 'env-secret', 1, 1700000000);
+		INSERT INTO api_tokens (name, token, enabled, created_at) VALUES ('old', 'old-disabled', 0, 1700000001);
+		INSERT INTO api_tokens (name, token, enabled, created_at) VALUES ('ci', '` + generated + `', 1, 1700000002);`); err != nil {
+		t.Fatalf("seed old schema: %v", err)
+	}
+	_ = db.Close()
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer func() { _ = s.Close() }()
+
+	if name, err := s.ValidateToken("env-secret", models.ScopeImpersonate); err != nil || name != "legacy-env-token" {
+		t.Fatalf("migrated token: %q,%v", name, err)
+	}
+	if _, err := s.ValidateToken("old-disabled", models.ScopeImpersonate); err == nil {
+		t.Fatal("disabled flag lost in migration")
+	}
+	var n int
+	_ = s.db.QueryRow(`SELECT COUNT(1) FROM pragma_table_info('api_tokens') WHERE name = 'token'`).Scan(&n)
+	if n != 0 {
+		t.Fatal("plaintext column still present")
+	}
+	// Re-seeding the same env value must not duplicate it.
+	if err := s.SeedToken("legacy-env-token", "env-secret"); err != nil {
+		t.Fatalf("SeedToken: %v", err)
+	}
+	toks, _ := s.ListTokens()
+	if len(toks) != 3 {
+		t.Fatalf("got %d tokens after reseed, want 3", len(toks))
+	}
+	// Generated tokens stay on the fast path; others are imported.
+	for _, tok := range toks {
+		if tok.Imported != (tok.Name != "ci") || (tok.Name == "ci" && tok.Prefix != generated[:TokenPrefixLen]) {
+			t.Errorf("migrated token %+v", tok)
+		}
+	}
+	if name, err := s.ValidateToken(generated, models.ScopeImpersonate); err != nil || name != "ci" {
+		t.Fatalf("migrated generated token: %q, %v", name, err)
+	}
+
+	// The stored token key survives a restart.
+	_ = s.Close()
+	if s, err = Open(path); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if _, err := s.ValidateToken("env-secret", models.ScopeImpersonate); err != nil {
+		t.Fatalf("after reopen: %v", err)
+	}
+}
+
+func TestTokenScopesExpiryAndRotation(t *testing.T) {
+	s := openTestStore(t)
+
+	past := time.Now().Add(-time.Minute)
+	expired, _ := s.CreateToken("old", TokenOptions{ExpiresAt: &past})
+	if _, err := s.ValidateToken(expired.Token, models.ScopeImpersonate); !errors.Is(err, models.ErrTokenExpired) {
+		t.Fatalf("expired token: err = %v, want ErrTokenExpired", err)
+	}
+
+	scoped, err := s.CreateToken("metrics-only", TokenOptions{Scopes: []string{"Metrics"}})
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+	if _, err := s.ValidateToken(scoped.Token, models.ScopeMetrics); err != nil {
+		t.Fatalf("in-scope: %v", err)
+	}
+	if _, err := s.ValidateToken(scoped.Token, models.ScopeImpersonate); !errors.Is(err, models.ErrTokenScope) {
+		t.Fatalf("out-of-scope: err = %v, want ErrTokenScope", err)
+	}
+	if _, err := s.CreateToken("bad", TokenOptions{Scopes: []string{"root"}}); err == nil {
+		t.Fatal("unknown scope accepted")
+	}
+
+	// Rotation: the new value inherits scopes; the old one survives the grace
+	// period only.
+	next, err := s.RotateToken(scoped.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("RotateToken: %v", err)
+	}
+	if _, err := s.ValidateToken(next.Token, models.ScopeImpersonate); !errors.Is(err, models.ErrTokenScope) {
+		t.Fatalf("rotated token lost scopes: %v", err)
+	}
+	if _, err := s.ValidateToken(scoped.Token, models.ScopeMetrics); err != nil {
+		t.Fatalf("old value refused during grace: %v", err)
+	}
+	now, _ := s.RotateToken(next.ID, 0)
+	if _, err := s.ValidateToken(next.Token, models.ScopeMetrics); !errors.Is(err, models.ErrTokenExpired) {
+		t.Fatalf("old value after zero grace: err = %v", err)
+	}
+	if _, err := s.ValidateToken(now.Token, models.ScopeMetrics); err != nil {
+		t.Fatalf("newest value: %v", err)
+	}
+	toks, _ := s.ListTokens()
+	for _, tk := range toks {
+		if tk.ID == scoped.ID && tk.ReplacedBy != next.ID {
+			t.Fatalf("ReplacedBy = %d, want %d", tk.ReplacedBy, next.ID)
+		}
+	}
+}
+
+func TestSigningKeysAndAuthRestrictions(t *testing.T) {
+	s := openTestStore(t)
+	tok, _ := s.CreateToken("signer", TokenOptions{Scopes: []string{models.ScopeImpersonate}})
+
+	if err := s.UpdateToken(tok.ID, TokenUpdate{SignedOnly: ptr(true)}); !errors.Is(err, ErrNoSigningKey) {
+		t.Fatalf("signed-only without key: err = %v, want ErrNoSigningKey", err)
+	}
+	if _, _, err := s.EnableSigning(tok.ID); !errors.Is(err, ErrNoSecretsKey) {
+		t.Fatalf("EnableSigning without secrets key: err = %v, want ErrNoSecretsKey", err)
+	}
+	if err := s.SetSecretsKey(bytes.Repeat([]byte{9}, 32)); err != nil {
+		t.Fatalf("SetSecretsKey: %v", err)
+	}
+	keyID, secret, err := s.EnableSigning(tok.ID)
+	if err != nil {
+		t.Fatalf("EnableSigning: %v", err)
+	}
+	var stored string
+	_ = s.db.QueryRow(`SELECT signing_secret FROM api_tokens WHERE id = ?`, tok.ID).Scan(&stored)
+	if !strings.HasPrefix(stored, sealedSigningPrefix) || strings.Contains(stored, secret) {
+		t.Fatalf("signing secret stored unsealed: %q", stored)
+	}
+	name, got, err := s.SigningKey(keyID, models.ScopeImpersonate)
+	if err != nil || name != "signer" || string(got) != secret {
+		t.Fatalf("SigningKey = %q, %q, %v", name, got, err)
+	}
+	if _, _, err := s.SigningKey(keyID, models.ScopeMetrics); !errors.Is(err, models.ErrTokenScope) {
+		t.Fatalf("out-of-scope signing key: err = %v", err)
+	}
+	if _, _, err := s.SigningKey("sk_unknown", models.ScopeImpersonate); !errors.Is(err, models.ErrTokenInvalid) {
+		t.Fatalf("unknown signing key: err = %v", err)
+	}
+
+	// Per-token restrictions on bearer and query use.
+	if err := s.UpdateToken(tok.ID, TokenUpdate{NoQueryToken: ptr(true)}); err != nil {
+		t.Fatalf("UpdateToken: %v", err)
+	}
+	if _, err := s.ValidateToken(tok.Token, models.ScopeImpersonate); err != nil {
+		t.Fatalf("bearer use: %v", err)
+	}
+	if _, err := s.ValidateQueryToken(tok.Token, models.ScopeImpersonate); !errors.Is(err, models.ErrQueryTokenDisabled) {
+		t.Fatalf("query use: err = %v, want ErrQueryTokenDisabled", err)
+	}
+	if err := s.UpdateToken(tok.ID, TokenUpdate{SignedOnly: ptr(true)}); err != nil {
+		t.Fatalf("UpdateToken: %v", err)
+	}
+	if _, err := s.ValidateToken(tok.Token, models.ScopeImpersonate); !errors.Is(err, models.ErrSignatureRequired) {
+		t.Fatalf("signed-only bearer use: err = %v, want ErrSignatureRequired", err)
+	}
+
+	// Rotation moves the key and restrictions to the replacement.
+	next, err := s.RotateToken(tok.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("RotateToken: %v", err)
+	}
+	got2, _ := s.GetToken(next.ID)
+	old, _ := s.GetToken(tok.ID)
+	if got2.SigningKeyID != keyID || !got2.SignedOnly || !got2.NoQueryToken || old.SigningKeyID != "" {
+		t.Fatalf("after rotation: new = %+v, old key = %q", got2, old.SigningKeyID)
+	}
+	if _, got, err := s.SigningKey(keyID, models.ScopeImpersonate); err != nil || string(got) != secret {
+		t.Fatalf("SigningKey after rotation = %q, %v", got, err)
+	}
+
+	// Disabling signing also lifts signed-only, which would lock the token out.
+	if err := s.DisableSigning(next.ID); err != nil {
+		t.Fatalf("DisableSigning: %v", err)
+	}
+	if _, _, err := s.SigningKey(keyID, models.ScopeImpersonate); !errors.Is(err, models.ErrTokenInvalid) {
+		t.Fatalf("removed signing key: err = %v", err)
+	}
+	if _, err := s.ValidateToken(next.Token, models.ScopeImpersonate); err != nil {
+		t.Fatalf("bearer use after disabling signing: %v", err)
+	}
+}
+
+func TestSigningSecretsSealedBySecretsKey(t *testing.T) {
+	s := openTestStore(t)
+	tok, _ := s.CreateToken("legacy", TokenOptions{})
+	// A signing secret stored before secrets were sealed.
+	if _, err := s.db.Exec(`UPDATE api_tokens SET signing_key_id = 'sk_old', signing_secret = 'plain-secret' WHERE id = ?`, tok.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetSecretsKey(bytes.Repeat([]byte{1}, 32)); err != nil {
+		t.Fatalf("SetSecretsKey: %v", err)
+	}
+	var stored string
+	_ = s.db.QueryRow(`SELECT signing_secret FROM api_tokens WHERE id = ?`, tok.ID).Scan(&stored)
+	if !strings.HasPrefix(stored, sealedSigningPrefix) || strings.Contains(stored, "plain-secret") {
+		t.Fatalf("legacy secret not sealed: %q", stored)
+	}
+	if _, got, err := s.SigningKey("sk_old", models.ScopeImpersonate); err != nil || string(got) != "plain-secret" {
+		t.Fatalf("SigningKey = %q, %v", got, err)
+	}
+
+	// The sealed secret is bound to its key ID and to the key.
+	if _, err := s.db.Exec(`UPDATE api_tokens SET signing_key_id = 'sk_moved' WHERE id = ?`, tok.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.SigningKey("sk_moved", models.ScopeImpersonate); err == nil {
+		t.Fatal("sealed secret opened under another key ID")
+	}
+	_, _ = s.db.Exec(`UPDATE api_tokens SET signing_key_id = 'sk_old' WHERE id = ?`, tok.ID)
+	_ = s.SetSecretsKey(bytes.Repeat([]byte{2}, 32))
+	if _, _, err := s.SigningKey("sk_old", models.ScopeImpersonate); err == nil || !strings.Contains(err.Error(), "SECRETS_KEY") {
+		t.Fatalf("wrong secrets key: err = %v", err)
+	}
+}
+
+func TestValidateTokenName(t *testing.T) {
+	s := openTestStore(t)
+	tok, _ := s.CreateToken("worker", TokenOptions{Scopes: []string{models.ScopeImpersonate}})
+	if _, err := s.ValidateTokenName("worker", models.ScopeImpersonate); err != nil {
+		t.Fatalf("ValidateTokenName: %v", err)
+	}
+	if _, err := s.ValidateTokenName("worker", models.ScopeMetrics); !errors.Is(err, models.ErrTokenScope) {
+		t.Fatalf("out of scope: err = %v", err)
+	}
+	if _, err := s.ValidateTokenName("nobody", models.ScopeImpersonate); !errors.Is(err, models.ErrTokenInvalid) {
+		t.Fatalf("unknown name: err = %v", err)
+	}
+	// After rotation with no grace the replacement carries the name.
+	if _, err := s.RotateToken(tok.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ValidateTokenName("worker", models.ScopeImpersonate); err != nil {
+		t.Fatalf("after rotation: %v", err)
+	}
+	if shared, err := s.SharedTokenNames([]string{"worker", "nobody"}); err != nil || len(shared) != 0 {
+		t.Fatalf("SharedTokenNames = %v, %v", shared, err)
+	}
+
+	// A second live token of the same name makes the name ambiguous: it is
+	// refused rather than resolved to either token.
+	dup, _ := s.CreateToken("worker", TokenOptions{Scopes: []string{models.ScopeImpersonate}})
+	if _, err := s.ValidateTokenName("worker", models.ScopeImpersonate); !errors.Is(err, models.ErrTokenInvalid) {
+		t.Fatalf("shared name: err = %v", err)
+	}
+	if shared, err := s.SharedTokenNames([]string{"worker", "nobody"}); err != nil || !slices.Equal(shared, []string{"worker"}) {
+		t.Fatalf("SharedTokenNames = %v, %v", shared, err)
+	}
+	if err := s.SetTokenEnabled(dup.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ValidateTokenName("worker", models.ScopeImpersonate); err != nil {
+		t.Fatalf("after disabling the duplicate: %v", err)
+	}
+	toks, _ := s.ListTokens()
+	for _, tk := range toks {
+		_ = s.SetTokenEnabled(tk.ID, false)
+	}
+	if _, err := s.ValidateTokenName("worker", models.ScopeImpersonate); !errors.Is(err, models.ErrTokenInvalid) {
+		t.Fatalf("disabled: err = %v", err)
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
 func TestSettings(t *testing.T) {
 	s := openTestStore(t)
 	if got := s.GetSetting("cors", "default"); got != "default" {
@@ -85,6 +462,121 @@ func TestSettings(t *testing.T) {
 	}
 }
 
+func TestProfiles(t *testing.T) {
+	s := openTestStore(t)
+	p := models.Profile{Name: "chrome-de", Base: "chrome136", AcceptLanguage: "de-DE", Source: models.ProfileSourceStore}
+	if err := s.SaveProfile(p); err != nil {
+		t.Fatalf("SaveProfile: %v", err)
+	}
+	p.HTTP2 = &models.HTTP2Options{WindowUpdate: 15663105}
+	if err := s.SaveProfile(p); err != nil {
+		t.Fatalf("SaveProfile update: %v", err)
+	}
+	got, err := s.ListProfiles()
+	if err != nil || len(got) != 1 || got[0].HTTP2 == nil || got[0].HTTP2.WindowUpdate != 15663105 || got[0].Source != "" {
+		t.Fatalf("ListProfiles = %+v, %v", got, err)
+	}
+	if err := s.DeleteProfile("chrome-de"); err != nil {
+		t.Fatalf("DeleteProfile: %v", err)
+	}
+	if err := s.DeleteProfile("chrome-de"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("DeleteProfile missing = %v", err)
+	}
+}
+
+// testCertAndKey returns a self-signed certificate and its key, as PEM.
+func testCertAndKey(t *testing.T, cn string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour).Truncate(time.Second),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func TestCredentials(t *testing.T) {
+	s := openTestStore(t)
+	certPEM, keyPEM := testCertAndKey(t, "partner-client")
+	_, otherKey := testCertAndKey(t, "other")
+
+	c, err := NewCredential(CredentialClientCert, "partner", certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("NewCredential: %v", err)
+	}
+	if err := s.SaveCredential(c); !errors.Is(err, ErrNoSecretsKey) {
+		t.Fatalf("SaveCredential without key = %v", err)
+	}
+	if err := s.SetSecretsKey(bytes.Repeat([]byte{7}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveCredential(c); err != nil {
+		t.Fatalf("SaveCredential: %v", err)
+	}
+
+	// The PEM is only stored encrypted.
+	var sealed []byte
+	if err := s.db.QueryRow(`SELECT sealed FROM tls_credentials WHERE name = 'partner'`).Scan(&sealed); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("PRIVATE KEY")) || bytes.Contains(sealed, []byte("CERTIFICATE")) {
+		t.Fatal("credential stored in the clear")
+	}
+
+	list, err := s.ListCredentials()
+	if err != nil || len(list) != 1 || list[0].Subject != "CN=partner-client" || list[0].CertPEM != "" || list[0].KeyPEM != "" {
+		t.Fatalf("ListCredentials = %+v, %v", list, err)
+	}
+	got, err := s.GetCredential(CredentialClientCert, "partner")
+	if err != nil || got.CertPEM != certPEM || got.KeyPEM != keyPEM || !got.NotAfter.Equal(c.NotAfter) {
+		t.Fatalf("GetCredential = %+v, %v", got, err)
+	}
+	if _, err := s.GetCredential(CredentialCABundle, "partner"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetCredential wrong kind = %v", err)
+	}
+
+	// Another key can't decrypt it.
+	_ = s.SetSecretsKey(bytes.Repeat([]byte{8}, 32))
+	if _, err := s.GetCredential(CredentialClientCert, "partner"); err == nil {
+		t.Fatal("GetCredential decrypted with the wrong key")
+	}
+
+	bad := []struct{ kind, name, cert, key string }{
+		{CredentialClientCert, "partner", certPEM, otherKey},
+		{CredentialClientCert, "partner", certPEM, ""},
+		{CredentialCABundle, "ca", certPEM, keyPEM},
+		{CredentialCABundle, "ca", "not a certificate", ""},
+		{CredentialCABundle, "bad name", certPEM, ""},
+		{"other", "x", certPEM, ""},
+	}
+	for _, b := range bad {
+		if _, err := NewCredential(b.kind, b.name, b.cert, b.key); err == nil {
+			t.Errorf("NewCredential(%s, %q) accepted", b.kind, b.name)
+		}
+	}
+
+	if err := s.DeleteCredential(CredentialClientCert, "partner"); err != nil {
+		t.Fatalf("DeleteCredential: %v", err)
+	}
+	if err := s.DeleteCredential(CredentialClientCert, "partner"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("DeleteCredential missing = %v", err)
+	}
+}
+
 func TestLogsAndPurge(t *testing.T) {
 	s := openTestStore(t)
 	for range 3 {
@@ -109,3 +601,315 @@ func TestLogsAndPurge(t *testing.T) {
 		t.Fatalf("expected 3 purged, got %d", n)
 	}
 }
+
+func TestQueryLogsFiltersAndPaginates(t *testing.T) {
+	s := openTestStore(t)
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for i := range 5 {
+		e := LogEntry{TS: base.Add(time.Duration(i) * time.Minute), TokenName: "ci", Browser: "chrome136", Method: "GET",
+			TargetHost: "a.com", StatusCode: 200, Success: true}
+		if i%2 == 1 {
+			e.TokenName, e.TargetHost, e.StatusCode, e.Success, e.ErrorType = "prod", "b.com", 0, false, "timeout"
+		}
+		if err := s.AddLog(e); err != nil {
+			t.Fatalf("AddLog: %v", err)
+		}
+	}
+
+	failed := false
+	page, err := s.QueryLogs(LogFilter{Success: &failed, ErrorType: "timeout"})
+	if err != nil {
+		t.Fatalf("QueryLogs: %v", err)
+	}
+	if len(page.Logs) != 2 || page.Logs[0].TargetHost != "b.com" {
+		t.Fatalf("failed filter = %+v", page.Logs)
+	}
+
+	page, _ = s.QueryLogs(LogFilter{Since: base.Add(2 * time.Minute), TokenName: "ci"})
+	if len(page.Logs) != 2 {
+		t.Fatalf("since+token filter got %d rows, want 2", len(page.Logs))
+	}
+
+	// Walk all rows two at a time; order is newest first with no overlap.
+	var seen []int64
+	cursor := ""
+	for {
+		page, err := s.QueryLogs(LogFilter{Limit: 2, Cursor: cursor})
+		if err != nil {
+			t.Fatalf("QueryLogs page: %v", err)
+		}
+		for _, e := range page.Logs {
+			seen = append(seen, e.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	if len(seen) != 5 || seen[0] != 5 || seen[4] != 1 {
+		t.Fatalf("paginated ids = %v, want 5..1", seen)
+	}
+
+	if _, err := s.QueryLogs(LogFilter{Cursor: "garbage"}); err == nil {
+		t.Fatal("invalid cursor accepted")
+	}
+}
+
+func TestEachLogReleasesConnectionBetweenPages(t *testing.T) {
+	s := openTestStore(t)
+	n := exportPageSize + 3
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for i := range n {
+		if err := s.AddLog(LogEntry{TS: base.Add(time.Duration(i/2) * time.Second), TokenName: "ci", Method: "GET"}); err != nil {
+			t.Fatalf("AddLog: %v", err)
+		}
+	}
+
+	// The callback uses the store, as a slow export writer competing with
+	// other requests would; with a single connection this only works if
+	// none is held across the callback.
+	var ids []int64
+	err := s.EachLog(LogFilter{TokenName: "ci"}, func(e LogEntry) error {
+		if _, err := s.ListTokens(); err != nil {
+			return err
+		}
+		ids = append(ids, e.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EachLog: %v", err)
+	}
+	if len(ids) != n || ids[0] != int64(n) || ids[n-1] != 1 {
+		t.Fatalf("exported %d rows from %d to %d, want %d..1", len(ids), ids[0], ids[len(ids)-1], n)
+	}
+}
+
+func TestBlockedLogs(t *testing.T) {
+	s := openTestStore(t)
+	for _, e := range []LogEntry{
+		{Browser: "chrome136", TargetHost: "a.com", StatusCode: 403, Success: true, BlockVendor: "cloudflare", BlockKind: "js-challenge"},
+		{Browser: "chrome136", TargetHost: "a.com", StatusCode: 200, Success: true},
+		{Browser: "safari260", TargetHost: "a.com", StatusCode: 200, Success: true},
+		{Browser: "chrome136", TargetHost: "b.com", StatusCode: 403, Success: true, BlockVendor: "datadome", BlockKind: "captcha"},
+		{Browser: "chrome136", TargetHost: "b.com", StatusCode: 429, Success: true, BlockVendor: "datadome", BlockKind: "rate-limit"},
+	} {
+		if err := s.AddLog(e); err != nil {
+			t.Fatalf("AddLog: %v", err)
+		}
+	}
+
+	blocked := true
+	page, err := s.QueryLogs(LogFilter{Blocked: &blocked, BlockVendor: "datadome"})
+	if err != nil {
+		t.Fatalf("QueryLogs: %v", err)
+	}
+	if len(page.Logs) != 2 || page.Logs[0].BlockKind != "rate-limit" {
+		t.Fatalf("blocked filter = %+v", page.Logs)
+	}
+
+	groups, err := s.BlockBreakdown(time.Now().Add(-time.Hour), 10)
+	if err != nil {
+		t.Fatalf("BlockBreakdown: %v", err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("BlockBreakdown = %+v, want 2 groups", groups)
+	}
+	if g := groups[0]; g.TargetHost != "b.com" || g.Blocked != 2 || g.Requests != 2 || g.Vendors != "datadome" {
+		t.Errorf("groups[0] = %+v", g)
+	}
+	if g := groups[1]; g.TargetHost != "a.com" || g.Browser != "chrome136" || g.Blocked != 1 || g.Requests != 2 {
+		t.Errorf("groups[1] = %+v", g)
+	}
+}
+
+func TestRollupCompletedAggregatesElapsedBuckets(t *testing.T) {
+	s := openTestStore(t)
+	now := time.Date(2026, 3, 2, 10, 30, 0, 0, time.UTC)
+	for i, d := range []int64{100, 200, 300, 400} {
+		e := LogEntry{TS: now.Add(-2 * time.Hour).Add(time.Duration(i) * time.Minute), TokenName: "ci", Browser: "chrome136",
+			TargetHost: "a.com", StatusCode: 200, Success: true, DurationMs: d}
+		if i == 3 {
+			e.Success, e.ErrorType = false, "timeout"
+		}
+		_ = s.AddLog(e)
+	}
+	// In the current (incomplete) hour; must not be rolled up yet.
+	_ = s.AddLog(LogEntry{TS: now, TokenName: "ci", Browser: "chrome136", TargetHost: "a.com", Success: true})
+
+	covered, err := s.RollupCompleted(now)
+	if err != nil {
+		t.Fatalf("RollupCompleted: %v", err)
+	}
+	if want := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC); !covered.Equal(want) {
+		t.Fatalf("covered = %v, want %v (start of the current day)", covered, want)
+	}
+
+	q := RollupQuery{Period: PeriodHour, Since: now.Add(-24 * time.Hour), Until: now}
+	series, err := s.UsageSeries(q)
+	if err != nil {
+		t.Fatalf("UsageSeries: %v", err)
+	}
+	if len(series) != 1 || series[0].Requests != 4 || series[0].Failed != 1 {
+		t.Fatalf("series = %+v, want one bucket with 4 requests, 1 failed", series)
+	}
+	if series[0].AvgMs != 250 {
+		t.Fatalf("AvgMs = %v, want 250", series[0].AvgMs)
+	}
+
+	groups, err := s.UsageBreakdown(q, "error_type", 10)
+	if err != nil {
+		t.Fatalf("UsageBreakdown: %v", err)
+	}
+	if len(groups) != 2 || groups[0].Key != "" || groups[0].Requests != 3 || groups[0].P95Ms != 300 {
+		t.Fatalf("breakdown = %+v", groups)
+	}
+	if _, err := s.UsageBreakdown(q, "method; DROP TABLE x", 10); err == nil {
+		t.Fatal("unknown dimension accepted")
+	}
+
+	// Running again is idempotent.
+	if _, err := s.RollupCompleted(now); err != nil {
+		t.Fatalf("RollupCompleted (2nd): %v", err)
+	}
+	series, _ = s.UsageSeries(q)
+	if len(series) != 1 || series[0].Requests != 4 {
+		t.Fatalf("series after rerun = %+v", series)
+	}
+}
+
+func TestAdminUsersAndSessions(t *testing.T) {
+	defer func(n int) { passwordIterations = n }(passwordIterations)
+	passwordIterations = 1000
+	s := openTestStore(t)
+
+	if _, err := s.CreateAdminUser("alice", "short", RoleOwner); err == nil {
+		t.Fatal("short password accepted")
+	}
+	if _, err := s.CreateAdminUser("alice", "correct horse battery", "root"); err == nil {
+		t.Fatal("unknown role accepted")
+	}
+	alice, err := s.CreateAdminUser("alice", "correct horse battery", RoleOwner)
+	if err != nil {
+		t.Fatalf("CreateAdminUser: %v", err)
+	}
+	bob, err := s.CreateAdminUser("bob", "", RoleViewer)
+	if err != nil {
+		t.Fatalf("CreateAdminUser (no password): %v", err)
+	}
+
+	if _, err := s.AuthenticateAdmin("alice", "wrong password!"); !errors.Is(err, ErrBadCredentials) {
+		t.Fatalf("wrong password: err = %v", err)
+	}
+	if _, err := s.AuthenticateAdmin("bob", ""); !errors.Is(err, ErrBadCredentials) {
+		t.Fatal("account without password signed in")
+	}
+	if u, err := s.AuthenticateAdmin("alice", "correct horse battery"); err != nil || u.ID != alice.ID {
+		t.Fatalf("AuthenticateAdmin = %+v, %v", u, err)
+	}
+
+	var hash string
+	_ = s.db.QueryRow(`SELECT password_hash FROM admin_users WHERE id = ?`, alice.ID).Scan(&hash)
+	if !strings.HasPrefix(hash, "pbkdf2-sha256$1000$") {
+		t.Fatalf("password stored as %q", hash)
+	}
+
+	// The last owner can't be demoted or deleted.
+	if err := s.SetAdminRole(alice.ID, RoleViewer); !errors.Is(err, ErrLastOwner) {
+		t.Fatalf("demote last owner: err = %v", err)
+	}
+	if err := s.DeleteAdminUser(alice.ID); !errors.Is(err, ErrLastOwner) {
+		t.Fatalf("delete last owner: err = %v", err)
+	}
+	if err := s.SetAdminRole(bob.ID, RoleOwner); err != nil {
+		t.Fatalf("SetAdminRole: %v", err)
+	}
+	if err := s.SetAdminRole(alice.ID, RoleOperator); err != nil {
+		t.Fatalf("demote with another owner: %v", err)
+	}
+
+	token, err := s.CreateAdminSession(alice.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateAdminSession: %v", err)
+	}
+	sess, err := s.GetAdminSession(token)
+	if err != nil || sess.User.Username != "alice" || sess.User.Role != RoleOperator || sess.CSRF == "" {
+		t.Fatalf("GetAdminSession = %+v, %v", sess, err)
+	}
+	if _, err := s.GetAdminSession(token + "x"); err == nil {
+		t.Fatal("unknown session accepted")
+	}
+
+	// A password change signs the account out everywhere.
+	if err := s.SetAdminPassword(alice.ID, "another long password"); err != nil {
+		t.Fatalf("SetAdminPassword: %v", err)
+	}
+	if _, err := s.GetAdminSession(token); err == nil {
+		t.Fatal("session survived password change")
+	}
+
+	expired, _ := s.CreateAdminSession(bob.ID, -time.Minute)
+	if _, err := s.GetAdminSession(expired); err == nil {
+		t.Fatal("expired session accepted")
+	}
+	if n, err := s.PurgeExpiredAdminSessions(); err != nil || n != 1 {
+		t.Fatalf("PurgeExpiredAdminSessions = %d, %v; want 1", n, err)
+	}
+}
+
+func TestAuditLogAppendOnlyAndFilters(t *testing.T) {
+	s := openTestStore(t)
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	entries := []AuditEntry{
+		{TS: base, Actor: "alice", Action: "login"},
+		{TS: base.Add(time.Minute), Actor: "alice", Action: "token.create", Target: "token #1"},
+		{TS: base.Add(2 * time.Minute), Actor: "bob", Action: "token.delete", Target: "token #1"},
+		{TS: base.Add(3 * time.Minute), Actor: "bob", Action: "settings.update"},
+	}
+	for _, e := range entries {
+		if err := s.AddAudit(e); err != nil {
+			t.Fatalf("AddAudit: %v", err)
+		}
+	}
+
+	if _, err := s.db.Exec(`UPDATE audit_log SET actor = 'mallory'`); err == nil {
+		t.Fatal("audit log entry updated")
+	}
+	if _, err := s.db.Exec(`DELETE FROM audit_log`); err == nil {
+		t.Fatal("audit log entry deleted")
+	}
+
+	page, err := s.QueryAudit(AuditFilter{Action: "token."})
+	if err != nil || len(page.Entries) != 2 || page.Entries[0].Action != "token.delete" {
+		t.Fatalf("prefix filter = %+v, %v", page, err)
+	}
+	page, _ = s.QueryAudit(AuditFilter{Actor: "alice", Action: "login"})
+	if len(page.Entries) != 1 {
+		t.Fatalf("actor+action filter returned %d entries, want 1", len(page.Entries))
+	}
+	page, _ = s.QueryAudit(AuditFilter{Since: base.Add(time.Minute), Until: base.Add(3 * time.Minute)})
+	if len(page.Entries) != 2 {
+		t.Fatalf("time filter returned %d entries, want 2", len(page.Entries))
+	}
+
+	var seen []string
+	f := AuditFilter{Limit: 3}
+	for {
+		page, err := s.QueryAudit(f)
+		if err != nil {
+			t.Fatalf("QueryAudit: %v", err)
+		}
+		for _, e := range page.Entries {
+			seen = append(seen, e.Action)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		f.Cursor = page.NextCursor
+	}
+	if len(seen) != 4 || seen[3] != "login" {
+		t.Fatalf("paged through %v", seen)
+	}
+	if actions, _ := s.AuditActions(); len(actions) != 4 {
+		t.Fatalf("AuditActions = %v", actions)
+	}
+}
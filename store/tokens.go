@@ -0,0 +1,895 @@
+package store
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/zupolgec/curl-impersonate-service/models"
+)
+
+// Token is an API token record. The secret itself is never stored: only a
+// salted hash and a short prefix that identifies the token in listings.
+type Token struct {
+	ID     int64
+	Name   string
+	Prefix string
+	// Imported is set for tokens the service did not generate, such as the
+	// one seeded from TOKEN. Their Prefix is a random display id, not the
+	// start of the secret.
+	Imported   bool
+	Enabled    bool
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+	// ExpiresAt is nil for tokens that never expire.
+	ExpiresAt *time.Time
+	// Scopes limits the endpoints the token may call; empty means all but
+	// the admin API.
+	Scopes []string
+	// ReplacedBy is the id of the token issued when this one was rotated.
+	ReplacedBy int64
+	// SigningKeyID identifies the token's request-signing secret; empty when
+	// request signing is not set up.
+	SigningKeyID string
+	// SignedOnly refuses the token as a bearer credential: only signed
+	// requests are accepted.
+	SignedOnly bool
+	// NoQueryToken refuses the token when sent as a ?token= parameter.
+	NoQueryToken bool
+
+	// Token holds the full secret. It is only set on the value returned by
+	// CreateToken, which is the one chance to show it to the user.
+	Token string
+}
+
+// TokenOptions are the optional restrictions of a new token.
+type TokenOptions struct {
+	Scopes    []string
+	ExpiresAt *time.Time
+}
+
+// Expired reports whether the token's expiry has passed at now.
+func (t Token) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+// HasScope reports whether the token may call endpoints of the given scope.
+// The admin scope is never implied.
+func (t Token) HasScope(scope string) bool {
+	if len(t.Scopes) == 0 && scope != models.ScopeAdmin {
+		return true
+	}
+	return slices.Contains(t.Scopes, scope)
+}
+
+// TokenUpdate lists the fields to change on a token; nil fields are kept.
+type TokenUpdate struct {
+	Name    *string
+	Enabled *bool
+	Scopes  *[]string
+	// ExpiresAt sets a new expiry; ClearExpiry removes it.
+	ExpiresAt    *time.Time
+	ClearExpiry  bool
+	SignedOnly   *bool
+	NoQueryToken *bool
+}
+
+// ErrNoSigningKey is returned when making a token signed-only before it has
+// a signing key.
+var ErrNoSigningKey = errors.New("enable request signing before requiring it")
+
+// TokenPrefixLen is the number of leading characters of a generated token
+// kept in the clear for identification. Imported tokens get a random display
+// id of the same length instead.
+const TokenPrefixLen = 8
+
+const tokenSchema = `
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    name         TEXT    NOT NULL,
+    prefix       TEXT    NOT NULL,
+    salt         TEXT    NOT NULL,
+    hash         TEXT    NOT NULL,
+    enabled      INTEGER NOT NULL DEFAULT 1,
+    created_at   INTEGER NOT NULL,
+    last_used_at INTEGER,
+    expires_at   INTEGER,
+    scopes       TEXT    NOT NULL DEFAULT '',
+    replaced_by  INTEGER NOT NULL DEFAULT 0,
+    signing_key_id TEXT,
+    signing_secret TEXT,
+    signed_only    INTEGER NOT NULL DEFAULT 0,
+    no_query_token INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_prefix ON api_tokens(prefix);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_hash ON api_tokens(hash);
+`
+
+// migrateTokens creates the token table and, for databases from versions
+// that stored tokens in plaintext, rehashes every existing token (including
+// the one seeded from TOKEN) and drops the plaintext column. Values in the
+// format generateToken produces keep their prefix and fast hash; others are
+// stored as imported, with keys.
+func migrateTokens(db *sql.DB, keys *tokenKeys) error {
+	var plain int
+	if err := db.QueryRow(`SELECT COUNT(1) FROM pragma_table_info('api_tokens') WHERE name = 'token'`).Scan(&plain); err != nil {
+		return err
+	}
+	if plain == 0 {
+		_, err := db.Exec(tokenSchema)
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec(`ALTER TABLE api_tokens RENAME TO api_tokens_plain`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(tokenSchema); err != nil {
+		return err
+	}
+	rows, err := tx.Query(`SELECT id, name, token, enabled, created_at, last_used_at FROM api_tokens_plain`)
+	if err != nil {
+		return err
+	}
+	type plainToken struct {
+		id, created int64
+		name, value string
+		enabled     int
+		lastUsed    sql.NullInt64
+	}
+	var old []plainToken
+	for rows.Next() {
+		var t plainToken
+		if err := rows.Scan(&t.id, &t.name, &t.value, &t.enabled, &t.created, &t.lastUsed); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		old = append(old, t)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, t := range old {
+		imported := keys
+		if looksGenerated(t.value) {
+			imported = nil
+		}
+		prefix, salt, hash, err := tokenHash(t.value, imported)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO api_tokens (id, name, prefix, salt, hash, enabled, created_at, last_used_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			t.id, t.name, prefix, salt, hash, t.enabled, t.created, t.lastUsed,
+		); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DROP TABLE api_tokens_plain`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// generateToken returns a cryptographically random token.
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func tokenPrefix(value string) string {
+	if len(value) <= TokenPrefixLen {
+		return value
+	}
+	return value[:TokenPrefixLen]
+}
+
+// hashToken returns the hex SHA-256 of salt and value. Generated tokens carry
+// 256 bits of entropy, so a fast hash is sufficient; the salt keeps equal
+// values from producing equal hashes.
+func hashToken(salt, value string) string {
+	sum := sha256.Sum256([]byte(salt + value))
+	return hex.EncodeToString(sum[:])
+}
+
+// looksGenerated reports whether value has the format of generateToken.
+func looksGenerated(value string) bool {
+	_, err := hex.DecodeString(value)
+	return len(value) == 64 && err == nil && strings.ToLower(value) == value
+}
+
+// tokenKeys are the keys imported tokens are hashed with. Those may be short
+// or guessable, so rather than a plain hash they get an HMAC under a key
+// kept apart from the rows: the one derived from SECRETS_KEY when it is set,
+// otherwise a random one stored with the settings. The hash column names the
+// key used, so tokens hashed before SECRETS_KEY was set still validate and
+// are moved to it.
+type tokenKeys struct {
+	stored  []byte
+	secrets []byte
+}
+
+// Imported token hash tags, naming the HMAC key.
+const (
+	tokenKeyStored  = "hmac-stored"
+	tokenKeySecrets = "hmac-secrets"
+)
+
+// tokenPepperSetting holds the stored token key, hex-encoded.
+const tokenPepperSetting = "api_token_pepper"
+
+// loadTokenKeys reads the stored token key, creating it on first use.
+func loadTokenKeys(db *sql.DB) (*tokenKeys, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(`INSERT OR IGNORE INTO settings (key, value) VALUES (?, ?)`, tokenPepperSetting, hex.EncodeToString(b)); err != nil {
+		return nil, err
+	}
+	var v string
+	if err := db.QueryRow(`SELECT value FROM settings WHERE key = ?`, tokenPepperSetting).Scan(&v); err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(v)
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("invalid %s setting", tokenPepperSetting)
+	}
+	return &tokenKeys{stored: key}, nil
+}
+
+func hmacToken(tag string, key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return tag + "$" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// hash returns the hash to store for an imported token.
+func (k *tokenKeys) hash(value string) string {
+	if k.secrets != nil {
+		return hmacToken(tokenKeySecrets, k.secrets, value)
+	}
+	return hmacToken(tokenKeyStored, k.stored, value)
+}
+
+// candidates returns every hash value may be stored under.
+func (k *tokenKeys) candidates(value string) []any {
+	out := []any{hmacToken(tokenKeyStored, k.stored, value)}
+	if k.secrets != nil {
+		out = append(out, hmacToken(tokenKeySecrets, k.secrets, value))
+	}
+	return out
+}
+
+// tokenHash returns the prefix, salt and hash to store for value. A token
+// the service generated (imported is nil) keeps its first characters as the
+// prefix, which finds it on validation, and gets a fast salted hash. An
+// imported token gets a random display id and an HMAC under imported; the
+// empty salt column marks it.
+func tokenHash(value string, imported *tokenKeys) (prefix, salt, hash string, err error) {
+	if imported != nil {
+		if prefix, err = randomHex(TokenPrefixLen / 2); err != nil {
+			return "", "", "", err
+		}
+		return prefix, "", imported.hash(value), nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	salt = hex.EncodeToString(b)
+	return tokenPrefix(value), salt, hashToken(salt, value), nil
+}
+
+// CreateToken creates a new random API token with the given name. The
+// returned Token carries the secret; it can't be recovered afterwards.
+func (s *Store) CreateToken(name string, opts TokenOptions) (*Token, error) {
+	scopes, err := models.ParseScopes(opts.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	tok, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	t := Token{Name: name, Token: tok, Enabled: true, Scopes: scopes, ExpiresAt: opts.ExpiresAt}
+	if err := insertToken(s.db, &t, tok, nil); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// insertToken stores t with the hash of value, filling in ID, Prefix,
+// Imported and CreatedAt. imported is nil for tokens the service generated
+// (see tokenHash).
+func insertToken(db execer, t *Token, value string, imported *tokenKeys) error {
+	prefix, salt, hash, err := tokenHash(value, imported)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	res, err := db.Exec(
+		`INSERT INTO api_tokens (name, prefix, salt, hash, enabled, created_at, expires_at, scopes)
+		 VALUES (?, ?, ?, ?, 1, ?, ?, ?)`,
+		t.Name, prefix, salt, hash, now, unixOrNil(t.ExpiresAt), strings.Join(t.Scopes, ","),
+	)
+	if err != nil {
+		return err
+	}
+	t.ID, _ = res.LastInsertId()
+	t.Prefix, t.Imported = prefix, imported != nil
+	t.CreatedAt = time.Unix(now, 0)
+	return nil
+}
+
+func unixOrNil(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.Unix()
+}
+
+func splitScopes(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// SeedToken inserts a token with an explicit value if it does not already
+// exist. Used to preserve the legacy TOKEN env value across restarts. The
+// token is stored as imported (see tokenKeys); one stored by an earlier
+// version with a clear prefix and fast hash is rehashed.
+func (s *Store) SeedToken(name, value string) error {
+	t, found, err := s.findGeneratedToken(value)
+	if err != nil {
+		return err
+	}
+	if found {
+		prefix, salt, hash, err := tokenHash(value, s.tokenKeys)
+		if err != nil {
+			return err
+		}
+		_, err = s.db.Exec(`UPDATE api_tokens SET prefix = ?, salt = ?, hash = ? WHERE id = ?`, prefix, salt, hash, t.ID)
+		return err
+	}
+	if _, found, err := s.findImportedToken(value); err != nil || found {
+		return err
+	}
+	return insertToken(s.db, &Token{Name: name}, value, s.tokenKeys)
+}
+
+// findToken looks value up among generated, then imported tokens.
+func (s *Store) findToken(value string) (Token, bool, error) {
+	if t, found, err := s.findGeneratedToken(value); err != nil || found {
+		return t, found, err
+	}
+	return s.findImportedToken(value)
+}
+
+// tokenAuthColumns are the api_tokens columns scanTokenAuth reads.
+const tokenAuthColumns = `id, name, salt, hash, enabled, expires_at, scopes, signed_only, no_query_token`
+
+// scanTokenAuth reads a token with its salt and hash.
+func scanTokenAuth(rows *sql.Rows) (t Token, salt, hash string, err error) {
+	var scopes string
+	var enabled int
+	var expires sql.NullInt64
+	if err := rows.Scan(&t.ID, &t.Name, &salt, &hash, &enabled, &expires, &scopes, &t.SignedOnly, &t.NoQueryToken); err != nil {
+		return t, "", "", err
+	}
+	t.Enabled = enabled == 1
+	t.ExpiresAt = timePtr(expires)
+	t.Scopes = splitScopes(scopes)
+	return t, salt, hash, nil
+}
+
+// findGeneratedToken looks value up by prefix among generated tokens and
+// compares hashes in constant time.
+func (s *Store) findGeneratedToken(value string) (t Token, found bool, err error) {
+	rows, err := s.db.Query(`SELECT `+tokenAuthColumns+` FROM api_tokens WHERE prefix = ? AND salt != ''`, tokenPrefix(value))
+	if err != nil {
+		return t, false, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		c, salt, hash, err := scanTokenAuth(rows)
+		if err != nil {
+			return t, false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(hashToken(salt, value)), []byte(hash)) == 1 {
+			t, found = c, true
+		}
+	}
+	return t, found, rows.Err()
+}
+
+// findImportedToken looks value up by its HMAC among imported tokens,
+// moving a match to the SECRETS_KEY-derived key once there is one.
+func (s *Store) findImportedToken(value string) (t Token, found bool, err error) {
+	hashes := s.tokenKeys.candidates(value)
+	rows, err := s.db.Query(`SELECT `+tokenAuthColumns+` FROM api_tokens WHERE salt = '' AND hash IN (?`+strings.Repeat(`, ?`, len(hashes)-1)+`)`, hashes...)
+	if err != nil {
+		return t, false, err
+	}
+	var hash string
+	if rows.Next() {
+		t, _, hash, err = scanTokenAuth(rows)
+		found = err == nil
+	}
+	_ = rows.Close()
+	if err != nil || !found {
+		return t, false, errors.Join(err, rows.Err())
+	}
+	if want := s.tokenKeys.hash(value); hash != want {
+		if _, err := s.db.Exec(`UPDATE api_tokens SET hash = ? WHERE id = ?`, want, t.ID); err != nil {
+			return t, false, err
+		}
+	}
+	return t, true, nil
+}
+
+// ValidateToken returns the token name if the value matches an enabled,
+// unexpired token allowed to use scope, updating its last-used timestamp.
+// Failures are models.ErrTokenInvalid, ErrTokenExpired, ErrTokenScope or
+// ErrSignatureRequired.
+func (s *Store) ValidateToken(value, scope string) (string, error) {
+	return s.validateToken(value, scope, false)
+}
+
+// ValidateQueryToken is ValidateToken for a value sent as ?token=; it also
+// fails with models.ErrQueryTokenDisabled for tokens that forbid it.
+func (s *Store) ValidateQueryToken(value, scope string) (string, error) {
+	return s.validateToken(value, scope, true)
+}
+
+func (s *Store) validateToken(value, scope string, query bool) (string, error) {
+	if value == "" {
+		return "", models.ErrTokenInvalid
+	}
+	t, found, err := s.findToken(value)
+	switch {
+	case err != nil || !found || !t.Enabled:
+		return "", models.ErrTokenInvalid
+	case t.Expired(time.Now()):
+		return "", models.ErrTokenExpired
+	case !t.HasScope(scope):
+		return "", models.ErrTokenScope
+	case t.SignedOnly:
+		return "", models.ErrSignatureRequired
+	case query && t.NoQueryToken:
+		return "", models.ErrQueryTokenDisabled
+	}
+	s.touchToken(t.ID)
+	return t.Name, nil
+}
+
+func (s *Store) touchToken(id int64) {
+	_, _ = s.db.Exec(`UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, time.Now().Unix(), id)
+}
+
+// ValidateTokenName authenticates a caller already identified by other means
+// (a mapped client certificate) as the token called name. Rotation keeps
+// names, so only the newest token of each rotation is considered. If more
+// than one enabled, unexpired token has the name the caller is refused
+// rather than matched to a guess (see SharedTokenNames). The token's scopes
+// apply and its last-used timestamp is updated. Failures are as for
+// ValidateToken.
+func (s *Store) ValidateTokenName(name, scope string) (string, error) {
+	live, expired, err := s.liveTokensNamed(name)
+	switch {
+	case err != nil:
+		return "", models.ErrTokenInvalid
+	case len(live) == 0 && expired:
+		return "", models.ErrTokenExpired
+	case len(live) != 1:
+		return "", models.ErrTokenInvalid
+	case !live[0].HasScope(scope):
+		return "", models.ErrTokenScope
+	}
+	s.touchToken(live[0].ID)
+	return name, nil
+}
+
+// SharedTokenNames returns those of names held by more than one enabled,
+// unexpired token, which ValidateTokenName refuses.
+func (s *Store) SharedTokenNames(names []string) ([]string, error) {
+	var shared []string
+	for _, name := range names {
+		live, _, err := s.liveTokensNamed(name)
+		if err != nil {
+			return nil, err
+		}
+		if len(live) > 1 && !slices.Contains(shared, name) {
+			shared = append(shared, name)
+		}
+	}
+	return shared, nil
+}
+
+// liveTokensNamed returns the enabled, unexpired tokens called name that
+// have not been replaced by rotation, and whether any were skipped as
+// expired.
+func (s *Store) liveTokensNamed(name string) ([]Token, bool, error) {
+	rows, err := s.db.Query(`SELECT id, enabled, expires_at, scopes FROM api_tokens WHERE name = ? AND replaced_by = 0`, name)
+	if err != nil {
+		return nil, false, err
+	}
+	defer func() { _ = rows.Close() }()
+	var live []Token
+	expired := false
+	now := time.Now()
+	for rows.Next() {
+		t := Token{Name: name}
+		var scopes string
+		var enabled int
+		var expires sql.NullInt64
+		if err := rows.Scan(&t.ID, &enabled, &expires, &scopes); err != nil {
+			return nil, false, err
+		}
+		t.ExpiresAt, t.Scopes = timePtr(expires), splitScopes(scopes)
+		switch {
+		case enabled != 1:
+		case t.Expired(now):
+			expired = true
+		default:
+			live = append(live, t)
+		}
+	}
+	return live, expired, rows.Err()
+}
+
+// SigningKey returns the token name and signing secret for a signing key ID
+// if its token is enabled, unexpired and allowed to use scope, updating the
+// token's last-used timestamp. Failures are as for ValidateToken, or an error
+// if the secret can't be decrypted.
+func (s *Store) SigningKey(keyID, scope string) (string, []byte, error) {
+	if keyID == "" {
+		return "", nil, models.ErrTokenInvalid
+	}
+	var t Token
+	var secret, scopes string
+	var enabled int
+	var expires sql.NullInt64
+	err := s.db.QueryRow(`SELECT id, name, enabled, expires_at, scopes, signing_secret FROM api_tokens WHERE signing_key_id = ?`, keyID).
+		Scan(&t.ID, &t.Name, &enabled, &expires, &scopes, &secret)
+	t.ExpiresAt = timePtr(expires)
+	t.Scopes = splitScopes(scopes)
+	switch {
+	case err != nil || enabled != 1:
+		return "", nil, models.ErrTokenInvalid
+	case t.Expired(time.Now()):
+		return "", nil, models.ErrTokenExpired
+	case !t.HasScope(scope):
+		return "", nil, models.ErrTokenScope
+	}
+	plain, err := s.openSigningSecret(keyID, secret)
+	if err != nil {
+		return "", nil, fmt.Errorf("signing key %s: %w", keyID, err)
+	}
+	s.touchToken(t.ID)
+	return t.Name, plain, nil
+}
+
+// sealedSigningPrefix marks a signing secret sealed with SECRETS_KEY; older
+// rows may still hold the secret itself until SetSecretsKey seals them.
+const sealedSigningPrefix = "sealed$"
+
+// sealSigningSecret encrypts a signing secret for storage, bound to its key
+// ID so it can't be moved to another key.
+func (s *Store) sealSigningSecret(keyID, secret string) (string, error) {
+	if s.secrets == nil {
+		return "", ErrNoSecretsKey
+	}
+	nonce := make([]byte, s.secrets.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := s.secrets.Seal(nonce, nonce, []byte(secret), credentialAD("signing", keyID))
+	return sealedSigningPrefix + hex.EncodeToString(sealed), nil
+}
+
+// openSigningSecret decrypts a stored signing secret.
+func (s *Store) openSigningSecret(keyID, stored string) ([]byte, error) {
+	hexed, ok := strings.CutPrefix(stored, sealedSigningPrefix)
+	if !ok {
+		return []byte(stored), nil
+	}
+	if s.secrets == nil {
+		return nil, ErrNoSecretsKey
+	}
+	sealed, err := hex.DecodeString(hexed)
+	n := s.secrets.NonceSize()
+	if err != nil || len(sealed) < n {
+		return nil, errors.New("corrupt secret")
+	}
+	plain, err := s.secrets.Open(nil, sealed[:n], sealed[n:], credentialAD("signing", keyID))
+	if err != nil {
+		return nil, errors.New("cannot decrypt, was SECRETS_KEY changed?")
+	}
+	return plain, nil
+}
+
+// sealSigningSecrets encrypts signing secrets stored before they were
+// sealed.
+func (s *Store) sealSigningSecrets() error {
+	rows, err := s.db.Query(`SELECT signing_key_id, signing_secret FROM api_tokens
+		WHERE signing_key_id IS NOT NULL AND signing_secret NOT LIKE 'sealed$%'`)
+	if err != nil {
+		return err
+	}
+	plain := map[string]string{}
+	for rows.Next() {
+		var keyID, secret string
+		if err := rows.Scan(&keyID, &secret); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		plain[keyID] = secret
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for keyID, secret := range plain {
+		sealed, err := s.sealSigningSecret(keyID, secret)
+		if err != nil {
+			return err
+		}
+		if _, err := s.db.Exec(`UPDATE api_tokens SET signing_secret = ? WHERE signing_key_id = ?`, sealed, keyID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnableSigning issues a new request-signing key for token id, replacing any
+// previous one, and returns its ID and secret. Verifying signatures needs the
+// secret itself, so it is stored encrypted with SECRETS_KEY and is only
+// returned here; without a secrets key it returns ErrNoSecretsKey.
+func (s *Store) EnableSigning(id int64) (keyID, secret string, err error) {
+	if s.secrets == nil {
+		return "", "", ErrNoSecretsKey
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	keyID = "sk_" + hex.EncodeToString(b)
+	if secret, err = generateToken(); err != nil {
+		return "", "", err
+	}
+	sealed, err := s.sealSigningSecret(keyID, secret)
+	if err != nil {
+		return "", "", err
+	}
+	res, err := s.db.Exec(`UPDATE api_tokens SET signing_key_id = ?, signing_secret = ? WHERE id = ?`, keyID, sealed, id)
+	if err != nil {
+		return "", "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", "", sql.ErrNoRows
+	}
+	return keyID, secret, nil
+}
+
+// DisableSigning removes token id's signing key. A signed-only token goes
+// back to accepting bearer use, since it would otherwise be unusable.
+func (s *Store) DisableSigning(id int64) error {
+	res, err := s.db.Exec(`UPDATE api_tokens SET signing_key_id = NULL, signing_secret = NULL, signed_only = 0 WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RotateToken issues a replacement for token id with the same name, scopes
+// and expiry. The old value keeps working for grace (never beyond its own
+// expiry) and is then refused as expired. The returned Token carries the new
+// secret.
+func (s *Store) RotateToken(id int64, grace time.Duration) (*Token, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var old Token
+	var scopes string
+	var expires sql.NullInt64
+	var keyID, secret sql.NullString
+	if err := tx.QueryRow(`SELECT name, scopes, expires_at, signing_key_id, signing_secret, signed_only, no_query_token FROM api_tokens WHERE id = ?`, id).
+		Scan(&old.Name, &scopes, &expires, &keyID, &secret, &old.SignedOnly, &old.NoQueryToken); err != nil {
+		return nil, err
+	}
+	old.ExpiresAt = timePtr(expires)
+
+	value, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	t := Token{Name: old.Name, Token: value, Enabled: true, Scopes: splitScopes(scopes), ExpiresAt: old.ExpiresAt}
+	if err := insertToken(tx, &t, value, nil); err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(grace)
+	if old.ExpiresAt != nil && old.ExpiresAt.Before(cutoff) {
+		cutoff = *old.ExpiresAt
+	}
+	if _, err := tx.Exec(`UPDATE api_tokens SET expires_at = ?, replaced_by = ?, signing_key_id = NULL, signing_secret = NULL WHERE id = ?`,
+		cutoff.Unix(), t.ID, id); err != nil {
+		return nil, err
+	}
+	// The signing key is a separate credential: it moves to the replacement
+	// along with the token's restrictions. Its sealed secret is bound to the
+	// key ID, not the row, so it moves as is.
+	if _, err := tx.Exec(`UPDATE api_tokens SET signing_key_id = ?, signing_secret = ?, signed_only = ?, no_query_token = ? WHERE id = ?`,
+		keyID, secret, old.SignedOnly, old.NoQueryToken, t.ID); err != nil {
+		return nil, err
+	}
+	t.SigningKeyID, t.SignedOnly, t.NoQueryToken = keyID.String, old.SignedOnly, old.NoQueryToken
+	return &t, tx.Commit()
+}
+
+const tokenColumns = `id, name, prefix, salt = '', enabled, created_at, last_used_at, expires_at, scopes, replaced_by,
+	COALESCE(signing_key_id, ''), signed_only, no_query_token`
+
+// ListTokens returns all API tokens ordered by creation time. Secrets are not
+// included.
+func (s *Store) ListTokens() ([]Token, error) {
+	rows, err := s.db.Query(`SELECT ` + tokenColumns + ` FROM api_tokens ORDER BY created_at DESC, id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var out []Token
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// GetToken returns one token by id, or sql.ErrNoRows.
+func (s *Store) GetToken(id int64) (*Token, error) {
+	rows, err := s.db.Query(`SELECT `+tokenColumns+` FROM api_tokens WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, sql.ErrNoRows
+	}
+	t, err := scanToken(rows)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// UpdateToken applies u to token id. It returns sql.ErrNoRows if the token
+// does not exist.
+func (s *Store) UpdateToken(id int64, u TokenUpdate) error {
+	var sets []string
+	var args []any
+	if u.Name != nil {
+		sets, args = append(sets, "name = ?"), append(args, *u.Name)
+	}
+	if u.Enabled != nil {
+		sets, args = append(sets, "enabled = ?"), append(args, boolInt(*u.Enabled))
+	}
+	if u.Scopes != nil {
+		scopes, err := models.ParseScopes(*u.Scopes)
+		if err != nil {
+			return err
+		}
+		sets, args = append(sets, "scopes = ?"), append(args, strings.Join(scopes, ","))
+	}
+	switch {
+	case u.ClearExpiry:
+		sets = append(sets, "expires_at = NULL")
+	case u.ExpiresAt != nil:
+		sets, args = append(sets, "expires_at = ?"), append(args, u.ExpiresAt.Unix())
+	}
+	if u.SignedOnly != nil {
+		if *u.SignedOnly {
+			t, err := s.GetToken(id)
+			if err != nil {
+				return err
+			}
+			if t.SigningKeyID == "" {
+				return ErrNoSigningKey
+			}
+		}
+		sets, args = append(sets, "signed_only = ?"), append(args, boolInt(*u.SignedOnly))
+	}
+	if u.NoQueryToken != nil {
+		sets, args = append(sets, "no_query_token = ?"), append(args, boolInt(*u.NoQueryToken))
+	}
+	if len(sets) == 0 {
+		_, err := s.GetToken(id)
+		return err
+	}
+	res, err := s.db.Exec(`UPDATE api_tokens SET `+strings.Join(sets, ", ")+` WHERE id = ?`, append(args, id)...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func scanToken(rows *sql.Rows) (Token, error) {
+	var t Token
+	var enabled int
+	var created int64
+	var lastUsed, expires sql.NullInt64
+	var scopes string
+	if err := rows.Scan(&t.ID, &t.Name, &t.Prefix, &t.Imported, &enabled, &created, &lastUsed, &expires, &scopes, &t.ReplacedBy,
+		&t.SigningKeyID, &t.SignedOnly, &t.NoQueryToken); err != nil {
+		return t, err
+	}
+	t.Enabled = enabled == 1
+	t.CreatedAt = time.Unix(created, 0)
+	t.LastUsedAt = timePtr(lastUsed)
+	t.ExpiresAt = timePtr(expires)
+	t.Scopes = splitScopes(scopes)
+	return t, nil
+}
+
+func timePtr(v sql.NullInt64) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+	t := time.Unix(v.Int64, 0)
+	return &t
+}
+
+// DeleteToken removes a token by id.
+func (s *Store) DeleteToken(id int64) error {
+	_, err := s.db.Exec(`DELETE FROM api_tokens WHERE id = ?`, id)
+	return err
+}
+
+// SetTokenEnabled enables or disables a token by id.
+func (s *Store) SetTokenEnabled(id int64, enabled bool) error {
+	_, err := s.db.Exec(`UPDATE api_tokens SET enabled = ? WHERE id = ?`, boolInt(enabled), id)
+	return err
+}